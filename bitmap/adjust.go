@@ -0,0 +1,106 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// adjust applies the generator's levels, brightness and contrast adjustments to img, in
+// that order. If no adjustment is required, img is returned unchanged.
+//
+// Levels maps the black point to 0 and the white point to 255, clipping anything
+// outside. Brightness is added to each channel, and Contrast scales each channel's
+// distance from mid grey by 1+Contrast. Both are in the range -1 to 1, where 1 is the
+// full range of a channel. Alpha is not adjusted.
+func (g *Generator) adjust(img image.Image) (image.Image, error) {
+	if g.Levels == "" && g.Brightness == 0 && g.Contrast == 0 {
+		return img, nil
+	}
+	if g.Brightness < -1 || g.Brightness > 1 {
+		return nil, fmt.Errorf("brightness must be between -1 and 1, found %g", g.Brightness)
+	}
+	if g.Contrast < -1 || g.Contrast > 1 {
+		return nil, fmt.Errorf("contrast must be between -1 and 1, found %g", g.Contrast)
+	}
+
+	black, white := 0, 255
+	if g.Levels != "" {
+		if _, err := fmt.Sscanf(g.Levels, "%d,%d", &black, &white); err != nil {
+			return nil, fmt.Errorf("invalid levels %q, expected '<black>,<white>': %w", g.Levels, err)
+		}
+		if black < 0 || white > 255 || black >= white {
+			return nil, fmt.Errorf("invalid levels %q, expected 0 <= black < white <= 255", g.Levels)
+		}
+	}
+
+	var lut [256]uint8
+	for i := range lut {
+		v := float64(i-black) / float64(white-black)
+		v += g.Brightness
+		v = (v-0.5)*(1+g.Contrast) + 0.5
+		lut[i] = uint8(math.Round(math.Max(0, math.Min(1, v)) * 0xff))
+	}
+
+	bounds := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			out.SetNRGBA(x-bounds.Min.X, y-bounds.Min.Y, color.NRGBA{lut[c.R], lut[c.G], lut[c.B], c.A})
+		}
+	}
+	return out, nil
+}
+
+// channelOrders maps each accepted ChannelSwap value to the source channel (0 for red, 1
+// for green, 2 for blue) used for each output channel.
+var channelOrders = map[string][3]int{
+	"rgb": {0, 1, 2}, "rbg": {0, 2, 1}, "grb": {1, 0, 2},
+	"gbr": {1, 2, 0}, "brg": {2, 0, 1}, "bgr": {2, 1, 0},
+}
+
+// swapChannels reorders img's colour channels using g.ChannelSwap, then inverts each
+// channel in g.InvertChannels. It corrects captures from devices which store channels
+// in a different order, and is applied before any other processing. If neither is set,
+// img is returned unchanged.
+func (g *Generator) swapChannels(img image.Image) (image.Image, error) {
+	if (g.ChannelSwap == "" || g.ChannelSwap == "rgb") && g.InvertChannels == "" {
+		return img, nil
+	}
+
+	order := channelOrders["rgb"]
+	if g.ChannelSwap != "" {
+		var ok bool
+		if order, ok = channelOrders[g.ChannelSwap]; !ok {
+			return nil, fmt.Errorf("invalid channel swap %q, expected an ordering of 'rgb', i.e. 'bgr'", g.ChannelSwap)
+		}
+	}
+
+	var invert [3]uint8
+	for _, c := range g.InvertChannels {
+		idx := strings.IndexRune("rgb", c)
+		if idx < 0 {
+			return nil, fmt.Errorf("invalid channel %q in %q, expected any of 'r', 'g' or 'b'", c, g.InvertChannels)
+		}
+		invert[idx] = 0xff
+	}
+
+	bounds := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			src := [3]uint8{c.R, c.G, c.B}
+			out.SetNRGBA(x-bounds.Min.X, y-bounds.Min.Y, color.NRGBA{
+				src[order[0]] ^ invert[0],
+				src[order[1]] ^ invert[1],
+				src[order[2]] ^ invert[2],
+				c.A,
+			})
+		}
+	}
+	return out, nil
+}
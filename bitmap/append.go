@@ -0,0 +1,116 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// appendWriter replaces a marked block for each output inside an existing file, so
+// generated code can live in a larger hand-maintained source file. Each block is
+// wrapped in comments naming the output, and the begin marker holds the CRC-32 of the
+// block's contents:
+//
+//	// BEGIN bmp2cpp bitmap.h crc32=1a2b3c4d
+//	...
+//	// END bmp2cpp bitmap.h
+//
+// If the contents no longer match the CRC, the block was edited by hand and is not
+// replaced. Outputs without a block are appended to the end of the file, and the file
+// is created if it does not exist. Nothing is written until Close.
+type appendWriter struct {
+	path string
+	outs []Output
+}
+
+func openAppend(path string) (*appendWriter, error) {
+	if path == "" || separateOutputs(path) {
+		return nil, fmt.Errorf("-append requires -o to be a single file")
+	}
+	return &appendWriter{path: path}, nil
+}
+
+func (a *appendWriter) Write(out Output) error {
+	if out.Binary {
+		return fmt.Errorf("output %q is binary, and cannot be appended to a source file", out.Name)
+	}
+	if appendComment(out.Name) == "" {
+		return fmt.Errorf("output %q does not support comments, so cannot be marked in a source file", out.Name)
+	}
+	a.outs = append(a.outs, out)
+	return nil
+}
+
+func (a *appendWriter) Close() error {
+	existing, err := os.ReadFile(a.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, out := range a.outs {
+		if existing, err = replaceBlock(existing, out); err != nil {
+			return fmt.Errorf("%s: %w", a.path, err)
+		}
+	}
+	return os.WriteFile(a.path, existing, 0644)
+}
+
+// appendComment returns the line comment prefix for the output named name, or an empty
+// string if the output's format has none.
+func appendComment(name string) string {
+	switch filepath.Ext(name) {
+	case ".asm":
+		return ";"
+	case ".txt", ".xpm", ".bin", ".hex", ".b64":
+		return ""
+	default:
+		return "//"
+	}
+}
+
+// replaceBlock returns src with the marked block for out replaced by out's data, or
+// with a new block appended if there is none.
+func replaceBlock(src []byte, out Output) ([]byte, error) {
+	comment := regexp.QuoteMeta(appendComment(out.Name))
+	name := regexp.QuoteMeta(out.Name)
+	beginPtn := regexp.MustCompile(`(?m)^[ \t]*` + comment + ` BEGIN bmp2cpp ` + name + ` crc32=([0-9a-f]{8})[ \t]*\r?\n`)
+	endPtn := regexp.MustCompile(`(?m)^[ \t]*` + comment + ` END bmp2cpp ` + name + `[ \t]*(\r?\n|$)`)
+
+	data := bytes.TrimRight(out.Data, "\n")
+	data = append(data, '\n')
+	var block bytes.Buffer
+	block.WriteString(fmt.Sprintf("%s BEGIN bmp2cpp %s crc32=%08x\n", appendComment(out.Name), out.Name, crc32.ChecksumIEEE(data)))
+	block.Write(data)
+	block.WriteString(fmt.Sprintf("%s END bmp2cpp %s\n", appendComment(out.Name), out.Name))
+
+	begin := beginPtn.FindSubmatchIndex(src)
+	if begin == nil {
+		var result []byte
+		result = append(result, src...)
+		if len(result) > 0 && result[len(result)-1] != '\n' {
+			result = append(result, '\n')
+		}
+		if len(result) > 0 {
+			result = append(result, '\n')
+		}
+		return append(result, block.Bytes()...), nil
+	}
+
+	end := endPtn.FindIndex(src[begin[1]:])
+	if end == nil {
+		return nil, fmt.Errorf("block %q has no end marker", out.Name)
+	}
+	content := src[begin[1] : begin[1]+end[0]]
+	want, _ := strconv.ParseUint(string(src[begin[2]:begin[3]]), 16, 32)
+	if crc32.ChecksumIEEE(content) != uint32(want) {
+		return nil, fmt.Errorf("block %q was edited by hand since it was generated, remove its markers to replace it", out.Name)
+	}
+
+	var result []byte
+	result = append(result, src[:begin[0]]...)
+	result = append(result, block.Bytes()...)
+	return append(result, src[begin[1]+end[1]:]...), nil
+}
@@ -0,0 +1,114 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// renderArduino renders the image as a PROGMEM array for Arduino sketches, with its
+// size as '#define's in the style of the Arduino display libraries. Values are read
+// back with pgm_read_byte.
+//
+// If gen.PackBits is 1, the array uses the format expected by Adafruit_GFX's
+// drawBitmap: one bit per pixel, most significant first, each row starting on a byte
+// boundary. Unpacked 8 bit values suit drawGrayscaleBitmap.
+func renderArduino(renderCtx *renderContext, out *bytes.Buffer) error {
+	gen := renderCtx.gen
+	pal := renderCtx.palette
+	name := gen.VarName
+	macro := strings.ToUpper(name)
+	l := renderCtx.layout
+	width, height := l.logicalSize()
+
+	if gen.Unpack {
+		return fmt.Errorf("-unpack is not supported by the arduino renderer")
+	}
+
+	var packed []byte
+	var rowBytes int
+	if gen.PackBits > 0 && gen.PackBits < 8 {
+		var err error
+		if packed, rowBytes, err = renderCtx.packRows(gen.PackBits); err != nil {
+			return err
+		}
+	}
+
+	out.WriteString("#include <Arduino.h>\n\n")
+
+	if packed == nil && !renderCtx.literal {
+		for intensity := range renderCtx.paletteIndexes {
+			out.WriteString(fmt.Sprintf("#define %c %d\n",
+				pal.IntensityRune[intensity],
+				pal.IntensityIndex[intensity]+uint8(gen.PaletteOffset)))
+		}
+		out.WriteByte('\n')
+	} else if !renderCtx.literal {
+		out.WriteString(fmt.Sprintf("// Values: %s\n", renderCtx.charDefs()))
+	}
+	if packed != nil {
+		out.WriteString(fmt.Sprintf("// %d bits per value, most significant first. Each row starts on a byte boundary.\n", gen.PackBits))
+	}
+
+	if comment := l.orderComment(name); comment != "" {
+		out.WriteString("// " + comment + "\n")
+	}
+	out.WriteString(fmt.Sprintf("#define %s_WIDTH %d\n", macro, width))
+	out.WriteString(fmt.Sprintf("#define %s_HEIGHT %d\n", macro, height))
+	if l.described() {
+		out.WriteString(fmt.Sprintf("#define %s_STRIDE %d\n", macro, l.stride))
+		out.WriteString(fmt.Sprintf("#define %s_SIZE %d\n", macro, l.size))
+	}
+	if l.order == "vertical-bytes" {
+		out.WriteString(fmt.Sprintf("#define %s_PAGES %d\n", macro, l.height))
+	}
+	if packed != nil {
+		out.WriteString(fmt.Sprintf("#define %s_ROW_BYTES %d\n", macro, rowBytes))
+	}
+	out.WriteByte('\n')
+
+	if err := renderCtx.writeColorTableCPP(out); err != nil {
+		return err
+	}
+	renderCtx.writeRevCPP(out)
+	renderCtx.writeSrcSizeCPP(out)
+	renderCtx.writeFramesCPP(out)
+	if err := renderCtx.writeGlyphsCPP(out); err != nil {
+		return err
+	}
+
+	out.WriteString(renderCtx.alignasCPP())
+	out.WriteString(fmt.Sprintf("const uint8_t %s[] PROGMEM = {\n", name))
+	if packed != nil {
+		for y := 0; y < l.height; y++ {
+			for _, line := range renderCtx.wrapRow(packed[y*rowBytes : (y+1)*rowBytes]) {
+				out.WriteString("    ")
+				for _, b := range line {
+					out.WriteString(fmt.Sprintf("0x%02x,", b))
+				}
+				out.WriteByte('\n')
+			}
+		}
+	} else {
+		renderCtx.eachRow(func(row []uint8) {
+			for _, line := range renderCtx.wrapRow(row) {
+				out.WriteString("    ")
+				for _, px := range line {
+					renderCtx.writePixel(out, px)
+					out.WriteByte(',')
+				}
+				out.WriteByte('\n')
+			}
+		})
+	}
+	out.WriteString("};\n\n")
+
+	if packed == nil && !renderCtx.literal {
+		for intensity := range renderCtx.paletteIndexes {
+			out.WriteString(fmt.Sprintf("#undef %c\n", pal.IntensityRune[intensity]))
+		}
+		out.WriteByte('\n')
+	}
+
+	return nil
+}
@@ -0,0 +1,405 @@
+package bitmap
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"strings"
+)
+
+const (
+	aseChunkOldPalette = 0x0004
+	aseChunkLayer      = 0x2004
+	aseChunkCel        = 0x2005
+	aseChunkPalette    = 0x2019
+
+	aseCelRaw        = 0
+	aseCelLinked     = 1
+	aseCelCompressed = 2
+)
+
+type aseLayer struct {
+	name    string
+	visible bool
+	level   int
+	group   bool
+	opacity uint8
+
+	// Names of the groups containing this layer, outermost first.
+	parents []string
+}
+
+type aseCel struct {
+	layer   int
+	x, y    int
+	opacity uint8
+	w, h    int
+	pixels  []byte
+	link    int
+	linked  bool
+}
+
+// asePaletted is an indexed Aseprite sprite. Its palette is in the authored order,
+// which Generator keeps rather than sorting the palette by intensity.
+type asePaletted struct{ *image.Paletted }
+
+// SubImage returns the part of the sprite within r, so areas of a sprite keep its
+// palette order too.
+func (p asePaletted) SubImage(r image.Rectangle) image.Image {
+	return asePaletted{p.Paletted.SubImage(r).(*image.Paletted)}
+}
+
+// decodeAseprite decodes a frame of an Aseprite (.ase/.aseprite) file by compositing
+// its visible layers. If layers is not empty, only layers with those names (or inside
+// groups with those names) are included, whether or not they are visible.
+//
+// Indexed sprites are returned as an asePaletted using the authored palette, in the
+// authored order. Layer opacity and blend modes are ignored for indexed sprites, as
+// they are in Aseprite itself.
+func decodeAseprite(bts []byte, frame int, layers []string) (image.Image, error) {
+	le := binary.LittleEndian
+	if len(bts) < 128 || le.Uint16(bts[4:]) != 0xA5E0 {
+		return nil, fmt.Errorf("aseprite: invalid header")
+	}
+	frames := int(le.Uint16(bts[6:]))
+	width := int(le.Uint16(bts[8:]))
+	height := int(le.Uint16(bts[10:]))
+	depth := int(le.Uint16(bts[12:]))
+	layerOpacity := le.Uint32(bts[14:])&1 != 0
+	transparent := bts[28]
+	if frame < 0 || frame >= frames {
+		return nil, fmt.Errorf("aseprite: frame %d not found, file has %d frames", frame, frames)
+	}
+	if depth != 8 && depth != 16 && depth != 32 {
+		return nil, fmt.Errorf("aseprite: unsupported colour depth %d", depth)
+	}
+
+	var aseLayers []aseLayer
+	var palette color.Palette
+	var frameCels [][]aseCel
+
+	pos := 128
+	for f := 0; f < frames; f++ {
+		if pos+16 > len(bts) {
+			return nil, fmt.Errorf("aseprite: frame %d is truncated", f)
+		}
+		frameSize := int(le.Uint32(bts[pos:]))
+		if le.Uint16(bts[pos+4:]) != 0xF1FA || frameSize < 16 || pos+frameSize > len(bts) {
+			return nil, fmt.Errorf("aseprite: frame %d is invalid", f)
+		}
+		chunks := int(le.Uint16(bts[pos+6:]))
+		if n := int(le.Uint32(bts[pos+12:])); n != 0 {
+			chunks = n
+		}
+
+		var cels []aseCel
+		cpos := pos + 16
+		for c := 0; c < chunks; c++ {
+			if cpos+6 > pos+frameSize {
+				return nil, fmt.Errorf("aseprite: frame %d chunk %d is truncated", f, c)
+			}
+			chunkSize := int(le.Uint32(bts[cpos:]))
+			if chunkSize < 6 || cpos+chunkSize > pos+frameSize {
+				return nil, fmt.Errorf("aseprite: frame %d chunk %d is invalid", f, c)
+			}
+			data := bts[cpos+6 : cpos+chunkSize]
+
+			var err error
+			switch le.Uint16(bts[cpos+4:]) {
+			case aseChunkLayer:
+				var layer aseLayer
+				if layer, err = aseReadLayer(data, aseLayers); err == nil {
+					aseLayers = append(aseLayers, layer)
+				}
+			case aseChunkCel:
+				var cel aseCel
+				if cel, err = aseReadCel(data, depth); err == nil && cel.layer >= 0 {
+					cels = append(cels, cel)
+				}
+			case aseChunkPalette:
+				palette, err = aseReadPalette(data, palette)
+			case aseChunkOldPalette:
+				if len(palette) == 0 {
+					palette, err = aseReadOldPalette(data)
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("aseprite: frame %d: %w", f, err)
+			}
+			cpos += chunkSize
+		}
+		frameCels = append(frameCels, cels)
+		pos += frameSize
+	}
+
+	include := func(layer aseLayer) bool {
+		if len(layers) == 0 {
+			return layer.visible
+		}
+		for _, name := range append([]string{layer.name}, layer.parents...) {
+			for _, want := range layers {
+				if name == want {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	var found = map[string]bool{}
+	for _, layer := range aseLayers {
+		found[layer.name] = true
+	}
+	for _, want := range layers {
+		if !found[want] {
+			return nil, fmt.Errorf("aseprite: layer %q not found", want)
+		}
+	}
+
+	// Cels are composited in layer order, from the bottom up:
+	cels := make([]*aseCel, len(aseLayers))
+	for idx := range frameCels[frame] {
+		cel := &frameCels[frame][idx]
+		if cel.linked {
+			if cel.link < 0 || cel.link >= len(frameCels) {
+				return nil, fmt.Errorf("aseprite: cel links to missing frame %d", cel.link)
+			}
+			for lidx := range frameCels[cel.link] {
+				if linked := &frameCels[cel.link][lidx]; linked.layer == cel.layer && !linked.linked {
+					cel = linked
+					break
+				}
+			}
+		}
+		if cel.layer < len(cels) && !cel.linked {
+			cels[cel.layer] = cel
+		}
+	}
+
+	bounds := image.Rect(0, 0, width, height)
+	if depth == 8 {
+		if len(palette) == 0 {
+			return nil, fmt.Errorf("aseprite: indexed sprite has no palette")
+		}
+		// Match what Aseprite exports, where the transparent index is fully transparent:
+		palette = append(color.Palette{}, palette...)
+		if int(transparent) < len(palette) {
+			c := color.NRGBAModel.Convert(palette[transparent]).(color.NRGBA)
+			c.A = 0
+			palette[transparent] = c
+		}
+		out := image.NewPaletted(bounds, palette)
+		for i := range out.Pix {
+			out.Pix[i] = transparent
+		}
+		for lidx, cel := range cels {
+			if cel == nil || aseLayers[lidx].group || !include(aseLayers[lidx]) {
+				continue
+			}
+			for y := 0; y < cel.h; y++ {
+				for x := 0; x < cel.w; x++ {
+					px := cel.pixels[y*cel.w+x]
+					if px != transparent && int(px) < len(palette) {
+						out.SetColorIndex(cel.x+x, cel.y+y, px)
+					}
+				}
+			}
+		}
+		return asePaletted{out}, nil
+	}
+
+	out := image.NewNRGBA(bounds)
+	for lidx, cel := range cels {
+		layer := aseLayers[lidx]
+		if cel == nil || layer.group || !include(layer) {
+			continue
+		}
+		src := image.NewNRGBA(image.Rect(cel.x, cel.y, cel.x+cel.w, cel.y+cel.h))
+		for i := 0; i < cel.w*cel.h; i++ {
+			if depth == 32 {
+				copy(src.Pix[i*4:i*4+4], cel.pixels[i*4:i*4+4])
+			} else {
+				v, a := cel.pixels[i*2], cel.pixels[i*2+1]
+				copy(src.Pix[i*4:i*4+4], []byte{v, v, v, a})
+			}
+		}
+		opacity := uint16(cel.opacity)
+		if layerOpacity {
+			opacity = opacity * uint16(layer.opacity) / 255
+		}
+		mask := image.NewUniform(color.Alpha{uint8(opacity)})
+		draw.DrawMask(out, src.Bounds(), src, src.Bounds().Min, mask, image.Point{}, draw.Over)
+	}
+	return out, nil
+}
+
+func aseString(data []byte) (string, []byte, error) {
+	if len(data) < 2 {
+		return "", nil, fmt.Errorf("string is truncated")
+	}
+	n := int(binary.LittleEndian.Uint16(data))
+	if len(data) < 2+n {
+		return "", nil, fmt.Errorf("string is truncated")
+	}
+	return string(data[2 : 2+n]), data[2+n:], nil
+}
+
+func aseReadLayer(data []byte, prev []aseLayer) (aseLayer, error) {
+	le := binary.LittleEndian
+	if len(data) < 16 {
+		return aseLayer{}, fmt.Errorf("layer chunk is truncated")
+	}
+	layer := aseLayer{
+		visible: le.Uint16(data[0:])&1 != 0,
+		group:   le.Uint16(data[2:]) == 1,
+		level:   int(le.Uint16(data[4:])),
+		opacity: data[12],
+	}
+	var err error
+	if layer.name, _, err = aseString(data[16:]); err != nil {
+		return aseLayer{}, err
+	}
+
+	// The closest preceding layer with a lower child level is the parent group:
+	for i := len(prev) - 1; i >= 0 && layer.level > 0; i-- {
+		if prev[i].level == layer.level-1 && prev[i].group {
+			layer.parents = append(append([]string{}, prev[i].parents...), prev[i].name)
+			layer.visible = layer.visible && prev[i].visible
+			break
+		}
+	}
+	return layer, nil
+}
+
+func aseReadCel(data []byte, depth int) (aseCel, error) {
+	le := binary.LittleEndian
+	if len(data) < 16 {
+		return aseCel{}, fmt.Errorf("cel chunk is truncated")
+	}
+	cel := aseCel{
+		layer:   int(le.Uint16(data[0:])),
+		x:       int(int16(le.Uint16(data[2:]))),
+		y:       int(int16(le.Uint16(data[4:]))),
+		opacity: data[6],
+	}
+	celType := le.Uint16(data[7:])
+	data = data[16:]
+
+	switch celType {
+	case aseCelLinked:
+		if len(data) < 2 {
+			return aseCel{}, fmt.Errorf("linked cel is truncated")
+		}
+		cel.linked, cel.link = true, int(le.Uint16(data))
+		return cel, nil
+
+	case aseCelRaw, aseCelCompressed:
+		if len(data) < 4 {
+			return aseCel{}, fmt.Errorf("cel is truncated")
+		}
+		cel.w, cel.h = int(le.Uint16(data[0:])), int(le.Uint16(data[2:]))
+		data = data[4:]
+		if celType == aseCelCompressed {
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return aseCel{}, err
+			}
+			if data, err = io.ReadAll(zr); err != nil {
+				return aseCel{}, err
+			}
+		}
+		n := cel.w * cel.h * depth / 8
+		if len(data) < n {
+			return aseCel{}, fmt.Errorf("cel pixels are truncated")
+		}
+		cel.pixels = data[:n]
+		return cel, nil
+
+	default:
+		// Tilemap cels are not supported, so they are skipped:
+		return aseCel{layer: -1}, nil
+	}
+}
+
+func aseReadPalette(data []byte, palette color.Palette) (color.Palette, error) {
+	le := binary.LittleEndian
+	if len(data) < 20 {
+		return nil, fmt.Errorf("palette chunk is truncated")
+	}
+	size := int(le.Uint32(data[0:]))
+	first, last := int(le.Uint32(data[4:])), int(le.Uint32(data[8:]))
+	if size > 256 || first > last || last >= size {
+		return nil, fmt.Errorf("invalid palette range %d-%d of %d", first, last, size)
+	}
+	for len(palette) < size {
+		palette = append(palette, color.NRGBA{})
+	}
+	palette = palette[:size]
+
+	data = data[20:]
+	for i := first; i <= last; i++ {
+		if len(data) < 6 {
+			return nil, fmt.Errorf("palette chunk is truncated")
+		}
+		flags := le.Uint16(data)
+		palette[i] = color.NRGBA{data[2], data[3], data[4], data[5]}
+		data = data[6:]
+		if flags&1 != 0 {
+			var err error
+			if _, data, err = aseString(data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return palette, nil
+}
+
+func aseReadOldPalette(data []byte) (color.Palette, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("old palette chunk is truncated")
+	}
+	packets := int(binary.LittleEndian.Uint16(data))
+	data = data[2:]
+
+	palette := make(color.Palette, 0, 256)
+	for p := 0; p < packets; p++ {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("old palette chunk is truncated")
+		}
+		for i := 0; i < int(data[0]); i++ {
+			palette = append(palette, color.NRGBA{A: 255})
+		}
+		n := int(data[1])
+		if n == 0 {
+			n = 256
+		}
+		data = data[2:]
+		if len(data) < n*3 {
+			return nil, fmt.Errorf("old palette chunk is truncated")
+		}
+		for i := 0; i < n; i++ {
+			palette = append(palette, color.NRGBA{data[i*3], data[i*3+1], data[i*3+2], 255})
+		}
+		data = data[n*3:]
+	}
+	if len(palette) > 256 {
+		palette = palette[:256]
+	}
+	return palette, nil
+}
+
+// parseLayers splits a comma separated -layers value.
+func parseLayers(v string) []string {
+	var out []string
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
@@ -0,0 +1,53 @@
+package bitmap
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestAsepriteAuthoredPalette(t *testing.T) {
+	white := color.NRGBA{255, 255, 255, 255}
+	black := color.NRGBA{0, 0, 0, 255}
+	grey := color.NRGBA{128, 128, 128, 255}
+
+	for _, tc := range []struct {
+		name    string
+		palette color.Palette
+		pix     []uint8
+		indexes []uint8
+		warn    bool
+	}{
+		// Sorted by intensity, black would come first. The last sprite can't be
+		// quantized in order, as it uses more colours than the palette has chars:
+		{"authored-order", color.Palette{white, black}, []uint8{1, 0, 0, 1}, []uint8{0, 1}, false},
+		{"unused-entries", color.Palette{grey, white, grey, black}, []uint8{3, 1, 3, 1}, []uint8{1, 3}, false},
+		{"duplicate-colours", color.Palette{black, black}, []uint8{0, 1, 1, 0}, []uint8{0, 1}, false},
+		{"too-many-colours", color.Palette{white, grey, black}, []uint8{0, 1, 2, 0}, nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sprite := image.NewPaletted(image.Rect(0, 0, 2, 2), tc.palette)
+			copy(sprite.Pix, tc.pix)
+
+			g := NewGenerator()
+			g.Warnings = &Warnings{}
+			if err := g.Palette.Set("auto:2"); err != nil {
+				t.Fatal(err)
+			}
+			rc, err := g.process(asePaletted{sprite})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.indexes != nil && !reflect.DeepEqual(rc.paletteIndexes, tc.indexes) {
+				t.Fatalf("expected palette indexes %v, found %v", tc.indexes, rc.paletteIndexes)
+			}
+			if tc.indexes != nil && !reflect.DeepEqual(rc.img.Pix, tc.pix) {
+				t.Fatalf("expected pixels %v, found %v", tc.pix, rc.img.Pix)
+			}
+			if warned := len(g.Warnings.List()) > 0 && g.Warnings.List()[0].Kind == WarnAuthoredPalette; warned != tc.warn {
+				t.Fatalf("expected warning %t, found %v", tc.warn, g.Warnings.List())
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// renderAsm renders the image as assembler data directives under a '<var>:' label, for
+// retro targets where assets are included in assembler source. The directive defaults
+// to '.byte', but varies between assemblers, i.e. 'db' or 'defb' for Z80 and 'dc.b'
+// for 68k. Values are decimal, as hex syntax varies too.
+//
+// Each directive holds gen.AsmPerLine values, or one row if it is 0. If gen.PackBits is
+// set, the packed bytes are emitted instead, as described by packRows.
+func renderAsm(renderCtx *renderContext, out *bytes.Buffer) error {
+	gen := renderCtx.gen
+	name := gen.VarName
+	directive := gen.AsmDirective
+	if directive == "" {
+		directive = ".byte"
+	}
+	if gen.Unpack {
+		return fmt.Errorf("-unpack is not supported by the asm renderer")
+	}
+	if gen.AsmPerLine < 0 {
+		return fmt.Errorf("values per line must be >= 0, found %d", gen.AsmPerLine)
+	}
+
+	rows, bits, err := renderCtx.byteRows()
+	if err != nil {
+		return err
+	}
+
+	if !renderCtx.literal {
+		out.WriteString(fmt.Sprintf("; Values: %s\n", renderCtx.charDefs()))
+	}
+	width, height := renderCtx.layout.logicalSize()
+	out.WriteString(fmt.Sprintf("; %dx%d", width, height))
+	if renderCtx.layout.described() {
+		out.WriteString(fmt.Sprintf(", stride %d, size %d", renderCtx.layout.stride, renderCtx.layout.size))
+	}
+	if bits > 0 {
+		out.WriteString(fmt.Sprintf(", %d bits per value, most significant first, rows start on a byte boundary", bits))
+	}
+	out.WriteByte('\n')
+	if comment := renderCtx.layout.orderComment(name); comment != "" {
+		out.WriteString("; " + comment + "\n")
+	}
+
+	out.WriteString(name)
+	out.WriteString(":\n")
+	for _, row := range rows {
+		perLine := gen.AsmPerLine
+		if perLine == 0 {
+			perLine = gen.Wrap
+		}
+		if perLine == 0 {
+			perLine = len(row)
+		}
+		for start := 0; start < len(row); start += perLine {
+			end := start + perLine
+			if end > len(row) {
+				end = len(row)
+			}
+			out.WriteString("    ")
+			out.WriteString(directive)
+			out.WriteByte(' ')
+			for i, v := range row[start:end] {
+				if i > 0 {
+					out.WriteByte(',')
+				}
+				out.WriteString(strconv.Itoa(int(v)))
+			}
+			out.WriteByte('\n')
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"sort"
+)
+
+// atlasTask packs the image of each task into an atlas no wider than width pixels, or
+// a roughly square one if width is < 0, and returns a single task that builds the
+// whole atlas using gen. Unlike a sheet, frames of different sizes are packed without
+// padding them to a common cell size: they are placed tallest first in rows, starting
+// a new row when a frame doesn't fit in the current one. Frames are transformed and
+// rescaled as they are for sheetTask. The position of each frame, in task order, is
+// passed to the renderer in Generator.Frames, and its variable name, if they are
+// unique, in Generator.FrameNames.
+func atlasTask(tasks []buildTask, width int, gen *Generator) (buildTask, error) {
+	if len(tasks) == 0 {
+		return buildTask{}, fmt.Errorf("atlas has no frames")
+	}
+	frames, err := sheetFrames(tasks)
+	if err != nil {
+		return buildTask{}, err
+	}
+
+	var widest, area int
+	for _, frame := range frames {
+		size := frame.Bounds().Size()
+		area += size.X * size.Y
+		if size.X > widest {
+			widest = size.X
+		}
+	}
+	if width < 0 {
+		width = int(math.Ceil(math.Sqrt(float64(area))))
+		if width < widest {
+			width = widest
+		}
+	}
+
+	order := make([]int, len(frames))
+	for idx := range order {
+		order[idx] = idx
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return frames[order[i]].Bounds().Dy() > frames[order[j]].Bounds().Dy()
+	})
+
+	rects := make([]image.Rectangle, len(frames))
+	var at, used image.Point
+	var rowHeight int
+	for _, idx := range order {
+		size := frames[idx].Bounds().Size()
+		if size.X > width {
+			return buildTask{}, fmt.Errorf("%s is %d pixels wide, which does not fit in an atlas %d pixels wide", tasks[idx].gen.VarName, size.X, width)
+		}
+		if at.X+size.X > width {
+			at = image.Pt(0, at.Y+rowHeight)
+			rowHeight = 0
+		}
+		rects[idx] = image.Rectangle{Min: at, Max: at.Add(size)}
+		at.X += size.X
+		if size.Y > rowHeight {
+			rowHeight = size.Y
+		}
+		if at.X > used.X {
+			used.X = at.X
+		}
+	}
+	used.Y = at.Y + rowHeight
+
+	atlas := image.NewNRGBA(image.Rectangle{Max: used})
+	for idx, frame := range frames {
+		draw.Draw(atlas, rects[idx], frame, frame.Bounds().Min, draw.Src)
+	}
+
+	// Frames of an animated GIF share a name, so they are only in the frame table:
+	names := make([]string, len(frames))
+	seen := map[string]bool{}
+	for idx, task := range tasks {
+		if seen[task.gen.VarName] {
+			names = nil
+			break
+		}
+		seen[task.gen.VarName] = true
+		names[idx] = task.gen.VarName
+	}
+
+	gen = sheetGenerator(gen, rects)
+	gen.FrameNames = names
+	return buildTask{gen: gen, img: atlas}, nil
+}
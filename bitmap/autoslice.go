@@ -0,0 +1,120 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// AreaAutoSlice slices an image into an area for each sprite found in it, so irregular
+// sprite sheets can be converted without measuring each sprite. A sprite is a blob of
+// pixels which aren't background, connected horizontally, vertically or diagonally.
+// Fully transparent pixels, and pixels of the Background colour if it is set, are
+// background. Sprites become areas after the areas listed in the map, the grid and the
+// regions, in the order of their first pixel, from the top row down and left to right.
+//
+// If Trim is set, each area is trimmed to the bounds of its sprite. Otherwise every area
+// is the size of the largest sprite, centred on its own sprite but kept within the
+// image, so the frames of an animation line up. Untrimmed areas may include parts of
+// neighbouring sprites.
+//
+// Each sprite's variable name comes from the Name pattern, where '{index}' is replaced
+// with the sprite's number and '{var}' with the variable name. The default pattern is
+// '{var}_{index}'.
+type AreaAutoSlice struct {
+	Background string `json:"background,omitempty"`
+	Trim       bool   `json:"trim,omitempty"`
+	Name       string `json:"name,omitempty"`
+
+	Gen   *Generator `json:"gen,omitempty"`
+	Group string     `json:"group,omitempty"`
+}
+
+// spriteName returns the variable name of the sprite with the given index.
+func (as *AreaAutoSlice) spriteName(idx int) string {
+	pattern := as.Name
+	if pattern == "" {
+		pattern = "{var}_{index}"
+	}
+	return strings.NewReplacer(
+		"{index}", strconv.Itoa(idx),
+		"{var}", as.Gen.VarName,
+	).Replace(pattern)
+}
+
+// findSprites returns the bounds of every sprite in img, relative to its top left. See
+// AreaAutoSlice.
+func findSprites(img image.Image, background *color.NRGBA) []image.Rectangle {
+	colors := imageColors(img)
+	skip := func(idx int) bool {
+		return colors[idx].A == 0 || (background != nil && colors[idx] == *background)
+	}
+	always := func(a, b int) bool { return true }
+
+	_, rects := findBlobs(img.Bounds().Dx(), img.Bounds().Dy(), skip, always, true)
+	return rects
+}
+
+// expandAutoSlice appends an area for each sprite found in img, the input, if the map
+// auto slices it.
+func (im *ImageMap) expandAutoSlice(img image.Image) error {
+	as := im.AutoSlice
+	if as == nil {
+		return nil
+	}
+
+	var background *color.NRGBA
+	if as.Background != "" {
+		c, err := parseHexColor(as.Background)
+		if err != nil {
+			return mapFieldError("background", err)
+		}
+		background = &c
+	}
+
+	rects := findSprites(img, background)
+	if len(rects) == 0 {
+		return fmt.Errorf("no sprites found in the input")
+	}
+
+	if !as.Trim {
+		var size image.Point
+		for _, rect := range rects {
+			if rect.Dx() > size.X {
+				size.X = rect.Dx()
+			}
+			if rect.Dy() > size.Y {
+				size.Y = rect.Dy()
+			}
+		}
+		// The furthest an area of that size can be from the top left:
+		last := img.Bounds().Size().Sub(size)
+		for idx, rect := range rects {
+			at := rect.Min.Sub(size.Sub(rect.Size()).Div(2))
+			if at.X > last.X {
+				at.X = last.X
+			}
+			if at.Y > last.Y {
+				at.Y = last.Y
+			}
+			if at.X < 0 {
+				at.X = 0
+			}
+			if at.Y < 0 {
+				at.Y = 0
+			}
+			rects[idx] = image.Rectangle{Min: at, Max: at.Add(size)}
+		}
+	}
+
+	for idx, rect := range rects {
+		gen := as.Gen.Clone()
+		gen.VarName = as.spriteName(idx)
+		area := NewArea(rect, gen)
+		area.Group = as.Group
+		im.Areas = append(im.Areas, area)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// basicLineLength is the longest line renderBasic writes, which is the longest logical
+// line the C64 screen editor accepts.
+const basicLineLength = 80
+
+// basicMaxLine is the highest line number accepted by C64 BASIC. ZX Spectrum BASIC only
+// accepts up to 9999.
+const basicMaxLine = 63999
+
+// renderBasic renders the image as numbered BASIC DATA statements, starting at line
+// gen.BasicLine and counting up by gen.BasicStep. The first DATA statement holds the
+// width and height, so a program can 'READ W,H' before reading the values. Rows are
+// split across several statements if they don't fit on one line.
+//
+// Attribution and palette characters are written as REM statements, as BASIC has no
+// unnumbered comments. Like the rows, they are split across several statements if they
+// don't fit on one line.
+func renderBasic(renderCtx *renderContext, out *bytes.Buffer) error {
+	gen := renderCtx.gen
+	if gen.Unpack {
+		return fmt.Errorf("-unpack is not supported by the basic renderer")
+	}
+	if gen.BasicLine < 0 || gen.BasicStep <= 0 {
+		return fmt.Errorf("BASIC line numbers must start at >= 0 and increase by > 0, found %d and %d", gen.BasicLine, gen.BasicStep)
+	}
+	rows, bits, err := renderCtx.byteRows()
+	if err != nil {
+		return err
+	}
+
+	line := gen.BasicLine
+	writeLine := func(stmt string) {
+		out.WriteString(fmt.Sprintf("%d %s\n", line, stmt))
+		line += gen.BasicStep
+	}
+
+	// Long remarks are wrapped at the last space that fits, or mid word if there is
+	// none:
+	writeREM := func(text string) {
+		for {
+			limit := basicLineLength - len(strconv.Itoa(line)) - len(" REM ")
+			if len(text) <= limit {
+				writeLine("REM " + text)
+				return
+			}
+			cut := strings.LastIndexByte(text[:limit+1], ' ')
+			if cut <= 0 {
+				for cut = limit; !utf8.RuneStart(text[cut]); cut-- {
+				}
+			}
+			writeLine("REM " + strings.TrimRight(text[:cut], " "))
+			text = strings.TrimLeft(text[cut:], " ")
+		}
+	}
+
+	if header := renderCtx.headerComment(); header != "" {
+		for _, text := range strings.Split(strings.TrimRight(header, "\n"), "\n") {
+			writeREM(strings.ToUpper(text))
+		}
+	}
+	width, height := renderCtx.layout.logicalSize()
+	header := fmt.Sprintf("%s %dX%d", strings.ToUpper(gen.VarName), width, height)
+	if bits > 0 {
+		header += fmt.Sprintf(" %d BITS PER VALUE", bits)
+	}
+	writeREM(header)
+	if !renderCtx.literal {
+		writeREM(renderCtx.charDefs())
+	}
+	if renderCtx.layout.described() {
+		writeLine(fmt.Sprintf("DATA %d,%d,%d,%d", width, height, renderCtx.layout.stride, renderCtx.layout.size))
+	} else {
+		writeLine(fmt.Sprintf("DATA %d,%d", width, height))
+	}
+
+	for _, row := range rows {
+		var stmt strings.Builder
+		for _, v := range row {
+			num := strconv.Itoa(int(v))
+			if stmt.Len() > 0 && len(strconv.Itoa(line))+1+stmt.Len()+1+len(num) > basicLineLength {
+				writeLine(stmt.String())
+				stmt.Reset()
+			}
+			if stmt.Len() == 0 {
+				stmt.WriteString("DATA ")
+			} else {
+				stmt.WriteByte(',')
+			}
+			stmt.WriteString(num)
+		}
+		if stmt.Len() > 0 {
+			writeLine(stmt.String())
+		}
+	}
+
+	if last := line - gen.BasicStep; last > basicMaxLine {
+		return fmt.Errorf("last BASIC line number %d is over %d, use a lower -basic-line or -basic-step", last, basicMaxLine)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package bitmap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// budgetLargest is the number of the largest builds listed when a budget is exceeded.
+const budgetLargest = 5
+
+// dataSize returns the number of bytes of image data emitted for rc: the size of the
+// array, including padding, or of the packed rows if the values are packed.
+func (rc *renderContext) dataSize() int {
+	l := rc.layout
+	if bits := rc.gen.PackBits; bits > 0 && bits < 8 {
+		perByte := 8 / bits
+		return (l.stride + perByte - 1) / perByte * l.height
+	}
+	return l.size
+}
+
+// checkBudget returns an error if the image data of every build in results adds up to
+// more than budget bytes, listing the largest builds so it is clear where to start
+// cutting. If budget is 0, there is no limit.
+func checkBudget(budget int, results [][]Output) error {
+	if budget <= 0 {
+		return nil
+	}
+	var total int
+	var builds []Output
+	for _, outs := range results {
+		if len(outs) > 0 && outs[0].Size > 0 {
+			total += outs[0].Size
+			builds = append(builds, outs[0])
+		}
+	}
+	if total <= budget {
+		return nil
+	}
+
+	sort.SliceStable(builds, func(i, j int) bool { return builds[i].Size > builds[j].Size })
+	if len(builds) > budgetLargest {
+		builds = builds[:budgetLargest]
+	}
+	largest := make([]string, len(builds))
+	for idx, out := range builds {
+		largest[idx] = fmt.Sprintf("%s (%d)", out.VarName, out.Size)
+	}
+	return fmt.Errorf("%d bytes of image data is over the budget of %d bytes by %d; largest: %s",
+		total, budget, total-budget, strings.Join(largest, ", "))
+}
@@ -0,0 +1,196 @@
+package bitmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+const buildUsage = "build [options] <manifest.json>"
+
+// Manifest lists many conversions to run in one invocation.
+//
+// Paths are relative to the manifest file. Each job's Generator starts from the
+// defaults set by the command line flags, then Gen, then the job's own Gen.
+type Manifest struct {
+	Gen  json.RawMessage `json:"gen,omitempty"`
+	Jobs []ManifestJob   `json:"jobs"`
+}
+
+type ManifestJob struct {
+	Input  string          `json:"input,omitempty"`
+	Output string          `json:"output"`
+	Map    string          `json:"map,omitempty"`
+	Crop   string          `json:"crop,omitempty"`
+	Gen    json.RawMessage `json:"gen,omitempty"`
+}
+
+// runBuild builds every job in a manifest, running up to -j jobs at once.
+func runBuild(args []string) error {
+	var sizeRaw string
+	var parallel int
+	var strictWarnings bool
+	var reproducible bool
+	var shared bool
+	var singleHeader bool
+	var gen Generator
+
+	flags := flag.NewFlagSet("build", 0)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s\n", buildUsage)
+		flags.PrintDefaults()
+	}
+	registerGeneratorFlags(flags, &gen, &sizeRaw)
+	registerLogFlags(flags)
+	flags.IntVar(&parallel, "j", runtime.NumCPU(), "Number of jobs, and areas within each job, to run at once.")
+	flags.BoolVar(&strictWarnings, "strict-warnings", false, "Fail any job that produces warnings.")
+	flags.BoolVar(&reproducible, "reproducible", false, "Give archive entries the time in SOURCE_DATE_EPOCH, or the Unix epoch, instead of the current time.")
+	flags.BoolVar(&shared, "shared-palette", false, "Quantize the inputs of every job together first, and convert each job with the resulting palette, so a set of separate files shares the same index-to-colour meaning.")
+	flags.BoolVar(&singleHeader, "single-header", false, "Combine the C++ outputs of each job into one header which compiles on its own.")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := parseSize(sizeRaw, &gen); err != nil {
+		return &usageError{err: err}
+	}
+	if err := checkFlagChoices(&gen); err != nil {
+		return err
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	if flags.NArg() != 1 {
+		return usageErrorf("missing <manifest.json> arg")
+	}
+	manifestFile := flags.Arg(0)
+	jobs, err := loadManifest(manifestFile, &gen)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		job.parallel = parallel
+		job.strictWarnings = strictWarnings
+		job.reproducible = reproducible
+		job.singleHeader = singleHeader
+	}
+
+	if shared {
+		palette, err := jobsPalette(jobs)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			job.sharedPalette = palette
+		}
+	}
+
+	var wg sync.WaitGroup
+	var errs = make([]error, len(jobs))
+	var sem = make(chan struct{}, parallel)
+	for idx, job := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(idx int, job *convertJob) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if _, err := job.run(); err != nil {
+				errs[idx] = fmt.Errorf("job %d (%s): %w", idx, job.outFile, err)
+			}
+		}(idx, job)
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return &jobsError{failed, len(jobs)}
+	}
+	return nil
+}
+
+// loadManifest reads the manifest at path and returns a convertJob for each entry.
+func loadManifest(path string, defaults *Generator) ([]*convertJob, error) {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	var dec = json.NewDecoder(bytes.NewReader(bts))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %q: %w", path, err)
+	}
+
+	base := *defaults
+	if len(manifest.Gen) > 0 {
+		if err := decodeGenerator(manifest.Gen, &base); err != nil {
+			return nil, fmt.Errorf("invalid manifest gen: %w", err)
+		}
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	jobs := make([]*convertJob, len(manifest.Jobs))
+	for idx, mj := range manifest.Jobs {
+		if mj.Output == "" {
+			return nil, fmt.Errorf("invalid manifest job %d: missing output", idx)
+		}
+		if mj.Input == "" && mj.Map == "" {
+			return nil, fmt.Errorf("invalid manifest job %d: missing input or map", idx)
+		}
+
+		job := &convertJob{
+			gen:        base,
+			mapFile:    resolve(mj.Map),
+			outFile:    resolve(mj.Output),
+			warnPrefix: fmt.Sprintf("job %d (%s)", idx, mj.Output),
+		}
+		if mj.Input != "" {
+			job.args = []string{resolve(mj.Input)}
+		}
+		if len(mj.Gen) > 0 {
+			if err := decodeGenerator(mj.Gen, &job.gen); err != nil {
+				return nil, fmt.Errorf("invalid manifest job %d gen: %w", idx, err)
+			}
+		}
+		if job.crop, err = parseCrop(mj.Crop); err != nil {
+			return nil, fmt.Errorf("invalid manifest job %d: %w", idx, err)
+		}
+		jobs[idx] = job
+	}
+
+	return jobs, nil
+}
+
+// jobsPalette loads every area and variant of every job and quantizes them together,
+// for 'build -shared-palette'. Warnings are left for the second pass, which reports
+// them when each job is built with the palette.
+func jobsPalette(jobs []*convertJob) (color.Palette, error) {
+	var tasks []buildTask
+	for idx, job := range jobs {
+		_, jobTasks, err := job.loadTasks(nil)
+		if err != nil {
+			return nil, fmt.Errorf("job %d (%s): %w", idx, job.outFile, err)
+		}
+		tasks = append(tasks, jobTasks...)
+	}
+	return tasksPalette(tasks)
+}
@@ -0,0 +1,154 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// canvasAnchors lists the values of Generator.CanvasAnchor.
+var canvasAnchors = []string{"center", "top", "bottom", "left", "right", "top-left", "top-right", "bottom-left", "bottom-right"}
+
+// padding is the number of pixels added to each side of an image.
+type padding struct {
+	left, top, right, bottom int
+}
+
+// parsePad parses padding in '<left>,<top>,<right>,<bottom>' format, or a single
+// number of pixels for every side. If v is empty, there is no padding.
+func parsePad(v string) (padding, error) {
+	if v == "" {
+		return padding{}, nil
+	}
+	bits := strings.Split(v, ",")
+	if len(bits) != 1 && len(bits) != 4 {
+		return padding{}, fmt.Errorf("invalid padding %q, expected '<l>,<t>,<r>,<b>' or a single value", v)
+	}
+	var sides [4]int
+	for idx := range sides {
+		bit := bits[0]
+		if len(bits) == 4 {
+			bit = bits[idx]
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(bit))
+		if err != nil || n < 0 {
+			return padding{}, fmt.Errorf("invalid padding %q, expected '<l>,<t>,<r>,<b>' or a single value", v)
+		}
+		sides[idx] = n
+	}
+	return padding{sides[0], sides[1], sides[2], sides[3]}, nil
+}
+
+// parseCanvas parses a canvas size in '<w>x<h>' format. If v is empty, the size is
+// zero.
+func parseCanvas(v string) (image.Point, error) {
+	if v == "" {
+		return image.Point{}, nil
+	}
+	var size image.Point
+	if _, err := fmt.Sscanf(v, "%dx%d", &size.X, &size.Y); err != nil || size.X <= 0 || size.Y <= 0 {
+		return image.Point{}, fmt.Errorf("invalid canvas size %q, expected '<w>x<h>'", v)
+	}
+	return size, nil
+}
+
+// anchorOffset returns where an image is placed on a canvas with space pixels more
+// than the image in each dimension, which is negative if the image is cropped.
+func anchorOffset(anchor string, space image.Point) (image.Point, error) {
+	var at image.Point
+	switch anchor {
+	case "", "center":
+		at = space.Div(2)
+	case "top":
+		at = image.Pt(space.X/2, 0)
+	case "bottom":
+		at = image.Pt(space.X/2, space.Y)
+	case "left":
+		at = image.Pt(0, space.Y/2)
+	case "right":
+		at = image.Pt(space.X, space.Y/2)
+	case "top-left":
+	case "top-right":
+		at = image.Pt(space.X, 0)
+	case "bottom-left":
+		at = image.Pt(0, space.Y)
+	case "bottom-right":
+		at = space
+	default:
+		return image.Point{}, unknownChoice("anchor", anchor, canvasAnchors)
+	}
+	return at, nil
+}
+
+// checkCanvas returns an error if g.Pad, g.Canvas or g.CanvasAnchor are invalid, or
+// used with options they don't work with.
+func (g *Generator) checkCanvas() error {
+	if _, err := parsePad(g.Pad); err != nil {
+		return err
+	}
+	if _, err := parseCanvas(g.Canvas); err != nil {
+		return err
+	}
+	if _, err := anchorOffset(g.CanvasAnchor, image.Point{}); err != nil {
+		return err
+	}
+	if (g.Pad != "" || g.Canvas != "") && (len(g.Frames) > 0 || g.Glyphs > 0) {
+		return fmt.Errorf("-pad and -canvas cannot be used with sprite sheets or glyph strips")
+	}
+	return nil
+}
+
+// extendCanvas returns palimg with g.Pad added to each side, then placed on a canvas
+// of g.Canvas pixels at g.CanvasAnchor, if set. New pixels are set to the palette index
+// for intensity g.PadFill. If the canvas is smaller than the image, the image is
+// cropped.
+func (g *Generator) extendCanvas(
+	palimg *image.Paletted,
+	paletteIndexes *[]uint8,
+	paletteIndexToChar *[256]rune,
+	pal *Palette,
+) (*image.Paletted, error) {
+	pad, err := parsePad(g.Pad)
+	if err != nil {
+		return nil, err
+	}
+	canvas, err := parseCanvas(g.Canvas)
+	if err != nil {
+		return nil, err
+	}
+	if pad == (padding{}) && canvas == (image.Point{}) {
+		return palimg, nil
+	}
+
+	bounds := palimg.Bounds()
+	size := bounds.Size().Add(image.Pt(pad.left+pad.right, pad.top+pad.bottom))
+	offset := image.Pt(pad.left, pad.top)
+	if canvas != (image.Point{}) {
+		at, err := anchorOffset(g.CanvasAnchor, canvas.Sub(size))
+		if err != nil {
+			return nil, err
+		}
+		if canvas.X < size.X || canvas.Y < size.Y {
+			g.Warnings.Add(WarnCanvasCrop, "%s: %dx%d image is cropped to fit the %dx%d canvas",
+				g.VarName, size.X, size.Y, canvas.X, canvas.Y)
+		}
+		offset, size = offset.Add(at), canvas
+	}
+
+	fill, err := ensureIntensity(palimg, paletteIndexes, paletteIndexToChar, pal, g.PadFill)
+	if err != nil {
+		return nil, fmt.Errorf("%s: -pad-fill: %w", g.VarName, err)
+	}
+	out := image.NewPaletted(image.Rectangle{Max: size}, palimg.Palette)
+	for idx := range out.Pix {
+		out.Pix[idx] = fill
+	}
+	dst := bounds.Sub(bounds.Min).Add(offset).Intersect(out.Bounds())
+	for y := dst.Min.Y; y < dst.Max.Y; y++ {
+		for x := dst.Min.X; x < dst.Max.X; x++ {
+			out.SetColorIndex(x, y, palimg.ColorIndexAt(bounds.Min.X+x-offset.X, bounds.Min.Y+y-offset.Y))
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,142 @@
+package bitmap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// scalerNames lists every value of -scaler accepted by findScaler, in the order
+// they're documented.
+var scalerNames = []string{"nn", "approxbilinear", "bilinear", "catmullrom", "edge", "lanczos2", "lanczos3", "mitchell", cubicScalerPrefix + "<B>,<C>"}
+
+// checkScaler returns an error listing the valid scalers if findScaler doesn't accept
+// name.
+func checkScaler(name string) error {
+	if findScaler(name) != nil {
+		return nil
+	}
+	if strings.HasPrefix(name, cubicScalerPrefix) {
+		_, err := parseCubicScaler(name)
+		return err
+	}
+	return unknownChoice("scaler", name, scalerNames)
+}
+
+// ditherNames lists every value of -dither, in the order they're documented.
+var ditherNames = []string{"none", "floyd-steinberg"}
+
+// checkDither returns an error listing the valid dithers if name isn't one of
+// ditherNames. An empty name is 'none'.
+func checkDither(name string) error {
+	for _, valid := range ditherNames {
+		if name == "" || name == valid {
+			return nil
+		}
+	}
+	return unknownChoice("dither", name, ditherNames)
+}
+
+// checkRenderer returns an error listing the valid renderers if name is not a
+// registered renderer, rustbin or an exec renderer.
+func checkRenderer(name string) error {
+	if _, ok := lookupRenderer(name); ok || name == "rustbin" {
+		return nil
+	}
+	names := []string{"rustbin"}
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return unknownChoice("renderer", name, append(names, execRendererPrefix+"<command>"))
+}
+
+// checkFlagChoices returns a usage error if the scaler, dither or renderer, set by
+// flags or a config file, is unknown, so a typo is reported before any work is done.
+func checkFlagChoices(gen *Generator) error {
+	if err := checkScaler(gen.Scaler); err != nil {
+		return &usageError{err: fmt.Errorf("-scaler: %w", err)}
+	}
+	if err := checkDither(gen.Dither); err != nil {
+		return &usageError{err: fmt.Errorf("-dither: %w", err)}
+	}
+	if err := checkRenderer(gen.Renderer); err != nil {
+		return &usageError{err: fmt.Errorf("-renderer: %w", err)}
+	}
+	return nil
+}
+
+// unknownChoice returns an error saying name is not a valid kind, suggesting the
+// closest of valid if there is one which is close enough to be a typo, and listing
+// them all.
+func unknownChoice(kind, name string, valid []string) error {
+	msg := fmt.Sprintf("unknown %s %q.", kind, name)
+	if suggestion := closestChoice(name, valid); suggestion != "" {
+		msg = fmt.Sprintf("unknown %s %q, did you mean %q?", kind, name, suggestion)
+	}
+	return fmt.Errorf("%s Valid %ss: %s", msg, kind, strings.Join(valid, ", "))
+}
+
+// closestChoice returns the choice which name differs from only by case, or which it
+// is the only prefix of, or the one with the smallest edit distance from name if that
+// is small enough to be a typo. Otherwise it returns "".
+func closestChoice(name string, choices []string) string {
+	lower := strings.ToLower(strings.TrimSpace(name))
+	if lower == "" {
+		return ""
+	}
+
+	var prefixed []string
+	for _, choice := range choices {
+		if strings.ToLower(choice) == lower {
+			return choice
+		}
+		if strings.HasPrefix(strings.ToLower(choice), lower) {
+			prefixed = append(prefixed, choice)
+		}
+	}
+	if len(prefixed) == 1 {
+		return prefixed[0]
+	}
+
+	// Up to a third of the name, plus one, may differ:
+	best, bestDist := "", len(lower)/3+2
+	for _, choice := range choices {
+		if dist := editDistance(lower, strings.ToLower(choice)); dist < bestDist {
+			best, bestDist = choice, dist
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(br)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
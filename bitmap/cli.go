@@ -0,0 +1,604 @@
+package bitmap
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const flatUsage = "[options] <input>"
+
+// command is a subcommand, run with the arguments after its name.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string) error
+}
+
+// commands lists every subcommand. Running bmp2cpp without one uses the flat set of
+// every flag; see runFlat.
+var commands = []command{
+	{"convert", convertUsage, "Convert a single image.", runConvert},
+	{"map", mapUsage, "Convert the areas of an image described by an image map in JSON, YAML or TOML.", runMap},
+	{"grid", gridUsage, "Convert each cell of an image sliced into a grid of equally sized cells.", runGrid},
+	{"regions", regionsUsage, "Convert each region marked in a colour on a second image of the same size.", runRegions},
+	{"font", fontUsage, "Convert each glyph of a font strip or TrueType or OpenType font, and emit a table of glyphs by char.", runFont},
+	{"preview", previewUsage, "Write the quantized image of each area as a PNG, without converting it.", runPreview},
+	{"palette", paletteUsage, "Print the colour each palette char was mapped to.", runPalette},
+	{"serve", serveUsage, "Serve the front end on an address, or requests from an editor on stdin/stdout.", runServeCommand},
+	{"build", buildUsage, "Run every job in a manifest.", runBuild},
+	{"validate", validateUsage, "Check manifests, image maps and config files without building anything.", runValidate},
+	{"explore", exploreUsage, "Render a PNG grid comparing palette sizes, scalers and dithers.", runExplore},
+}
+
+func findCommand(name string) *command {
+	for idx := range commands {
+		if commands[idx].name == name {
+			return &commands[idx]
+		}
+	}
+	return nil
+}
+
+// printCommands writes the usage of bmp2cpp and of each subcommand to flags' output.
+func printCommands(flags *flag.FlagSet) {
+	w := flags.Output()
+	fmt.Fprintf(w, "Usage: %s %s\n", os.Args[0], flatUsage)
+	fmt.Fprintf(w, "       %s <command> [options] ...\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s help <command>' for a command's options.\n", os.Args[0])
+}
+
+// runHelp prints the list of commands, or the usage and options of the command named
+// in args.
+func runHelp(args []string) error {
+	if len(args) == 0 {
+		flags := flag.NewFlagSet("", 0)
+		flags.SetOutput(os.Stdout)
+		printCommands(flags)
+		return nil
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		return usageErrorf("unknown command %q", args[0])
+	}
+	if err := cmd.run([]string{"-h"}); err != nil && !errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return nil
+}
+
+// newCommandFlags returns the flag set for the named subcommand, which prints usage
+// and the options when -h is passed.
+func newCommandFlags(name, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, 0)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s\n", usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// cliOptions holds the values of the flags which are shared between the flat command
+// line and the subcommands, each of which registers only the groups it uses.
+type cliOptions struct {
+	gen        Generator
+	sizeRaw    string
+	configFile string
+	noConfig   bool
+	parallel   int
+
+	mapFile    string
+	outFile    string
+	cropRaw    string
+	crop       image.Rectangle
+	reportFile string
+	emitConfig string
+
+	appendOutput   bool
+	singleHeader   bool
+	reproducible   bool
+	strictWarnings bool
+
+	watch         bool
+	watchInterval time.Duration
+
+	sheet        int
+	atlas        int
+	paletteUnion bool
+	index        string
+
+	autoSlice     bool
+	autoSliceBg   string
+	autoSliceTrim bool
+}
+
+func newCLIOptions() *cliOptions {
+	return &cliOptions{parallel: runtime.NumCPU()}
+}
+
+// registerGenFlags adds the generator options, and the config file flags which set
+// their defaults.
+func (o *cliOptions) registerGenFlags(flags *flag.FlagSet) {
+	registerGeneratorFlags(flags, &o.gen, &o.sizeRaw)
+	registerLogFlags(flags)
+	flags.StringVar(&o.configFile, "config", "", "Config file containing generator defaults, as JSON or TOML. Default: the first bmp2cpp.json or bmp2cpp.toml found in the working directory or its parents.")
+	flags.BoolVar(&o.noConfig, "no-config", false, "Do not load a config file.")
+	flags.StringVar(&o.cropRaw, "crop", "", "Crop the input to a single region before processing, in '<x>,<y>,<w>x<h>' format.")
+}
+
+// registerOutputFlags adds the flags which control where and how outputs are written.
+func (o *cliOptions) registerOutputFlags(flags *flag.FlagSet) {
+	flags.StringVar(&o.outFile, "o", "", "Output file. If it ends in .zip, .tar, .tar.gz or .tgz, each output is written as a separate archive entry. If it is a directory or ends in '/', each output is written as a separate file. Default: stdout")
+	flags.BoolVar(&o.appendOutput, "append", false, "Replace each output's marked block inside the existing -o file, or append one if there is none, rather than overwriting the file. Blocks edited by hand since they were generated are not replaced.")
+	flags.BoolVar(&o.singleHeader, "single-header", false, "Combine every C++ output into one header which compiles on its own, with a '#pragma once' and the includes the outputs need. Written to -o, or named after the map in an archive or directory.")
+	flags.BoolVar(&o.reproducible, "reproducible", false, "Make output byte for byte identical across runs by giving archive entries the time in SOURCE_DATE_EPOCH, or the Unix epoch, instead of the current time.")
+	flags.BoolVar(&o.strictWarnings, "strict-warnings", false, "Fail if any warnings are found.")
+	flags.StringVar(&o.reportFile, "report", "", "Write an HTML page showing each area's source, quantized preview, palette mapping and output sizes to this file.")
+	flags.StringVar(&o.emitConfig, "emit-config", "", "Write the resolved generator options, after applying the config file, flags and -map, to this file as JSON, for reuse as a config file or image map.")
+	flags.BoolVar(&o.watch, "watch", false, "Watch the input, map and any other referenced files, and regenerate the -o output whenever they change.")
+	flags.DurationVar(&o.watchInterval, "watch-interval", 500*time.Millisecond, "How often to check for changes when using -watch.")
+	flags.IntVar(&o.sheet, "sheet", 0, "Lay every area and variant, or every frame of an animated GIF, out in a grid this many frames wide and emit a single array with a table of frame rectangles. -1 for a roughly square grid.")
+	flags.IntVar(&o.atlas, "atlas", 0, "Pack every area and variant, or every frame of an animated GIF, into one atlas at most this many pixels wide, without padding frames to a common size, and emit a single array with the position of each as '<var>_<area>' rects. -1 for a roughly square atlas.")
+}
+
+// registerAreaFlags adds the flags which apply when building many areas at once.
+func (o *cliOptions) registerAreaFlags(flags *flag.FlagSet) {
+	flags.IntVar(&o.parallel, "j", runtime.NumCPU(), "Number of areas to build at once when using -map.")
+	flags.BoolVar(&o.paletteUnion, "palette-union", false, "Quantize every area and variant together to compute one palette, which is shared between them.")
+}
+
+// registerAutoSliceFlags adds the flags which convert each sprite found in the input
+// rather than the whole input.
+func (o *cliOptions) registerAutoSliceFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&o.autoSlice, "autoslice", false, "Convert each sprite found in the input, a connected blob of pixels which aren't background, as a separate area named '<var>_<index>', rather than the whole input.")
+	flags.StringVar(&o.autoSliceBg, "autoslice-bg", "", "With -autoslice, the colour of background pixels, as '#rrggbb'. Fully transparent pixels are always background.")
+	flags.BoolVar(&o.autoSliceTrim, "autoslice-trim", false, "With -autoslice, trim each sprite's area to its bounds, rather than making every area the size of the largest sprite so animation frames line up.")
+}
+
+// registerIndexFlag adds -index, for commands which build many areas into outputs.
+func (o *cliOptions) registerIndexFlag(flags *flag.FlagSet) {
+	flags.StringVar(&o.index, "index", "", "Also emit an index of every area and variant with this name: an 'enum class <name>' with tables of each one's data, size, width and height for C++, or an object mapping each variable name to its data and size for JS.")
+}
+
+// parse parses args into the registered flags, then applies the config file and
+// parses the values which need it.
+func (o *cliOptions) parse(flags *flag.FlagSet, args []string) error {
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if !o.noConfig {
+		if err := applyConfig(flags, o.configFile, &o.gen); err != nil {
+			return err
+		}
+	}
+	if err := parseSize(o.sizeRaw, &o.gen); err != nil {
+		return &usageError{err: err}
+	}
+	if o.autoSliceBg != "" {
+		if _, err := parseHexColor(o.autoSliceBg); err != nil {
+			return usageErrorf("-autoslice-bg: %w", err)
+		}
+	}
+	if o.autoSlice && o.mapFile != "" {
+		return usageErrorf("-autoslice cannot be used with -map; use the map's 'autoSlice' instead")
+	}
+	if err := checkFlagChoices(&o.gen); err != nil {
+		return err
+	}
+	var err error
+	if o.crop, err = parseCrop(o.cropRaw); err != nil {
+		return &usageError{err: err}
+	}
+	return nil
+}
+
+// job returns a job converting the inputs in args with the parsed options.
+func (o *cliOptions) job(args []string) *convertJob {
+	return &convertJob{
+		gen:     o.gen,
+		mapFile: o.mapFile,
+		outFile: o.outFile,
+		crop:    o.crop,
+		args:    args,
+		report:  o.reportFile,
+
+		parallel:       o.parallel,
+		strictWarnings: o.strictWarnings,
+		paletteUnion:   o.paletteUnion,
+		sheet:          o.sheet,
+		atlas:          o.atlas,
+		reproducible:   o.reproducible,
+		emitConfig:     o.emitConfig,
+		appendOutput:   o.appendOutput,
+		singleHeader:   o.singleHeader,
+		index:          o.index,
+		autoSlice:      o.autoSliceAreas(),
+	}
+}
+
+// autoSliceAreas returns the auto slicing of the input set by -autoslice, if any.
+func (o *cliOptions) autoSliceAreas() *AreaAutoSlice {
+	if !o.autoSlice {
+		return nil
+	}
+	return &AreaAutoSlice{Background: o.autoSliceBg, Trim: o.autoSliceTrim}
+}
+
+// runJob runs job once, or every time its files change if -watch is set.
+func (o *cliOptions) runJob(job *convertJob) error {
+	if o.watch {
+		if o.outFile == "" {
+			return usageErrorf("-watch requires -o")
+		}
+		return watchJob(job, o.watchInterval)
+	}
+	_, err := job.run()
+	return err
+}
+
+// runFlat runs the command line without a subcommand, where every option is available.
+func runFlat(args []string) error {
+	var lspLike bool
+	var daemon bool
+	var serveAddr string
+
+	o := newCLIOptions()
+	flags := flag.NewFlagSet("", 0)
+	flags.Usage = func() {
+		printCommands(flags)
+		fmt.Fprintf(flags.Output(), "\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	o.registerGenFlags(flags)
+	o.registerOutputFlags(flags)
+	o.registerAreaFlags(flags)
+	o.registerIndexFlag(flags)
+	o.registerAutoSliceFlags(flags)
+	flags.StringVar(&o.mapFile, "map", "", "Image map file (defines regions")
+	flags.BoolVar(&lspLike, "lsp-like", false, "Serve convert, preview and info requests as Content-Length framed JSON on stdin/stdout, for editor integrations. Other flags set the default options.")
+	flags.BoolVar(&daemon, "daemon", false, "Serve the same requests as -lsp-like as JSON-RPC 2.0 on stdin/stdout, one per line, keeping images decoded between requests. Other flags set the default options.")
+	flags.StringVar(&serveAddr, "serve", "", "Serve a page for converting dropped images on this address, i.e. 'localhost:8080'. Other flags set the default options; requests can't use options which read or write files or run commands.")
+	if err := o.parse(flags, args); err != nil {
+		return err
+	}
+
+	switch {
+	case lspLike:
+		return runStdio(os.Stdin, os.Stdout, o.gen, o.parallel)
+	case daemon:
+		return runDaemon(os.Stdin, os.Stdout, o.gen, o.parallel)
+	case serveAddr != "":
+		return runServe(serveAddr, o.gen, o.parallel)
+	}
+	return o.runJob(o.job(flags.Args()))
+}
+
+const convertUsage = "convert [options] <input>"
+
+func runConvert(args []string) error {
+	o := newCLIOptions()
+	flags := newCommandFlags("convert", convertUsage)
+	o.registerGenFlags(flags)
+	o.registerOutputFlags(flags)
+	o.registerAutoSliceFlags(flags)
+	if err := o.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageErrorf("missing <input> arg")
+	}
+	return o.runJob(o.job(flags.Args()))
+}
+
+const mapUsage = "map [options] <map.json> [<input>]\n       " + mapValidateUsage
+
+func runMap(args []string) error {
+	if len(args) > 0 && args[0] == "validate" {
+		return runMapValidate(args[1:])
+	}
+	o := newCLIOptions()
+	flags := newCommandFlags("map", mapUsage)
+	o.registerGenFlags(flags)
+	o.registerOutputFlags(flags)
+	o.registerAreaFlags(flags)
+	o.registerIndexFlag(flags)
+	if err := o.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return usageErrorf("expected <map.json> [<input>] args")
+	}
+	o.mapFile = flags.Arg(0)
+	return o.runJob(o.job(flags.Args()[1:]))
+}
+
+const gridUsage = "grid [options] -cell <w>x<h> <input>"
+
+// runGrid converts each cell of a grid, as an image map's grid does, without needing a
+// map. Unless -columns and -rows are set, the grid fills the input. Use -crop to start
+// the grid elsewhere than the input's top left.
+func runGrid(args []string) error {
+	var cellRaw string
+	var namesFile string
+	grid := &AreaGrid{}
+
+	o := newCLIOptions()
+	flags := newCommandFlags("grid", gridUsage)
+	o.registerGenFlags(flags)
+	o.registerOutputFlags(flags)
+	o.registerAreaFlags(flags)
+	o.registerIndexFlag(flags)
+	flags.StringVar(&cellRaw, "cell", "", "Size of each cell, in '<w>x<h>' format. Required.")
+	flags.IntVar(&grid.Columns, "columns", 0, "Number of columns. Default: as many as fit the input.")
+	flags.IntVar(&grid.Rows, "rows", 0, "Number of rows. Default: as many as fit the input.")
+	flags.IntVar(&grid.Gap, "gap", 0, "Space between cells, in pixels.")
+	flags.StringVar(&grid.Name, "name", "", "Variable name of each cell, where '{var}' is replaced with -var, and '{row}', '{col}' and '{index}' with the cell's position. Default: '{var}_{index}'.")
+	flags.StringVar(&namesFile, "names", "", "File with the variable name of each cell in row order, one per line. Cells named '-' are skipped.")
+	if err := o.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageErrorf("missing <input> arg")
+	}
+	if _, err := fmt.Sscanf(cellRaw, "%dx%d", &grid.W, &grid.H); err != nil || grid.W <= 0 || grid.H <= 0 {
+		return usageErrorf("-cell must be a size in '<w>x<h>' format, found %q", cellRaw)
+	}
+	if grid.Columns < 0 || grid.Rows < 0 || grid.Gap < 0 {
+		return usageErrorf("-columns, -rows and -gap must not be negative")
+	}
+	grid.NamesFile = namesFile
+
+	job := o.job(flags.Args())
+	job.grid = grid
+	return o.runJob(job)
+}
+
+const regionsUsage = "regions [options] <regions.png> <input>"
+
+// runRegions converts each region of an image marked on a region image, as an image
+// map's regions do, without needing a map.
+func runRegions(args []string) error {
+	regions := &AreaRegions{}
+
+	o := newCLIOptions()
+	flags := newCommandFlags("regions", regionsUsage)
+	o.registerGenFlags(flags)
+	o.registerOutputFlags(flags)
+	o.registerAreaFlags(flags)
+	o.registerIndexFlag(flags)
+	flags.StringVar(&regions.Background, "background", "", "Colour of pixels in the region image which are not part of any region, as '#rrggbb'. Fully transparent pixels never are.")
+	flags.StringVar(&regions.Name, "name", "", "Variable name of each region, where '{var}' is replaced with -var, '{index}' with the region's number and '{color}' with its colour, i.e. 'ff0000'. Default: '{var}_{index}'.")
+	if err := o.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usageErrorf("expected <regions.png> <input> args")
+	}
+	if regions.Background != "" {
+		if _, err := parseHexColor(regions.Background); err != nil {
+			return usageErrorf("-background: %w", err)
+		}
+	}
+	regions.Image = flags.Arg(0)
+
+	job := o.job(flags.Args()[1:])
+	job.regions = regions
+	return o.runJob(job)
+}
+
+const fontUsage = "font [options] (-cell <w>x<h> | -separator <colour>) <strip>\n       font [options] -px <size> <font.ttf|font.otf|font.ttc>"
+
+// runFont converts each glyph of a font strip, sliced into cells like grid does or
+// between separator columns, or of a TrueType or OpenType font rasterized at -px, into
+// its own array named '<var>_<char code>', then emits the font: tables of each glyph's
+// data, width and advance, indexed by char. Every glyph shares one palette, so values
+// mean the same in each.
+func runFont(args []string) error {
+	var cellRaw string
+	font := &fontStrip{}
+
+	o := newCLIOptions()
+	flags := newCommandFlags("font", fontUsage)
+	o.registerGenFlags(flags)
+	o.registerOutputFlags(flags)
+	o.registerAreaFlags(flags)
+	flags.StringVar(&cellRaw, "cell", "", "Size of each glyph cell, in '<w>x<h>' format. Cells are numbered along each row, and every glyph has the same width.")
+	flags.StringVar(&font.separator, "separator", "", "Colour of the columns between glyphs in a single row strip, as '#rrggbb', or 'auto' for the colour of the top left pixel. Each glyph is as wide as the columns between separators.")
+	flags.Float64Var(&font.px, "px", 0, "Size in pixels to rasterize a font file at. Glyphs are a line high, and their x offsets from the pen and the font's ascent are emitted too. Use -chars with 2 chars and -pack-bits 1 for 1 bit per pixel.")
+	flags.IntVar(&font.first, "first", 32, "Char code of the first glyph. Following glyphs are mapped to the following chars.")
+	flags.IntVar(&font.count, "count", 0, "Number of glyphs to use. Default: every glyph in a strip, or up to char 126 from a font file.")
+	flags.IntVar(&font.spacing, "spacing", 0, "Pixels between glyphs, added to each glyph's width, or a font file's advances, to get each advance.")
+	if err := o.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageErrorf("missing <input> arg")
+	}
+	switch {
+	case cellRaw != "" && font.separator != "":
+		return usageErrorf("-cell and -separator cannot be used together")
+	case cellRaw != "":
+		if _, err := fmt.Sscanf(cellRaw, "%dx%d", &font.cell.X, &font.cell.Y); err != nil || font.cell.X <= 0 || font.cell.Y <= 0 {
+			return usageErrorf("-cell must be a size in '<w>x<h>' format, found %q", cellRaw)
+		}
+	case font.separator != "" && font.separator != "auto":
+		if _, err := parseHexColor(font.separator); err != nil {
+			return usageErrorf("-separator: %w", err)
+		}
+	}
+	if font.count < 0 || font.spacing < 0 {
+		return usageErrorf("-count and -spacing must not be negative")
+	}
+	if o.sheet != 0 || o.atlas != 0 {
+		return usageErrorf("-sheet and -atlas cannot be used with fonts")
+	}
+
+	job := o.job(flags.Args())
+	job.font = font
+	job.paletteUnion = true
+	return o.runJob(job)
+}
+
+const previewUsage = "preview [options] -o <output.png|dir/|archive> <input>"
+
+// runPreview builds the input, or each area of the map, and writes the quantized image
+// of each as a PNG named after its variable, instead of the outputs.
+func runPreview(args []string) error {
+	o := newCLIOptions()
+	flags := newCommandFlags("preview", previewUsage)
+	o.registerGenFlags(flags)
+	o.registerAreaFlags(flags)
+	flags.StringVar(&o.mapFile, "map", "", "Image map file. Each area is previewed separately.")
+	flags.StringVar(&o.outFile, "o", "", "Output PNG, or if there are many areas, a directory or archive to write one PNG per area to. Required.")
+	if err := o.parse(flags, args); err != nil {
+		return err
+	}
+	if o.outFile == "" {
+		return usageErrorf("-o is required")
+	}
+
+	job := o.job(flags.Args())
+	warnings := &Warnings{}
+	build, err := job.build(warnings, true)
+	warnings.Report(os.Stderr, "")
+	if err != nil {
+		return err
+	}
+
+	var pngs []Output
+	for _, entry := range build.report {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, entry.Preview); err != nil {
+			return err
+		}
+		pngs = append(pngs, Output{Name: entry.Name + ".png", Data: buf.Bytes(), Binary: true})
+	}
+	if !separateOutputs(o.outFile) {
+		if len(pngs) != 1 {
+			return fmt.Errorf("%d areas were previewed, so -o must be a directory or archive", len(pngs))
+		}
+		return os.WriteFile(o.outFile, pngs[0].Data, 0644)
+	}
+	return writeOutputs(o.outFile, pngs)
+}
+
+const paletteUsage = "palette [options] <input>"
+
+// runPalette builds the input, or each area of the map, and prints the colour each
+// palette char was mapped to, as '<char> <value> <colour>' lines, as a list of hex
+// colours which can be passed to -fixed-palette, or as a GIMP palette.
+func runPalette(args []string) error {
+	var format string
+
+	o := newCLIOptions()
+	flags := newCommandFlags("palette", paletteUsage)
+	o.registerGenFlags(flags)
+	o.registerAreaFlags(flags)
+	flags.StringVar(&o.mapFile, "map", "", "Image map file. The palette of each area is printed separately, unless -palette-union is set.")
+	flags.StringVar(&o.outFile, "o", "", "Output file, or a directory or archive to write one palette per area to. Default: stdout")
+	flags.StringVar(&format, "format", "text", "Format. Values: text, hex (for -fixed-palette), gpl (GIMP palette).")
+	if err := o.parse(flags, args); err != nil {
+		return err
+	}
+	ext, ok := map[string]string{"text": ".txt", "hex": ".txt", "gpl": ".gpl"}[format]
+	if !ok {
+		return usageErrorf("unknown -format %q", format)
+	}
+
+	job := o.job(flags.Args())
+	warnings := &Warnings{}
+	build, err := job.build(warnings, true)
+	warnings.Report(os.Stderr, "")
+	if err != nil {
+		return err
+	}
+
+	var outs []Output
+	for _, entry := range build.report {
+		var buf bytes.Buffer
+		switch format {
+		case "text":
+			if len(build.report) > 1 {
+				buf.WriteString(entry.Name + ":\n")
+			}
+			for _, col := range entry.Palette {
+				fmt.Fprintf(&buf, "%c %3d %s\n", col.Char, col.Value, hexColor(col.Color))
+			}
+		case "hex":
+			hexes := make([]string, len(entry.Palette))
+			for idx, col := range entry.Palette {
+				hexes[idx] = hexColor(col.Color)
+			}
+			buf.WriteString(strings.Join(hexes, ",") + "\n")
+		case "gpl":
+			fmt.Fprintf(&buf, "GIMP Palette\nName: %s\n#\n", entry.Name)
+			for _, col := range entry.Palette {
+				fmt.Fprintf(&buf, "%3d %3d %3d\t%c\n", col.Color.R, col.Color.G, col.Color.B, col.Char)
+			}
+		}
+		outs = append(outs, Output{Name: entry.Name + ext, Data: bytes.TrimSuffix(buf.Bytes(), []byte("\n"))})
+	}
+	if format == "gpl" && len(outs) > 1 && !separateOutputs(o.outFile) {
+		return fmt.Errorf("%d areas have palettes, so -o must be a directory or archive", len(outs))
+	}
+	return writeOutputs(o.outFile, outs)
+}
+
+const serveUsage = "serve [options] [<addr>]"
+
+func runServeCommand(args []string) error {
+	var stdio string
+
+	o := newCLIOptions()
+	flags := newCommandFlags("serve", serveUsage)
+	o.registerGenFlags(flags)
+	flags.IntVar(&o.parallel, "j", runtime.NumCPU(), "Number of areas to build at once in each request.")
+	flags.StringVar(&stdio, "stdio", "", "Serve requests on stdin/stdout instead, for editor integrations. Values: lsp (Content-Length framed JSON), jsonrpc (JSON-RPC 2.0, one per line).")
+	if err := o.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return usageErrorf("expected at most one <addr> arg")
+	}
+
+	switch stdio {
+	case "lsp":
+		return runStdio(os.Stdin, os.Stdout, o.gen, o.parallel)
+	case "jsonrpc":
+		return runDaemon(os.Stdin, os.Stdout, o.gen, o.parallel)
+	case "":
+	default:
+		return usageErrorf("unknown -stdio %q", stdio)
+	}
+	addr := "localhost:8080"
+	if flags.NArg() == 1 {
+		addr = flags.Arg(0)
+	}
+	return runServe(addr, o.gen, o.parallel)
+}
+
+// writeOutputs writes outs to path, as the outputs of a job are written.
+func writeOutputs(path string, outs []Output) error {
+	w, err := openOutput(path, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, out := range outs {
+		if err := w.Write(out); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
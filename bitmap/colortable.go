@@ -0,0 +1,91 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// colorTable returns the colour of each emitted value, indexed by value, packed in the
+// generator's ColorTable format. Values below PaletteOffset, and values no palette
+// entry maps to, are 0.
+func (rc *renderContext) colorTable() (table []uint32, bits int, err error) {
+	gen := rc.gen
+	if gen.SDF {
+		return nil, 0, fmt.Errorf("colour tables are not supported with SDF output")
+	}
+
+	var pack func(c color.NRGBA) uint32
+	switch gen.ColorTable {
+	case "rgb888":
+		bits = 32
+		pack = func(c color.NRGBA) uint32 {
+			return uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+		}
+	case "rgb565":
+		bits = 16
+		pack = func(c color.NRGBA) uint32 {
+			return uint32(c.R>>3)<<11 | uint32(c.G>>2)<<5 | uint32(c.B>>3)
+		}
+	default:
+		return nil, 0, fmt.Errorf("unknown colour table format %q, expected rgb888 or rgb565", gen.ColorTable)
+	}
+
+	for _, v := range rc.paletteIndexes {
+		value := int(rc.paletteIndexToValue[v])
+		for len(table) <= value {
+			table = append(table, 0)
+		}
+		if int(v) < len(rc.img.Palette) {
+			table[value] = pack(color.NRGBAModel.Convert(rc.img.Palette[v]).(color.NRGBA))
+		}
+	}
+	return table, bits, nil
+}
+
+// colorTableValues returns the table as a comma separated list of hex literals.
+func colorTableValues(table []uint32, bits int) string {
+	var out strings.Builder
+	for i, v := range table {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(fmt.Sprintf("0x%0*x", bits/4, v))
+	}
+	return out.String()
+}
+
+// writeColorTableCPP writes the colour table as '<var>_palette', if one was requested.
+func (rc *renderContext) writeColorTableCPP(out *bytes.Buffer) error {
+	if rc.gen.ColorTable == "" {
+		return nil
+	}
+	table, bits, err := rc.colorTable()
+	if err != nil {
+		return err
+	}
+	out.WriteString(fmt.Sprintf("// %s colour of each value in %s\n", rc.gen.ColorTable, rc.gen.VarName))
+	out.WriteString(fmt.Sprintf("static const uint%d_t %s_palette[%d] = {%s};\n\n",
+		bits, rc.gen.VarName, len(table), colorTableValues(table, bits)))
+	return nil
+}
+
+// writeColorTableJS writes the colour table as an exported typed array named
+// '<var>_palette', if one was requested.
+func (rc *renderContext) writeColorTableJS(out *bytes.Buffer, esm bool) error {
+	if rc.gen.ColorTable == "" {
+		return nil
+	}
+	table, bits, err := rc.colorTable()
+	if err != nil {
+		return err
+	}
+	if esm {
+		out.WriteString(fmt.Sprintf("export const %s_palette", rc.gen.VarName))
+	} else {
+		out.WriteString(fmt.Sprintf("exports.%s_palette", rc.gen.VarName))
+	}
+	out.WriteString(fmt.Sprintf(" = new Uint%dArray([%s]);\n", bits, colorTableValues(table, bits)))
+	return nil
+}
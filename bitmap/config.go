@@ -0,0 +1,149 @@
+package bitmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configFileNames are the names of config files, in the order they are looked for in
+// each directory.
+var configFileNames = []string{"bmp2cpp.json", "bmp2cpp.toml"}
+
+// Config holds project-wide Generator defaults, loaded from a bmp2cpp.json file, or a
+// bmp2cpp.toml file with the same structure.
+//
+// Gen is applied first, followed by the Gen of every entry in Files whose Match glob
+// matches the input path (relative to the config file's directory) or the input's base
+// name. Flags passed on the command line take precedence over both.
+type Config struct {
+	Gen   json.RawMessage `json:"gen,omitempty"`
+	Files []FileConfig    `json:"files,omitempty"`
+
+	dir string
+}
+
+type FileConfig struct {
+	Match string          `json:"match"`
+	Gen   json.RawMessage `json:"gen"`
+}
+
+// findConfig searches dir and each of its parents for a config file named one of
+// configFileNames, returning its path, or an empty string if none is found.
+func findConfig(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, name := range configFileNames {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			} else if !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// isConfigFileName reports whether path is named one of configFileNames.
+func isConfigFileName(path string) bool {
+	for _, name := range configFileNames {
+		if filepath.Base(path) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfig loads the config file at path. TOML files, with a '.toml' extension, are
+// converted to JSON with mapJSON first.
+func loadConfig(path string) (*Config, error) {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		if bts, err = mapJSON(path, bts); err != nil {
+			return nil, fmt.Errorf("invalid config %q: %w", path, err)
+		}
+	}
+	var cfg Config
+	var dec = json.NewDecoder(bytes.NewReader(bts))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %q: %w", path, err)
+	}
+	cfg.dir = filepath.Dir(path)
+
+	for idx, file := range cfg.Files {
+		if _, err := filepath.Match(file.Match, ""); err != nil {
+			return nil, fmt.Errorf("invalid config %q: file %d has invalid match pattern: %w", path, idx, err)
+		}
+	}
+	return &cfg, nil
+}
+
+// Apply decodes the config's defaults, and any overrides matching input, onto gen.
+// input may be empty, in which case only the defaults are applied.
+func (cfg *Config) Apply(gen *Generator, input string) error {
+	if len(cfg.Gen) > 0 {
+		if err := decodeGenerator(cfg.Gen, gen); err != nil {
+			return fmt.Errorf("invalid config gen: %w", err)
+		}
+	}
+	if input == "" {
+		return nil
+	}
+
+	rel := input
+	if abs, err := filepath.Abs(input); err == nil {
+		if r, err := filepath.Rel(cfg.dir, abs); err == nil {
+			rel = r
+		}
+	}
+	rel = filepath.ToSlash(rel)
+	base := filepath.Base(input)
+
+	for idx, file := range cfg.Files {
+		relMatch, _ := filepath.Match(file.Match, rel)
+		baseMatch, _ := filepath.Match(file.Match, base)
+		if !relMatch && !baseMatch {
+			continue
+		}
+		if err := decodeGenerator(file.Gen, gen); err != nil {
+			return fmt.Errorf("invalid config file %d gen: %w", idx, err)
+		}
+	}
+	return nil
+}
+
+// decodeGenerator decodes the fields present in raw onto gen, leaving all other fields
+// untouched.
+func decodeGenerator(raw json.RawMessage, gen *Generator) error {
+	var dec = json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	return dec.Decode(gen)
+}
+
+// writeConfig writes gen to path as a config file, so the options of a working
+// invocation can be reused as a bmp2cpp.json, or as the gen of an image map. Paths in
+// gen are written as given, relative to the working directory.
+func writeConfig(path string, gen *Generator) error {
+	bts, err := json.MarshalIndent(struct {
+		Gen *Generator `json:"gen"`
+	}{gen}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(bts, '\n'), 0644)
+}
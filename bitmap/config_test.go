@@ -0,0 +1,68 @@
+package bitmap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigTOML(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sprites")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	toml := `
+[gen]
+renderer = "js"
+wrap = 16
+
+[[files]]
+match = "*.ase"
+gen = { invert = true }
+`
+	if err := os.WriteFile(filepath.Join(dir, "bmp2cpp.toml"), []byte(toml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := findConfig(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(dir, "bmp2cpp.toml"); path != expected {
+		t.Fatalf("expected %q, found %q", expected, path)
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen := NewGenerator()
+	if err := cfg.Apply(gen, filepath.Join(sub, "hero.ase")); err != nil {
+		t.Fatal(err)
+	}
+	if gen.Renderer != "js" || gen.Wrap != 16 || !gen.Invert {
+		t.Fatalf("expected renderer js, wrap 16 and invert, found %q, %d and %t", gen.Renderer, gen.Wrap, gen.Invert)
+	}
+
+	// A bmp2cpp.json in the same directory is preferred:
+	if err := os.WriteFile(filepath.Join(dir, "bmp2cpp.json"), []byte(`{"gen": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if path, err = findConfig(sub); err != nil {
+		t.Fatal(err)
+	} else if expected := filepath.Join(dir, "bmp2cpp.json"); path != expected {
+		t.Fatalf("expected %q, found %q", expected, path)
+	}
+
+	// Unknown fields are rejected, as they are in JSON:
+	bad := filepath.Join(dir, "bad.toml")
+	if err := os.WriteFile(bad, []byte("[gen]\nrendrer = 'js'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = loadConfig(bad); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Apply(NewGenerator(), ""); err == nil {
+		t.Fatalf("expected an error for the unknown field")
+	}
+}
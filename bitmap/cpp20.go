@@ -0,0 +1,81 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// cppStorages maps each value of gen.CPPStorage to the specifiers the cpp20 renderer
+// declares the array with. Inline variables have a single definition shared by every
+// translation unit which includes the header, while 'static' gives each one its own
+// copy, as the cpp and cpp17 renderers do.
+var cppStorages = map[string]string{
+	"":          "inline constinit const",
+	"constinit": "inline constinit const",
+	"constexpr": "inline constexpr",
+	"static":    "static const",
+}
+
+// renderCPP20 renders the image as a std::array built with std::to_array, declared with
+// the storage in gen.CPPStorage. Unless the values are literal, they are built in a
+// consteval lambda which scopes the char constants, so nothing of it is left at run
+// time. If gen.CPPSpan is set, a '<var>_span()' accessor returning a fixed extent
+// std::span of the array is emitted too.
+func renderCPP20(renderCtx *renderContext, out *bytes.Buffer) error {
+	gen := renderCtx.gen
+	storage := cppStorages[gen.CPPStorage]
+
+	renderCtx.writeLayoutCPP(out)
+	if err := renderCtx.writeColorTableCPP(out); err != nil {
+		return err
+	}
+	renderCtx.writeRevCPP(out)
+	renderCtx.writeSrcSizeCPP(out)
+	renderCtx.writeFramesCPP(out)
+	if err := renderCtx.writeGlyphsCPP(out); err != nil {
+		return err
+	}
+
+	szStr := renderCtx.layout.sizeExpr()
+	out.WriteString(renderCtx.alignasCPP())
+	out.WriteString(fmt.Sprintf("%s std::array<uint8_t, %s> %s = ", storage, szStr, gen.VarName))
+
+	indent := "    "
+	if !renderCtx.literal {
+		out.WriteString("[]() consteval {\n")
+		out.WriteString("    const uint8_t ")
+		out.WriteString(renderCtx.charDefs())
+		out.WriteString(";\n")
+		out.WriteString("    return ")
+		indent = "        "
+	}
+	out.WriteString("std::to_array<uint8_t>({\n")
+	renderCtx.eachRow(func(row []uint8) {
+		for _, line := range renderCtx.wrapRow(row) {
+			out.WriteString(indent)
+			for _, px := range line {
+				renderCtx.writePixel(out, px)
+				out.WriteByte(',')
+			}
+			out.WriteByte('\n')
+		}
+	})
+	if !renderCtx.literal {
+		out.WriteString("    });\n")
+		out.WriteString("}();\n\n")
+	} else {
+		out.WriteString("});\n\n")
+	}
+
+	if gen.CPPSpan {
+		// An inline accessor of a static array would differ between translation units:
+		linkage := "inline"
+		if gen.CPPStorage == "static" {
+			linkage = "static"
+		}
+		out.WriteString(fmt.Sprintf("%s constexpr std::span<const uint8_t, %s> %s_span() noexcept {\n", linkage, szStr, gen.VarName))
+		out.WriteString(fmt.Sprintf("    return %s;\n", gen.VarName))
+		out.WriteString("}\n\n")
+	}
+	return nil
+}
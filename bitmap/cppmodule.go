@@ -0,0 +1,57 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	cppModuleNamePtn = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+	// Exported declarations can't have internal linkage, so 'static' in the cpp17
+	// output is replaced with 'inline', which has external linkage.
+	cppStaticPtn = regexp.MustCompile(`(?m)^((?:alignas\(\d+\) )?)static (?:inline )?`)
+)
+
+// renderCPPModule renders the image as a C++20 module interface unit named gen.Module,
+// or the variable name if that is empty, which exports the same declarations as the
+// cpp17 renderer. Packing is supported.
+func renderCPPModule(renderCtx *renderContext, out *bytes.Buffer) error {
+	gen := renderCtx.gen
+	module := gen.Module
+	if module == "" {
+		module = gen.VarName
+	}
+	if !cppModuleNamePtn.MatchString(module) {
+		return fmt.Errorf("invalid module name %q, expected dot separated identifiers, i.e. 'assets.logo'", module)
+	}
+
+	var body bytes.Buffer
+	var err error
+	if gen.PackBits > 0 && gen.PackBits < 8 {
+		err = renderPackedCPP(renderCtx, &body)
+	} else {
+		err = renderCPP17(renderCtx, &body)
+	}
+	if err != nil {
+		return err
+	}
+
+	out.WriteString("module;\n\n")
+	out.WriteString("#include <array>\n")
+	out.WriteString("#include <cstddef>\n")
+	out.WriteString("#include <cstdint>\n\n")
+	out.WriteString(fmt.Sprintf("export module %s;\n\n", module))
+	out.WriteString("export {\n\n")
+	for _, line := range strings.Split(strings.TrimRight(cppStaticPtn.ReplaceAllString(body.String(), "${1}inline "), "\n"), "\n") {
+		if line != "" {
+			out.WriteString("    ")
+			out.WriteString(line)
+		}
+		out.WriteByte('\n')
+	}
+	out.WriteString("\n}\n")
+	return nil
+}
@@ -0,0 +1,204 @@
+package bitmap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+
+	// Returned when a request is valid but building it fails, i.e. because the input
+	// can't be decoded.
+	rpcBuildError = -32000
+)
+
+// rpcRequest is a single JSON-RPC 2.0 request in -daemon mode. Requests without an ID
+// are notifications, which are handled but not responded to.
+type rpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// runDaemon reads JSON-RPC 2.0 requests from r, one per line, until EOF or an 'exit'
+// request, writing each response to w as a single line as soon as it is done.
+// Requests are handled in order. The methods and their params are the same as
+// -lsp-like mode's; see runStdio.
+//
+// Images are kept decoded between requests, so converting the same image again with
+// different options, as an editor previewing changes to them does, only costs the
+// build.
+func runDaemon(r io.Reader, w io.Writer, gen Generator, parallel int) error {
+	reader := bufio.NewReader(r)
+	cache := &decodeCache{}
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("could not read request: %w", err)
+		}
+		eof := err == io.EOF
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if eof {
+				return nil
+			}
+			continue
+		}
+
+		var req rpcRequest
+		rsp := rpcResponse{Version: "2.0", ID: json.RawMessage("null")}
+		if err := json.Unmarshal(line, &req); err != nil {
+			rsp.Error = &rpcError{rpcParseError, err.Error()}
+		} else if req.Method == "exit" {
+			return nil
+		} else {
+			if req.ID != nil {
+				rsp.ID = req.ID
+			}
+			rsp.Result, rsp.Error = handleRPC(req, gen, parallel, cache)
+			if req.ID == nil {
+				continue
+			}
+		}
+
+		out, err := json.Marshal(rsp)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(out, '\n')); err != nil {
+			return err
+		}
+		if eof {
+			return nil
+		}
+	}
+}
+
+func handleRPC(req rpcRequest, gen Generator, parallel int, cache *decodeCache) (interface{}, *rpcError) {
+	if req.Version != "2.0" {
+		return nil, &rpcError{rpcInvalidRequest, fmt.Sprintf("unsupported jsonrpc version %q", req.Version)}
+	}
+	switch req.Method {
+	case "convert", "preview", "info":
+	default:
+		return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("unknown method %q", req.Method)}
+	}
+
+	sreq := stdioRequest{ID: req.ID, Method: req.Method}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &sreq.Params); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+	}
+	if sreq.Params.Input == "" {
+		return nil, &rpcError{rpcInvalidParams, "missing input"}
+	}
+	result, err := handleStdio(sreq, gen, parallel, cache)
+	if err != nil {
+		return nil, &rpcError{rpcBuildError, err.Error()}
+	}
+	return result, nil
+}
+
+// decodeCacheSize is the number of inputs a decodeCache holds.
+const decodeCacheSize = 16
+
+// decodeCache holds the most recently decoded inputs, keyed by their contents and
+// how they were decoded. The images must not be modified. A nil *decodeCache decodes
+// every time.
+type decodeCache struct {
+	mu      sync.Mutex
+	entries map[string]decodeCacheEntry
+	order   []string
+}
+
+// decodeCacheEntry is a decoded input and the warnings from decoding it, which are
+// added again each time it is used.
+type decodeCacheEntry struct {
+	imgs     []image.Image
+	warnings []Warning
+}
+
+// decode decodes the frames of the image read from the file called name, as
+// decodeFrames does if frames is set, otherwise as decodeBytes does, or returns the
+// frames already decoded from the same bytes with the same options.
+func (c *decodeCache) decode(name string, bts []byte, opts decodeOptions, frames bool) ([]image.Image, error) {
+	decode := func() ([]image.Image, error) {
+		if frames {
+			return decodeFrames(name, bts, opts)
+		}
+		img, err := decodeBytes(name, bts, opts)
+		return []image.Image{img}, err
+	}
+	if c == nil {
+		return decode()
+	}
+
+	// The name is only used if the format can't be sniffed, so only its extension
+	// matters. Where warnings go doesn't change the images:
+	keyOpts := opts
+	keyOpts.warnings = nil
+	key := fmt.Sprintf("%x %s %t %+v", sha256.Sum256(bts), strings.ToLower(filepath.Ext(name)), frames, keyOpts)
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok {
+		var warnings Warnings
+		target := opts.warnings
+		opts.warnings = &warnings
+		imgs, err := decode()
+		opts.warnings = target
+		if err != nil {
+			return nil, err
+		}
+		entry = decodeCacheEntry{imgs: imgs, warnings: warnings.List()}
+		c.store(key, entry)
+	}
+	for _, w := range entry.warnings {
+		opts.warnings.Add(w.Kind, "%s", w.Message)
+	}
+	return entry.imgs, nil
+}
+
+// store adds entry to the cache, removing the oldest entry if the cache is full.
+func (c *decodeCache) store(key string, entry decodeCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]decodeCacheEntry{}
+	}
+	if _, ok := c.entries[key]; !ok {
+		if len(c.order) >= decodeCacheSize {
+			delete(c.entries, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = entry
+}
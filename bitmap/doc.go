@@ -0,0 +1,7 @@
+// Package bitmap converts images into source code representing the bitmap and
+// palette, and is the whole of the bmp2cpp command, which calls Main.
+//
+// To convert images from Go, build a Generator with NewGenerator, or an ImageMap of
+// several areas with NewImageMap and AddArea, and call ImageMap.Build. Output formats
+// can be added with RegisterRenderer.
+package bitmap
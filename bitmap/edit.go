@@ -0,0 +1,165 @@
+package bitmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"strings"
+	"unicode/utf8"
+)
+
+// PixelEdit is a small correction applied to the quantized image, using palette
+// characters to refer to colours. Edits are written as:
+//
+//	set <x>,<y> <char>
+//	fill <x>,<y>,<w>x<h> <char>
+//	replace <from> <to>
+type PixelEdit struct {
+	Op   string
+	Rect image.Rectangle
+	From rune
+	To   rune
+}
+
+func (e PixelEdit) String() string {
+	switch e.Op {
+	case "set":
+		return fmt.Sprintf("set %d,%d %c", e.Rect.Min.X, e.Rect.Min.Y, e.To)
+	case "fill":
+		return fmt.Sprintf("fill %d,%d,%dx%d %c", e.Rect.Min.X, e.Rect.Min.Y, e.Rect.Dx(), e.Rect.Dy(), e.To)
+	default:
+		return fmt.Sprintf("replace %c %c", e.From, e.To)
+	}
+}
+
+func parsePixelEdit(v string) (PixelEdit, error) {
+	char := func(s string) (rune, error) {
+		r, n := utf8.DecodeRuneInString(s)
+		if n == 0 || n != len(s) {
+			return 0, fmt.Errorf("invalid edit %q: expected a single palette character, found %q", v, s)
+		}
+		return r, nil
+	}
+
+	fields := strings.Fields(v)
+	if len(fields) != 3 {
+		return PixelEdit{}, fmt.Errorf("invalid edit %q: expected 'set <x>,<y> <char>', 'fill <x>,<y>,<w>x<h> <char>' or 'replace <from> <to>'", v)
+	}
+
+	var e = PixelEdit{Op: fields[0]}
+	var err error
+	switch e.Op {
+	case "set":
+		var x, y int
+		if _, err := fmt.Sscanf(fields[1], "%d,%d", &x, &y); err != nil {
+			return PixelEdit{}, fmt.Errorf("invalid edit %q: %w", v, err)
+		}
+		e.Rect = image.Rect(x, y, x+1, y+1)
+	case "fill":
+		if e.Rect, err = parseCrop(fields[1]); err != nil {
+			return PixelEdit{}, fmt.Errorf("invalid edit %q: %w", v, err)
+		}
+	case "replace":
+		if e.From, err = char(fields[1]); err != nil {
+			return PixelEdit{}, err
+		}
+	default:
+		return PixelEdit{}, fmt.Errorf("invalid edit %q: unknown operation %q", v, e.Op)
+	}
+	if e.To, err = char(fields[2]); err != nil {
+		return PixelEdit{}, err
+	}
+	return e, nil
+}
+
+// PixelEdits is a list of edits, applied in order. As a flag, it may be repeated, and
+// each value may contain several edits separated by ';'.
+type PixelEdits []PixelEdit
+
+func (p *PixelEdits) Set(v string) error {
+	for _, part := range strings.Split(v, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		e, err := parsePixelEdit(part)
+		if err != nil {
+			return err
+		}
+		*p = append(*p, e)
+	}
+	return nil
+}
+
+func (p PixelEdits) String() string {
+	var parts []string
+	for _, e := range p {
+		parts = append(parts, e.String())
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (p PixelEdits) MarshalJSON() ([]byte, error) {
+	raw := make([]string, len(p))
+	for i, e := range p {
+		raw[i] = e.String()
+	}
+	return json.Marshal(raw)
+}
+
+func (p *PixelEdits) UnmarshalJSON(b []byte) error {
+	var raw []string
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*p = nil
+	for _, v := range raw {
+		if err := p.Set(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEdits applies g.Edits to palimg, in palimg's coordinates. Characters must be
+// mapped to a colour in the image's palette.
+func (g *Generator) applyEdits(palimg *image.Paletted, paletteIndexes []uint8, paletteIndexToChar [256]rune) error {
+	index := func(char rune) (uint8, error) {
+		for _, v := range paletteIndexes {
+			if paletteIndexToChar[v] == char {
+				return v, nil
+			}
+		}
+		return 0, fmt.Errorf("%s: edit uses character %q, which is not mapped to a colour in the image", g.VarName, char)
+	}
+
+	bounds := palimg.Bounds()
+	for _, e := range g.Edits {
+		to, err := index(e.To)
+		if err != nil {
+			return err
+		}
+		switch e.Op {
+		case "set", "fill":
+			rect := e.Rect.Add(bounds.Min)
+			if !rect.In(bounds) {
+				return fmt.Errorf("%s: edit %q is outside the image bounds %v", g.VarName, e, bounds)
+			}
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				for x := rect.Min.X; x < rect.Max.X; x++ {
+					palimg.SetColorIndex(x, y, to)
+				}
+			}
+		case "replace":
+			from, err := index(e.From)
+			if err != nil {
+				return err
+			}
+			for i, px := range palimg.Pix {
+				if px == from {
+					palimg.Pix[i] = to
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+package bitmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Exit codes, so scripts can tell why a conversion failed. Other errors, such as
+// failing to write an output, exit with exitFailure.
+const (
+	exitFailure = 1
+	exitUsage   = 2 // Bad arguments, or options which can't be used together.
+	exitDecode  = 3 // An input couldn't be read or decoded.
+	exitMap     = 4 // An image map couldn't be read or is invalid.
+	exitRender  = 5 // A renderer, or a post-process command, failed.
+)
+
+// verbosity is set by -quiet (-1) and -verbose (1). When quiet, warnings aren't
+// written to stderr; when verbose, each file read and output written is.
+var verbosity int
+
+// registerLogFlags adds -quiet and -verbose to flags.
+func registerLogFlags(flags *flag.FlagSet) {
+	flags.Var(verbosityFlag(-1), "quiet", "Only write errors to stderr, not warnings.")
+	flags.Var(verbosityFlag(1), "verbose", "Also write each file read and output written to stderr.")
+}
+
+// verbosityFlag is a boolean flag which sets verbosity to its value.
+type verbosityFlag int
+
+func (v verbosityFlag) String() string   { return "false" }
+func (v verbosityFlag) IsBoolFlag() bool { return true }
+
+func (v verbosityFlag) Set(s string) error {
+	on, err := strconv.ParseBool(s)
+	if on {
+		verbosity = int(v)
+	}
+	return err
+}
+
+// verbosef writes a line to stderr if -verbose is set.
+func verbosef(format string, args ...interface{}) {
+	if verbosity > 0 {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+// usageError is a problem with the arguments or options, rather than with any of the
+// files they name. shown is set if the flag package has already printed it, along with
+// the usage.
+type usageError struct {
+	err   error
+	shown bool
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{err: fmt.Errorf(format, args...)}
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+func (e *usageError) Unwrap() error { return e.err }
+
+// parseFlags parses args into flags, returning any error as a usageError.
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return &usageError{err: err, shown: true}
+	}
+	return nil
+}
+
+// decodeError is a failure to read or decode the input at path.
+type decodeError struct {
+	path string
+	err  error
+}
+
+func (e *decodeError) Error() string {
+	if errors.Is(e.err, os.ErrNotExist) || errors.Is(e.err, os.ErrPermission) {
+		return e.err.Error() // Already has the path.
+	}
+	return fmt.Sprintf("%s: %v", e.path, e.err)
+}
+
+func (e *decodeError) Unwrap() error { return e.err }
+
+// mapError is a problem with the image map at path, or if path is empty, an image map
+// being decoded. field is the JSON path of the value it's about, i.e.
+// 'areas[2].gen.scaler', if known.
+type mapError struct {
+	path  string
+	field string
+	err   error
+}
+
+func (e *mapError) Error() string {
+	var parts []string
+	for _, part := range []string{e.path, e.field, e.err.Error()} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ": ")
+}
+
+func (e *mapError) Unwrap() error { return e.err }
+
+// mapFieldError returns err as a mapError about field. If err is already about a value
+// within field, such as a JSON type error or a mapError from a nested value, its path
+// is appended to field.
+func mapFieldError(field string, err error) error {
+	var me *mapError
+	if errors.As(err, &me) {
+		me.field = joinField(field, me.field)
+		return me
+	}
+	var te *json.UnmarshalTypeError
+	if errors.As(err, &te) {
+		return &mapError{
+			field: joinField(field, te.Field),
+			err:   fmt.Errorf("expected %s, found %s", te.Type, te.Value),
+		}
+	}
+	return &mapError{field: field, err: err}
+}
+
+// unknownFieldError returns an error about the unknown field name, found by a
+// json.Decoder decoding the JSON object raw into a value of type t, naming the closest
+// known field. Unknown fields within objects nested in raw, such as an area's gen, are
+// reported by their path from raw.
+func unknownFieldError(raw []byte, t reflect.Type, name string) error {
+	field := name
+	var keys map[string]json.RawMessage
+	if json.Unmarshal(raw, &keys) == nil && keys[name] == nil {
+		for key, value := range keys {
+			var nested map[string]json.RawMessage
+			if json.Unmarshal(value, &nested) != nil || nested[name] == nil {
+				continue
+			}
+			if nestedType := jsonFieldType(t, key); nestedType != nil {
+				field, t = key+"."+name, nestedType
+				break
+			}
+		}
+	}
+
+	fields := jsonFields(t)
+	if suggestion := closestChoice(name, fields); suggestion != "" {
+		return &mapError{field: field, err: fmt.Errorf("unknown field, did you mean %q?", suggestion)}
+	}
+	return &mapError{field: field, err: fmt.Errorf("unknown field, expected one of %s", strings.Join(fields, ", "))}
+}
+
+// jsonFields returns the JSON name of each field of the struct, or pointer to a
+// struct, of type t.
+func jsonFields(t reflect.Type) []string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var fields []string
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	for idx := 0; idx < t.NumField(); idx++ {
+		f := t.Field(idx)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+// jsonFieldType returns the type of the field of the struct, or pointer to a struct, of
+// type t with the JSON name key, if it is a struct or pointer to a struct itself.
+func jsonFieldType(t reflect.Type, key string) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	for idx := 0; idx < t.NumField(); idx++ {
+		f := t.Field(idx)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = f.Name
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if strings.EqualFold(name, key) && ft.Kind() == reflect.Struct {
+			return ft
+		}
+	}
+	return nil
+}
+
+func joinField(parent, child string) string {
+	if parent == "" || child == "" {
+		return parent + child
+	}
+	return parent + "." + child
+}
+
+// fileMapError returns err, an error reading or decoding the image map in bts from
+// path, as a mapError about path. JSON syntax errors are given the line and column
+// they were found at.
+func fileMapError(path string, bts []byte, err error) error {
+	var me *mapError
+	if !errors.As(err, &me) {
+		me = &mapError{err: err}
+		var se *json.SyntaxError
+		var te *json.UnmarshalTypeError
+		if errors.As(err, &se) {
+			// Offset counts the byte the error is about:
+			me.field = lineCol(bts, se.Offset-1)
+		} else if errors.As(err, &te) {
+			me = mapFieldError("", err).(*mapError)
+		}
+	}
+	me.path = path
+	return me
+}
+
+// lineCol returns the line and column of the byte at offset in bts, as 'line:col'.
+func lineCol(bts []byte, offset int64) string {
+	if offset > int64(len(bts)) {
+		offset = int64(len(bts))
+	} else if offset < 0 {
+		offset = 0
+	}
+	before := bts[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Sprintf("%d:%d", line, col)
+}
+
+// renderError is a failure rendering, or post-processing, the image called name.
+type renderError struct {
+	name string
+	err  error
+}
+
+func (e *renderError) Error() string { return fmt.Sprintf("%s: %v", e.name, e.err) }
+func (e *renderError) Unwrap() error { return e.err }
+
+// jobsError is the failure of some of a batch of jobs.
+type jobsError struct {
+	errs []error
+	jobs int
+}
+
+func (e *jobsError) Error() string {
+	msgs := make([]string, len(e.errs))
+	for idx, err := range e.errs {
+		msgs[idx] = err.Error()
+	}
+	return fmt.Sprintf("%d of %d jobs failed:\n%s", len(e.errs), e.jobs, strings.Join(msgs, "\n"))
+}
+
+// exitCode returns the exit code for err. For a jobsError, it is the code of every
+// failed job if they are all the same.
+func exitCode(err error) int {
+	var je *jobsError
+	if errors.As(err, &je) {
+		code := exitCode(je.errs[0])
+		for _, err := range je.errs[1:] {
+			if exitCode(err) != code {
+				return exitFailure
+			}
+		}
+		return code
+	}
+
+	var ue *usageError
+	var de *decodeError
+	var me *mapError
+	var re *renderError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, &ue):
+		return exitUsage
+	case errors.As(err, &de):
+		return exitDecode
+	case errors.As(err, &me):
+		return exitMap
+	case errors.As(err, &re):
+		return exitRender
+	}
+	return exitFailure
+}
+
+// reportError writes err to w, prefixed with what kind of error it is, unless the flag
+// package has already printed it.
+func reportError(w io.Writer, err error) {
+	var ue *usageError
+	if errors.Is(err, flag.ErrHelp) || (errors.As(err, &ue) && ue.shown) {
+		return
+	}
+	kind := map[int]string{
+		exitUsage:  "usage error: ",
+		exitDecode: "decode error: ",
+		exitMap:    "map error: ",
+		exitRender: "render error: ",
+	}[exitCode(err)]
+	if _, ok := err.(*jobsError); ok {
+		kind = ""
+	}
+	fmt.Fprintf(w, "bmp2cpp: %s%v\n", kind, err)
+}
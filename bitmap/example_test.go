@@ -0,0 +1,68 @@
+package bitmap_test
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+
+	"k3jw.com/bmp2cpp/bitmap"
+)
+
+func ExampleImageMap_Build() {
+	// A 4x2 image whose left half is black and right half is white:
+	img := image.NewGray(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 2; x < 4; x++ {
+			img.SetGray(x, y, color.Gray{255})
+		}
+	}
+
+	gen := bitmap.NewGenerator()
+	imap := bitmap.NewImageMap(gen)
+	imap.AddArea(image.Rect(0, 0, 2, 2), "left")
+	right := imap.AddArea(image.Rect(2, 0, 4, 2), "right")
+	right.Invert = true
+
+	outputs, err := imap.Build(img)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, out := range outputs {
+		fmt.Printf("%s %dx%d\n", out.VarName, out.Width, out.Height)
+	}
+	// Output:
+	// left 2x2
+	// right 2x2
+}
+
+func ExampleRegisterRenderer() {
+	bitmap.RegisterRenderer("rows", ".txt", func(data *bitmap.RenderData, out io.Writer) error {
+		for _, row := range data.Rows {
+			if _, err := fmt.Fprintln(out, row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	img.SetGray(1, 0, color.Gray{255})
+	img.SetGray(2, 1, color.Gray{255})
+
+	gen := bitmap.NewGenerator()
+	gen.Renderer = "rows"
+	imap := bitmap.NewImageMap(gen)
+	imap.AddArea(img.Bounds(), "dots")
+
+	outputs, err := imap.Build(img)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s\n%s", outputs[0].Name, outputs[0].Data)
+	// Output:
+	// dots.txt
+	// [0 1 0]
+	// [0 0 1]
+}
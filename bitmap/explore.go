@@ -0,0 +1,183 @@
+package bitmap
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const exploreUsage = "explore [options] <input>"
+
+// runExplore renders a grid image comparing the quantized output for combinations of
+// palette sizes (columns), and scalers and dithers (rows), to help choose settings for
+// a display.
+func runExplore(args []string) error {
+	var sizeRaw string
+	var levelsRaw string
+	var scalersRaw string
+	var dithersRaw string
+	var outFile string
+	var zoom int
+	var gen Generator
+
+	flags := flag.NewFlagSet("explore", 0)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s\n", exploreUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&sizeRaw, "size", "", "Size, in '<w>x<h>' format. <=0 for either dimension for aspect.")
+	flags.StringVar(&levelsRaw, "levels", "2,3,4,6,9,16", "Comma separated list of palette sizes to compare.")
+	flags.StringVar(&scalersRaw, "scalers", "nn,approxbilinear,bilinear,catmullrom", "Comma separated list of scalers to compare. See -scaler in the main options for values.")
+	flags.StringVar(&dithersRaw, "dithers", "none,floyd-steinberg", "Comma separated list of dithers to compare. See -dither in the main options for values.")
+	flags.StringVar(&outFile, "o", "explore.png", "Output PNG file.")
+	flags.IntVar(&zoom, "zoom", 0, "Integer zoom for each cell. Default: enough to make each cell at least 128px wide.")
+	flags.BoolVar(&gen.Invert, "invert", false, "Invert colours")
+	flags.BoolVar(&gen.Linear, "linear", false, "Rescale and compute intensity in linear light.")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if err := parseSize(sizeRaw, &gen); err != nil {
+		return &usageError{err: err}
+	}
+
+	var levels []int
+	for _, bit := range splitPtn.Split(levelsRaw, -1) {
+		n, err := strconv.Atoi(bit)
+		if err != nil || n < 1 || n > 256 {
+			return fmt.Errorf("invalid palette size %q in -levels", bit)
+		}
+		levels = append(levels, n)
+	}
+
+	scalers := splitScalers(scalersRaw)
+	for _, scaler := range scalers {
+		if err := checkScaler(scaler); err != nil {
+			return &usageError{err: fmt.Errorf("-scalers: %w", err)}
+		}
+	}
+
+	dithers := splitPtn.Split(dithersRaw, -1)
+	for _, dither := range dithers {
+		if err := checkDither(dither); err != nil {
+			return &usageError{err: fmt.Errorf("-dithers: %w", err)}
+		}
+	}
+
+	// Each row is a scaler and dither. The dither is only named if there is more than
+	// one:
+	type exploreRow struct{ label, scaler, dither string }
+	var rows []exploreRow
+	for _, scaler := range scalers {
+		for _, dither := range dithers {
+			label := scaler
+			if len(dithers) > 1 {
+				label = scaler + ", " + dither
+			}
+			rows = append(rows, exploreRow{label, scaler, dither})
+		}
+	}
+
+	if flags.NArg() != 1 {
+		return usageErrorf("missing <input> arg")
+	}
+	img, err := decode(flags.Arg(0), decodeOptions{})
+	if err != nil {
+		return err
+	}
+
+	cellSize := img.Bounds().Size()
+	if gen.TargetWidth > 0 || gen.TargetHeight > 0 {
+		cellSize = prepareSize(gen.TargetWidth, gen.TargetHeight, cellSize)
+	}
+	if zoom <= 0 {
+		zoom = (128 + cellSize.X - 1) / cellSize.X
+	}
+	cellSize = cellSize.Mul(zoom)
+
+	const pad = 8
+	const labelH = 16
+	face := basicfont.Face7x13
+
+	labelW := 0
+	for _, row := range rows {
+		if w := font.MeasureString(face, row.label).Ceil(); w > labelW {
+			labelW = w
+		}
+	}
+
+	origin := image.Pt(labelW+pad*2, labelH+pad*2)
+	total := image.Pt(
+		origin.X+len(levels)*(cellSize.X+pad),
+		origin.Y+len(rows)*(cellSize.Y+pad),
+	)
+	sheet := image.NewRGBA(image.Rectangle{Max: total})
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.Gray{Y: 0x40}), image.Point{}, draw.Src)
+
+	drawLabel := func(s string, x, y int) {
+		d := font.Drawer{Dst: sheet, Src: image.White, Face: face, Dot: fixed.P(x, y)}
+		d.DrawString(s)
+	}
+
+	for col, n := range levels {
+		x := origin.X + col*(cellSize.X+pad)
+		drawLabel(fmt.Sprintf("%d levels", n), x, pad+labelH-4)
+	}
+
+	for ridx, row := range rows {
+		y := origin.Y + ridx*(cellSize.Y+pad)
+		drawLabel(row.label, pad, y+labelH-4)
+
+		for col, n := range levels {
+			cell := gen.Clone()
+			cell.Scaler = row.scaler
+			cell.Dither = row.dither
+			cell.Palette = Palette{Size: n}
+
+			renderCtx, err := cell.process(img)
+			if err != nil {
+				return fmt.Errorf("scaler %q, dither %q, %d levels: %w", row.scaler, row.dither, n, err)
+			}
+
+			x := origin.X + col*(cellSize.X+pad)
+			dr := image.Rectangle{Min: image.Pt(x, y), Max: image.Pt(x, y).Add(cellSize)}
+			draw.NearestNeighbor.Scale(sheet, dr, renderCtx.img, renderCtx.img.Bounds(), draw.Src, nil)
+		}
+	}
+
+	f, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, sheet); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// splitScalers splits a comma separated list of scalers, keeping the B and C of each
+// 'cubic:<B>,<C>' scaler together.
+func splitScalers(raw string) []string {
+	var scalers []string
+	bits := splitPtn.Split(raw, -1)
+	for idx := 0; idx < len(bits); idx++ {
+		bit := bits[idx]
+		if strings.HasPrefix(bit, cubicScalerPrefix) && idx+1 < len(bits) {
+			bit += "," + bits[idx+1]
+			idx++
+		}
+		scalers = append(scalers, bit)
+	}
+	return scalers
+}
@@ -0,0 +1,71 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/bmp"
+)
+
+// exportImagePath returns g.ExportImage with '{var}' replaced by the variable name.
+func (g *Generator) exportImagePath() string {
+	return strings.ReplaceAll(g.ExportImage, "{var}", g.VarName)
+}
+
+// previewPath returns g.Preview with '{var}' replaced by the variable name.
+func (g *Generator) previewPath() string {
+	return strings.ReplaceAll(g.Preview, "{var}", g.VarName)
+}
+
+// checkImagePaths returns an error if two tasks would save their preview or exported
+// image to the same path, so one would overwrite the other's.
+func checkImagePaths(tasks []buildTask) error {
+	for _, opt := range []struct {
+		name string
+		path func(g *Generator) string
+	}{
+		{"preview", (*Generator).previewPath},
+		{"exported image", (*Generator).exportImagePath},
+	} {
+		varNames := map[string]string{}
+		for _, task := range tasks {
+			path := opt.path(task.gen)
+			if path == "" {
+				continue
+			}
+			if other, ok := varNames[path]; ok {
+				return fmt.Errorf("%s and %s would both save their %s to %q; use '{var}' in the path", other, task.gen.VarName, opt.name, path)
+			}
+			varNames[path] = task.gen.VarName
+		}
+	}
+	return nil
+}
+
+// exportImage writes the processed image to path, in the format given by its
+// extension: PNG, BMP or GIF. All three keep the image paletted, so the colours are
+// exactly those the values were mapped from.
+func exportImage(path string, img *image.Paletted) error {
+	var b bytes.Buffer
+	var err error
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".png":
+		err = png.Encode(&b, img)
+	case ".bmp":
+		err = bmp.Encode(&b, img)
+	case ".gif":
+		err = gif.Encode(&b, img, &gif.Options{NumColors: len(img.Palette)})
+	default:
+		return fmt.Errorf("unsupported export image format %q, expected .png, .bmp or .gif", ext)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b.Bytes(), 0644)
+}
@@ -0,0 +1,150 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// fitModes lists the values of Generator.Fit.
+var fitModes = []string{"exact", "integer", "contain", "cover"}
+
+// fitSizes returns the part of an image of srcSize to scale, the size to scale it to,
+// and the size of the final image, which the scaled image is centred in: larger if the
+// scaled image is padded, or smaller if it is cropped. If no size was requested, ok is
+// false.
+//
+//	exact    Scale to the target size, ignoring the aspect ratio if both dimensions
+//	         are given.
+//	contain  Scale to fit within the target size, keeping the aspect ratio.
+//	cover    Scale to cover the target size, keeping the aspect ratio, and crop the
+//	         overflow.
+//	integer  Scale by the largest whole multiple, or the smallest whole divisor, of the
+//	         source size which fits within the target size, so pixel art is sampled
+//	         without artifacts. When dividing by d, the remainder of the source size
+//	         is cropped, evenly from each side, so each scaled pixel is made from
+//	         exactly d by d source pixels.
+//
+// If g.FitPad is set, contained and integer scaled images are padded to the target
+// size.
+func (g *Generator) fitSizes(srcSize image.Point) (src image.Rectangle, scaled, final image.Point, ok bool) {
+	src = image.Rectangle{Max: srcSize}
+	w, h := g.TargetWidth, g.TargetHeight
+	if w <= 0 && h <= 0 {
+		return src, srcSize, srcSize, false
+	}
+
+	// The largest scale which fits the target, or if only one dimension is given, the
+	// scale of that dimension:
+	sx, sy := float64(w)/float64(srcSize.X), float64(h)/float64(srcSize.Y)
+	scale := math.Min(sx, sy)
+	if w <= 0 {
+		scale = sy
+	} else if h <= 0 {
+		scale = sx
+	}
+	byScale := func(s float64) image.Point {
+		return image.Point{
+			X: int(math.Max(1, math.Round(float64(srcSize.X)*s))),
+			Y: int(math.Max(1, math.Round(float64(srcSize.Y)*s))),
+		}
+	}
+
+	switch g.Fit {
+	case "", "exact":
+		scaled = prepareSize(w, h, srcSize)
+		return src, scaled, scaled, true
+
+	case "contain":
+		scaled = byScale(scale)
+
+	case "cover":
+		if w > 0 && h > 0 {
+			scaled = byScale(math.Max(sx, sy))
+			return src, scaled, image.Point{w, h}, true
+		}
+		scaled = byScale(scale)
+
+	case "integer":
+		if scale >= 1 {
+			n := int(math.Floor(scale))
+			scaled = image.Point{srcSize.X * n, srcSize.Y * n}
+		} else {
+			d := int(math.Ceil(1/scale - 1e-9))
+			scaled = image.Point{srcSize.X / d, srcSize.Y / d}
+			if scaled.X < 1 {
+				scaled.X = 1
+			}
+			if scaled.Y < 1 {
+				scaled.Y = 1
+			}
+			used := scaled.Mul(d)
+			if used.X > srcSize.X {
+				used.X = srcSize.X
+			}
+			if used.Y > srcSize.Y {
+				used.Y = srcSize.Y
+			}
+			src = image.Rectangle{Max: used}.Add(srcSize.Sub(used).Div(2))
+		}
+	}
+
+	final = scaled
+	if g.FitPad {
+		if w > 0 {
+			final.X = w
+		}
+		if h > 0 {
+			final.Y = h
+		}
+	}
+	return src, scaled, final, true
+}
+
+// checkFit returns an error if g.Fit is unknown, or used with options it doesn't work
+// with.
+func (g *Generator) checkFit() error {
+	switch g.Fit {
+	case "", "exact", "integer", "contain", "cover":
+	default:
+		return unknownChoice("fit mode", g.Fit, fitModes)
+	}
+	if g.FitPad && g.Fit != "contain" && g.Fit != "integer" {
+		return fmt.Errorf("-fit-pad requires -fit contain or integer")
+	}
+	if g.TileRows > 0 && (g.Fit == "cover" || g.FitPad) {
+		return fmt.Errorf("tiling cannot be used with -fit cover or -fit-pad")
+	}
+	return nil
+}
+
+// placeScaled returns img, which was scaled to scaled, centred in an image of size
+// final, which crops or pads it. Padding is transparent.
+func placeScaled(img image.Image, scaled, final image.Point) image.Image {
+	if scaled == final {
+		return img
+	}
+	out := image.NewRGBA(image.Rectangle{Max: final})
+	offset := final.Sub(scaled).Div(2)
+	draw.Draw(out, image.Rectangle{Min: offset, Max: offset.Add(scaled)}, img, img.Bounds().Min, draw.Src)
+	return out
+}
+
+// placeScaledPaletted is placeScaled for an already quantized image. Padding is the
+// first colour of the palette.
+func placeScaledPaletted(img *image.Paletted, scaled, final image.Point) *image.Paletted {
+	if scaled == final {
+		return img
+	}
+	out := image.NewPaletted(image.Rectangle{Max: final}, img.Palette)
+	offset := final.Sub(scaled).Div(2)
+	bounds := img.Bounds()
+	dst := image.Rectangle{Min: offset, Max: offset.Add(scaled)}.Intersect(out.Bounds())
+	for y := dst.Min.Y; y < dst.Max.Y; y++ {
+		for x := dst.Min.X; x < dst.Max.X; x++ {
+			out.SetColorIndex(x, y, img.ColorIndexAt(bounds.Min.X+x-offset.X, bounds.Min.Y+y-offset.Y))
+		}
+	}
+	return out
+}
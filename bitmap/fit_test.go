@@ -0,0 +1,44 @@
+package bitmap
+
+import (
+	"image"
+	"testing"
+)
+
+func TestFitSizes(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		fit    string
+		pad    bool
+		src    image.Point
+		target image.Point
+		crop   image.Rectangle
+		scaled image.Point
+		final  image.Point
+	}{
+		{"exact", "exact", false, image.Pt(10, 10), image.Pt(4, 6), image.Rect(0, 0, 10, 10), image.Pt(4, 6), image.Pt(4, 6)},
+		{"contain", "contain", false, image.Pt(20, 10), image.Pt(8, 8), image.Rect(0, 0, 20, 10), image.Pt(8, 4), image.Pt(8, 4)},
+		{"contain-pad", "contain", true, image.Pt(20, 10), image.Pt(8, 8), image.Rect(0, 0, 20, 10), image.Pt(8, 4), image.Pt(8, 8)},
+		{"cover", "cover", false, image.Pt(20, 10), image.Pt(8, 8), image.Rect(0, 0, 20, 10), image.Pt(16, 8), image.Pt(8, 8)},
+		{"integer-up", "integer", false, image.Pt(3, 2), image.Pt(10, 10), image.Rect(0, 0, 3, 2), image.Pt(9, 6), image.Pt(9, 6)},
+		{"integer-down-exact", "integer", false, image.Pt(12, 9), image.Pt(4, 4), image.Rect(0, 0, 12, 9), image.Pt(4, 3), image.Pt(4, 3)},
+
+		// 10 isn't a multiple of 3, so a column and row are cropped to keep the ratio whole:
+		{"integer-down-crop", "integer", false, image.Pt(10, 10), image.Pt(4, 4), image.Rect(0, 0, 9, 9), image.Pt(3, 3), image.Pt(3, 3)},
+		{"integer-down-crop-centred", "integer", true, image.Pt(11, 6), image.Pt(4, 4), image.Rect(1, 0, 10, 6), image.Pt(3, 2), image.Pt(4, 4)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &Generator{Fit: tc.fit, FitPad: tc.pad, TargetWidth: tc.target.X, TargetHeight: tc.target.Y}
+			crop, scaled, final, ok := g.fitSizes(tc.src)
+			if !ok {
+				t.Fatal("expected a size")
+			}
+			if crop != tc.crop || scaled != tc.scaled || final != tc.final {
+				t.Fatalf("expected %v %v %v, found %v %v %v", tc.crop, tc.scaled, tc.final, crop, scaled, final)
+			}
+			if tc.fit == "integer" && scaled.X < crop.Dx() && (crop.Dx()%scaled.X != 0 || crop.Dy()%scaled.Y != 0 || crop.Dx()/scaled.X != crop.Dy()/scaled.Y) {
+				t.Fatalf("%v doesn't divide %v by a whole number", scaled, crop)
+			}
+		})
+	}
+}
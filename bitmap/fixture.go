@@ -0,0 +1,119 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// fixtureSample is a single pixel assertion in a generated test fixture.
+type fixtureSample struct {
+	x, y   int
+	offset int
+	value  uint8
+}
+
+// renderTestFixture renders a companion test for the output named outName, which
+// checks the FNV-1a checksum of the emitted values and a few sampled pixels. kind may
+// be "gtest" or "catch2" for the C++ renderers, or "js" for the JS renderers.
+func renderTestFixture(renderCtx *renderContext, kind string, outName string) (Output, error) {
+	gen := renderCtx.gen
+
+	values := renderCtx.values()
+	sum := renderCtx.checksum()
+
+	l := renderCtx.layout
+	var samples []fixtureSample
+	seen := map[[2]int]bool{}
+	for _, pt := range [][2]int{
+		{0, 0}, {l.width - 1, 0}, {0, l.height - 1}, {l.width - 1, l.height - 1},
+		{l.width / 2, l.height / 2}, {l.width / 4, l.height / 4}, {l.width * 3 / 4, l.height * 3 / 4},
+	} {
+		if seen[pt] {
+			continue
+		}
+		seen[pt] = true
+		offset := pt[1]*l.stride + pt[0]
+		samples = append(samples, fixtureSample{pt[0], pt[1], offset, values[offset]})
+	}
+
+	var out bytes.Buffer
+	switch kind {
+	case "gtest", "catch2":
+		if gen.Renderer != "cpp" && gen.Renderer != "cpp17" && gen.Renderer != "cpp20" {
+			return Output{}, fmt.Errorf("%s test fixtures require a C++ renderer", kind)
+		}
+		if kind == "gtest" {
+			out.WriteString("#include <gtest/gtest.h>\n")
+		} else {
+			out.WriteString("#include <catch2/catch_test_macros.hpp>\n")
+		}
+		out.WriteString("#include <cstdint>\n")
+		out.WriteString(fmt.Sprintf("#include %q\n\n", outName))
+
+		check := func(expr string, expected string) string {
+			if kind == "gtest" {
+				return fmt.Sprintf("    EXPECT_EQ(%s, %s);\n", expr, expected)
+			}
+			return fmt.Sprintf("    REQUIRE(%s == %s);\n", expr, expected)
+		}
+		open := func(name string) string {
+			if kind == "gtest" {
+				return fmt.Sprintf("TEST(%s, %s) {\n", gen.VarName, name)
+			}
+			return fmt.Sprintf("TEST_CASE(\"%s %s\") {\n", gen.VarName, name)
+		}
+
+		out.WriteString(open("Checksum"))
+		out.WriteString("    uint32_t hash = 2166136261u;\n")
+		out.WriteString(fmt.Sprintf("    for (auto v : %s) {\n", gen.VarName))
+		out.WriteString("        hash = (hash ^ v) * 16777619u;\n")
+		out.WriteString("    }\n")
+		out.WriteString(check(fmt.Sprintf("%s.size()", gen.VarName), fmt.Sprintf("%du", len(values))))
+		out.WriteString(check("hash", fmt.Sprintf("0x%08xu", sum)))
+		out.WriteString("}\n\n")
+
+		out.WriteString(open("Pixels"))
+		for _, s := range samples {
+			out.WriteString(check(fmt.Sprintf("%s[%d]", gen.VarName, s.offset), fmt.Sprintf("%d", s.value)))
+		}
+		out.WriteString("}\n")
+
+		return Output{Name: gen.VarName + "_test.cpp", Data: out.Bytes()}, nil
+
+	case "js":
+		if gen.Renderer != "js" && gen.Renderer != "cjs" {
+			return Output{}, fmt.Errorf("js test fixtures require a JS renderer")
+		}
+		if gen.Renderer == "js" {
+			out.WriteString("import { test } from 'node:test';\n")
+			out.WriteString("import assert from 'node:assert';\n")
+			out.WriteString(fmt.Sprintf("import { %s } from './%s';\n\n", gen.VarName, outName))
+		} else {
+			out.WriteString("const { test } = require('node:test');\n")
+			out.WriteString("const assert = require('node:assert');\n")
+			out.WriteString(fmt.Sprintf("const { %s } = require('./%s');\n\n", gen.VarName, outName))
+		}
+
+		out.WriteString(fmt.Sprintf("const data = Array.isArray(%[1]s) ? %[1]s.flatMap((row) => Array.from(row)) : Array.from(%[1]s);\n\n", gen.VarName))
+
+		out.WriteString(fmt.Sprintf("test('%s checksum', () => {\n", gen.VarName))
+		out.WriteString("  let hash = 2166136261;\n")
+		out.WriteString("  for (const v of data) {\n")
+		out.WriteString("    hash = Math.imul(hash ^ v, 16777619) >>> 0;\n")
+		out.WriteString("  }\n")
+		out.WriteString(fmt.Sprintf("  assert.strictEqual(data.length, %d);\n", len(values)))
+		out.WriteString(fmt.Sprintf("  assert.strictEqual(hash, 0x%08x);\n", sum))
+		out.WriteString("});\n\n")
+
+		out.WriteString(fmt.Sprintf("test('%s pixels', () => {\n", gen.VarName))
+		for _, s := range samples {
+			out.WriteString(fmt.Sprintf("  assert.strictEqual(data[%d], %d); // %d,%d\n", s.offset, s.value, s.x, s.y))
+		}
+		out.WriteString("});\n")
+
+		return Output{Name: gen.VarName + ".test.js", Data: out.Bytes()}, nil
+
+	default:
+		return Output{}, fmt.Errorf("unknown test fixture kind %q", kind)
+	}
+}
@@ -0,0 +1,296 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// fontStrip slices an image of glyphs into one glyph per char, starting at the char
+// code first. Glyphs are either cells of a fixed size, numbered from 0 along each row,
+// or the runs of columns between separator columns, which are columns of a single
+// colour across the whole height of the strip.
+type fontStrip struct {
+	// Size of each glyph cell. If zero, glyphs are found using separator instead.
+	cell image.Point
+
+	// Colour of separator columns, as '#rrggbb' or '#rrggbbaa', or 'auto' to use the
+	// colour of the strip's top left pixel.
+	separator string
+
+	// Char code of the first glyph, and the number of glyphs to use. If count is 0,
+	// every glyph in the strip is used.
+	first int
+	count int
+
+	// Pixels added to each glyph's width, or to a rasterized glyph's advance, to get
+	// its advance.
+	spacing int
+
+	// Size in pixels to rasterize TrueType and OpenType fonts at. See rasterize.
+	px float64
+}
+
+// fontGlyph is the char code and rectangle of a glyph in a font strip. Glyphs
+// rasterized from a font also have the advance from the font, and the offset of the
+// glyph's left edge from the pen position, in pixels before any rescaling.
+type fontGlyph struct {
+	code    int
+	rect    image.Rectangle
+	advance int
+	offset  int
+}
+
+// check returns a usage error if fs doesn't have the options needed to slice a strip,
+// or to rasterize a font file if isFont is set.
+func (fs *fontStrip) check(isFont bool) error {
+	switch {
+	case isFont && (fs.cell != image.Point{} || fs.separator != ""):
+		return usageErrorf("-cell and -separator are for glyph strips, not font files")
+	case isFont && fs.px <= 0:
+		return usageErrorf("-px is required to rasterize a font file")
+	case !isFont && fs.px > 0:
+		return usageErrorf("-px is for font files, not glyph strips")
+	case !isFont && fs.cell == (image.Point{}) && fs.separator == "":
+		return usageErrorf("glyph strips require -cell or -separator")
+	}
+	return nil
+}
+
+// glyphs returns every glyph in img, with rectangles relative to img's top left.
+func (fs *fontStrip) glyphs(img image.Image) ([]fontGlyph, error) {
+	var rects []image.Rectangle
+	if fs.cell != (image.Point{}) {
+		rects = cellRects(img.Bounds().Size(), fs.cell)
+	} else {
+		sep, err := fs.separatorColor(img)
+		if err != nil {
+			return nil, err
+		}
+		rects = separatedRects(img, sep)
+	}
+	if len(rects) == 0 {
+		return nil, fmt.Errorf("no glyphs found in the %dx%d image", img.Bounds().Dx(), img.Bounds().Dy())
+	}
+	if fs.count > 0 && fs.count < len(rects) {
+		rects = rects[:fs.count]
+	}
+	if last := fs.first + len(rects) - 1; fs.first < 0 || last > 255 {
+		return nil, fmt.Errorf("%d glyphs starting at char %d go past char 255; use -count to use fewer", len(rects), fs.first)
+	}
+
+	glyphs := make([]fontGlyph, len(rects))
+	for idx, rect := range rects {
+		glyphs[idx] = fontGlyph{code: fs.first + idx, rect: rect}
+	}
+	return glyphs, nil
+}
+
+func (fs *fontStrip) separatorColor(img image.Image) (color.NRGBA, error) {
+	if fs.separator == "auto" {
+		return color.NRGBAModel.Convert(img.At(img.Bounds().Min.X, img.Bounds().Min.Y)).(color.NRGBA), nil
+	}
+	return parseHexColor(fs.separator)
+}
+
+// cellRects returns the rectangle of every whole cell in an image of size, in row
+// order.
+func cellRects(size, cell image.Point) []image.Rectangle {
+	var rects []image.Rectangle
+	for y := 0; y+cell.Y <= size.Y; y += cell.Y {
+		for x := 0; x+cell.X <= size.X; x += cell.X {
+			rects = append(rects, image.Rectangle{Min: image.Pt(x, y), Max: image.Pt(x+cell.X, y+cell.Y)})
+		}
+	}
+	return rects
+}
+
+// separatedRects returns the rectangle of every run of columns in img which are not
+// entirely sep, from left to right. Fully transparent pixels match any transparent sep.
+func separatedRects(img image.Image, sep color.NRGBA) []image.Rectangle {
+	bounds := img.Bounds()
+	isSep := func(x int) bool {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c != sep && (c.A != 0 || sep.A != 0) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var rects []image.Rectangle
+	start := -1
+	for x := bounds.Min.X; x <= bounds.Max.X; x++ {
+		if x < bounds.Max.X && !isSep(x) {
+			if start < 0 {
+				start = x
+			}
+			continue
+		}
+		if start >= 0 {
+			rects = append(rects, image.Rect(start, 0, x, bounds.Dy()).Sub(image.Pt(bounds.Min.X, 0)))
+			start = -1
+		}
+	}
+	return rects
+}
+
+// fontGlyphName returns the variable name of the glyph for code in the font called
+// name.
+func fontGlyphName(name string, code int) string {
+	return fmt.Sprintf("%s_%d", name, code)
+}
+
+// renderFont renders the font called name, whose glyphs were built by tasks: the first
+// char code, the glyph count and height, and tables of each glyph's data, width and
+// advance, indexed by char code minus the first, for C++ headers, or as a frozen
+// object for JS. results holds the outputs of each task, in the same order.
+//
+// If ascent is set, the glyphs were rasterized from a font, so the ascent (the row of
+// the baseline) and each glyph's offset from the pen position are emitted too, and
+// advances come from the font rather than the glyph widths.
+//
+// If separate is set, each glyph is in its own file which the font includes or
+// imports; otherwise the font is expected to follow the glyphs in the same file.
+func renderFont(name string, spacing, ascent int, separate bool, glyphs []fontGlyph, tasks []buildTask, results [][]Output) (Output, error) {
+	gen := tasks[0].gen
+	images := make([]Output, len(tasks))
+	for idx, task := range tasks {
+		if task.gen.ForegroundRule != "" {
+			return Output{}, fmt.Errorf("font %q: %s is split into layers by a foreground rule", name, task.gen.VarName)
+		}
+		images[idx] = results[idx][0]
+		if images[idx].Height != images[0].Height {
+			return Output{}, fmt.Errorf("font %q: glyph %d is %d pixels high, but glyph %d is %d", name,
+				glyphs[idx].code, images[idx].Height, glyphs[0].code, images[0].Height)
+		}
+	}
+
+	// Metrics from the font are scaled by the same amount as the glyphs were:
+	count := len(images)
+	first, last := glyphs[0].code, glyphs[count-1].code
+	widths := make([]int, count)
+	advances := make([]int, count)
+	offsets := make([]int, count)
+	for idx, img := range images {
+		widths[idx], advances[idx] = img.Width, img.Width+spacing
+		if ascent > 0 {
+			scale := float64(img.Width) / float64(glyphs[idx].rect.Dx())
+			advances[idx] = int(math.Round(float64(glyphs[idx].advance)*scale)) + spacing
+			offsets[idx] = int(math.Round(float64(glyphs[idx].offset) * scale))
+		}
+		if widths[idx] > 255 || advances[idx] < 0 || advances[idx] > 255 || offsets[idx] < -128 || offsets[idx] > 127 {
+			return Output{}, fmt.Errorf("font %q: glyph %d is too wide for the 8 bit width, advance and offset tables", name, glyphs[idx].code)
+		}
+	}
+	if ascent > 0 {
+		ascent = int(math.Round(float64(ascent) * float64(images[0].Height) / float64(glyphs[0].rect.Dy())))
+	}
+	list := func(values []int) string {
+		strs := make([]string, len(values))
+		for idx, v := range values {
+			strs[idx] = strconv.Itoa(v)
+		}
+		return strings.Join(strs, ", ")
+	}
+
+	var out bytes.Buffer
+	switch gen.Renderer {
+	case "cpp", "cpp17", "cpp20", "arduino":
+		if separate {
+			for _, img := range images {
+				out.WriteString(fmt.Sprintf("#include %q\n", img.Name))
+			}
+			out.WriteByte('\n')
+		}
+		out.WriteString(fmt.Sprintf("// Glyphs for chars %d to %d. The glyph for char c is %s_glyphs[c - %s_first],\n", first, last, name, name))
+		out.WriteString(fmt.Sprintf("// which is %s_widths[c - %s_first] pixels wide and %s_height high.\n", name, name, name))
+		out.WriteString(fmt.Sprintf("static constexpr uint8_t %s_first = %d;\n", name, first))
+		out.WriteString(fmt.Sprintf("static constexpr size_t %s_count = %d;\n", name, count))
+		out.WriteString(fmt.Sprintf("static constexpr size_t %s_height = %d;\n", name, images[0].Height))
+		if ascent > 0 {
+			out.WriteString(fmt.Sprintf("// Row of the baseline. Each glyph is drawn %s_x_offsets[c - %s_first] pixels from the pen.\n", name, name))
+			out.WriteString(fmt.Sprintf("static constexpr size_t %s_ascent = %d;\n", name, ascent))
+		}
+		out.WriteByte('\n')
+
+		// '&v[0]' works for both std::array and the arduino renderer's plain arrays:
+		out.WriteString(fmt.Sprintf("static const uint8_t *const %s_glyphs[%d] = {\n", name, count))
+		for _, img := range images {
+			out.WriteString(fmt.Sprintf("    &%s[0],\n", img.VarName))
+		}
+		out.WriteString("};\n\n")
+		out.WriteString(fmt.Sprintf("static const uint8_t %s_widths[%d] = { %s };\n", name, count, list(widths)))
+		out.WriteString(fmt.Sprintf("static const uint8_t %s_advances[%d] = { %s };\n", name, count, list(advances)))
+		if ascent > 0 {
+			out.WriteString(fmt.Sprintf("static const int8_t %s_x_offsets[%d] = { %s };\n", name, count, list(offsets)))
+		}
+		out.WriteByte('\n')
+		out.WriteString("// Returns the glyph for c, or nullptr if the font doesn't have one.\n")
+		out.WriteString(fmt.Sprintf("static inline const uint8_t *%s_glyph(char c) {\n", name))
+		out.WriteString(fmt.Sprintf("    size_t idx = static_cast<size_t>(static_cast<unsigned char>(c) - %s_first);\n", name))
+		out.WriteString(fmt.Sprintf("    return idx < %s_count ? %s_glyphs[idx] : nullptr;\n", name, name))
+		out.WriteString("}\n")
+
+	case "js", "cjs":
+		esm := gen.Renderer == "js"
+		ref := func(varName string) string {
+			if esm || separate {
+				return varName
+			}
+			return "exports." + varName
+		}
+		if separate {
+			for _, img := range images {
+				if esm {
+					out.WriteString(fmt.Sprintf("import { %s } from %q;\n", img.VarName, "./"+img.Name))
+				} else {
+					out.WriteString(fmt.Sprintf("const { %s } = require(%q);\n", img.VarName, "./"+img.Name))
+				}
+			}
+			out.WriteByte('\n')
+		}
+		export := "exports." + name
+		if esm {
+			export = "export const " + name
+		}
+		refs := make([]string, count)
+		for idx, img := range images {
+			refs[idx] = ref(img.VarName)
+		}
+		out.WriteString(fmt.Sprintf("// Glyphs for chars %d to %d. The glyph for char c is glyphs[c - first].\n", first, last))
+		out.WriteString("// prettier-ignore deno-fmt-ignore\n")
+		out.WriteString(fmt.Sprintf("%s = Object.freeze({\n", export))
+		out.WriteString(fmt.Sprintf("  first: %d,\n", first))
+		out.WriteString(fmt.Sprintf("  height: %d,\n", images[0].Height))
+		if ascent > 0 {
+			out.WriteString(fmt.Sprintf("  ascent: %d,\n", ascent))
+		}
+		out.WriteString("  glyphs: Object.freeze([\n")
+		for _, r := range refs {
+			out.WriteString(fmt.Sprintf("    %s,\n", r))
+		}
+		out.WriteString("  ]),\n")
+		out.WriteString(fmt.Sprintf("  widths: new Uint8Array([%s]),\n", list(widths)))
+		out.WriteString(fmt.Sprintf("  advances: new Uint8Array([%s]),\n", list(advances)))
+		if ascent > 0 {
+			out.WriteString(fmt.Sprintf("  xOffsets: new Int8Array([%s]),\n", list(offsets)))
+		}
+		out.WriteString("});\n")
+
+	default:
+		return Output{}, fmt.Errorf("font %q: fonts are not supported by the %q renderer", name, gen.Renderer)
+	}
+
+	outName := name + rendererExt(gen.Renderer)
+	data, err := gen.reindent(outName, out.Bytes())
+	if err != nil {
+		return Output{}, err
+	}
+	return Output{Name: outName, Data: data, VarName: name}, nil
+}
@@ -0,0 +1,83 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// outputIndents is the width of one level of indentation written by the built in
+// renderers, keyed by output extension. Outputs not listed, such as term, xpm and basic
+// output, where leading whitespace is part of the content, are never reindented.
+var outputIndents = map[string]int{
+	".h":     4,
+	".cpp":   4,
+	".cppm":  4,
+	".js":    2,
+	".xbm":   4,
+	".java":  4,
+	".kt":    4,
+	".swift": 4,
+	".asm":   4,
+	".glsl":  4,
+	".wgsl":  4,
+	".rs":    4,
+}
+
+// indentUnit parses g.Indent, which is 'tab' or a number of spaces, into the string
+// written for one level of indentation. It returns "" if g.Indent is empty, meaning
+// each renderer's own indentation is kept.
+func (g *Generator) indentUnit() (string, error) {
+	switch g.Indent {
+	case "":
+		return "", nil
+	case "tab", "tabs":
+		return "\t", nil
+	}
+	n, err := strconv.Atoi(g.Indent)
+	if err != nil || n < 0 || n > 16 {
+		return "", fmt.Errorf("indent must be 'tab' or a number of spaces from 0 to 16, found %q", g.Indent)
+	}
+	return strings.Repeat(" ", n), nil
+}
+
+// reindent replaces each level of the renderer's indentation at the start of the lines
+// in the output called name with g.Indent.
+func (g *Generator) reindent(name string, data []byte) ([]byte, error) {
+	unit, err := g.indentUnit()
+	if err != nil || g.Indent == "" {
+		return data, err
+	}
+	width := outputIndents[filepath.Ext(name)]
+	if width == 0 {
+		return data, nil
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, " ")
+		spaces := len(line) - len(trimmed)
+		out.WriteString(strings.Repeat(unit, spaces/width))
+		out.WriteString(strings.Repeat(" ", spaces%width))
+		out.Write(trimmed)
+	}
+	return out.Bytes(), nil
+}
+
+// wrapRow splits row into the values written on each line: the whole row, or gen.Wrap
+// values at a time if set.
+func (rc *renderContext) wrapRow(row []uint8) [][]uint8 {
+	n := rc.gen.Wrap
+	if n <= 0 || n >= len(row) {
+		return [][]uint8{row}
+	}
+	lines := make([][]uint8, 0, (len(row)+n-1)/n)
+	for len(row) > n {
+		lines = append(lines, row[:n])
+		row = row[n:]
+	}
+	return append(lines, row)
+}
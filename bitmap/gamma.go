@@ -0,0 +1,150 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/draw"
+)
+
+// srgbToLinear converts an sRGB-encoded channel value in the range 0-1 to linear light.
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB converts a linear light channel value in the range 0-1 to sRGB.
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+var srgbToLinear16 = func() (lut [256]uint16) {
+	for i := range lut {
+		lut[i] = uint16(math.Round(srgbToLinear(float64(i)/0xff) * 0xffff))
+	}
+	return lut
+}()
+
+// linearHSP is the HSP intensity of col, computed from linear light rather than the
+// sRGB-encoded values.
+func linearHSP(col color.Color) float64 {
+	c := color.NRGBAModel.Convert(col).(color.NRGBA)
+	return hsp(color.NRGBA64{
+		R: srgbToLinear16[c.R],
+		G: srgbToLinear16[c.G],
+		B: srgbToLinear16[c.B],
+		A: uint16(c.A) * 0x101,
+	})
+}
+
+// scaleLinear scales the bounds of img into a new image of size, converting to linear
+// light before scaling and back to sRGB afterwards, so that averaging neighbouring
+// pixels does not darken the result.
+func scaleLinear(scl draw.Scaler, img image.Image, bounds image.Rectangle, size image.Point) image.Image {
+	lin := image.NewRGBA64(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			a := uint32(c.A) * 0x101
+			lin.SetRGBA64(x-bounds.Min.X, y-bounds.Min.Y, color.RGBA64{
+				R: uint16(uint32(srgbToLinear16[c.R]) * a / 0xffff),
+				G: uint16(uint32(srgbToLinear16[c.G]) * a / 0xffff),
+				B: uint16(uint32(srgbToLinear16[c.B]) * a / 0xffff),
+				A: uint16(a),
+			})
+		}
+	}
+
+	nb := image.Rectangle{Max: size}
+	scaled := image.NewRGBA64(nb)
+	scl.Scale(scaled, nb, lin, lin.Bounds(), draw.Over, nil)
+
+	dst := image.NewNRGBA(nb)
+	encode := func(v, a uint16) uint8 {
+		return uint8(math.Round(linearToSRGB(float64(v)/float64(a)) * 0xff))
+	}
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			c := scaled.RGBA64At(x, y)
+			if c.A == 0 {
+				continue
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: encode(c.R, c.A),
+				G: encode(c.G, c.A),
+				B: encode(c.B, c.A),
+				A: uint8(c.A >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// deviceRamp returns the grey each of levels palette values appears as on a display
+// described by g.DeviceGamma, as sRGB colours from the lowest value to the highest.
+//
+// g.DeviceGamma is either the display's gamma, in which case value i of n emits
+// (i/(n-1))^gamma of its maximum luminance, or the path to a file of the measured
+// luminance of each value, separated by commas or whitespace. Measurements may be in
+// any unit, i.e. cd/m², as they are scaled so the brightest is 1. Mapping pixels to the
+// nearest of these greys spaces the values as they are perceived on the device, rather
+// than evenly in sRGB.
+func (g *Generator) deviceRamp(levels int) (color.Palette, error) {
+	var lum []float64
+	if gamma, err := strconv.ParseFloat(g.DeviceGamma, 64); err == nil {
+		if gamma <= 0 {
+			return nil, fmt.Errorf("device gamma must be greater than 0, found %g", gamma)
+		}
+		for i := 0; i < levels; i++ {
+			v := 1.0
+			if levels > 1 {
+				v = math.Pow(float64(i)/float64(levels-1), gamma)
+			}
+			lum = append(lum, v)
+		}
+
+	} else {
+		bts, err := os.ReadFile(g.DeviceGamma)
+		if err != nil {
+			return nil, fmt.Errorf("device gamma must be a number or a luminance table file: %w", err)
+		}
+		var max float64
+		for _, field := range strings.FieldsFunc(string(bts), func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		}) {
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("luminance table %q: invalid luminance %q", g.DeviceGamma, field)
+			}
+			lum = append(lum, v)
+			max = math.Max(max, v)
+		}
+		if len(lum) != levels {
+			return nil, fmt.Errorf("luminance table %q has %d values, but the char palette has %d", g.DeviceGamma, len(lum), levels)
+		}
+		if max == 0 {
+			return nil, fmt.Errorf("luminance table %q has no values above 0", g.DeviceGamma)
+		}
+		for i := range lum {
+			lum[i] /= max
+		}
+	}
+
+	ramp := make(color.Palette, len(lum))
+	for i, v := range lum {
+		y := uint8(math.Round(linearToSRGB(v) * 0xff))
+		ramp[i] = color.Gray{Y: y}
+	}
+	return ramp, nil
+}
@@ -0,0 +1,1095 @@
+package bitmap
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/shabbyrobe/wu2quant"
+	"golang.org/x/image/draw"
+)
+
+type Generator struct {
+	Palette       Palette `json:"palette,omitempty"`
+	Invert        bool    `json:"invert,omitempty"`
+	TargetWidth   int     `json:"targetWidth,omitempty"`
+	TargetHeight  int     `json:"targetHeight,omitempty"`
+	Scaler        string  `json:"scaler,omitempty"`
+	Renderer      string  `json:"renderer,omitempty"`
+	VarName       string  `json:"varName,omitempty"`
+	PaletteOffset int     `json:"paletteOffset,omitempty"`
+	RowWiseJS     bool    `json:"rowWiseJS,omitempty"`
+	NoQuantize    bool    `json:"noQuantize,omitempty"`
+	Linear        bool    `json:"linear,omitempty"`
+	TermColor     string  `json:"termColor,omitempty"`
+	PostProcess   string  `json:"postProcess,omitempty"`
+
+	// If set, the variable name of each area, or of the whole input if there is no map,
+	// where '{name}' is replaced with VarName, '{index}' with the area's number, '{file}'
+	// with the input's name without its extension, and '{w}' and '{h}' with the size of
+	// the output. See expandVarName.
+	VarNameTemplate string `json:"varNameTemplate,omitempty"`
+
+	// Indentation and number of values per line of the generated code. Indent is 'tab'
+	// or a number of spaces per level; if empty, each renderer's own indentation is
+	// kept. If Wrap is 0, each row is one line. See reindent and wrapRow.
+	Indent string `json:"indent,omitempty"`
+	Wrap   int    `json:"wrap,omitempty"`
+
+	// If set, renderers write each pixel's numeric value, with PaletteOffset applied,
+	// rather than defining a constant for each palette char and writing the chars. The
+	// term and xpm renderers, whose output is made of the chars, ignore it.
+	Literal bool `json:"literal,omitempty"`
+
+	// Comma separated identifiers the palette chars must not collide with, such as
+	// existing single letter macros in the project. See pickChars.
+	Reserved string `json:"reserved,omitempty"`
+
+	// Data directive and number of values per directive used by the asm renderer. The
+	// directive defaults to '.byte'. If AsmPerLine is 0, Wrap is used, and if that is 0
+	// too, each row is one directive.
+	AsmDirective string `json:"asmDirective,omitempty"`
+	AsmPerLine   int    `json:"asmPerLine,omitempty"`
+
+	// First line number and line number increment used by the basic renderer.
+	BasicLine int `json:"basicLine,omitempty"`
+	BasicStep int `json:"basicStep,omitempty"`
+
+	// If > 0, the image is rescaled and quantized this many output rows at a time, to
+	// reduce memory use when rescaling very large images. See tiledPaletted.
+	TileRows int `json:"tileRows,omitempty"`
+
+	// Number of goroutines used to quantize. 0 or 1 uses wu2quant directly, < 0 uses one
+	// per CPU. See quantizeParallel.
+	Threads int `json:"threads,omitempty"`
+
+	// If true, adaptive quantization counts each pixel in proportion to its alpha, so
+	// nearly transparent fringe pixels don't take palette entries from visible ones.
+	// See alphaWeightedSample.
+	AlphaWeight bool `json:"alphaWeight,omitempty"`
+
+	// Error diffusion used when mapping pixels to a quantized or fixed palette: 'none',
+	// or 'floyd-steinberg', which trades flat areas of the nearest colour for noise
+	// approximating the source colour. See ditherPaletted.
+	Dither string `json:"dither,omitempty"`
+
+	// Rotate the source clockwise by this many degrees (0, 90, 180 or 270), then flip
+	// it horizontally ('h') or vertically ('v'), before any other processing.
+	Rotate int    `json:"rotate,omitempty"`
+	Flip   string `json:"flip,omitempty"`
+
+	// Colour channel corrections applied before any other processing. ChannelSwap is
+	// the source channel to use for each of red, green and blue, i.e. 'bgr' swaps red
+	// and blue. InvertChannels is any of 'r', 'g' and 'b', i.e. 'rb'. See swapChannels.
+	ChannelSwap    string `json:"channelSwap,omitempty"`
+	InvertChannels string `json:"invertChannels,omitempty"`
+
+	// Tonal adjustments applied after rescaling, before quantization. Levels is a black
+	// and white point in '<black>,<white>' format, i.e. '16,240'. Brightness and
+	// Contrast are in the range -1 to 1. See adjust.
+	Levels     string  `json:"levels,omitempty"`
+	Brightness float64 `json:"brightness,omitempty"`
+	Contrast   float64 `json:"contrast,omitempty"`
+
+	// Name of the C++20 module declared by the cppm renderer, i.e. 'assets.logo'.
+	// Defaults to the variable name.
+	Module string `json:"module,omitempty"`
+
+	// Storage the cpp20 renderer declares the array with: 'constinit' (the default) or
+	// 'constexpr' for an inline variable shared by every translation unit, or 'static'
+	// for a copy in each. If CPPSpan is set, a '<var>_span()' accessor is emitted too.
+	// See renderCPP20.
+	CPPStorage string `json:"cppStorage,omitempty"`
+	CPPSpan    bool   `json:"cppSpan,omitempty"`
+
+	// Author/license text emitted as a comment at the top of the output, for asset
+	// provenance. May contain multiple lines.
+	Attribution string `json:"attribution,omitempty"`
+
+	// If set, a comment describing how the output was generated is emitted after the
+	// attribution, so generated files can be traced and regenerated. Input describes
+	// the input file, if known. See provenanceComment.
+	Provenance bool       `json:"provenance,omitempty"`
+	Input      *InputInfo `json:"-"`
+
+	// If set, the image is mapped to the nearest colours in this palette rather than
+	// adaptively quantized. See loadFixedPalette for supported formats.
+	FixedPalette string `json:"fixedPalette,omitempty"`
+
+	// If set, this image is quantized once and its palette is used as a fixed palette,
+	// so images converted with the same reference share index-to-colour meanings.
+	PaletteFrom string `json:"paletteFrom,omitempty"`
+
+	// If set, the colour each character was mapped to is saved to this file, or if it
+	// already exists, loaded from it and reused, so regenerating an edited image keeps
+	// the same characters. '{var}' is replaced with VarName.
+	PaletteLock string `json:"paletteLock,omitempty"`
+
+	// If set, the image is mapped to the greys each palette value appears as on the
+	// target display, rather than adaptively quantized, so equal perceived steps on the
+	// device map to the emitted values. Either the display's gamma, i.e. '2.2', or the
+	// path to a file of the measured luminance of each value. See deviceRamp.
+	DeviceGamma string `json:"deviceGamma,omitempty"`
+
+	// If set, used as a fixed palette in preference to PaletteFrom and FixedPalette.
+	// Set for every area by -palette-union.
+	SharedPalette color.Palette `json:"-"`
+
+	// If set, quantization is skipped and each pixel is mapped to the darkest or
+	// lightest character by comparing its luma with this threshold (0-255), or 'auto'
+	// to pick one with Otsu's method. See monoPaletted.
+	Mono string `json:"mono,omitempty"`
+
+	// If set, source colours are mapped directly to the characters in the ColorMap,
+	// and Palette, NoQuantize and Invert are ignored.
+	ColorMap ColorMap `json:"colorMap,omitempty"`
+
+	// If set, only pixels that are opaque in this image are emitted. All other pixels
+	// are set to the palette character at intensity StencilFill.
+	Stencil     string `json:"stencil,omitempty"`
+	StencilFill int    `json:"stencilFill,omitempty"`
+
+	// If set, a signed distance field is computed from the source shape and emitted as
+	// raw 8-bit values instead of palette characters. See buildSDF.
+	SDF       bool    `json:"sdf,omitempty"`
+	SDFSpread float64 `json:"sdfSpread,omitempty"`
+
+	// Alignment of the emitted data, for consumption by DMA. Align is the alignment of
+	// the start of the array in bytes, RowAlign pads each row to a multiple of this many
+	// values, and SizeAlign pads the total size to a multiple of this many values.
+	// Padding uses the lowest intensity. If any are set, the layout is also emitted as
+	// constants.
+	Align     int `json:"align,omitempty"`
+	RowAlign  int `json:"rowAlign,omitempty"`
+	SizeAlign int `json:"sizeAlign,omitempty"`
+
+	// Width of the image to select from .ico and .cur inputs. If 0, the largest is used.
+	IcoSize int `json:"icoSize,omitempty"`
+
+	// Pixel corrections applied after quantization, rescaling and the stencil, such as
+	// 'set 3,4 _', 'fill 0,0,2x2 _' or 'replace c o'.
+	Edits PixelEdits `json:"edits,omitempty"`
+
+	// If true, the image is quantized at its original size, then scaled with nearest
+	// neighbour sampling, which keeps hard palette boundaries in pixel art. Scaler is
+	// ignored.
+	ScaleAfterQuantize bool `json:"scaleAfterQuantize,omitempty"`
+
+	// How the image is fitted to TargetWidth and TargetHeight: exact, integer, contain
+	// or cover. If FitPad is set, contained and integer scaled images are centred in
+	// the target size. See fitSizes.
+	Fit    string `json:"fit,omitempty"`
+	FitPad bool   `json:"fitPad,omitempty"`
+
+	// Pixels added around the quantized image, as '<l>,<t>,<r>,<b>' or a single value
+	// for every side. The padded image is then placed on a canvas of Canvas pixels
+	// ('<w>x<h>'), if set, at CanvasAnchor: center (the default), top, bottom, left,
+	// right, top-left, top-right, bottom-left or bottom-right. New pixels use the
+	// palette character at intensity PadFill. See extendCanvas.
+	Pad          string `json:"pad,omitempty"`
+	Canvas       string `json:"canvas,omitempty"`
+	CanvasAnchor string `json:"canvasAnchor,omitempty"`
+	PadFill      int    `json:"padFill,omitempty"`
+
+	// Frame and comma separated layer names to use from Aseprite inputs. If AseLayers is
+	// empty, all visible layers are used.
+	AseFrame  int    `json:"aseFrame,omitempty"`
+	AseLayers string `json:"aseLayers,omitempty"`
+
+	// Order the pixels are stored in: 'row-major' (the default), 'column-major', where
+	// each stored row is a logical column, or 'vertical-bytes', the page layout used by
+	// SSD1306 displays, where each byte is a column of 8 pixels with the top pixel in the
+	// least significant bit. vertical-bytes requires a 2 character palette.
+	Layout string `json:"layout,omitempty"`
+
+	// If true, the array is stored rotated 90 degrees clockwise, so each stored row is a
+	// logical column read from the bottom up. The logical width and height are emitted
+	// alongside a flag so rendering code knows how to index it.
+	StoreRotated bool `json:"storeRotated,omitempty"`
+
+	// If true, the js and cjs renderers also export the full colour image, after
+	// rescaling but before quantization, as '<var>_rgba', a Uint8ClampedArray suitable
+	// for ImageData.
+	JSRGBA bool `json:"jsRGBA,omitempty"`
+
+	// If set, the colour of each emitted value is also emitted as '<var>_palette',
+	// indexed by value, so a display's colour lookup table can be programmed to match.
+	// Values: rgb888, rgb565.
+	ColorTable string `json:"colorTable,omitempty"`
+
+	// If true, the js and cjs renderers drop comments and whitespace, for modules that
+	// are served directly rather than through a bundler. Attribution is kept.
+	Minify bool `json:"minify,omitempty"`
+
+	// If true, a '<var>_rev' constant is emitted containing the FNV-1a hash of the
+	// values, so firmware can tell whether a flashed asset differs from the build tree.
+	Rev bool `json:"rev,omitempty"`
+
+	// If set, the size of the source image before rescaling is emitted as
+	// '<var>_src_width' and '<var>_src_height', for layout code which needs the
+	// original aspect ratio.
+	SrcSize bool `json:"srcSize,omitempty"`
+
+	// If set, Build fails if the image data it emits, after packing, is more than this
+	// many bytes. Tables such as colour tables and frames are not counted.
+	Budget int `json:"budget,omitempty"`
+
+	// If set, the image is split into two layers using this rule against each source
+	// pixel, i.e. 'alpha>=128' or 'luma<64', for displays which draw a foreground
+	// plane over a background plane. See layerRows. C++ and JS renderers only.
+	ForegroundRule string `json:"foregroundRule,omitempty"`
+
+	// If set, a companion test file is emitted which checks a checksum and a few
+	// sampled pixels of the output. Values: gtest, catch2, js.
+	TestFixture string `json:"testFixture,omitempty"`
+
+	// If set to 1, 2 or 4, the C++, arduino, asm, basic, hex and base64 renderers pack
+	// several values into each byte, most significant first, with each row starting on
+	// a byte boundary. If Unpack is also set, a '<var>_unpack' function is emitted which
+	// unpacks the data into a RAM buffer, for projects without a decoder of their own
+	// (C++ renderers only).
+	PackBits int  `json:"packBits,omitempty"`
+	Unpack   bool `json:"unpack,omitempty"`
+
+	// If Glyphs is set, the image is a font strip of this many glyphs, and their
+	// offsets and widths are emitted as '<var>_glyph_*' tables. The strip wraps after
+	// every GlyphColumns glyphs, if set. GlyphWidths is a comma separated list of each
+	// glyph's width in output pixels, for proportional fonts. See glyphRects.
+	Glyphs       int    `json:"glyphs,omitempty"`
+	GlyphColumns int    `json:"glyphColumns,omitempty"`
+	GlyphWidths  string `json:"glyphWidths,omitempty"`
+
+	// Position of each frame in a sprite sheet, emitted as '<var>_frames'. Set by
+	// -sheet and -atlas. If FrameNames is set, each frame's position is also emitted
+	// by name, as '<var>_<name>'.
+	Frames     []image.Rectangle `json:"-"`
+	FrameNames []string          `json:"-"`
+
+	// Warnings found while building are added to this collector, if it is not nil.
+	// Clones share the same collector.
+	Warnings *Warnings `json:"-"`
+
+	// If set, Build describes the conversion here for the -report page. Unlike Warnings,
+	// each Build needs its own entry.
+	Report *ReportEntry `json:"-"`
+
+	// If set, Build leaves the palette lock here for its caller to save once every
+	// Build has succeeded, rather than saving it itself. See deferPaletteLocks.
+	lock *paletteLock
+
+	// If set, the quantized (and rescaled) image is saved to this path as a PNG
+	// for inspection. '{var}' is replaced with VarName.
+	Preview string `json:"preview,omitempty"`
+
+	// If set, the fully processed image, after edits, is saved to this path as a PNG,
+	// BMP or GIF, depending on the extension, so it can be reviewed or used by other
+	// tools. '{var}' is replaced with VarName. See exportImage.
+	ExportImage string `json:"exportImage,omitempty"`
+}
+
+// NewGenerator returns a Generator with the same defaults as the command line flags,
+// for building images in code.
+func NewGenerator() *Generator {
+	var gen Generator
+	var sizeRaw string
+	registerGeneratorFlags(flag.NewFlagSet("", flag.ContinueOnError), &gen, &sizeRaw)
+	return &gen
+}
+
+func (g *Generator) Clone() *Generator {
+	clone := *g
+	return &clone
+}
+
+// Build processes img and renders it using g.Renderer. Most renderers produce a single
+// output, named after g.VarName.
+func (g *Generator) Build(img image.Image) ([]Output, error) {
+	if err := g.checkOptions(); err != nil {
+		return nil, &usageError{err: err}
+	}
+	renderCtx, err := g.process(img)
+	if err != nil {
+		return nil, err
+	}
+
+	outs, err := g.renderOutputs(renderCtx)
+	if err != nil {
+		return nil, &renderError{g.VarName, err}
+	}
+	width, height := renderCtx.layout.logicalSize()
+	size := renderCtx.dataSize()
+	if g.Budget > 0 && size > g.Budget {
+		return nil, fmt.Errorf("%s: %d bytes of image data is over the budget of %d bytes", g.VarName, size, g.Budget)
+	}
+	for idx := range outs {
+		outs[idx].VarName, outs[idx].Width, outs[idx].Height = g.VarName, width, height
+		outs[idx].Size = size
+	}
+	if g.Report != nil {
+		g.Report.fill(img, renderCtx, outs)
+	}
+	if renderCtx.lock != nil {
+		if g.lock != nil {
+			*g.lock = *renderCtx.lock
+		} else if err := renderCtx.lock.save(); err != nil {
+			return nil, err
+		}
+	}
+	return outs, nil
+}
+
+// checkOptions returns an error if g names an unknown renderer or scaler, or has
+// options which can't be used together. It doesn't need an image, so it is run before
+// Build does any work, and by 'validate'.
+func (g *Generator) checkOptions() error {
+	if err := checkRenderer(g.Renderer); err != nil {
+		return err
+	}
+	if err := checkScaler(g.Scaler); err != nil {
+		return err
+	}
+	if err := checkDither(g.Dither); err != nil {
+		return err
+	}
+	if g.dithered() && (g.SDF || g.Mono != "" || g.NoQuantize || g.PaletteLock != "" || g.ColorMap.Palette.Size > 0 || g.TileRows > 0) {
+		return fmt.Errorf("dithering cannot be used with -sdf, -mono, -no-quantize, -palette-lock, -colormap or tiling")
+	}
+	if err := g.checkFit(); err != nil {
+		return err
+	}
+	if err := g.checkCanvas(); err != nil {
+		return err
+	}
+	if err := g.checkVarNameTemplate(); err != nil {
+		return err
+	}
+	if _, ok := cppStorages[g.CPPStorage]; !ok {
+		return fmt.Errorf("unknown C++ storage %q, expected constinit, constexpr or static", g.CPPStorage)
+	}
+	if g.TileRows > 0 && (g.SDF || g.ScaleAfterQuantize || g.Mono != "" || g.PaletteLock != "" || g.ColorMap.Palette.Size > 0) {
+		return fmt.Errorf("tiling cannot be used with -sdf, -scale-after, -mono, -palette-lock or -colormap")
+	}
+	if g.DeviceGamma != "" && (g.FixedPalette != "" || g.PaletteFrom != "" || len(g.SharedPalette) > 0 || g.TileRows > 0) {
+		return fmt.Errorf("device gamma cannot be used with -fixed-palette, -palette-from, -palette-union or tiling")
+	}
+
+	switch g.PackBits {
+	case 0, 8:
+		if g.Unpack {
+			return fmt.Errorf("-unpack requires -pack-bits")
+		}
+	case 1, 2, 4:
+		switch g.Renderer {
+		case "cpp", "cpp17", "cppm", "arduino", "asm", "basic", "hex", "base64":
+		default:
+			return fmt.Errorf("packing is not supported by the %q renderer", g.Renderer)
+		}
+		if g.Layout == "vertical-bytes" {
+			return fmt.Errorf("the vertical-bytes layout is already packed")
+		}
+		if g.TestFixture != "" {
+			return fmt.Errorf("test fixtures are not supported with packing")
+		}
+	default:
+		return fmt.Errorf("bits per value must be 1, 2, 4 or 8, found %d", g.PackBits)
+	}
+
+	// Renderers outside this package only see RenderData, which has no tables:
+	tables := builtinRenderer(g.Renderer)
+	switch g.Renderer {
+	case "term", "xbm", "xpm", "java", "kotlin", "swift", "glsl", "wgsl", "asm", "basic", "hex", "base64":
+		tables = false
+	}
+	if !tables {
+		if g.ColorTable != "" {
+			return fmt.Errorf("colour tables are not supported by the %q renderer", g.Renderer)
+		}
+		if len(g.Frames) > 0 {
+			return fmt.Errorf("sprite sheets are not supported by the %q renderer", g.Renderer)
+		}
+		if g.Glyphs > 0 {
+			return fmt.Errorf("glyph tables are not supported by the %q renderer", g.Renderer)
+		}
+	}
+	if g.ForegroundRule != "" {
+		switch {
+		case g.Renderer != "cpp" && g.Renderer != "cpp17" && g.Renderer != "js" && g.Renderer != "cjs":
+			return fmt.Errorf("foreground rules are not supported by the %q renderer", g.Renderer)
+		case g.PackBits > 0 && g.PackBits < 8, g.TestFixture != "":
+			return fmt.Errorf("foreground rules cannot be used with packing or test fixtures")
+		case g.StoreRotated, g.Layout != "" && g.Layout != "row-major":
+			return fmt.Errorf("foreground rules cannot be used with -store-rotated or -layout")
+		}
+	}
+
+	switch g.Renderer {
+	case "term", "xbm", "xpm":
+		if g.Layout != "" && g.Layout != "row-major" {
+			return fmt.Errorf("the %s layout is not supported by the %q renderer", g.Layout, g.Renderer)
+		}
+	}
+
+	if g.Wrap < 0 {
+		return fmt.Errorf("values per line must be >= 0, found %d", g.Wrap)
+	}
+	if _, err := g.indentUnit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (g *Generator) renderOutputs(renderCtx *renderContext) ([]Output, error) {
+	var outs []Output
+	var err error
+	if g.Renderer == "rustbin" {
+		if outs, err = renderRustBin(renderCtx); err != nil {
+			return nil, err
+		}
+	} else {
+		var out bytes.Buffer
+		if err := render(g, renderCtx, &out); err != nil {
+			return nil, err
+		}
+		outs = []Output{{Name: g.VarName + rendererExt(g.Renderer), Data: out.Bytes()}}
+		if g.TestFixture != "" {
+			fixture, err := renderTestFixture(renderCtx, g.TestFixture, outs[0].Name)
+			if err != nil {
+				return nil, err
+			}
+			outs = append(outs, fixture)
+		}
+	}
+
+	for idx, out := range outs {
+		if !out.Binary {
+			if outs[idx].Data, err = g.reindent(out.Name, out.Data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return outs, nil
+}
+
+// process runs img through the rescaling, quantization and palette mapping pipeline,
+// returning everything a renderer needs.
+func (g *Generator) process(img image.Image) (*renderContext, error) {
+	srcBounds := img.Bounds()
+
+	if err := g.pickChars(); err != nil {
+		return nil, err
+	}
+
+	// Indexed Aseprite sprites are mapped to their authored palette instead of being
+	// quantized, unless another palette is given:
+	var authored color.Palette
+	if ase, ok := img.(asePaletted); ok {
+		authored = ase.Palette
+	}
+
+	img, err := transform(img, g.Rotate, g.Flip)
+	if err != nil {
+		return nil, err
+	}
+	if img, err = g.swapChannels(img); err != nil {
+		return nil, err
+	}
+	srcSize := img.Bounds().Size()
+
+	var palimg *image.Paletted
+	var paletteIndexes []uint8
+	var paletteIndexToChar [256]rune
+	var pal = &g.Palette
+	var literal bool
+
+	// Rescale. SDFs are computed from the unscaled source, and tiled images are rescaled
+	// a band at a time as they are quantized:
+	if !g.SDF && !g.ScaleAfterQuantize && g.TileRows <= 0 {
+		img = g.rescale(img)
+	}
+	if img, err = g.adjust(img); err != nil {
+		return nil, err
+	}
+	source := img
+
+	// The lock loaded from g.PaletteLock, or the lock to save to it once the build has
+	// succeeded:
+	var lock map[rune]color.NRGBA
+	var newLock *paletteLock
+	if g.PaletteLock != "" && !g.SDF && g.Mono == "" && g.ColorMap.Palette.Size == 0 {
+		if lock, err = loadPaletteLock(g.paletteLockPath()); err != nil {
+			return nil, err
+		}
+	}
+
+	if g.SDF {
+		// SDF values are emitted as raw values rather than palette characters:
+		pal, literal = &sdfPalette, true
+		palimg = g.buildSDF(img)
+		for v := 0; v < 256; v++ {
+			paletteIndexes = append(paletteIndexes, uint8(v))
+			paletteIndexToChar[v] = pal.IntensityRune[v]
+		}
+
+	} else if lock != nil {
+		// Locked colours keep the characters they were saved with. As with a colour map,
+		// the palette index is also the intensity:
+		palimg, err = lockedPaletted(img, pal, lock)
+		if err != nil {
+			return nil, err
+		}
+		for intensity := 0; intensity < pal.Size; intensity++ {
+			paletteIndexes = append(paletteIndexes, uint8(intensity))
+			paletteIndexToChar[intensity] = pal.IntensityRune[intensity]
+		}
+
+	} else if g.Mono != "" {
+		palimg, err = g.monoPaletted(img)
+		if err != nil {
+			return nil, err
+		}
+		for intensity := 0; intensity < pal.Size; intensity++ {
+			paletteIndexes = append(paletteIndexes, uint8(intensity))
+			paletteIndexToChar[intensity] = pal.IntensityRune[intensity]
+		}
+
+	} else if g.ColorMap.Palette.Size > 0 {
+		// Colour map entries are already in the order they should be emitted, so the
+		// palette index is also the intensity:
+		pal = &g.ColorMap.Palette
+		palimg, err = colorMappedPaletted(img, g.ColorMap.Colors)
+		if err != nil {
+			return nil, err
+		}
+		for intensity := 0; intensity < pal.Size; intensity++ {
+			paletteIndexes = append(paletteIndexes, uint8(intensity))
+			paletteIndexToChar[intensity] = pal.IntensityRune[intensity]
+		}
+
+	} else {
+		// Quantise:
+		fixed := g.FixedPalette != "" || g.PaletteFrom != "" || len(g.SharedPalette) > 0 || g.DeviceGamma != ""
+		inOrder := false
+		if len(authored) > 0 && !fixed && g.TileRows <= 0 {
+			palimg = authoredPaletted(img, authored)
+			if used := len(uniquePaletteIndexes(palimg)); used <= g.Palette.Size {
+				inOrder = true
+			} else {
+				palimg = nil
+				g.Warnings.Add(WarnAuthoredPalette, "%s: sprite uses %d palette colours, but the char palette only has %d, so it was quantized",
+					g.VarName, used, g.Palette.Size)
+			}
+		}
+		switch {
+		case palimg != nil:
+		case g.TileRows > 0:
+			palimg, err = g.tiledPaletted(img)
+		case fixed:
+			palimg, err = g.fixedPaletted(img)
+		case g.NoQuantize:
+			palimg, err = exactPaletted(img, g.Palette.Size)
+		case g.Threads > 1 || g.Threads < 0 || g.AlphaWeight:
+			palimg, err = quantizeParallel(img, g.Palette.Size, g.Threads, g.AlphaWeight)
+		default:
+			quant := wu2quant.New()
+			palimg, err = quant.ToPaletted(g.Palette.Size, img, nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := img.(asePaletted); g.dithered() && !ok {
+			palimg = ditherPaletted(img, palimg.Palette)
+		}
+
+		// Sort colors by intensity (HSP colour space), except for an authored palette,
+		// whose colours in use keep their authored order. Every colour in a fixed
+		// palette is sorted, not just the ones in use, so the characters are stable
+		// across images:
+		if fixed {
+			for idx := range palimg.Palette {
+				paletteIndexes = append(paletteIndexes, uint8(idx))
+			}
+		} else {
+			paletteIndexes = uniquePaletteIndexes(palimg)
+			if len(paletteIndexes) < g.Palette.Size {
+				g.Warnings.Add(WarnPaletteUnderuse, "%s: image uses %d of %d palette levels",
+					g.VarName, len(paletteIndexes), g.Palette.Size)
+			}
+		}
+		if !inOrder {
+			g.sortByIntensity(paletteIndexes, palimg.Palette)
+		}
+
+		// PaletteIndexes should now be sorted by HSP intensity, so the index will be our
+		// intensity ordering. Map the unique, sorted colors back to the palette characters,
+		// which are ordered by intensity too:
+		for intensity, v := range paletteIndexes {
+			paletteIndexToChar[v] = g.Palette.IntensityRune[intensity]
+		}
+
+		if g.PaletteLock != "" {
+			newLock = newPaletteLock(g.paletteLockPath(), palimg, paletteIndexes, paletteIndexToChar)
+		}
+	}
+
+	if !g.SDF && g.ScaleAfterQuantize {
+		palimg = g.rescalePaletted(palimg)
+	}
+
+	if g.Stencil != "" {
+		if err := g.applyStencil(srcBounds, palimg, &paletteIndexes, &paletteIndexToChar, pal); err != nil {
+			return nil, err
+		}
+	}
+
+	if palimg, err = g.extendCanvas(palimg, &paletteIndexes, &paletteIndexToChar, pal); err != nil {
+		return nil, err
+	}
+
+	if len(g.Edits) > 0 {
+		if literal {
+			return nil, fmt.Errorf("%s: edits are not supported with -sdf", g.VarName)
+		}
+		if err := g.applyEdits(palimg, paletteIndexes, paletteIndexToChar); err != nil {
+			return nil, err
+		}
+	}
+
+	if g.Preview != "" {
+		var b bytes.Buffer
+		if err := png.Encode(&b, palimg); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(g.previewPath(), b.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	if g.ExportImage != "" {
+		if err := exportImage(g.exportImagePath(), palimg); err != nil {
+			return nil, err
+		}
+	}
+
+	for intensity := range paletteIndexes {
+		if v := int(pal.IntensityIndex[intensity]) + g.PaletteOffset; v < 0 || v > 255 {
+			g.Warnings.Add(WarnOffsetOverflow, "%s: palette value %d with offset %d does not fit in a uint8",
+				g.VarName, pal.IntensityIndex[intensity], g.PaletteOffset)
+			break
+		}
+	}
+
+	pixelHeight := palimg.Bounds().Dy()
+	switch g.Layout {
+	case "", "row-major", "vertical-bytes":
+	case "column-major":
+		palimg = transposeStored(palimg)
+	default:
+		return nil, fmt.Errorf("unknown layout %q, expected row-major, column-major or vertical-bytes", g.Layout)
+	}
+	if g.StoreRotated {
+		if g.Layout != "" && g.Layout != "row-major" {
+			return nil, fmt.Errorf("-store-rotated cannot be used with the %s layout", g.Layout)
+		}
+		palimg = rotateStored(palimg)
+	}
+
+	var renderCtx = newRenderContext(g, pal, palimg, paletteIndexes, paletteIndexToChar)
+	renderCtx.literal = literal || g.Literal
+	renderCtx.source = source
+
+	if g.Layout == "vertical-bytes" {
+		// Each value is a byte of 8 pixels, emitted literally like an SDF:
+		bytesImg, err := verticalBytes(palimg, renderCtx.paletteIndexToValue)
+		if err != nil {
+			return nil, err
+		}
+		var byteIndexes []uint8
+		var byteChars [256]rune
+		for v := 0; v < 256; v++ {
+			byteIndexes = append(byteIndexes, uint8(v))
+			byteChars[v] = sdfPalette.IntensityRune[v]
+		}
+		renderCtx = newRenderContext(g, &sdfPalette, bytesImg, byteIndexes, byteChars)
+		renderCtx.literal = true
+		renderCtx.source = source
+		for v := range renderCtx.paletteIndexToValue {
+			renderCtx.paletteIndexToValue[v] = uint8(v)
+		}
+		palimg = bytesImg
+	}
+
+	renderCtx.layout, err = g.computeLayout(palimg.Bounds().Dx(), palimg.Bounds().Dy())
+	if err != nil {
+		return nil, err
+	}
+	renderCtx.layout.rotated = g.StoreRotated
+	if g.Layout != "row-major" {
+		renderCtx.layout.order = g.Layout
+	}
+	renderCtx.layout.pixelHeight = pixelHeight
+	renderCtx.srcSize = srcSize
+	renderCtx.lock = newLock
+	return renderCtx, nil
+}
+
+// rescaleSize returns the size an image of srcSize is rescaled to, after any cropping
+// or padding by g.Fit, warning if the downscale loses too much detail. If no size was
+// requested, ok is false.
+func (g *Generator) rescaleSize(srcSize image.Point) (newSize image.Point, ok bool) {
+	_, _, newSize, ok = g.rescaleSizes(srcSize)
+	return newSize, ok
+}
+
+// rescaleSizes is fitSizes, warning if the downscale loses too much detail.
+func (g *Generator) rescaleSizes(srcSize image.Point) (src image.Rectangle, scaled, final image.Point, ok bool) {
+	src, scaled, final, ok = g.fitSizes(srcSize)
+	if ok && (srcSize.X > scaled.X*lossyDownscaleRatio || srcSize.Y > scaled.Y*lossyDownscaleRatio) {
+		g.Warnings.Add(WarnLossyDownscale, "%s: downscaling from %dx%d to %dx%d loses more than %dx detail",
+			g.VarName, srcSize.X, srcSize.Y, scaled.X, scaled.Y, lossyDownscaleRatio)
+	}
+	return src, scaled, final, ok
+}
+
+func (g *Generator) rescale(img image.Image) image.Image {
+	bounds := img.Bounds()
+	src, scaledSize, newSize, ok := g.rescaleSizes(bounds.Size())
+	if !ok {
+		return img
+	}
+	src = src.Add(bounds.Min)
+	scl := findScaler(g.Scaler)
+	if g.Linear {
+		return placeScaled(scaleLinear(scl, img, src, scaledSize), scaledSize, newSize)
+	}
+	nb := image.Rectangle{Max: scaledSize}
+	dst := image.NewRGBA(nb)
+	scl.Scale(dst, nb, img, src, draw.Over, nil)
+	return placeScaled(dst, scaledSize, newSize)
+}
+
+// rescalePaletted rescales an already quantized image using nearest neighbour sampling,
+// so no colours outside the palette are introduced.
+func (g *Generator) rescalePaletted(img *image.Paletted) *image.Paletted {
+	bounds := img.Bounds()
+	src, scaledSize, newSize, ok := g.rescaleSizes(bounds.Size())
+	if !ok {
+		return img
+	}
+	src = src.Add(bounds.Min)
+	out := image.NewPaletted(image.Rectangle{Max: scaledSize}, img.Palette)
+	for y := 0; y < scaledSize.Y; y++ {
+		sy := src.Min.Y + (2*y+1)*src.Dy()/(2*scaledSize.Y)
+		for x := 0; x < scaledSize.X; x++ {
+			sx := src.Min.X + (2*x+1)*src.Dx()/(2*scaledSize.X)
+			out.SetColorIndex(x, y, img.ColorIndexAt(sx, sy))
+		}
+	}
+	return placeScaledPaletted(out, scaledSize, newSize)
+}
+
+// exactPaletted maps each unique colour in img directly to a palette entry without
+// quantizing. If img contains more than maxColors unique colours, an error is returned.
+func exactPaletted(img image.Image, maxColors int) (*image.Paletted, error) {
+	bounds := img.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), nil)
+	seen := map[color.RGBA]uint8{}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			idx, ok := seen[c]
+			if !ok {
+				if len(seen) >= maxColors {
+					return nil, fmt.Errorf("image contains more than %d unique colours, cannot map without quantizing", maxColors)
+				}
+				idx = uint8(len(seen))
+				seen[c] = idx
+				out.Palette = append(out.Palette, c)
+			}
+			out.SetColorIndex(x-bounds.Min.X, y-bounds.Min.Y, idx)
+		}
+	}
+
+	return out, nil
+}
+
+// fixedPaletted maps each pixel in img to the nearest colour in the device's grey ramp,
+// the shared palette, the palette computed from g.PaletteFrom, or the fixed palette, in
+// that order.
+func (g *Generator) fixedPaletted(img image.Image) (*image.Paletted, error) {
+	var palette = g.SharedPalette
+	var err error
+	if g.DeviceGamma != "" {
+		palette, err = g.deviceRamp(g.Palette.Size)
+	} else if len(palette) == 0 && g.PaletteFrom != "" {
+		palette, err = paletteFromImage(g.PaletteFrom, g.Palette.Size)
+	} else if len(palette) == 0 {
+		palette, err = loadFixedPalette(g.FixedPalette)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(palette) > g.Palette.Size {
+		return nil, fmt.Errorf("fixed palette has %d colours, but the char palette only has %d", len(palette), g.Palette.Size)
+	}
+	return nearestPaletted(img, palette), nil
+}
+
+// authoredPaletted maps img to the authored palette of an indexed Aseprite sprite. If
+// img is still the sprite, its indexes are kept, so duplicate colours in the palette
+// stay distinct, otherwise each pixel is mapped to the nearest colour.
+func authoredPaletted(img image.Image, palette color.Palette) *image.Paletted {
+	ase, ok := img.(asePaletted)
+	if !ok {
+		return nearestPaletted(img, palette)
+	}
+	bounds := img.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			out.SetColorIndex(x-bounds.Min.X, y-bounds.Min.Y, ase.ColorIndexAt(x, y))
+		}
+	}
+	return out
+}
+
+// dithered reports whether g.Dither diffuses error when mapping to a palette.
+func (g *Generator) dithered() bool {
+	return g.Dither != "" && g.Dither != "none"
+}
+
+// ditherPaletted maps img to palette with Floyd-Steinberg error diffusion, which is
+// the only dither checkDither accepts.
+func ditherPaletted(img image.Image, palette color.Palette) *image.Paletted {
+	bounds := img.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette)
+	draw.FloydSteinberg.Draw(out, out.Bounds(), img, bounds.Min)
+	return out
+}
+
+// nearestPaletted maps each pixel in img to the nearest colour in palette.
+func nearestPaletted(img image.Image, palette color.Palette) *image.Paletted {
+	bounds := img.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			out.SetColorIndex(x-bounds.Min.X, y-bounds.Min.Y, uint8(palette.Index(img.At(x, y))))
+		}
+	}
+	return out
+}
+
+// colorMappedPaletted maps each pixel in img to the entry in colors that matches it
+// exactly. If a pixel does not match any entry, an error is returned.
+func colorMappedPaletted(img image.Image, colors []color.NRGBA) (*image.Paletted, error) {
+	bounds := img.Bounds()
+	palette := make(color.Palette, len(colors))
+	lookup := make(map[color.NRGBA]uint8, len(colors))
+	for idx, c := range colors {
+		palette[idx] = c
+		lookup[c] = uint8(idx)
+	}
+
+	out := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			idx, ok := lookup[c]
+			if !ok {
+				return nil, fmt.Errorf("colour %s at %d,%d is not in the colour map", hexColor(c), x, y)
+			}
+			out.SetColorIndex(x-bounds.Min.X, y-bounds.Min.Y, idx)
+		}
+	}
+
+	return out, nil
+}
+
+// applyStencil replaces every pixel in palimg that is not opaque in the stencil image
+// with the palette index for intensity g.StencilFill.
+//
+// srcBounds are the bounds of the source image passed to Build. If the stencil contains
+// srcBounds (i.e. the stencil covers a whole sheet and the source is an area), the
+// matching region of the stencil is used. The stencil is then rescaled to the size of
+// palimg if required.
+func (g *Generator) applyStencil(
+	srcBounds image.Rectangle,
+	palimg *image.Paletted,
+	paletteIndexes *[]uint8,
+	paletteIndexToChar *[256]rune,
+	pal *Palette,
+) error {
+	stencil, err := decode(g.Stencil, decodeOptions{})
+	if err != nil {
+		return fmt.Errorf("could not load stencil: %w", err)
+	}
+	if srcBounds.In(stencil.Bounds()) && srcBounds != stencil.Bounds() {
+		stencil = subImage(stencil, srcBounds)
+	}
+	stencil, err = transform(stencil, g.Rotate, g.Flip)
+	if err != nil {
+		return err
+	}
+
+	size := palimg.Bounds().Size()
+	if stencil.Bounds().Size() != size {
+		nb := image.Rectangle{Max: size}
+		dst := image.NewNRGBA(nb)
+		draw.NearestNeighbor.Scale(dst, nb, stencil, stencil.Bounds(), draw.Src, nil)
+		stencil = dst
+	}
+
+	fill, err := ensureIntensity(palimg, paletteIndexes, paletteIndexToChar, pal, g.StencilFill)
+	if err != nil {
+		return err
+	}
+
+	min := stencil.Bounds().Min
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			_, _, _, a := stencil.At(min.X+x, min.Y+y).RGBA()
+			if a < 0x8000 {
+				palimg.SetColorIndex(x, y, fill)
+			}
+		}
+	}
+	return nil
+}
+
+// ensureIntensity returns the index in palimg that maps to the palette character at
+// intensity. If the image does not use enough colours to reach that intensity,
+// placeholder entries are appended to palimg's palette so that paletteIndexes still
+// maps every intensity to a palette index.
+func ensureIntensity(
+	palimg *image.Paletted,
+	paletteIndexes *[]uint8,
+	paletteIndexToChar *[256]rune,
+	pal *Palette,
+	intensity int,
+) (uint8, error) {
+	if intensity < 0 || intensity >= pal.Size {
+		return 0, fmt.Errorf("intensity %d out of range for palette of size %d", intensity, pal.Size)
+	}
+	for len(*paletteIndexes) <= intensity {
+		if len(palimg.Palette) >= 256 {
+			return 0, fmt.Errorf("no free palette index for intensity %d", intensity)
+		}
+		idx := uint8(len(palimg.Palette))
+		palimg.Palette = append(palimg.Palette, color.Transparent)
+		paletteIndexToChar[idx] = pal.IntensityRune[len(*paletteIndexes)]
+		*paletteIndexes = append(*paletteIndexes, idx)
+	}
+	return (*paletteIndexes)[intensity], nil
+}
+
+// sortByIntensity sorts paletteIndexes from least to most intense, or the reverse if
+// g.Invert is set. Colours with the same intensity are ordered by their RGBA value,
+// then by palette index, so the characters they are assigned don't depend on the order
+// the quantizer happened to produce them in.
+func (g *Generator) sortByIntensity(paletteIndexes []uint8, palette color.Palette) {
+	less := func(a, b uint8) bool {
+		ia, ib := g.intensity(palette[a]), g.intensity(palette[b])
+		if ia != ib {
+			return ia < ib
+		}
+		ar, ag, ab, aa := palette[a].RGBA()
+		br, bg, bb, ba := palette[b].RGBA()
+		if ka, kb := uint64(ar)<<48|uint64(ag)<<32|uint64(ab)<<16|uint64(aa),
+			uint64(br)<<48|uint64(bg)<<32|uint64(bb)<<16|uint64(ba); ka != kb {
+			return ka < kb
+		}
+		return a < b
+	}
+	sort.SliceStable(paletteIndexes, func(i, j int) bool {
+		if g.Invert {
+			return less(paletteIndexes[j], paletteIndexes[i])
+		}
+		return less(paletteIndexes[i], paletteIndexes[j])
+	})
+}
+
+// intensity returns the HSP intensity of col, in linear light if g.Linear is set.
+func (g *Generator) intensity(col color.Color) float64 {
+	if g.Linear {
+		return linearHSP(col)
+	}
+	return hsp(col)
+}
+
+func hsp(col color.Color) float64 {
+	r, g, b, _ := col.RGBA()
+
+	rf := float64(r) / 0xffff
+	gf := float64(g) / 0xffff
+	bf := float64(b) / 0xffff
+
+	rfs := 0.299 * rf
+	gfs := 0.587 * gf
+	bfs := 0.114 * bf
+
+	return math.Sqrt(rfs*rfs + gfs*gfs + bfs*bfs)
+}
+
+func mapSeenChars(img *image.Paletted, paletteIndexToChar [256]rune) map[rune]bool {
+	out := map[rune]bool{}
+	width := img.Bounds().Dx()
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < width; x++ {
+			px := img.ColorIndexAt(x, y)
+			char := paletteIndexToChar[px]
+			out[char] = true
+		}
+	}
+	return out
+}
+
+func uniquePaletteIndexes(palimg *image.Paletted) []uint8 {
+	foundColors := [256]bool{}
+	width := palimg.Bounds().Dx()
+	for y := 0; y < palimg.Bounds().Dy(); y++ {
+		for x := 0; x < width; x++ {
+			px := palimg.ColorIndexAt(x, y)
+			foundColors[px] = true
+		}
+	}
+	colors := make([]uint8, 0)
+	for fc, ok := range foundColors {
+		if ok {
+			colors = append(colors, uint8(fc))
+		}
+	}
+	return colors
+}
+
+// subImage returns the part of img within r. Images that don't implement SubImage are
+// copied into a new image with the same bounds as r.
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	type subImager interface {
+		SubImage(r image.Rectangle) image.Image
+	}
+	if si, ok := img.(subImager); ok {
+		return si.SubImage(r)
+	}
+	r = r.Intersect(img.Bounds())
+	dst := image.NewRGBA(r)
+	draw.Draw(dst, r, img, r.Min, draw.Src)
+	return dst
+}
+
+func prepareSize(targetWidth, targetHeight int, orig image.Point) image.Point {
+	if targetWidth <= 0 {
+		targetWidth = int(
+			math.Round(float64(orig.X) * (float64(targetHeight) / float64(orig.Y))))
+	}
+	if targetHeight <= 0 {
+		targetHeight = int(
+			math.Round(float64(orig.Y) * (float64(targetWidth) / float64(orig.X))))
+	}
+	return image.Point{targetWidth, targetHeight}
+}
@@ -0,0 +1,142 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// glyphRects splits a font strip of width x height into g.Glyphs glyphs, wrapping after
+// every g.GlyphColumns glyphs. Each row of glyphs is the same height. If GlyphWidths
+// is set, it lists the width of each glyph in output pixels and glyphs are packed left
+// to right within their row, otherwise every glyph has the same width.
+func (g *Generator) glyphRects(width, height int) ([]image.Rectangle, error) {
+	count, columns := g.Glyphs, g.GlyphColumns
+	if count <= 0 {
+		return nil, fmt.Errorf("glyph count must be > 0, found %d", count)
+	}
+	if columns <= 0 || columns > count {
+		columns = count
+	}
+	rows := (count + columns - 1) / columns
+	if height%rows != 0 {
+		return nil, fmt.Errorf("%s: height %d does not divide into %d rows of glyphs", g.VarName, height, rows)
+	}
+	glyphHeight := height / rows
+
+	widths := make([]int, count)
+	if g.GlyphWidths == "" {
+		if width%columns != 0 {
+			return nil, fmt.Errorf("%s: width %d does not divide into %d glyphs; use -glyph-widths for proportional fonts", g.VarName, width, columns)
+		}
+		for i := range widths {
+			widths[i] = width / columns
+		}
+	} else {
+		parts := strings.Split(g.GlyphWidths, ",")
+		if len(parts) != count {
+			return nil, fmt.Errorf("%s: found %d glyph widths for %d glyphs", g.VarName, len(parts), count)
+		}
+		for i, part := range parts {
+			w, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("%s: invalid glyph width %q", g.VarName, part)
+			}
+			widths[i] = w
+		}
+	}
+
+	rects := make([]image.Rectangle, count)
+	x := 0
+	for i, w := range widths {
+		if i%columns == 0 {
+			x = 0
+		}
+		y := i / columns * glyphHeight
+		rects[i] = image.Rect(x, y, x+w, y+glyphHeight)
+		x += w
+		if x > width {
+			return nil, fmt.Errorf("%s: glyph %d ends at x=%d, past the image width %d", g.VarName, i, x, width)
+		}
+	}
+	return rects, nil
+}
+
+// glyphTables returns the x offset, y offset and width of each glyph as comma separated
+// lists, and the glyph height. ys is empty if the glyphs are not wrapped. The offsets
+// are emitted as 16 bit values and the widths as 8 bit values, so glyphs which don't
+// fit in them are an error.
+func (rc *renderContext) glyphTables() (xs, ys, widths string, height int, err error) {
+	rects, err := rc.gen.glyphRects(rc.layout.logicalSize())
+	if err != nil {
+		return "", "", "", 0, err
+	}
+	var xl, yl, wl []string
+	wrapped := false
+	for idx, r := range rects {
+		if r.Dx() > math.MaxUint8 {
+			return "", "", "", 0, fmt.Errorf("glyph %d is %d pixels wide, but glyph widths are 8 bit, so at most %d", idx, r.Dx(), math.MaxUint8)
+		}
+		if r.Min.X > math.MaxUint16 || r.Min.Y > math.MaxUint16 {
+			return "", "", "", 0, fmt.Errorf("glyph %d is at %d,%d, but glyph offsets are 16 bit, so at most %d", idx, r.Min.X, r.Min.Y, math.MaxUint16)
+		}
+		xl = append(xl, strconv.Itoa(r.Min.X))
+		yl = append(yl, strconv.Itoa(r.Min.Y))
+		wl = append(wl, strconv.Itoa(r.Dx()))
+		wrapped = wrapped || r.Min.Y > 0
+	}
+	if wrapped {
+		ys = strings.Join(yl, ", ")
+	}
+	return strings.Join(xl, ", "), ys, strings.Join(wl, ", "), rects[0].Dy(), nil
+}
+
+// writeGlyphsCPP writes the glyph count, height, offsets and widths of a font strip as
+// '<var>_glyph_*' constants, if the generator has glyphs.
+func (rc *renderContext) writeGlyphsCPP(out *bytes.Buffer) error {
+	if rc.gen.Glyphs == 0 {
+		return nil
+	}
+	xs, ys, widths, height, err := rc.glyphTables()
+	if err != nil {
+		return err
+	}
+	name, count := rc.gen.VarName, rc.gen.Glyphs
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_glyph_count = %d;\n", name, count))
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_glyph_height = %d;\n", name, height))
+	out.WriteString(fmt.Sprintf("static const uint16_t %s_glyph_x[%d] = {%s};\n", name, count, xs))
+	if ys != "" {
+		out.WriteString(fmt.Sprintf("static const uint16_t %s_glyph_y[%d] = {%s};\n", name, count, ys))
+	}
+	out.WriteString(fmt.Sprintf("static const uint8_t %s_glyph_widths[%d] = {%s};\n\n", name, count, widths))
+	return nil
+}
+
+// writeGlyphsJS writes the same tables as writeGlyphsCPP as exported JS constants.
+func (rc *renderContext) writeGlyphsJS(out *bytes.Buffer, esm bool) error {
+	if rc.gen.Glyphs == 0 {
+		return nil
+	}
+	xs, ys, widths, height, err := rc.glyphTables()
+	if err != nil {
+		return err
+	}
+	export := func(suffix string, value string) {
+		if esm {
+			out.WriteString(fmt.Sprintf("export const %s_%s = %s;\n", rc.gen.VarName, suffix, value))
+		} else {
+			out.WriteString(fmt.Sprintf("exports.%s_%s = %s;\n", rc.gen.VarName, suffix, value))
+		}
+	}
+	export("glyph_count", strconv.Itoa(rc.gen.Glyphs))
+	export("glyph_height", strconv.Itoa(height))
+	export("glyph_x", "new Uint16Array(["+xs+"])")
+	if ys != "" {
+		export("glyph_y", "new Uint16Array(["+ys+"])")
+	}
+	export("glyph_widths", "new Uint8Array(["+widths+"])")
+	return nil
+}
@@ -0,0 +1,128 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// areaGroup is a named set of areas from an image map, in map order.
+type areaGroup struct {
+	name  string
+	tasks []buildTask
+}
+
+// groupTasks returns the groups the tasks belong to, in order of first appearance.
+// Tasks without a group are skipped.
+func groupTasks(tasks []buildTask) []*areaGroup {
+	var groups []*areaGroup
+	byName := map[string]*areaGroup{}
+	for _, task := range tasks {
+		if task.group == "" {
+			continue
+		}
+		group := byName[task.group]
+		if group == nil {
+			group = &areaGroup{name: task.group}
+			byName[task.group] = group
+			groups = append(groups, group)
+		}
+		group.tasks = append(group.tasks, task)
+	}
+	return groups
+}
+
+// memberName returns the name of the group's index constant for an area's variable.
+// Variables already prefixed with the group's name are used as is.
+func (ag *areaGroup) memberName(varName string) string {
+	if strings.HasPrefix(varName, ag.name+"_") {
+		return varName
+	}
+	return ag.name + "_" + varName
+}
+
+// render renders the group's index: an enum of each area's position in the group, and
+// tables of each area's data and size, so runtime code can use 'digits[7]' rather than
+// naming each area. If separate is set, each area is in its own file which the index
+// includes or imports; otherwise the index is expected to follow the areas in the same
+// file.
+func (ag *areaGroup) render(separate bool) (Output, error) {
+	renderer := ag.tasks[0].gen.Renderer
+	for _, task := range ag.tasks[1:] {
+		if rendererExt(task.gen.Renderer) != rendererExt(renderer) {
+			return Output{}, fmt.Errorf("group %q mixes the %q and %q renderers", ag.name, renderer, task.gen.Renderer)
+		}
+	}
+
+	var out bytes.Buffer
+	switch renderer {
+	case "cpp", "cpp17", "cpp20":
+		if separate {
+			for _, task := range ag.tasks {
+				out.WriteString(fmt.Sprintf("#include %q\n", task.gen.VarName+rendererExt(renderer)))
+			}
+			out.WriteByte('\n')
+		}
+		// Enumerators are upper case so they don't collide with the areas' variables:
+		out.WriteString(fmt.Sprintf("enum %s_index : size_t {\n", ag.name))
+		for idx, task := range ag.tasks {
+			out.WriteString(fmt.Sprintf("    %s = %d,\n", strings.ToUpper(ag.memberName(task.gen.VarName)), idx))
+		}
+		out.WriteString(fmt.Sprintf("    %s_COUNT = %d,\n", strings.ToUpper(ag.name), len(ag.tasks)))
+		out.WriteString("};\n\n")
+		out.WriteString(fmt.Sprintf("static const uint8_t *const %s[%d] = {\n", ag.name, len(ag.tasks)))
+		for _, task := range ag.tasks {
+			out.WriteString(fmt.Sprintf("    %s.data(),\n", task.gen.VarName))
+		}
+		out.WriteString("};\n\n")
+		out.WriteString(fmt.Sprintf("static const size_t %s_sizes[%d] = {\n", ag.name, len(ag.tasks)))
+		for _, task := range ag.tasks {
+			out.WriteString(fmt.Sprintf("    %s.size(),\n", task.gen.VarName))
+		}
+		out.WriteString("};\n")
+
+	case "js", "cjs":
+		esm := renderer == "js"
+		export := func(name string) string {
+			if esm {
+				return "export const " + name
+			}
+			return "exports." + name
+		}
+		var names []string
+		for _, task := range ag.tasks {
+			names = append(names, task.gen.VarName)
+		}
+		if separate {
+			for _, name := range names {
+				if esm {
+					out.WriteString(fmt.Sprintf("import { %s } from %q;\n", name, "./"+name+".js"))
+				} else {
+					out.WriteString(fmt.Sprintf("const { %s } = require(%q);\n", name, "./"+name+".js"))
+				}
+			}
+			out.WriteByte('\n')
+		} else if !esm {
+			// Each area is assigned to exports rather than declared in the same file:
+			for idx, name := range names {
+				names[idx] = "exports." + name
+			}
+		}
+		out.WriteString(fmt.Sprintf("%s_index = Object.freeze({\n", export(ag.name)))
+		for idx, task := range ag.tasks {
+			out.WriteString(fmt.Sprintf("  %s: %d,\n", ag.memberName(task.gen.VarName), idx))
+		}
+		out.WriteString("});\n")
+		out.WriteString(fmt.Sprintf("%s = Object.freeze([%s]);\n", export(ag.name), strings.Join(names, ", ")))
+
+	default:
+		return Output{}, fmt.Errorf("group %q: groups are not supported by the %q renderer", ag.name, renderer)
+	}
+
+	name := ag.name + rendererExt(renderer)
+	data, err := ag.tasks[0].gen.reindent(name, out.Bytes())
+	if err != nil {
+		return Output{}, err
+	}
+	return Output{Name: name, Data: data, VarName: ag.name}, nil
+}
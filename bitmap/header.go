@@ -0,0 +1,82 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// headerIncludes lists the standard headers a single header includes, and an
+// identifier whose use in any of the outputs means the header is needed.
+var headerIncludes = []struct{ header, uses string }{
+	{"<array>", "std::array"},
+	{"<array>", "std::to_array"},
+	{"<cstddef>", "size_t"},
+	{"<cstdint>", "int8_t"},
+	{"<cstdint>", "int16_t"},
+	{"<cstdint>", "int32_t"},
+	{"<span>", "std::span"},
+}
+
+// singleHeader combines the C++ outputs of every build in results into one complete
+// header called name, which can be included on its own: a '#pragma once', then every
+// header the outputs need, then each output in turn. Includes in the outputs, such as
+// the arduino renderer's, are moved to the top.
+func singleHeader(name string, results [][]Output) (Output, error) {
+	var body bytes.Buffer
+	includes := map[string]bool{}
+	for _, outs := range results {
+		for _, out := range outs {
+			if out.Binary || filepath.Ext(out.Name) != ".h" {
+				return Output{}, fmt.Errorf("output %q is not a C++ header, so it can't be part of a single header", out.Name)
+			}
+			if body.Len() > 0 {
+				body.WriteByte('\n')
+			}
+			for _, line := range strings.SplitAfter(string(out.Data), "\n") {
+				if strings.HasPrefix(line, "#include ") {
+					includes[strings.TrimSpace(strings.TrimPrefix(line, "#include "))] = true
+					continue
+				}
+				body.WriteString(line)
+			}
+			for _, inc := range headerIncludes {
+				if bytes.Contains(out.Data, []byte(inc.uses)) {
+					includes[inc.header] = true
+				}
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(includes))
+	for inc := range includes {
+		sorted = append(sorted, inc)
+	}
+	sort.Strings(sorted)
+
+	var out bytes.Buffer
+	out.WriteString("#pragma once\n\n")
+	for _, inc := range sorted {
+		out.WriteString("#include " + inc + "\n")
+	}
+	if len(sorted) > 0 {
+		out.WriteByte('\n')
+	}
+	out.Write(bytes.TrimLeft(body.Bytes(), "\n"))
+	return Output{Name: name, Data: out.Bytes()}, nil
+}
+
+// singleHeaderName returns the name of the single header for a job: the output file's
+// name, or if the outputs are written separately, the map's name, or the variable
+// name if there is no map.
+func (job *convertJob) singleHeaderName() string {
+	if job.outFile != "" && !separateOutputs(job.outFile) {
+		return filepath.Base(job.outFile)
+	}
+	if job.mapFile != "" {
+		return strings.TrimSuffix(filepath.Base(job.mapFile), filepath.Ext(job.mapFile)) + ".h"
+	}
+	return job.gen.VarName + ".h"
+}
@@ -0,0 +1,181 @@
+package bitmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"sort"
+)
+
+type icoEntry struct {
+	width, height int
+	bitCount      int
+	size, offset  uint32
+}
+
+// decodeICO decodes an image from an .ico or .cur file. If width is greater than 0, the
+// image with that width is used, otherwise the largest image is used. Where there are
+// several candidates of the same width, the one with the most colours wins.
+//
+// Embedded PNGs are supported, as are 1, 4, 8, 24 and 32-bit DIBs. The AND mask is
+// applied to DIBs without an alpha channel.
+func decodeICO(bts []byte, width int) (image.Image, error) {
+	if len(bts) < 6 {
+		return nil, fmt.Errorf("ico: file too short")
+	}
+	le := binary.LittleEndian
+	if le.Uint16(bts[0:]) != 0 || (le.Uint16(bts[2:]) != 1 && le.Uint16(bts[2:]) != 2) {
+		return nil, fmt.Errorf("ico: invalid header")
+	}
+	count := int(le.Uint16(bts[4:]))
+	if len(bts) < 6+count*16 {
+		return nil, fmt.Errorf("ico: file too short for %d images", count)
+	}
+
+	var entries []icoEntry
+	for i := 0; i < count; i++ {
+		raw := bts[6+i*16:]
+		e := icoEntry{
+			width:    int(raw[0]),
+			height:   int(raw[1]),
+			bitCount: int(le.Uint16(raw[6:])),
+			size:     le.Uint32(raw[8:]),
+			offset:   le.Uint32(raw[12:]),
+		}
+		// A dimension of 0 means 256:
+		if e.width == 0 {
+			e.width = 256
+		}
+		if e.height == 0 {
+			e.height = 256
+		}
+		if uint64(e.offset)+uint64(e.size) > uint64(len(bts)) {
+			return nil, fmt.Errorf("ico: image %d is outside the file", i)
+		}
+		entries = append(entries, e)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].width != entries[j].width {
+			return entries[i].width > entries[j].width
+		}
+		return entries[i].bitCount > entries[j].bitCount
+	})
+
+	var entry *icoEntry
+	for i := range entries {
+		if width <= 0 || entries[i].width == width {
+			entry = &entries[i]
+			break
+		}
+	}
+	if entry == nil {
+		var sizes []int
+		for _, e := range entries {
+			sizes = append(sizes, e.width)
+		}
+		return nil, fmt.Errorf("ico: no image with width %d, found %v", width, sizes)
+	}
+
+	data := bts[entry.offset : entry.offset+entry.size]
+	if bytes.HasPrefix(data, []byte("\x89PNG")) {
+		return png.Decode(bytes.NewReader(data))
+	}
+	return decodeICODIB(data)
+}
+
+// decodeICODIB decodes a DIB embedded in an .ico file. The DIB's height covers both the
+// XOR (colour) bitmap and the AND (transparency) mask that follows it.
+func decodeICODIB(data []byte) (image.Image, error) {
+	le := binary.LittleEndian
+	if len(data) < 40 {
+		return nil, fmt.Errorf("ico: bitmap too short")
+	}
+	headerSize := int(le.Uint32(data[0:]))
+	w := int(int32(le.Uint32(data[4:])))
+	h := int(int32(le.Uint32(data[8:]))) / 2
+	bitCount := int(le.Uint16(data[14:]))
+	compression := le.Uint32(data[16:])
+	colorsUsed := int(le.Uint32(data[32:]))
+	if compression != 0 {
+		return nil, fmt.Errorf("ico: compressed bitmaps are not supported")
+	}
+	if w <= 0 || h <= 0 || headerSize < 40 || headerSize > len(data) {
+		return nil, fmt.Errorf("ico: invalid bitmap header")
+	}
+
+	var palette []color.NRGBA
+	pos := headerSize
+	if bitCount <= 8 {
+		if colorsUsed == 0 {
+			colorsUsed = 1 << bitCount
+		}
+		if pos+colorsUsed*4 > len(data) {
+			return nil, fmt.Errorf("ico: bitmap palette too short")
+		}
+		for i := 0; i < colorsUsed; i++ {
+			c := data[pos+i*4:]
+			palette = append(palette, color.NRGBA{c[2], c[1], c[0], 255})
+		}
+		pos += colorsUsed * 4
+	}
+
+	switch bitCount {
+	case 1, 4, 8, 24, 32:
+	default:
+		return nil, fmt.Errorf("ico: unsupported bit count %d", bitCount)
+	}
+
+	// Rows are padded to 4 bytes and stored bottom-up:
+	stride := (w*bitCount + 31) / 32 * 4
+	maskStride := (w + 31) / 32 * 4
+	maskPos := pos + stride*h
+	hasMask := maskPos+maskStride*h <= len(data)
+	if maskPos > len(data) {
+		return nil, fmt.Errorf("ico: bitmap data too short")
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	hasAlpha := false
+	for y := 0; y < h; y++ {
+		row := data[pos+(h-1-y)*stride:]
+		for x := 0; x < w; x++ {
+			var c color.NRGBA
+			switch bitCount {
+			case 32:
+				c = color.NRGBA{row[x*4+2], row[x*4+1], row[x*4], row[x*4+3]}
+				hasAlpha = hasAlpha || c.A != 0
+			case 24:
+				c = color.NRGBA{row[x*3+2], row[x*3+1], row[x*3], 255}
+			default:
+				bit := x * bitCount
+				idx := int(row[bit/8]>>(8-bitCount-bit%8)) & (1<<bitCount - 1)
+				if idx < len(palette) {
+					c = palette[idx]
+				}
+			}
+			out.SetNRGBA(x, y, c)
+		}
+	}
+
+	// 32-bit images normally carry their own alpha, but some old ones leave it empty and
+	// rely on the mask instead:
+	if hasMask && !hasAlpha {
+		for y := 0; y < h; y++ {
+			row := data[maskPos+(h-1-y)*maskStride:]
+			for x := 0; x < w; x++ {
+				c := out.NRGBAAt(x, y)
+				if row[x/8]&(0x80>>(x%8)) != 0 {
+					c.A = 0
+				} else {
+					c.A = 255
+				}
+				out.SetNRGBA(x, y, c)
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,618 @@
+package bitmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Area struct {
+	X   AreaCoord  `json:"x"`
+	Y   AreaCoord  `json:"y"`
+	W   AreaCoord  `json:"w"`
+	H   AreaCoord  `json:"h"`
+	Gen *Generator `json:"gen,omitempty"`
+
+	// Point of the area that X and Y position, relative to the same point of the image:
+	// 'top-left' (the default), 'top-right', 'bottom-left', 'bottom-right' or 'center'.
+	// For example, an area anchored 'bottom-right' at 0,0 is flush with the image's
+	// bottom right corner, and X and Y move it left and up.
+	Anchor string `json:"anchor,omitempty"`
+
+	// Name of a palette from ImageMap.Palettes to use for this area. Overrides the
+	// palette in Gen.
+	Palette string `json:"palette,omitempty"`
+
+	// Name of the group this area belongs to, if any. An index of each group's areas is
+	// emitted as an extra output named after the group. See areaGroup.
+	Group string `json:"group,omitempty"`
+}
+
+// NewArea returns an area covering rect, in pixels, built with gen.
+func NewArea(rect image.Rectangle, gen *Generator) Area {
+	px := func(v int) AreaCoord { return AreaCoord{Value: float64(v)} }
+	return Area{X: px(rect.Min.X), Y: px(rect.Min.Y), W: px(rect.Dx()), H: px(rect.Dy()), Gen: gen}
+}
+
+// AreaCoord is a position or size in an image map area. In JSON, it is either a number
+// of pixels, or a string containing a percentage of the image's width or height, i.e.
+// "50%". Positions may also be "center", which centres the area along that axis.
+type AreaCoord struct {
+	Value   float64
+	Percent bool
+	Center  bool
+}
+
+func (c *AreaCoord) UnmarshalJSON(b []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	switch raw := raw.(type) {
+	case float64:
+		*c = AreaCoord{Value: raw}
+	case string:
+		v := strings.TrimSpace(raw)
+		if v == "center" {
+			*c = AreaCoord{Center: true}
+			return nil
+		}
+		percent := strings.HasSuffix(v, "%")
+		f, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(v, "%")), 64)
+		if err != nil {
+			return fmt.Errorf("invalid area coordinate %q, expected pixels, a percentage or 'center'", raw)
+		}
+		*c = AreaCoord{Value: f, Percent: percent}
+	default:
+		return fmt.Errorf("invalid area coordinate %s, expected pixels, a percentage or 'center'", b)
+	}
+	return nil
+}
+
+func (c AreaCoord) MarshalJSON() ([]byte, error) {
+	if c.Center {
+		return json.Marshal("center")
+	}
+	if c.Percent {
+		return json.Marshal(strconv.FormatFloat(c.Value, 'f', -1, 64) + "%")
+	}
+	return json.Marshal(c.Value)
+}
+
+// pixels returns the coordinate in pixels, where extent is the image's width or
+// height.
+func (c AreaCoord) pixels(extent int) int {
+	if c.Percent {
+		return int(math.Round(c.Value * float64(extent) / 100))
+	}
+	return int(math.Round(c.Value))
+}
+
+// areaAnchors lists the values of Area.Anchor.
+var areaAnchors = []string{"top-left", "top-right", "bottom-left", "bottom-right", "center"}
+
+// Rect returns the area's rectangle within an image with the given bounds. Percentages
+// and centred positions are resolved against the bounds, so maps using them still fit
+// when the source is re-exported at a different resolution. It is an error if the area
+// is empty or falls outside the bounds.
+func (a Area) Rect(bounds image.Rectangle) (image.Rectangle, error) {
+	if a.W.Center || a.H.Center {
+		return image.Rectangle{}, fmt.Errorf("'center' is only valid for x and y")
+	}
+	iw, ih := bounds.Dx(), bounds.Dy()
+	x, y, w, h := a.X.pixels(iw), a.Y.pixels(ih), a.W.pixels(iw), a.H.pixels(ih)
+	if w <= 0 || h <= 0 {
+		return image.Rectangle{}, fmt.Errorf("size %dx%d is empty", w, h)
+	}
+
+	switch a.Anchor {
+	case "", "top-left":
+	case "top-right":
+		x = iw - w - x
+	case "bottom-left":
+		y = ih - h - y
+	case "bottom-right":
+		x, y = iw-w-x, ih-h-y
+	case "center":
+		x, y = (iw-w)/2+x, (ih-h)/2+y
+	default:
+		return image.Rectangle{}, unknownChoice("anchor", a.Anchor, areaAnchors)
+	}
+	if a.X.Center {
+		x = (iw - w) / 2
+	}
+	if a.Y.Center {
+		y = (ih - h) / 2
+	}
+
+	rect := image.Rect(x, y, x+w, y+h).Add(bounds.Min)
+	if !rect.In(bounds) {
+		var why string
+		switch {
+		case x < 0:
+			why = fmt.Sprintf("x is %d, but must be at least 0", x)
+		case y < 0:
+			why = fmt.Sprintf("y is %d, but must be at least 0", y)
+		case x+w > iw:
+			why = fmt.Sprintf("x + w is %d, but must be at most the image's width, %d", x+w, iw)
+		default:
+			why = fmt.Sprintf("y + h is %d, but must be at most the image's height, %d", y+h, ih)
+		}
+		return image.Rectangle{}, fmt.Errorf("rectangle %v is outside the image bounds %v: %s", rect, bounds, why)
+	}
+	return rect, nil
+}
+
+// AreaGrid slices an image into a grid of equally sized cells, each of which becomes an
+// area after the areas listed in the map. Cells are numbered from 0 along each row.
+//
+// Each cell's variable name comes from Names, or the lines of NamesFile (relative to the
+// map file), by cell number. Cells without a name, or named '-', are skipped. If
+// neither is set, the Name pattern is used instead, where '{row}', '{col}' and '{index}'
+// are replaced with the cell's row, column and number, and '{var}' with the variable
+// name. The default pattern is '{var}_{index}'.
+type AreaGrid struct {
+	X       int `json:"x,omitempty"`
+	Y       int `json:"y,omitempty"`
+	W       int `json:"w"`
+	H       int `json:"h"`
+	Columns int `json:"columns"`
+	Rows    int `json:"rows"`
+
+	// Space between cells, in pixels.
+	Gap int `json:"gap,omitempty"`
+
+	Name      string   `json:"name,omitempty"`
+	Names     []string `json:"names,omitempty"`
+	NamesFile string   `json:"namesFile,omitempty"`
+
+	Gen   *Generator `json:"gen,omitempty"`
+	Group string     `json:"group,omitempty"`
+}
+
+// cellName returns the variable name of the cell at row, col, or an empty string if the
+// cell should be skipped. names is the list of names from Names or NamesFile, if any.
+func (ag *AreaGrid) cellName(names []string, row, col int) string {
+	idx := row*ag.Columns + col
+	if len(names) > 0 {
+		if idx >= len(names) || names[idx] == "-" {
+			return ""
+		}
+		return names[idx]
+	}
+	pattern := ag.Name
+	if pattern == "" {
+		pattern = "{var}_{index}"
+	}
+	return strings.NewReplacer(
+		"{row}", strconv.Itoa(row),
+		"{col}", strconv.Itoa(col),
+		"{index}", strconv.Itoa(idx),
+		"{var}", ag.Gen.VarName,
+	).Replace(pattern)
+}
+
+// imageMapVersion is the newest version of the image map format which can be read.
+const imageMapVersion = 1
+
+type ImageMap struct {
+	// Version of the image map format the map was written for, so maps written for a
+	// newer bmp2cpp are rejected rather than misread. If 0, the map is version 1.
+	Version int `json:"version,omitempty"`
+
+	Areas    []Area             `json:"areas"`
+	Gen      *Generator         `json:"gen,omitempty"`
+	Palettes map[string]Palette `json:"palettes,omitempty"`
+
+	// Grid of areas added after Areas, if set. See AreaGrid.
+	Grid *AreaGrid `json:"grid,omitempty"`
+
+	// Region image marking areas added after Areas and Grid, if set. See AreaRegions.
+	Regions *AreaRegions `json:"regions,omitempty"`
+
+	// Sprites found in the image, added as areas after Areas, Grid and Regions, if set.
+	// See AreaAutoSlice.
+	AutoSlice *AreaAutoSlice `json:"autoSlice,omitempty"`
+
+	// Source image, relative to the map file. Used if no input is passed on the
+	// command line. If Variants is set, '{variant}' in the source is replaced with
+	// each variant in turn, and each area is built once per variant with the variant
+	// appended to its variable name.
+	Source   string   `json:"source,omitempty"`
+	Variants []string `json:"variants,omitempty"`
+
+	// If set, building fails if the image data of every area and variant adds up to
+	// more than this many bytes, i.e. the flash set aside for UI assets. Each area may
+	// have its own budget too; see Generator.Budget.
+	Budget int `json:"budget,omitempty"`
+
+	// If set, an index of every area and variant is emitted as an extra output with
+	// this name, i.e. an 'enum class <index>' and tables of each area's data and size.
+	// See renderIndex.
+	Index string `json:"index,omitempty"`
+}
+
+// loadImageMap reads the image map at path, in any format mapJSON reads, whose areas
+// default to gen. It returns the map's contents too, for reporting errors found later.
+func loadImageMap(path string, gen *Generator) (*ImageMap, []byte, error) {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, &mapError{err: err}
+	}
+	jsonBts, err := mapJSON(path, bts)
+	if err != nil {
+		return nil, bts, fileMapError(path, bts, err)
+	}
+	imap := &ImageMap{Gen: gen}
+	var dec = json.NewDecoder(bytes.NewReader(jsonBts))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(imap); err != nil {
+		return nil, bts, fileMapError(path, jsonBts, err)
+	}
+	return imap, bts, nil
+}
+
+// NewImageMap returns an empty map whose areas default to gen, or the defaults from
+// NewGenerator if gen is nil, for building maps in code rather than JSON.
+func NewImageMap(gen *Generator) *ImageMap {
+	if gen == nil {
+		gen = NewGenerator()
+	}
+	return &ImageMap{Gen: gen}
+}
+
+// AddArea appends an area covering rect, in pixels, built with a copy of the map's
+// Generator named varName, and returns the copy so it can be customised.
+func (im *ImageMap) AddArea(rect image.Rectangle, varName string) *Generator {
+	gen := im.Gen.Clone()
+	gen.VarName = varName
+	im.Areas = append(im.Areas, NewArea(rect, gen))
+	return gen
+}
+
+// Build builds every area of the map, and the grid and regions if there are any, from
+// img, returning their outputs in order followed by an index for each group. Unlike
+// maps loaded from JSON, NamesFile in the grid and the region image are relative to
+// the working directory. Variants and Source are ignored; call Build once for each
+// image. Palette locks are only saved once every area has been built.
+func (im *ImageMap) Build(img image.Image) ([]Output, error) {
+	m := *im
+	if m.Gen == nil {
+		m.Gen = NewGenerator()
+	}
+	m.Areas = make([]Area, len(im.Areas))
+	for idx, area := range im.Areas {
+		if area.Gen == nil {
+			area.Gen = m.Gen
+		}
+		if err := checkScaler(area.Gen.Scaler); err != nil {
+			return nil, mapFieldError(fmt.Sprintf("areas[%d].gen.scaler", idx), err)
+		}
+		if area.Palette != "" {
+			pal, ok := im.Palettes[area.Palette]
+			if !ok {
+				return nil, mapFieldError(fmt.Sprintf("areas[%d].palette", idx), fmt.Errorf("unknown palette %q", area.Palette))
+			}
+			area.Gen = area.Gen.Clone()
+			area.Gen.Palette = pal
+		}
+		m.Areas[idx] = area
+	}
+	if m.Grid != nil && m.Grid.Gen == nil {
+		grid := *m.Grid
+		grid.Gen = m.Gen
+		m.Grid = &grid
+	}
+	if _, err := m.expandGrid(""); err != nil {
+		return nil, err
+	}
+	if m.Regions != nil {
+		regions := *m.Regions
+		if regions.Gen == nil {
+			regions.Gen = m.Gen
+		}
+		m.Regions = &regions
+		if _, err := m.expandRegions("", img.Bounds()); err != nil {
+			return nil, mapFieldError("regions", err)
+		}
+	}
+	if m.AutoSlice != nil {
+		autoSlice := *m.AutoSlice
+		if autoSlice.Gen == nil {
+			autoSlice.Gen = m.Gen
+		}
+		m.AutoSlice = &autoSlice
+		if err := m.expandAutoSlice(img); err != nil {
+			return nil, mapFieldError("autoSlice", err)
+		}
+	}
+
+	tasks, err := buildTasks(&m, m.Gen, img, "", "")
+	if err != nil {
+		return nil, err
+	}
+	if err := sharePaletteFrom(tasks); err != nil {
+		return nil, err
+	}
+	if err := checkImagePaths(tasks); err != nil {
+		return nil, err
+	}
+	if err := deferPaletteLocks(tasks); err != nil {
+		return nil, err
+	}
+	results, err := runBuildTasks(tasks, runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBudget(m.Budget, results); err != nil {
+		return nil, err
+	}
+	var outs []Output
+	for _, result := range results {
+		outs = append(outs, result...)
+	}
+	for _, group := range groupTasks(tasks) {
+		out, err := group.render(false)
+		if err != nil {
+			return nil, err
+		}
+		outs = append(outs, out)
+	}
+	if m.Index != "" {
+		out, err := renderIndex(m.Index, false, tasks, results)
+		if err != nil {
+			return nil, err
+		}
+		outs = append(outs, out)
+	}
+	if err := savePaletteLocks(tasks); err != nil {
+		return nil, err
+	}
+	return outs, nil
+}
+
+func (im *ImageMap) UnmarshalJSON(b []byte) error {
+	var tmp struct {
+		Version   int                `json:"version"`
+		Gen       *Generator         `json:"gen"`
+		Areas     []json.RawMessage  `json:"areas"`
+		Palettes  map[string]Palette `json:"palettes"`
+		Source    string             `json:"source"`
+		Variants  []string           `json:"variants"`
+		Grid      json.RawMessage    `json:"grid"`
+		Regions   json.RawMessage    `json:"regions"`
+		AutoSlice json.RawMessage    `json:"autoSlice"`
+		Budget    int                `json:"budget"`
+		Index     string             `json:"index"`
+	}
+	im.Gen = im.Gen.Clone()
+	tmp.Gen = im.Gen
+	if err := decodeMapValue(b, &tmp); err != nil {
+		return err
+	}
+	if tmp.Version < 0 || tmp.Version > imageMapVersion {
+		return mapFieldError("version", fmt.Errorf("unsupported version %d, expected at most %d; maps written for a newer bmp2cpp need a newer bmp2cpp to build them",
+			tmp.Version, imageMapVersion))
+	}
+	if err := checkMapGen(b, im.Gen); err != nil {
+		return mapFieldError("gen", err)
+	}
+	im.Version = tmp.Version
+	im.Palettes = tmp.Palettes
+	im.Source = tmp.Source
+	im.Variants = tmp.Variants
+	im.Budget = tmp.Budget
+	im.Index = tmp.Index
+	im.Areas = make([]Area, len(tmp.Areas))
+	for idx, a := range tmp.Areas {
+		im.Areas[idx].Gen = im.Gen.Clone()
+		field := fmt.Sprintf("areas[%d]", idx)
+		if err := decodeMapValue(a, &im.Areas[idx]); err != nil {
+			return mapFieldError(field, err)
+		}
+		if err := checkScaler(im.Areas[idx].Gen.Scaler); err != nil {
+			return mapFieldError(field+".gen.scaler", err)
+		}
+		if name := im.Areas[idx].Palette; name != "" {
+			pal, ok := im.Palettes[name]
+			if !ok {
+				return mapFieldError(field+".palette", unknownChoice("palette", name, paletteNames(im.Palettes)))
+			}
+			im.Areas[idx].Gen.Palette = pal
+		}
+		if err := checkMapGen(a, im.Areas[idx].Gen); err != nil {
+			return mapFieldError(field+".gen", err)
+		}
+	}
+
+	if len(tmp.Grid) > 0 {
+		im.Grid = &AreaGrid{Gen: im.Gen.Clone()}
+		if err := decodeMapValue(tmp.Grid, im.Grid); err != nil {
+			return mapFieldError("grid", err)
+		}
+		if err := checkScaler(im.Grid.Gen.Scaler); err != nil {
+			return mapFieldError("grid.gen.scaler", err)
+		}
+		if err := checkMapGen(tmp.Grid, im.Grid.Gen); err != nil {
+			return mapFieldError("grid.gen", err)
+		}
+		for _, v := range []struct {
+			field string
+			value int
+		}{{"w", im.Grid.W}, {"h", im.Grid.H}, {"columns", im.Grid.Columns}, {"rows", im.Grid.Rows}} {
+			if v.value <= 0 {
+				return mapFieldError("grid."+v.field, fmt.Errorf("must be > 0, found %d", v.value))
+			}
+		}
+		if len(im.Grid.Names) > 0 && im.Grid.NamesFile != "" {
+			return mapFieldError("grid", fmt.Errorf("names and namesFile cannot both be set"))
+		}
+	}
+
+	if len(tmp.Regions) > 0 {
+		im.Regions = &AreaRegions{Gen: im.Gen.Clone()}
+		if err := decodeMapValue(tmp.Regions, im.Regions); err != nil {
+			return mapFieldError("regions", err)
+		}
+		if err := checkScaler(im.Regions.Gen.Scaler); err != nil {
+			return mapFieldError("regions.gen.scaler", err)
+		}
+		if err := checkMapGen(tmp.Regions, im.Regions.Gen); err != nil {
+			return mapFieldError("regions.gen", err)
+		}
+		if im.Regions.Image == "" {
+			return mapFieldError("regions.image", fmt.Errorf("region image is required"))
+		}
+	}
+
+	if len(tmp.AutoSlice) > 0 {
+		im.AutoSlice = &AreaAutoSlice{Gen: im.Gen.Clone()}
+		if err := decodeMapValue(tmp.AutoSlice, im.AutoSlice); err != nil {
+			return mapFieldError("autoSlice", err)
+		}
+		if err := checkScaler(im.AutoSlice.Gen.Scaler); err != nil {
+			return mapFieldError("autoSlice.gen.scaler", err)
+		}
+		if err := checkMapGen(tmp.AutoSlice, im.AutoSlice.Gen); err != nil {
+			return mapFieldError("autoSlice.gen", err)
+		}
+	}
+	return nil
+}
+
+// decodeMapValue decodes the JSON object raw, part of an image map, into v, rejecting
+// unknown fields. See unknownFieldError.
+func decodeMapValue(raw []byte, v interface{}) error {
+	var dec = json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err != nil && strings.HasPrefix(err.Error(), "json: unknown field ") {
+		if name, uerr := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field ")); uerr == nil {
+			return unknownFieldError(raw, reflect.TypeOf(v), name)
+		}
+	}
+	return err
+}
+
+// checkMapGen checks the options of gen, decoded from the 'gen' of the JSON object raw,
+// if it has one. Options which came from elsewhere, such as the command line, are only
+// checked when the map is built, so their errors aren't blamed on the map.
+func checkMapGen(raw []byte, gen *Generator) error {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &keys); err != nil || keys["gen"] == nil {
+		return nil
+	}
+	return gen.checkOptions()
+}
+
+// paletteNames returns the names of palettes, sorted.
+func paletteNames(palettes map[string]Palette) []string {
+	names := make([]string, 0, len(palettes))
+	for name := range palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expandGrid appends an area for each named cell of the grid, if there is one. dir is
+// the directory NamesFile is relative to. It returns the names file, if one was read.
+func (im *ImageMap) expandGrid(dir string) (namesFile string, err error) {
+	grid := im.Grid
+	if grid == nil {
+		return "", nil
+	}
+
+	names := grid.Names
+	if grid.NamesFile != "" {
+		namesFile = grid.NamesFile
+		if !filepath.IsAbs(namesFile) {
+			namesFile = filepath.Join(dir, namesFile)
+		}
+		bts, err := os.ReadFile(namesFile)
+		if err != nil {
+			return namesFile, mapFieldError("namesFile", err)
+		}
+		for _, line := range strings.Split(string(bts), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				names = append(names, line)
+			}
+		}
+	}
+
+	for row := 0; row < grid.Rows; row++ {
+		for col := 0; col < grid.Columns; col++ {
+			name := grid.cellName(names, row, col)
+			if name == "" {
+				continue
+			}
+			gen := grid.Gen.Clone()
+			gen.VarName = name
+			px := func(v int) AreaCoord { return AreaCoord{Value: float64(v)} }
+			im.Areas = append(im.Areas, Area{
+				X:     px(grid.X + col*(grid.W+grid.Gap)),
+				Y:     px(grid.Y + row*(grid.H+grid.Gap)),
+				W:     px(grid.W),
+				H:     px(grid.H),
+				Gen:   gen,
+				Group: grid.Group,
+			})
+		}
+	}
+	return namesFile, nil
+}
+
+// fitGrid expands the grid, as expandGrid does, after setting its Columns and Rows, if
+// they are not already set, to as many as fit within bounds.
+func (im *ImageMap) fitGrid(bounds image.Rectangle) (namesFile string, err error) {
+	grid := im.Grid
+	if grid.Columns == 0 {
+		grid.Columns = (bounds.Dx() - grid.X + grid.Gap) / (grid.W + grid.Gap)
+	}
+	if grid.Rows == 0 {
+		grid.Rows = (bounds.Dy() - grid.Y + grid.Gap) / (grid.H + grid.Gap)
+	}
+	if grid.Columns <= 0 || grid.Rows <= 0 {
+		return "", fmt.Errorf("%dx%d grid cells don't fit in the %dx%d image", grid.W, grid.H, bounds.Dx(), bounds.Dy())
+	}
+	return im.expandGrid("")
+}
+
+// areaRects returns the rectangle of each area within an image with the given bounds.
+func (im *ImageMap) areaRects(bounds image.Rectangle) ([]image.Rectangle, error) {
+	rects := make([]image.Rectangle, len(im.Areas))
+	for idx, area := range im.Areas {
+		rect, err := area.Rect(bounds)
+		if err != nil {
+			return nil, mapFieldError(fmt.Sprintf("areas[%d]", idx), err)
+		}
+		rects[idx] = rect
+	}
+	return rects, nil
+}
+
+// checkDuplicateAreas adds a warning for every area with the same rectangle as an
+// earlier area, within an image with the given bounds.
+func (im *ImageMap) checkDuplicateAreas(bounds image.Rectangle, warnings *Warnings) {
+	rects, err := im.areaRects(bounds)
+	if err != nil {
+		return
+	}
+	seen := map[image.Rectangle]int{}
+	for idx, rect := range rects {
+		if first, ok := seen[rect]; ok {
+			warnings.Add(WarnDuplicateArea, "area %d has the same rectangle %v as area %d", idx, rect, first)
+		} else {
+			seen[rect] = idx
+		}
+	}
+}
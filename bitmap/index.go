@@ -0,0 +1,118 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// renderIndex renders an index called name of every image built by tasks, so code
+// can refer to sprites symbolically: for C++ headers, an 'enum class <name>' with an
+// enumerator for each image, tables of each image's data, size in bytes, width and
+// height indexed by it, and a '<name>_get' function; for JS, an object mapping each
+// variable name to its data, width and height. results holds the outputs of each
+// task, in the same order.
+//
+// If separate is set, each image is in its own file which the index includes or
+// imports; otherwise the index is expected to follow the images in the same file.
+func renderIndex(name string, separate bool, tasks []buildTask, results [][]Output) (Output, error) {
+	if len(tasks) == 0 {
+		return Output{}, fmt.Errorf("index %q has no images", name)
+	}
+	gen := tasks[0].gen
+	images := make([]Output, len(tasks))
+	for idx, task := range tasks {
+		if rendererExt(task.gen.Renderer) != rendererExt(gen.Renderer) {
+			return Output{}, fmt.Errorf("index %q mixes the %q and %q renderers", name, gen.Renderer, task.gen.Renderer)
+		}
+		if task.gen.ForegroundRule != "" {
+			return Output{}, fmt.Errorf("index %q: %s is split into layers by a foreground rule", name, task.gen.VarName)
+		}
+		images[idx] = results[idx][0]
+	}
+
+	var out bytes.Buffer
+	switch gen.Renderer {
+	case "cpp", "cpp17", "cpp20", "arduino":
+		if separate {
+			for _, img := range images {
+				out.WriteString(fmt.Sprintf("#include %q\n", img.Name))
+			}
+			out.WriteByte('\n')
+		}
+		count := len(images)
+		out.WriteString(fmt.Sprintf("enum class %s : size_t {\n", name))
+		for idx, img := range images {
+			out.WriteString(fmt.Sprintf("    %s = %d,\n", img.VarName, idx))
+		}
+		out.WriteString("};\n\n")
+		out.WriteString(fmt.Sprintf("static constexpr size_t %s_count = %d;\n\n", name, count))
+
+		// '&v[0]' works for both std::array and the arduino renderer's plain arrays:
+		out.WriteString(fmt.Sprintf("static const uint8_t *const %s_data[%d] = {\n", name, count))
+		for _, img := range images {
+			out.WriteString(fmt.Sprintf("    &%s[0],\n", img.VarName))
+		}
+		out.WriteString("};\n\n")
+		for _, table := range []struct {
+			suffix, typ string
+			value       func(img Output) int
+		}{
+			{"sizes", "size_t", func(img Output) int { return img.Size }},
+			{"widths", "uint16_t", func(img Output) int { return img.Width }},
+			{"heights", "uint16_t", func(img Output) int { return img.Height }},
+		} {
+			out.WriteString(fmt.Sprintf("static const %s %s_%s[%d] = {", table.typ, name, table.suffix, count))
+			for idx, img := range images {
+				if idx > 0 {
+					out.WriteByte(',')
+				}
+				out.WriteString(fmt.Sprintf(" %d", table.value(img)))
+			}
+			out.WriteString(" };\n")
+		}
+		out.WriteByte('\n')
+		out.WriteString(fmt.Sprintf("static inline const uint8_t *%s_get(%s id) {\n", name, name))
+		out.WriteString(fmt.Sprintf("    return %s_data[static_cast<size_t>(id)];\n", name))
+		out.WriteString("}\n")
+
+	case "js", "cjs":
+		esm := gen.Renderer == "js"
+		ref := func(varName string) string {
+			if esm || separate {
+				return varName
+			}
+			return "exports." + varName
+		}
+		if separate {
+			for _, img := range images {
+				if esm {
+					out.WriteString(fmt.Sprintf("import { %s } from %q;\n", img.VarName, "./"+img.Name))
+				} else {
+					out.WriteString(fmt.Sprintf("const { %s } = require(%q);\n", img.VarName, "./"+img.Name))
+				}
+			}
+			out.WriteByte('\n')
+		}
+		export := "exports." + name
+		if esm {
+			export = "export const " + name
+		}
+		out.WriteString("// prettier-ignore deno-fmt-ignore\n")
+		out.WriteString(fmt.Sprintf("%s = Object.freeze({\n", export))
+		for _, img := range images {
+			out.WriteString(fmt.Sprintf("  %s: Object.freeze({ data: %s, width: %d, height: %d }),\n",
+				img.VarName, ref(img.VarName), img.Width, img.Height))
+		}
+		out.WriteString("});\n")
+
+	default:
+		return Output{}, fmt.Errorf("index %q: indexes are not supported by the %q renderer", name, gen.Renderer)
+	}
+
+	outName := name + rendererExt(gen.Renderer)
+	data, err := gen.reindent(outName, out.Bytes())
+	if err != nil {
+		return Output{}, err
+	}
+	return Output{Name: outName, Data: data, VarName: name}, nil
+}
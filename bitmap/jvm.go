@@ -0,0 +1,97 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// renderJava renders the image as a Java class named after the variable, holding the
+// values in a 'static final byte[]' of the same name, plus '<var>_width',
+// '<var>_height' and, if gen.Rev is set, '<var>_rev'. Use 'import static' to refer to
+// them unqualified. Java bytes are signed, so values over 127 are cast.
+func renderJava(renderCtx *renderContext, out *bytes.Buffer) error {
+	name := renderCtx.gen.VarName
+	writeValuesHeader(renderCtx, out)
+
+	out.WriteString(fmt.Sprintf("public final class %s {\n", name))
+	out.WriteString(fmt.Sprintf("    private %s() {}\n\n", name))
+	renderCtx.eachLayoutConstant(func(suffix string, v int) {
+		out.WriteString(fmt.Sprintf("    public static final int %s_%s = %d;\n", name, suffix, v))
+	})
+	if renderCtx.gen.Rev {
+		out.WriteString(fmt.Sprintf("    public static final int %s_rev = 0x%08x;\n", name, renderCtx.checksum()))
+	}
+	out.WriteByte('\n')
+
+	out.WriteString(fmt.Sprintf("    public static final byte[] %s = {\n", name))
+	renderCtx.eachRow(func(row []uint8) {
+		for _, line := range renderCtx.wrapRow(row) {
+			out.WriteString("        ")
+			for _, px := range line {
+				v := renderCtx.paletteIndexToValue[px]
+				if v > 127 {
+					out.WriteString("(byte)")
+				}
+				out.WriteString(strconv.Itoa(int(v)))
+				out.WriteByte(',')
+			}
+			out.WriteByte('\n')
+		}
+	})
+	out.WriteString("    };\n")
+	out.WriteString("}\n")
+	return nil
+}
+
+// renderKotlin renders the image as top level Kotlin declarations: the values as
+// 'val <var> = byteArrayOf(...)', plus '<var>_width' and '<var>_height' constants and,
+// if gen.Rev is set, '<var>_rev' as a UInt. Kotlin bytes are signed, so values over 127
+// are converted with toByte.
+func renderKotlin(renderCtx *renderContext, out *bytes.Buffer) error {
+	name := renderCtx.gen.VarName
+	writeValuesHeader(renderCtx, out)
+
+	renderCtx.eachLayoutConstant(func(suffix string, v int) {
+		out.WriteString(fmt.Sprintf("const val %s_%s = %d\n", name, suffix, v))
+	})
+	if renderCtx.gen.Rev {
+		out.WriteString(fmt.Sprintf("const val %s_rev = 0x%08xu\n", name, renderCtx.checksum()))
+	}
+	out.WriteByte('\n')
+
+	out.WriteString(fmt.Sprintf("val %s = byteArrayOf(\n", name))
+	renderCtx.eachRow(func(row []uint8) {
+		for _, line := range renderCtx.wrapRow(row) {
+			out.WriteString("    ")
+			for _, px := range line {
+				v := renderCtx.paletteIndexToValue[px]
+				out.WriteString(strconv.Itoa(int(v)))
+				if v > 127 {
+					out.WriteString(".toByte()")
+				}
+				out.WriteByte(',')
+			}
+			out.WriteByte('\n')
+		}
+	})
+	out.WriteString(")\n")
+	return nil
+}
+
+// writeValuesHeader writes the comments at the top of the outputs of renderers which
+// emit values as numbers, such as java, kotlin and glsl. Palette characters can't be
+// declared as constants like in C++, as '_' is reserved in those languages, so they are
+// listed in a comment instead.
+func writeValuesHeader(renderCtx *renderContext, out *bytes.Buffer) {
+	start := out.Len()
+	if !renderCtx.literal {
+		out.WriteString(fmt.Sprintf("// Values: %s\n", renderCtx.charDefs()))
+	}
+	if comment := renderCtx.layout.orderComment(renderCtx.gen.VarName); comment != "" {
+		out.WriteString("// " + comment + "\n")
+	}
+	if out.Len() > start {
+		out.WriteByte('\n')
+	}
+}
@@ -0,0 +1,207 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"regexp"
+	"strconv"
+)
+
+// layerTransparent is the value of background pixels in the foreground layer.
+const layerTransparent = 0xff
+
+var foregroundRulePtn = regexp.MustCompile(`^(alpha|luma)\s*(>=|<=|>|<)\s*(\d+)$`)
+
+// parseForegroundRule parses a rule such as 'alpha>=128' or 'luma<64', returning a
+// function which reports whether a source colour is in the foreground.
+func parseForegroundRule(rule string) (func(c color.Color) bool, error) {
+	m := foregroundRulePtn.FindStringSubmatch(rule)
+	if m == nil {
+		return nil, fmt.Errorf("invalid foreground rule %q, expected '<alpha|luma><op><0-255>', i.e. 'alpha>=128'", rule)
+	}
+	threshold, err := strconv.Atoi(m[3])
+	if err != nil || threshold > 255 {
+		return nil, fmt.Errorf("invalid foreground rule %q, threshold must be 0-255", rule)
+	}
+
+	channel := func(c color.Color) int {
+		_, _, _, a := c.RGBA()
+		return int(a >> 8)
+	}
+	if m[1] == "luma" {
+		channel = func(c color.Color) int {
+			return int(color.GrayModel.Convert(c).(color.Gray).Y)
+		}
+	}
+
+	op := m[2]
+	return func(c color.Color) bool {
+		v := channel(c)
+		switch op {
+		case ">=":
+			return v >= threshold
+		case "<=":
+			return v <= threshold
+		case ">":
+			return v > threshold
+		default:
+			return v < threshold
+		}
+	}, nil
+}
+
+// layerRows splits the emitted values of each row into a foreground and a background
+// layer, using gen.ForegroundRule against the source pixel. Foreground pixels keep
+// their value in fg, and bg is filled with the lowest intensity value beneath them.
+// Background pixels keep their value in bg, and are layerTransparent in fg. Padding is
+// background.
+func (rc *renderContext) layerRows() (fg, bg [][]uint8, err error) {
+	isForeground, err := parseForegroundRule(rc.gen.ForegroundRule)
+	if err != nil {
+		return nil, nil, err
+	}
+	src := rc.source
+	srcBounds := src.Bounds()
+	if srcBounds.Dx() != rc.layout.width || srcBounds.Dy() != rc.layout.height {
+		return nil, nil, fmt.Errorf("%s: foreground rules require the source to be the same size as the output", rc.gen.VarName)
+	}
+
+	var fill uint8
+	if len(rc.paletteIndexes) > 0 {
+		fill = rc.paletteIndexToValue[rc.paletteIndexes[0]]
+	}
+
+	y := 0
+	rc.eachRow(func(row []uint8) {
+		fgRow, bgRow := make([]uint8, len(row)), make([]uint8, len(row))
+		for x, px := range row {
+			v := rc.paletteIndexToValue[px]
+			if v == layerTransparent && err == nil {
+				err = fmt.Errorf("%s: value %d is reserved for transparent foreground pixels", rc.gen.VarName, v)
+			}
+			if y < rc.layout.height && x < rc.layout.width && isForeground(src.At(srcBounds.Min.X+x, srcBounds.Min.Y+y)) {
+				fgRow[x], bgRow[x] = v, fill
+			} else {
+				fgRow[x], bgRow[x] = layerTransparent, v
+			}
+		}
+		fg, bg = append(fg, fgRow), append(bg, bgRow)
+		y++
+	})
+	return fg, bg, err
+}
+
+// renderLayersCPP renders the image for the cpp and cpp17 renderers as separate
+// '<var>_fg' and '<var>_bg' arrays, as described by layerRows, plus a '<var>_combine'
+// function which draws the foreground over the background into a RAM buffer.
+func renderLayersCPP(renderCtx *renderContext, out *bytes.Buffer) error {
+	fg, bg, err := renderCtx.layerRows()
+	if err != nil {
+		return err
+	}
+	name := renderCtx.gen.VarName
+
+	if !renderCtx.literal {
+		out.WriteString(fmt.Sprintf("// Values: %s\n", renderCtx.charDefs()))
+	}
+	out.WriteString(fmt.Sprintf("// Foreground pixels (%s) are in %s_fg, and background pixels in %s_bg.\n",
+		renderCtx.gen.ForegroundRule, name, name))
+	renderCtx.writeLayoutCPP(out)
+	if err := renderCtx.writeColorTableCPP(out); err != nil {
+		return err
+	}
+	renderCtx.writeRevCPP(out)
+	renderCtx.writeSrcSizeCPP(out)
+	renderCtx.writeFramesCPP(out)
+	if err := renderCtx.writeGlyphsCPP(out); err != nil {
+		return err
+	}
+	out.WriteString(fmt.Sprintf("static constexpr uint8_t %s_transparent = %d;\n\n", name, layerTransparent))
+
+	for _, layer := range []struct {
+		suffix string
+		rows   [][]uint8
+	}{{"fg", fg}, {"bg", bg}} {
+		out.WriteString(renderCtx.alignasCPP())
+		out.WriteString(fmt.Sprintf("static const std::array<uint8_t, %s> %s_%s = {{\n", renderCtx.layout.sizeExpr(), name, layer.suffix))
+		for _, row := range layer.rows {
+			for _, line := range renderCtx.wrapRow(row) {
+				out.WriteString("    ")
+				for _, v := range line {
+					out.WriteString(strconv.Itoa(int(v)))
+					out.WriteByte(',')
+				}
+				out.WriteByte('\n')
+			}
+		}
+		out.WriteString("}};\n\n")
+	}
+
+	out.WriteString(fmt.Sprintf("// Draws %s_fg over %s_bg into out, which must hold %s values.\n", name, name, renderCtx.layout.sizeExpr()))
+	out.WriteString(fmt.Sprintf("static inline void %s_combine(uint8_t *out) {\n", name))
+	out.WriteString(fmt.Sprintf("    for (size_t i = 0; i < %s_fg.size(); i++) {\n", name))
+	out.WriteString(fmt.Sprintf("        out[i] = %s_fg[i] != %s_transparent ? %s_fg[i] : %s_bg[i];\n", name, name, name, name))
+	out.WriteString("    }\n")
+	out.WriteString("}\n\n")
+	return nil
+}
+
+// renderLayersJS renders the image for the js and cjs renderers as separate '<var>_fg'
+// and '<var>_bg' Uint8Arrays, as described by layerRows, plus a '<var>_combine'
+// function which returns the foreground drawn over the background.
+func renderLayersJS(renderCtx *renderContext, out *bytes.Buffer, esm bool) error {
+	fg, bg, err := renderCtx.layerRows()
+	if err != nil {
+		return err
+	}
+	name := renderCtx.gen.VarName
+	export := func(suffix string) string {
+		if esm {
+			return fmt.Sprintf("export const %s_%s", name, suffix)
+		}
+		return fmt.Sprintf("exports.%s_%s", name, suffix)
+	}
+	ref := func(suffix string) string {
+		if esm {
+			return name + "_" + suffix
+		}
+		return "exports." + name + "_" + suffix
+	}
+
+	out.WriteString("// prettier-ignore deno-fmt-ignore\n")
+	if !renderCtx.literal {
+		out.WriteString(fmt.Sprintf("// Values: %s\n", renderCtx.charDefs()))
+	}
+	out.WriteString(fmt.Sprintf("// Foreground pixels (%s) are in %s_fg, and background pixels in %s_bg.\n",
+		renderCtx.gen.ForegroundRule, name, name))
+	out.WriteString(fmt.Sprintf("%s = %d;\n", export("transparent"), layerTransparent))
+	for _, layer := range []struct {
+		suffix string
+		rows   [][]uint8
+	}{{"fg", fg}, {"bg", bg}} {
+		out.WriteString(fmt.Sprintf("%s = new Uint8Array([\n", export(layer.suffix)))
+		for _, row := range layer.rows {
+			for _, line := range renderCtx.wrapRow(row) {
+				out.WriteString("  ")
+				for _, v := range line {
+					out.WriteString(strconv.Itoa(int(v)))
+					out.WriteByte(',')
+				}
+				out.WriteByte('\n')
+			}
+		}
+		out.WriteString("]);\n")
+	}
+	out.WriteString(fmt.Sprintf("%s = () => %s.map((v, i) => v !== %s ? v : %s[i]);\n",
+		export("combine"), ref("fg"), ref("transparent"), ref("bg")))
+
+	renderCtx.writeLayoutJS(out, esm)
+	if err := renderCtx.writeColorTableJS(out, esm); err != nil {
+		return err
+	}
+	renderCtx.writeRevJS(out, esm)
+	renderCtx.writeSrcSizeJS(out, esm)
+	renderCtx.writeFramesJS(out, esm)
+	return renderCtx.writeGlyphsJS(out, esm)
+}
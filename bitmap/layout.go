@@ -0,0 +1,253 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// layout describes how the pixels are arranged in the emitted array.
+type layout struct {
+	width, height int
+
+	// Number of values per row, including padding.
+	stride int
+
+	// Total number of values, including row padding and any trailing padding.
+	size int
+
+	// Required alignment of the start of the array in bytes, or 0 for none.
+	align int
+
+	// If true, width and height describe the stored array, which is the logical image
+	// rotated 90 degrees clockwise. Logical pixel x,y is at index x*stride + (width-1-y).
+	rotated bool
+
+	// Storage order, if not row-major. If 'column-major', width and height describe the
+	// transposed image, and logical pixel x,y is at index x*stride + y. If
+	// 'vertical-bytes', each value is 8 vertical pixels and height is the number of
+	// pages of 8 rows; logical pixel x,y is bit y%8 of index (y/8)*stride + x.
+	order string
+
+	// Height of the logical image in pixels, which differs from height for
+	// vertical-bytes.
+	pixelHeight int
+}
+
+// logicalSize returns the width and height of the image before it was rotated or
+// reordered for storage.
+func (l layout) logicalSize() (width, height int) {
+	switch {
+	case l.rotated, l.order == "column-major":
+		return l.height, l.width
+	case l.order == "vertical-bytes":
+		return l.width, l.pixelHeight
+	}
+	return l.width, l.height
+}
+
+// described reports whether the layout needs to be described with constants.
+func (l layout) described() bool {
+	return l.padded() || l.rotated || l.order != ""
+}
+
+// orderComment describes how to find a logical pixel in the array named name, if the
+// layout is not row-major.
+func (l layout) orderComment(name string) string {
+	switch {
+	case l.rotated:
+		return fmt.Sprintf("Stored rotated 90 degrees clockwise: pixel x,y is at %s[x*%s_stride + (%s_height-1-y)]", name, name, name)
+	case l.order == "column-major":
+		return fmt.Sprintf("Stored column by column: pixel x,y is at %s[x*%s_stride + y]", name, name)
+	case l.order == "vertical-bytes":
+		return fmt.Sprintf("Stored in pages of 8 rows: pixel x,y is bit y%%8 of %s[(y/8)*%s_stride + x]", name, name)
+	}
+	return ""
+}
+
+// padded reports whether the layout differs from a tightly packed array of
+// width*height values.
+func (l layout) padded() bool {
+	return l.stride != l.width || l.size != l.width*l.height || l.align > 1
+}
+
+// sizeExpr returns the array size as a C expression. Tightly packed arrays are
+// described as '<w>*<h>'.
+func (l layout) sizeExpr() string {
+	if l.size == l.width*l.height {
+		return fmt.Sprintf("%d*%d", l.width, l.height)
+	}
+	return fmt.Sprintf("%d", l.size)
+}
+
+func alignUp(v, align int) int {
+	if align <= 1 {
+		return v
+	}
+	return (v + align - 1) / align * align
+}
+
+// computeLayout returns the layout for an image of width x height, using the
+// generator's alignment options.
+func (g *Generator) computeLayout(width, height int) (layout, error) {
+	if g.Align < 0 || g.RowAlign < 0 || g.SizeAlign < 0 {
+		return layout{}, fmt.Errorf("alignments must be >= 0")
+	}
+	if g.Align > 1 && g.Align&(g.Align-1) != 0 {
+		return layout{}, fmt.Errorf("start alignment must be a power of 2, found %d", g.Align)
+	}
+	l := layout{width: width, height: height, align: g.Align}
+	l.stride = alignUp(width, g.RowAlign)
+	l.size = alignUp(l.stride*height, g.SizeAlign)
+	return l, nil
+}
+
+// eachRow calls fn with the palette indexes for each row of emitted data, including
+// padding. Padding uses the lowest intensity palette index. If the total size is padded
+// beyond the last row, fn is called once more with the trailing padding.
+func (rc *renderContext) eachRow(fn func(row []uint8)) {
+	var pad uint8
+	if len(rc.paletteIndexes) > 0 {
+		pad = rc.paletteIndexes[0]
+	}
+
+	l := rc.layout
+	row := make([]uint8, l.stride)
+	for y := 0; y < l.height; y++ {
+		for x := 0; x < l.stride; x++ {
+			if x < l.width {
+				row[x] = rc.img.ColorIndexAt(x, y)
+			} else {
+				row[x] = pad
+			}
+		}
+		fn(row)
+	}
+
+	if trailing := l.size - l.stride*l.height; trailing > 0 {
+		row = make([]uint8, trailing)
+		for i := range row {
+			row[i] = pad
+		}
+		fn(row)
+	}
+}
+
+// writeLayoutCPP writes the layout as C++ constants, if the layout is padded, rotated or
+// reordered.
+// Width and height are always the logical size.
+func (rc *renderContext) writeLayoutCPP(out *bytes.Buffer) {
+	l := rc.layout
+	if !l.described() {
+		return
+	}
+	name := rc.gen.VarName
+	width, height := l.logicalSize()
+	if comment := l.orderComment(name); comment != "" {
+		out.WriteString("// " + comment + "\n")
+	}
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_width = %d;\n", name, width))
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_height = %d;\n", name, height))
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_stride = %d;\n", name, l.stride))
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_size = %d;\n", name, l.size))
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_align = %d;\n", name, l.align))
+	out.WriteString(fmt.Sprintf("static constexpr bool %s_rotated = %t;\n", name, l.rotated))
+	if l.order == "vertical-bytes" {
+		out.WriteString(fmt.Sprintf("static constexpr size_t %s_pages = %d;\n", name, l.height))
+	}
+	out.WriteByte('\n')
+}
+
+// writeLayoutJS writes the layout as exported JS constants, if the layout is padded,
+// rotated or reordered. Start alignment is not meaningful in JS so it is omitted.
+func (rc *renderContext) writeLayoutJS(out *bytes.Buffer, esm bool) {
+	l := rc.layout
+	if !l.described() {
+		return
+	}
+	type constant struct {
+		name  string
+		value interface{}
+	}
+	width, height := l.logicalSize()
+	constants := []constant{
+		{"width", width}, {"height", height}, {"stride", l.stride}, {"size", l.size},
+		{"rotated", l.rotated},
+	}
+	if l.order != "" {
+		constants = append(constants, constant{"layout", fmt.Sprintf("%q", l.order)})
+	}
+	if l.order == "vertical-bytes" {
+		constants = append(constants, constant{"pages", l.height})
+	}
+	if comment := l.orderComment(rc.gen.VarName); comment != "" {
+		out.WriteString("// " + comment + "\n")
+	}
+	for _, v := range constants {
+		if esm {
+			out.WriteString(fmt.Sprintf("export const %s_%s = %v;\n", rc.gen.VarName, v.name, v.value))
+		} else {
+			out.WriteString(fmt.Sprintf("exports.%s_%s = %v;\n", rc.gen.VarName, v.name, v.value))
+		}
+	}
+}
+
+// eachLayoutConstant calls fn with the name suffix and value of each layout constant for
+// renderers that don't have their own layout writer: the logical width and height,
+// then the stride and size if the layout needs describing, then the source size if
+// gen.SrcSize is set.
+func (rc *renderContext) eachLayoutConstant(fn func(suffix string, v int)) {
+	l := rc.layout
+	width, height := l.logicalSize()
+	fn("width", width)
+	fn("height", height)
+	if l.described() {
+		fn("stride", l.stride)
+		fn("size", l.size)
+	}
+	if l.order == "vertical-bytes" {
+		fn("pages", l.height)
+	}
+	if rc.gen.SrcSize {
+		fn("src_width", rc.srcSize.X)
+		fn("src_height", rc.srcSize.Y)
+	}
+}
+
+// writeSrcSizeCPP writes the size of the source image before rescaling as
+// '<var>_src_width' and '<var>_src_height', if gen.SrcSize is set.
+func (rc *renderContext) writeSrcSizeCPP(out *bytes.Buffer) {
+	if !rc.gen.SrcSize {
+		return
+	}
+	name := rc.gen.VarName
+	out.WriteString("// Size of the source image before rescaling.\n")
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_src_width = %d;\n", name, rc.srcSize.X))
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_src_height = %d;\n\n", name, rc.srcSize.Y))
+}
+
+// writeSrcSizeJS writes the size of the source image before rescaling as exported
+// '<var>_src_width' and '<var>_src_height' constants, if gen.SrcSize is set.
+func (rc *renderContext) writeSrcSizeJS(out *bytes.Buffer, esm bool) {
+	if !rc.gen.SrcSize {
+		return
+	}
+	for _, v := range []struct {
+		suffix string
+		value  int
+	}{{"src_width", rc.srcSize.X}, {"src_height", rc.srcSize.Y}} {
+		if esm {
+			out.WriteString(fmt.Sprintf("export const %s_%s = %d;\n", rc.gen.VarName, v.suffix, v.value))
+		} else {
+			out.WriteString(fmt.Sprintf("exports.%s_%s = %d;\n", rc.gen.VarName, v.suffix, v.value))
+		}
+	}
+}
+
+// alignasCPP returns an 'alignas(N) ' prefix for declarations, or an empty string if
+// no alignment is required.
+func (rc *renderContext) alignasCPP() string {
+	if rc.layout.align > 1 {
+		return fmt.Sprintf("alignas(%d) ", rc.layout.align)
+	}
+	return ""
+}
@@ -0,0 +1,959 @@
+package bitmap
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// runMain is the program's entry point: run for the command line, or runWasm when
+// built for the browser.
+var runMain = run
+
+// Main runs the bmp2cpp command with the process's arguments, reporting any error to
+// stderr, and returns the exit status. It is the whole of the bmp2cpp command.
+func Main() int {
+	if err := runMain(); err != nil {
+		reportError(os.Stderr, err)
+		return exitCode(err)
+	}
+	return 0
+}
+
+func run() error {
+	if len(os.Args) > 1 {
+		if os.Args[1] == "help" {
+			return runHelp(os.Args[2:])
+		}
+		if cmd := findCommand(os.Args[1]); cmd != nil {
+			return cmd.run(os.Args[2:])
+		}
+	}
+	return runFlat(os.Args[1:])
+}
+
+// registerGeneratorFlags adds a flag for each Generator option to flags, and sets gen
+// to the default values. The raw -size value is stored in sizeRaw, which should be
+// passed to parseSize after the flags are parsed.
+func registerGeneratorFlags(flags *flag.FlagSet, gen *Generator, sizeRaw *string) {
+	const defaultPaletteChars = "_cowgCONW"
+
+	if err := gen.Palette.Set(defaultPaletteChars); err != nil {
+		panic(err)
+	}
+
+	flags.StringVar(sizeRaw, "size", "", "Size, in '<w>x<h>' format. <=0 for either dimension for aspect.")
+	flags.Var(&gen.Palette, "chars", fmt.Sprintf("Palette, ordered from least to most intense (HSP colorspace). May be a string of chars, where palette index is determined by rune index, i.e. 'oxXW', or a comma separated list of char/index pairs, i.e. 'o=0,x=1,X=2,W=3', or 'auto:<n>' to pick n chars which don't collide with the renderer's identifiers or -reserved. Chars must be valid in a C++ identifier. Default: %s", defaultPaletteChars))
+	flags.StringVar(&gen.Reserved, "reserved", "", "Comma separated identifiers the palette chars must not collide with, i.e. existing macros. 'auto' palettes skip them, and explicit -chars using them are an error.")
+	flags.StringVar(&gen.Scaler, "scaler", "catmullrom", "Scaler when resizing. Values: nn, approxbilinear, bilinear, catmullrom, edge (downscaling which keeps thin strokes and small text), lanczos2, lanczos3, mitchell (less ringing than catmullrom on hard edges), cubic:<B>,<C> (a cubic with custom B and C, i.e. 'cubic:0,0.5' is catmullrom).")
+	flags.StringVar(&gen.Renderer, "renderer", "cpp17", "Renderer. Values: cpp17, cpp, cpp20 (std::to_array in an inline constinit variable), cppm (C++20 module), cjs, js, java, kotlin, swift, glsl, wgsl, asm, basic (DATA statements), hex, base64 (a single string literal of the bytes), term, xbm (requires 2 -chars), xpm, arduino (PROGMEM; use -pack-bits 1 for Adafruit_GFX drawBitmap), rustbin (requires -o to be an archive or directory), exec:<command> (pipes the image as JSON to the command, and uses its output).")
+	flags.StringVar(&gen.VarName, "var", "bitmap", "Output variable name.")
+	flags.StringVar(&gen.VarNameTemplate, "var-template", "", "Variable name of each area, or of the input if there is no map, so areas don't share one name, i.e. 'sprite_{index}' or '{name}_{w}x{h}'. '{name}' is replaced with -var or the area's name, '{index}' with the area's number, '{file}' with the input's name without its extension, and '{w}' and '{h}' with the output size.")
+	flags.BoolVar(&gen.RowWiseJS, "jsrow", true, "When rendering for javascript, output each row as a Uint8Array, rather than the whole image.")
+	flags.BoolVar(&gen.Minify, "minify", false, "When rendering for javascript, drop comments and whitespace. Attribution is kept.")
+	flags.BoolVar(&gen.JSRGBA, "js-rgba", false, "When rendering for javascript, also export the full colour (rescaled, unquantized) image as '<var>_rgba', a Uint8ClampedArray for ImageData.")
+	flags.StringVar(&gen.AsmDirective, "asm-directive", ".byte", "When using the 'asm' renderer, the data directive to emit, i.e. 'db', 'defb' or 'dc.b'.")
+	flags.IntVar(&gen.AsmPerLine, "asm-per-line", 0, "When using the 'asm' renderer, the number of values in each directive. 0 for -wrap, or one row per directive.")
+	flags.IntVar(&gen.BasicLine, "basic-line", 1000, "When using the 'basic' renderer, the first line number.")
+	flags.IntVar(&gen.BasicStep, "basic-step", 10, "When using the 'basic' renderer, the line number increment.")
+	flags.StringVar(&gen.TermColor, "termcolor", "none", "When using the 'term' renderer, colour each pixel using ANSI escapes. Values: none, 256, truecolor.")
+	flags.BoolVar(&gen.Invert, "invert", false, "Invert colours")
+	flags.IntVar(&gen.Rotate, "rotate", 0, "Rotate the source clockwise before processing. Values: 0, 90, 180, 270.")
+	flags.StringVar(&gen.Flip, "flip", "", "Flip the source after rotating. Values: h, v.")
+	flags.StringVar(&gen.ChannelSwap, "channel-swap", "", "Reorder the source's colour channels before processing, for devices with a different channel order. Values: any ordering of rgb, i.e. bgr.")
+	flags.StringVar(&gen.InvertChannels, "invert-channels", "", "Invert these source colour channels before processing, i.e. 'rb'.")
+	flags.Float64Var(&gen.Brightness, "brightness", 0, "Brightness adjustment applied before quantizing, from -1 to 1.")
+	flags.Float64Var(&gen.Contrast, "contrast", 0, "Contrast adjustment applied before quantizing, from -1 (flat grey) to 1 (doubled).")
+	flags.StringVar(&gen.Levels, "levels", "", "Black and white points applied before quantizing, in '<black>,<white>' format (0-255), i.e. '16,240'.")
+	flags.StringVar(&gen.Mono, "mono", "", "Skip quantization and map each pixel to the darkest or lightest char by luma threshold (0-255), or 'auto' for Otsu's method. For monochrome OLED and e-paper displays.")
+	flags.IntVar(&gen.TileRows, "tile-rows", 0, "Rescale and quantize this many output rows at a time, with the palette computed from a reduced copy first, to reduce memory use when rescaling very large images. The decoded input and the quantized output are still held in memory whole.")
+	flags.BoolVar(&gen.Linear, "linear", false, "Rescale and compute intensity in linear light rather than sRGB, which avoids darkening detailed images when downscaling.")
+	flags.IntVar(&gen.PaletteOffset, "offset", 0, "Palette offset")
+	flags.BoolVar(&gen.Literal, "literal", false, "Write each pixel's numeric value, with -offset applied, rather than defining a single character constant for each palette char. The cpp17 renderer declares the array directly rather than in a constexpr lambda.")
+	flags.StringVar(&gen.Preview, "preview", "", "Save the quantized/rescaled image to this path as a PNG. '{var}' is replaced with the variable name.")
+	flags.StringVar(&gen.ExportImage, "export-image", "", "Save the fully processed image to this path as a PNG, BMP or GIF, depending on the extension, for review or use by other tools. '{var}' is replaced with the variable name.")
+	flags.StringVar(&gen.CPPStorage, "cpp-storage", "constinit", "When using the 'cpp20' renderer, how the array is declared. Values: constinit, constexpr (inline variables with one definition for every translation unit), static (a copy in each translation unit).")
+	flags.BoolVar(&gen.CPPSpan, "cpp-span", false, "When using the 'cpp20' renderer, also emit a '<var>_span()' accessor returning a fixed extent std::span.")
+	flags.StringVar(&gen.Module, "module", "", "Name of the C++20 module declared by the cppm renderer, i.e. 'assets.logo'. Default: the variable name.")
+	flags.StringVar(&gen.Attribution, "attribution", "", "Author/license text to emit as a comment at the top of the output.")
+	flags.BoolVar(&gen.Provenance, "provenance", false, "Emit a comment at the top of the output with the input file and its hash, the source and output sizes, the palette, the scaler, the tool version and the command line, so generated files can be traced and regenerated.")
+	flags.StringVar(&gen.Indent, "indent", "", "Indentation of the generated code: 'tab', or a number of spaces per level. Default: each renderer's own.")
+	flags.IntVar(&gen.Wrap, "wrap", 0, "Number of values on each line of the generated code, rather than one row per line. Packed output wraps bytes. Ignored by the term, xpm, basic, hex and base64 renderers.")
+	flags.StringVar(&gen.PostProcess, "postprocess", "", "Pipe each output through this command before writing, i.e. 'clang-format --assume-filename={out}'. '{out}' is replaced with the output's name.")
+	flags.Var(&gen.ColorMap, "colormap", "Map exact source colours to chars, bypassing quantization and intensity sorting, i.e. '#ff0000=r,#00ff00=g'. An explicit palette index may follow the char, i.e. '#ff0000=r=3'. Source colours not in the map are an error.")
+	flags.StringVar(&gen.Stencil, "stencil", "", "Stencil image. Only pixels that are opaque in the stencil are emitted, all others are set to the -stencil-fill intensity.")
+	flags.IntVar(&gen.StencilFill, "stencil-fill", 0, "Palette intensity to use for pixels masked out by -stencil.")
+	flags.StringVar(&gen.DeviceGamma, "device-gamma", "", "Map to the greys each value appears as on the target display instead of quantizing, so equal perceived steps on the device map to the emitted values. Either the display's gamma, i.e. '2.2', or a file of the measured luminance of each value, lowest first.")
+	flags.StringVar(&gen.PaletteFrom, "palette-from", "", "Quantize this reference image once and map to its palette, so every image converted with it shares the same index-to-colour meaning.")
+	flags.StringVar(&gen.PaletteLock, "palette-lock", "", "Save the colour each char is mapped to in this JSON file, or reuse the mapping if it exists, so edited images keep their chars. '{var}' is replaced with the variable name.")
+	flags.StringVar(&gen.FixedPalette, "fixed-palette", "", "Map to the nearest colours in a fixed palette instead of quantizing. May be a comma separated list of hex colours, i.e. '#000000,#ff0000,#ffffff', a GIMP palette file (.gpl), or an image file.")
+	flags.BoolVar(&gen.SDF, "sdf", false, "Emit an 8-bit signed distance field computed from the source shape instead of palette characters. 128 is the edge, higher values are inside.")
+	flags.Float64Var(&gen.SDFSpread, "sdf-spread", defaultSDFSpread, "Distance, in output pixels, at which -sdf values saturate.")
+	flags.IntVar(&gen.Threads, "threads", 1, "Number of threads to use when quantizing large images. -1 uses one thread per CPU.")
+	flags.IntVar(&gen.Align, "align", 0, "Align the start of the emitted array to this many bytes (C++ only). Must be a power of 2.")
+	flags.IntVar(&gen.RowAlign, "row-align", 0, "Pad each emitted row to a multiple of this many values.")
+	flags.IntVar(&gen.SizeAlign, "size-align", 0, "Pad the total emitted size to a multiple of this many values, i.e. a cache line or DMA burst.")
+	flags.Var(&gen.Edits, "edit", "Pixel edit applied after quantization, using palette characters: 'set <x>,<y> <c>', 'fill <x>,<y>,<w>x<h> <c>' or 'replace <from> <to>'. May be repeated, or separated by ';'.")
+	flags.StringVar(&gen.Fit, "fit", "exact", "How the image is fitted to -size. Values: exact (stretch to the size), integer (the largest whole multiple or divisor of the source size which fits, cropping any remainder, for pixel art), contain (fit within the size, keeping the aspect ratio), cover (cover the size, keeping the aspect ratio, and crop the overflow).")
+	flags.BoolVar(&gen.FitPad, "fit-pad", false, "Centre the image in the whole -size when using -fit contain or integer, padding it with transparent pixels.")
+	flags.StringVar(&gen.Pad, "pad", "", "Pixels to add around the quantized image, as '<l>,<t>,<r>,<b>' or a single value for every side.")
+	flags.StringVar(&gen.Canvas, "canvas", "", "Place the (padded) image on a canvas of this size, '<w>x<h>', cropping it if it doesn't fit, i.e. '16x16' to centre every icon in the same cell.")
+	flags.StringVar(&gen.CanvasAnchor, "canvas-anchor", "center", "Where the image is placed on the -canvas. Values: center, top, bottom, left, right, top-left, top-right, bottom-left, bottom-right.")
+	flags.IntVar(&gen.PadFill, "pad-fill", 0, "Palette intensity to use for pixels added by -pad and -canvas.")
+	flags.BoolVar(&gen.ScaleAfterQuantize, "scale-after", false, "Quantize first, then scale the indexed image with nearest neighbour, preserving hard palette boundaries. -scaler is ignored.")
+	flags.IntVar(&gen.IcoSize, "ico-size", 0, "Width of the image to use from .ico and .cur inputs. 0 for the largest.")
+	flags.IntVar(&gen.AseFrame, "ase-frame", 0, "Frame to use from Aseprite inputs, starting at 0.")
+	flags.StringVar(&gen.AseLayers, "layers", "", "Comma separated names of the layers (or groups) to use from Aseprite inputs. Defaults to all visible layers.")
+	flags.StringVar(&gen.Layout, "layout", "", "Order to store pixels in. Values: row-major, column-major, vertical-bytes (SSD1306 pages: each byte is 8 vertical pixels, LSB at the top; requires 2 -chars).")
+	flags.BoolVar(&gen.StoreRotated, "store-rotated", false, "Store the array rotated 90 degrees clockwise, for column-addressed displays. Logical width/height and a rotated flag are emitted.")
+	flags.StringVar(&gen.ColorTable, "color-table", "", "Also emit the colour of each value as '<var>_palette', indexed by value, for programming a display's CLUT. Values: rgb888, rgb565.")
+	flags.IntVar(&gen.PackBits, "pack-bits", 0, "Pack several values into each byte using this many bits per value (1, 2 or 4), each row starting on a byte boundary. C++, arduino, asm, basic, hex and base64 renderers only.")
+	flags.BoolVar(&gen.Unpack, "unpack", false, "With -pack-bits, also emit '<var>_unpack(uint8_t *out)', which unpacks the image into a RAM buffer at boot.")
+	flags.IntVar(&gen.Glyphs, "glyphs", 0, "Treat the image as a font strip of this many glyphs, and emit their offsets and widths as '<var>_glyph_*' tables.")
+	flags.IntVar(&gen.GlyphColumns, "glyph-columns", 0, "Number of glyphs in each row of a font strip that wraps onto several rows. Default: all of them.")
+	flags.StringVar(&gen.GlyphWidths, "glyph-widths", "", "Comma separated width of each glyph in output pixels, for proportional fonts, i.e. '3,5,5,4'. Default: equal widths.")
+	flags.IntVar(&gen.Budget, "budget", 0, "Fail if the emitted image data, after packing, is more than this many bytes. In an image map, applies to each area; the map's own 'budget' limits the total of every area.")
+	flags.BoolVar(&gen.SrcSize, "src-size", false, "Emit '<var>_src_width' and '<var>_src_height', the size of the source image before rescaling, for layout code which needs the original aspect ratio.")
+	flags.BoolVar(&gen.Rev, "rev", false, "Emit '<var>_rev', the FNV-1a hash of the values, for detecting changed assets when hot-reloading.")
+	flags.StringVar(&gen.ForegroundRule, "fg-rule", "", "Split the image into '<var>_fg' and '<var>_bg' layers plus a '<var>_combine' helper, putting source pixels matching this rule in the foreground, i.e. 'alpha>=128' or 'luma<64'. C++ and JS renderers only.")
+	flags.StringVar(&gen.TestFixture, "test-fixture", "", "Also emit a test file checking a checksum and sampled pixels of the output. Values: gtest, catch2 (C++ renderers), js (JS renderers).")
+	flags.StringVar(&gen.Dither, "dither", "none", "Error diffusion when mapping to the quantized or fixed palette. Values: none, floyd-steinberg (noise which approximates the source colours, rather than flat areas of the nearest one).")
+	flags.BoolVar(&gen.AlphaWeight, "alpha-weight", false, "Weight pixels by alpha when quantizing, so nearly transparent antialiased edges don't use up palette levels.")
+	flags.BoolVar(&gen.NoQuantize, "no-quantize", false, "Map source colours directly to the palette without quantizing. Fails if the source has more unique colours than the palette.")
+}
+
+// parseSize sets gen's target size from a '<w>x<h>' string. If sizeRaw is empty, gen is
+// not modified.
+func parseSize(sizeRaw string, gen *Generator) error {
+	if len(sizeRaw) > 0 {
+		if _, err := fmt.Sscanf(sizeRaw, "%dx%d", &gen.TargetWidth, &gen.TargetHeight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseCrop parses a crop rectangle in '<x>,<y>,<w>x<h>' format. If cropRaw is empty,
+// an empty rectangle is returned.
+func parseCrop(cropRaw string) (image.Rectangle, error) {
+	if len(cropRaw) == 0 {
+		return image.Rectangle{}, nil
+	}
+	var x, y, w, h int
+	if _, err := fmt.Sscanf(cropRaw, "%d,%d,%dx%d", &x, &y, &w, &h); err != nil {
+		return image.Rectangle{}, fmt.Errorf("invalid crop %q: %w", cropRaw, err)
+	}
+	return image.Rect(x, y, x+w, y+h), nil
+}
+
+// applyConfig applies the config file at path (or the discovered config if path is
+// empty) to gen. Flags that were explicitly set are re-applied afterwards so they take
+// precedence over the config.
+func applyConfig(flags *flag.FlagSet, path string, gen *Generator) error {
+	if path == "" {
+		found, err := findConfig(".")
+		if err != nil || found == "" {
+			return err
+		}
+		path = found
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	// Visit is in lexicographical order, so flags are always re-applied in the same
+	// order:
+	var explicit [][2]string
+	flags.Visit(func(f *flag.Flag) {
+		explicit = append(explicit, [2]string{f.Name, f.Value.String()})
+	})
+
+	if err := cfg.Apply(gen, flags.Arg(0)); err != nil {
+		return fmt.Errorf("config %q: %w", path, err)
+	}
+
+	for _, f := range explicit {
+		// Repeatable flags append, so they are cleared before being re-applied:
+		if f[0] == "edit" {
+			gen.Edits = nil
+		}
+		if err := flags.Set(f[0], f[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertJob converts the input image, or every area in an image map, and writes the
+// outputs.
+type convertJob struct {
+	gen     Generator
+	mapFile string
+	outFile string
+	crop    image.Rectangle
+	args    []string
+
+	// Number of areas/variants to build at once.
+	parallel int
+
+	// If set, any warning causes the job to fail.
+	strictWarnings bool
+
+	// Prefix for warnings written to stderr. May be empty.
+	warnPrefix string
+
+	// If set, an HTML page describing each area is written to this path.
+	report string
+
+	// If set, one palette is computed from every area and variant together and shared
+	// between them, so their indexes have the same meaning.
+	paletteUnion bool
+
+	// If set, used as the palette of every area and variant in preference to
+	// paletteUnion. Set for every job by 'build -shared-palette'.
+	sharedPalette color.Palette
+
+	// If set, outputs don't contain anything that changes between runs, such as archive
+	// timestamps. See archiveTime.
+	reproducible bool
+
+	// If not 0, every area and variant is laid out in a sprite sheet this many frames
+	// wide, or a roughly square one if < 0, which is built as a single output.
+	sheet int
+
+	// If not 0, every area and variant is packed into an atlas this many pixels wide,
+	// or a roughly square one if < 0, which is built as a single output. See atlasTask.
+	atlas int
+
+	// If set, the resolved Generator is written to this path. See writeConfig.
+	emitConfig string
+
+	// If set, outputs replace their own marked blocks inside the existing output file.
+	// See appendWriter.
+	appendOutput bool
+
+	// If set, every output is combined into one header. See singleHeader.
+	singleHeader bool
+
+	// If set, an index of every area and variant with this name is emitted, overriding
+	// the map's. See renderIndex.
+	index string
+
+	// If set, the input is decoded from data rather than read from disk. The input
+	// path is still used to determine the format.
+	data []byte
+
+	// If set, and there is no map, each cell of the grid is built as if it were an area of
+	// a map. Unless Columns and Rows are set, the grid fills the input.
+	grid *AreaGrid
+
+	// If set, and there is no map, each region of the region image is built as if it were
+	// an area of a map.
+	regions *AreaRegions
+
+	// If set, and there is no map, each sprite found in the input is built as if it were
+	// an area of a map.
+	autoSlice *AreaAutoSlice
+
+	// If set, and there is no map, the input is a font strip and each glyph is built as
+	// if it were an area of a map, followed by the font's tables. See renderFont.
+	font *fontStrip
+
+	// If set, inputs decoded by earlier jobs are reused. See decodeCache.
+	cache *decodeCache
+}
+
+// run performs the conversion. It returns the paths of every file read during the
+// conversion so they can be watched for changes, even if an error occurs.
+func (job *convertJob) run() (files []string, err error) {
+	var warnings = &Warnings{}
+	defer warnings.Report(os.Stderr, job.warnPrefix)
+
+	build, err := job.build(warnings, job.report != "")
+	files = build.files
+	for _, file := range files {
+		verbosef("read %s", file)
+	}
+	if err != nil {
+		return files, err
+	}
+
+	// Fail before writing the report or the output, so -strict-warnings leaves them as
+	// they were:
+	if n := len(warnings.List()); n > 0 && job.strictWarnings {
+		return files, fmt.Errorf("%d warning(s) found, failing due to -strict-warnings", n)
+	}
+
+	if job.report != "" {
+		if err := writeReport(job.report, build.input, build.report); err != nil {
+			return files, err
+		}
+	}
+
+	if job.singleHeader {
+		header, err := singleHeader(job.singleHeaderName(), build.results)
+		if err != nil {
+			return files, err
+		}
+		build.results = [][]Output{{header}}
+	}
+
+	modTime, err := archiveTime(job.reproducible)
+	if err != nil {
+		return files, err
+	}
+	var w outputWriter
+	if job.appendOutput {
+		w, err = openAppend(job.outFile)
+	} else {
+		w, err = openOutput(job.outFile, modTime)
+	}
+	if err != nil {
+		return files, err
+	}
+	for _, outs := range build.results {
+		for _, out := range outs {
+			if err := w.Write(out); err != nil {
+				w.Close()
+				return files, err
+			}
+			verbosef("wrote %s (%d bytes)", out.Name, len(out.Data))
+		}
+	}
+	if err := w.Close(); err != nil {
+		return files, err
+	}
+	return files, savePaletteLocks(build.tasks)
+}
+
+// jobBuild is the result of convertJob.build.
+type jobBuild struct {
+	// Path of the input, before any '{variant}' is replaced.
+	input string
+
+	// Paths of every file read, even if an error occurred.
+	files []string
+
+	// Outputs of each area and variant, in order.
+	results [][]Output
+
+	// Task for each area and variant, whose palette locks are saved by convertJob.run
+	// once the outputs are written. See deferPaletteLocks.
+	tasks []buildTask
+
+	// Budget for the image data of every area and variant, and the name of their
+	// index, from the map. See ImageMap.Budget and ImageMap.Index.
+	budget int
+	index  string
+
+	// Glyphs of the font strip, if the job has one, in the same order as the tasks, and
+	// the font's ascent if they were rasterized from a font file.
+	glyphs     []fontGlyph
+	fontAscent int
+
+	// Report entry for each area and variant, if requested.
+	report []*ReportEntry
+}
+
+// build loads the map and inputs and builds every area and variant, without writing
+// any outputs. Warnings are added to warnings. If withReport is set, a ReportEntry is
+// collected for each build.
+func (job *convertJob) build(warnings *Warnings, withReport bool) (build jobBuild, err error) {
+	build, tasks, err := job.loadTasks(warnings)
+	if err != nil {
+		return build, err
+	}
+
+	palette := job.sharedPalette
+	if job.paletteUnion && palette == nil {
+		if palette, err = tasksPalette(tasks); err != nil {
+			return build, err
+		}
+	}
+	if palette != nil {
+		for idx := range tasks {
+			tasks[idx].gen = tasks[idx].gen.Clone()
+			tasks[idx].gen.SharedPalette = palette
+		}
+	}
+	if err := sharePaletteFrom(tasks); err != nil {
+		return build, err
+	}
+
+	if withReport {
+		for idx := range tasks {
+			tasks[idx].gen = tasks[idx].gen.Clone()
+			tasks[idx].gen.Report = &ReportEntry{}
+			build.report = append(build.report, tasks[idx].gen.Report)
+		}
+	}
+
+	if err := checkImagePaths(tasks); err != nil {
+		return build, err
+	}
+	if err := deferPaletteLocks(tasks); err != nil {
+		return build, err
+	}
+	build.tasks = tasks
+
+	build.results, err = runBuildTasks(tasks, job.parallel)
+	if err != nil {
+		return build, err
+	}
+	if err := checkBudget(build.budget, build.results); err != nil {
+		return build, err
+	}
+
+	for _, group := range groupTasks(tasks) {
+		// A single header has the areas and the index in the same file:
+		out, err := group.render(separateOutputs(job.outFile) && !job.singleHeader)
+		if err != nil {
+			return build, err
+		}
+		build.results = append(build.results, []Output{out})
+	}
+
+	index := build.index
+	if job.index != "" {
+		index = job.index
+	}
+	if index != "" {
+		out, err := renderIndex(index, separateOutputs(job.outFile) && !job.singleHeader, tasks, build.results[:len(tasks)])
+		if err != nil {
+			return build, err
+		}
+		build.results = append(build.results, []Output{out})
+	}
+
+	if build.glyphs != nil {
+		out, err := renderFont(job.gen.VarName, job.font.spacing, build.fontAscent, separateOutputs(job.outFile) && !job.singleHeader,
+			build.glyphs, tasks, build.results[:len(tasks)])
+		if err != nil {
+			return build, err
+		}
+		build.results = append(build.results, []Output{out})
+	}
+	return build, nil
+}
+
+// loadTasks loads the map and inputs, returning a task for each area and variant to
+// build. Warnings are added to warnings.
+func (job *convertJob) loadTasks(warnings *Warnings) (build jobBuild, tasks []buildTask, err error) {
+	var gen = job.gen
+	gen.Warnings = warnings
+
+	var imap *ImageMap
+	if job.mapFile != "" {
+		build.files = append(build.files, job.mapFile)
+		var mapBts []byte
+		if imap, mapBts, err = loadImageMap(job.mapFile, &gen); err != nil {
+			return build, nil, err
+		}
+		build.budget, build.index = imap.Budget, imap.Index
+		namesFile, err := imap.expandGrid(filepath.Dir(job.mapFile))
+		if namesFile != "" {
+			build.files = append(build.files, namesFile)
+		}
+		if err != nil {
+			return build, nil, fileMapError(job.mapFile, mapBts, mapFieldError("grid", err))
+		}
+	}
+
+	if job.grid != nil && imap == nil {
+		grid := *job.grid
+		grid.Gen = gen.Clone()
+		imap = &ImageMap{Gen: &gen, Grid: &grid}
+	} else if job.regions != nil && imap == nil {
+		regions := *job.regions
+		regions.Gen = gen.Clone()
+		imap = &ImageMap{Gen: &gen, Regions: &regions}
+	} else if job.autoSlice != nil && imap == nil {
+		autoSlice := *job.autoSlice
+		autoSlice.Gen = gen.Clone()
+		imap = &ImageMap{Gen: &gen, AutoSlice: &autoSlice}
+	} else if job.font != nil && imap == nil {
+		imap = &ImageMap{Gen: &gen}
+	}
+
+	if job.emitConfig != "" {
+		resolved := &gen
+		if imap != nil {
+			resolved = imap.Gen
+		}
+		if err := writeConfig(job.emitConfig, resolved); err != nil {
+			return build, nil, err
+		}
+	}
+
+	var input string
+	if len(job.args) == 1 {
+		input = job.args[0]
+	} else if len(job.args) == 0 && imap != nil && imap.Source != "" {
+		input = filepath.Join(filepath.Dir(job.mapFile), imap.Source)
+	} else {
+		return build, nil, usageErrorf("missing <input> arg")
+	}
+	build.input = input
+
+	var variants = []string{""}
+	if imap != nil && len(imap.Variants) > 0 {
+		if !strings.Contains(input, "{variant}") {
+			return build, nil, fmt.Errorf("image map has variants, but input %q does not contain '{variant}'", input)
+		}
+		if job.data != nil {
+			return build, nil, fmt.Errorf("image map variants cannot be used with in-memory input data")
+		}
+		variants = imap.Variants
+	}
+
+	var gens = []*Generator{&gen}
+	if imap != nil {
+		gens = gens[:0]
+		for _, area := range imap.Areas {
+			gens = append(gens, area.Gen)
+		}
+	}
+	for _, g := range gens {
+		if g.Stencil != "" {
+			build.files = append(build.files, g.Stencil)
+		}
+		if g.FixedPalette != "" && !strings.HasPrefix(g.FixedPalette, "#") {
+			build.files = append(build.files, g.FixedPalette)
+		}
+		if g.PaletteFrom != "" {
+			build.files = append(build.files, g.PaletteFrom)
+		}
+		if _, err := strconv.ParseFloat(g.DeviceGamma, 64); g.DeviceGamma != "" && err != nil {
+			build.files = append(build.files, g.DeviceGamma)
+		}
+	}
+
+	// SVGs are rasterized at the requested size, unless areas of them are being
+	// extracted, in which case the areas are in the SVG's own coordinates:
+	var opts = decodeOptions{
+		icoSize:   gen.IcoSize,
+		aseFrame:  gen.AseFrame,
+		aseLayers: parseLayers(gen.AseLayers),
+		warnings:  warnings,
+	}
+	if imap == nil && job.crop == (image.Rectangle{}) {
+		opts.size = image.Point{gen.TargetWidth, gen.TargetHeight}
+	}
+
+	// Load every input before opening the output, so a missing input doesn't leave an
+	// empty output behind. Animated GIFs are split into frames when building a sprite
+	// sheet or atlas of a single image:
+	imgs := make([][]image.Image, len(variants))
+	inputs := make([]*InputInfo, len(variants))
+	for idx, variant := range variants {
+		path := strings.ReplaceAll(input, "{variant}", variant)
+		bts := job.data
+		if bts == nil {
+			build.files = append(build.files, path)
+			if bts, err = os.ReadFile(path); err != nil {
+				return build, nil, &decodeError{path, err}
+			}
+		}
+		inputs[idx] = newInputInfo(path, bts)
+		if job.font != nil {
+			if err := job.font.check(isFontFile(bts)); err != nil {
+				return build, nil, err
+			}
+		}
+		if job.font != nil && isFontFile(bts) {
+			strip, glyphs, ascent, err := job.font.rasterize(bts, warnings)
+			if err != nil {
+				return build, nil, &decodeError{path, err}
+			}
+			build.glyphs, build.fontAscent = glyphs, ascent
+			imgs[idx] = []image.Image{strip}
+			continue
+		}
+		frames := (job.sheet != 0 || job.atlas != 0) && imap == nil
+		decoded, err := job.cache.decode(path, bts, opts, frames)
+		if err != nil {
+			return build, nil, &decodeError{path, err}
+		}
+		imgs[idx] = append([]image.Image(nil), decoded...)
+		for frame := range imgs[idx] {
+			if imgs[idx][frame], err = cropInput(imgs[idx][frame], job.crop); err != nil {
+				return build, nil, err
+			}
+		}
+	}
+
+	if job.grid != nil && job.mapFile == "" {
+		namesFile, err := imap.fitGrid(imgs[0][0].Bounds())
+		if namesFile != "" {
+			build.files = append(build.files, namesFile)
+		}
+		if err != nil {
+			return build, nil, err
+		}
+	}
+
+	if imap != nil && imap.Regions != nil {
+		imageFile, err := imap.expandRegions(filepath.Dir(job.mapFile), imgs[0][0].Bounds())
+		if imageFile != "" {
+			build.files = append(build.files, imageFile)
+		}
+		if err != nil {
+			err = mapFieldError("regions", err)
+			if job.mapFile != "" {
+				err = fileMapError(job.mapFile, nil, err)
+			}
+			return build, nil, err
+		}
+	}
+
+	if imap != nil && imap.AutoSlice != nil {
+		if err := imap.expandAutoSlice(imgs[0][0]); err != nil {
+			err = mapFieldError("autoSlice", err)
+			if job.mapFile != "" {
+				err = fileMapError(job.mapFile, nil, err)
+			}
+			return build, nil, err
+		}
+	}
+
+	if job.font != nil && job.mapFile == "" {
+		if build.glyphs == nil {
+			if build.glyphs, err = job.font.glyphs(imgs[0][0]); err != nil {
+				return build, nil, err
+			}
+		}
+		for _, glyph := range build.glyphs {
+			imap.AddArea(glyph.rect, fontGlyphName(gen.VarName, glyph.code))
+		}
+	}
+
+	if imap != nil {
+		imap.checkDuplicateAreas(imgs[0][0].Bounds(), warnings)
+	}
+
+	for idx, variant := range variants {
+		for _, img := range imgs[idx] {
+			variantTasks, err := buildTasks(imap, &gen, img, inputs[idx].Path, variant)
+			if err != nil {
+				var me *mapError
+				if errors.As(err, &me) && job.mapFile != "" {
+					err = fileMapError(job.mapFile, nil, err)
+				}
+				return build, nil, err
+			}
+			for i, task := range variantTasks {
+				if task.gen.Provenance {
+					variantTasks[i].gen = task.gen.Clone()
+					variantTasks[i].gen.Input = inputs[idx]
+				}
+			}
+			tasks = append(tasks, variantTasks...)
+		}
+	}
+	if job.sheet == 0 && job.atlas == 0 {
+		checkDuplicateVarNames(tasks, warnings)
+	}
+
+	if job.sheet != 0 || job.atlas != 0 {
+		sheetGen := &gen
+		if imap != nil && imap.Gen != nil {
+			sheetGen = imap.Gen
+		}
+		var task buildTask
+		switch {
+		case job.sheet != 0 && job.atlas != 0:
+			err = usageErrorf("-sheet and -atlas cannot be used together")
+		case job.sheet != 0:
+			task, err = sheetTask(tasks, job.sheet, sheetGen)
+		default:
+			task, err = atlasTask(tasks, job.atlas, sheetGen)
+		}
+		if err != nil {
+			return build, nil, err
+		}
+		tasks = []buildTask{task}
+	}
+
+	return build, tasks, nil
+}
+
+// tasksPalette quantizes the images of every task together, returning a palette which
+// fits in the smallest char palette of any of them.
+func tasksPalette(tasks []buildTask) (color.Palette, error) {
+	imgs := make([]image.Image, len(tasks))
+	colors := 256
+	for idx, task := range tasks {
+		imgs[idx] = task.img
+		if task.gen.Palette.Size < colors {
+			colors = task.gen.Palette.Size
+		}
+	}
+	return sharedPalette(imgs, colors)
+}
+
+// sharePaletteFrom quantizes the reference image of every task with a PaletteFrom
+// once for each image and palette size, and gives the palette to the tasks as their
+// SharedPalette, rather than each task quantizing it again. Tasks which already have a
+// shared palette, or use a device's grey ramp, are skipped.
+func sharePaletteFrom(tasks []buildTask) error {
+	type reference struct {
+		path   string
+		colors int
+	}
+	palettes := map[reference]color.Palette{}
+	for idx := range tasks {
+		gen := tasks[idx].gen
+		if gen.PaletteFrom == "" || len(gen.SharedPalette) > 0 || gen.DeviceGamma != "" {
+			continue
+		}
+		ref := reference{gen.PaletteFrom, gen.Palette.Size}
+		palette, ok := palettes[ref]
+		if !ok {
+			var err error
+			if palette, err = paletteFromImage(ref.path, ref.colors); err != nil {
+				return err
+			}
+			palettes[ref] = palette
+		}
+		tasks[idx].gen = gen.Clone()
+		tasks[idx].gen.SharedPalette = palette
+	}
+	return nil
+}
+
+// cropInput crops img to crop, if it is not empty.
+func cropInput(img image.Image, crop image.Rectangle) (image.Image, error) {
+	if crop != (image.Rectangle{}) {
+		if crop.Empty() || !crop.In(img.Bounds()) {
+			return nil, fmt.Errorf("-crop %v is outside the image bounds %v", crop, img.Bounds())
+		}
+		img = subImage(img, crop)
+	}
+	return img, nil
+}
+
+// buildTask is a single call to Generator.Build.
+type buildTask struct {
+	gen *Generator
+	img image.Image
+
+	// Name of the area group the task belongs to, if any.
+	group string
+}
+
+// buildTasks returns a task for every area in imap, or for the whole of img using gen
+// if imap is nil. Each output's variable name is expanded from its VarNameTemplate, if
+// set, for img read from input, then if variant is not empty, it is appended. It is an
+// error if any area falls outside img.
+func buildTasks(imap *ImageMap, gen *Generator, img image.Image, input, variant string) ([]buildTask, error) {
+	withVariant := func(gen *Generator) *Generator {
+		if variant == "" {
+			return gen
+		}
+		gen = gen.Clone()
+		gen.VarName += "_" + variant
+		return gen
+	}
+
+	if imap == nil {
+		gen = gen.expandVarName(0, input, img.Bounds().Size())
+		return []buildTask{{gen: withVariant(gen), img: img}}, nil
+	}
+
+	rects, err := imap.areaRects(img.Bounds())
+	if err != nil {
+		return nil, err
+	}
+	tasks := make([]buildTask, len(imap.Areas))
+	for idx, area := range imap.Areas {
+		areaGen := area.Gen.expandVarName(idx, input, rects[idx].Size())
+		tasks[idx] = buildTask{gen: withVariant(areaGen), img: subImage(img, rects[idx])}
+		if area.Group != "" {
+			tasks[idx].group = area.Group
+			if variant != "" {
+				tasks[idx].group += "_" + variant
+			}
+		}
+	}
+	return tasks, nil
+}
+
+// checkDuplicateVarNames adds a warning for every variable name used by more than one
+// task.
+func checkDuplicateVarNames(tasks []buildTask, warnings *Warnings) {
+	counts := make(map[string]int, len(tasks))
+	for _, task := range tasks {
+		counts[task.gen.VarName]++
+		if counts[task.gen.VarName] == 2 {
+			warnings.Add(WarnDuplicateVarName, "%q is the variable name of more than one output; use -var-template to name each one",
+				task.gen.VarName)
+		}
+	}
+}
+
+// runBuildTasks runs each task on a pool of parallel workers, returning the outputs in
+// the same order as the tasks. If any task fails, the error from the first failed task
+// is returned.
+func runBuildTasks(tasks []buildTask, parallel int) ([][]Output, error) {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([][]Output, len(tasks))
+	errs := make([]error, len(tasks))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallel && i < len(tasks); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range next {
+				results[idx], errs[idx] = buildOutputs(tasks[idx].gen, tasks[idx].img)
+			}
+		}()
+	}
+	for idx := range tasks {
+		next <- idx
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// buildOutputs builds img with gen, passing each output through the generator's
+// postprocess command.
+func buildOutputs(gen *Generator, img image.Image) ([]Output, error) {
+	outs, err := gen.Build(img)
+	if err != nil {
+		return nil, err
+	}
+
+	if gen.PostProcess != "" {
+		for idx, out := range outs {
+			if out.Binary {
+				continue
+			}
+			outs[idx].Data, err = postProcess(gen.PostProcess, out.Name, out.Data)
+			if err != nil {
+				return nil, &renderError{out.Name, err}
+			}
+		}
+	}
+	return outs, nil
+}
+
+func findScaler(v string) draw.Scaler {
+	switch v {
+	case "nn":
+		return draw.NearestNeighbor
+	case "approxbilinear":
+		return draw.ApproxBiLinear
+	case "bilinear":
+		return draw.BiLinear
+	case "catmullrom", "":
+		return draw.CatmullRom
+	case "edge":
+		return edgeScaler{}
+	case "lanczos2":
+		return lanczos2
+	case "lanczos3":
+		return lanczos3
+	case "mitchell":
+		return mitchell
+	}
+	if strings.HasPrefix(v, cubicScalerPrefix) {
+		if kernel, err := parseCubicScaler(v); err == nil {
+			return kernel
+		}
+	}
+	return nil
+}
+
+// decodeOptions controls how inputs which are not a single raster image are decoded.
+type decodeOptions struct {
+	// Size to rasterize vector inputs at. If empty, their intrinsic size is used.
+	size image.Point
+
+	// Width of the image to select from .ico and .cur files. If 0, the largest is used.
+	icoSize int
+
+	// Frame and layers to composite from Aseprite files. If aseLayers is empty, all
+	// visible layers are used.
+	aseFrame  int
+	aseLayers []string
+
+	// Parts of the input which couldn't be decoded, such as unsupported SVG elements,
+	// are added to warnings, if it isn't nil.
+	warnings *Warnings
+}
+
+func decode(input string, opts decodeOptions) (image.Image, error) {
+	bts, err := os.ReadFile(input)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBytes(input, bts, opts)
+}
+
+// decodeBytes decodes an image read from the file called name. The format is detected
+// from the file's contents, falling back to the extension of name if it isn't
+// recognised.
+func decodeBytes(name string, bts []byte, opts decodeOptions) (image.Image, error) {
+	ext := sniffFormat(bts)
+	if ext == "" {
+		ext = strings.ToLower(filepath.Ext(name))
+	}
+	switch ext {
+	case ".png":
+		return png.Decode(bytes.NewReader(bts))
+	case ".bmp":
+		return bmp.Decode(bytes.NewReader(bts))
+	case ".tiff", ".tif":
+		return tiff.Decode(bytes.NewReader(bts))
+	case ".gif":
+		return gif.Decode(bytes.NewReader(bts))
+	case ".webp":
+		return webp.Decode(bytes.NewReader(bts))
+	case ".jpg", ".jpeg":
+		return jpeg.Decode(bytes.NewReader(bts))
+	case ".svg":
+		return decodeSVG(bts, opts.size, opts.warnings)
+	case ".pbm", ".pgm", ".ppm", ".pnm":
+		return decodeNetpbm(bts)
+	case ".xbm":
+		return decodeXBM(bts)
+	case ".xpm":
+		return decodeXPM(bts)
+	case ".ico", ".cur":
+		return decodeICO(bts, opts.icoSize)
+	case ".ase", ".aseprite":
+		return decodeAseprite(bts, opts.aseFrame, opts.aseLayers)
+	default:
+		return nil, fmt.Errorf("unsupported image format")
+	}
+}
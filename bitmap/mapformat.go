@@ -0,0 +1,894 @@
+package bitmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// mapJSON returns the image map in bts, read from path, as JSON. Maps ending in .yaml
+// or .yml are YAML, maps ending in .toml are TOML, and any other map is already JSON.
+// YAML and TOML maps allow comments and trailing commas, and are converted to the same
+// structure as the JSON, so fields have the same names in every format. Syntax errors
+// are mapErrors about the line and column they were found at.
+//
+// Only the parts of each format which maps need are supported. YAML maps may use block
+// and flow collections and plain, single and double quoted scalars, but not block
+// scalars, anchors, aliases, tags or more than one document. Colours such as '#ff0000'
+// must be quoted in YAML, or they are comments. TOML maps may use every TOML value but
+// dates and times, inf and nan.
+func mapJSON(path string, bts []byte) ([]byte, error) {
+	var v interface{}
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		v, err = parseYAML(string(bts))
+	case ".toml":
+		v, err = parseTOML(string(bts))
+	default:
+		return bts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// syntaxErrorf returns a mapError about the position line:col.
+func syntaxErrorf(line, col int, format string, args ...interface{}) error {
+	return &mapError{field: fmt.Sprintf("%d:%d", line, col), err: fmt.Errorf(format, args...)}
+}
+
+var (
+	decimalInt  = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	prefixedInt = regexp.MustCompile(`^0[xob][0-9a-fA-F]+$`)
+	decimalReal = regexp.MustCompile(`^[-+]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][-+]?[0-9]+)?$`)
+)
+
+// parseNumber returns s as a JSON number, if it is a decimal integer or real, or an
+// integer in hex, octal or binary with a '0x', '0o' or '0b' prefix.
+func parseNumber(s string) (json.Number, bool) {
+	switch {
+	case decimalInt.MatchString(s):
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return json.Number(strconv.FormatInt(n, 10)), true
+		}
+	case prefixedInt.MatchString(s):
+		if n, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return json.Number(strconv.FormatInt(n, 10)), true
+		}
+	}
+	if decimalReal.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), true
+		}
+	}
+	return "", false
+}
+
+// readEscape decodes the escape sequence in s after the backslash at s[i-1], returning
+// the rune and the index after the sequence. It accepts the escapes of both YAML and
+// TOML strings.
+func readEscape(s string, i int) (rune, int, error) {
+	if i >= len(s) {
+		return 0, i, fmt.Errorf("unterminated escape sequence")
+	}
+	simple := map[byte]rune{
+		'b': '\b', 't': '\t', 'n': '\n', 'f': '\f', 'r': '\r', 'a': '\a', 'v': '\v',
+		'e': 0x1b, '0': 0, '"': '"', '\\': '\\', '/': '/', ' ': ' ',
+	}
+	if r, ok := simple[s[i]]; ok {
+		return r, i + 1, nil
+	}
+	digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[s[i]]
+	if digits == 0 || i+1+digits > len(s) {
+		return 0, i, fmt.Errorf("invalid escape sequence '\\%c'", s[i])
+	}
+	n, err := strconv.ParseUint(s[i+1:i+1+digits], 16, 32)
+	if err != nil || !utf8.ValidRune(rune(n)) {
+		return 0, i, fmt.Errorf("invalid escape sequence '\\%s'", s[i:i+1+digits])
+	}
+	return rune(n), i + 1 + digits, nil
+}
+
+// yamlLine is a line of a YAML document which isn't blank or only a comment.
+type yamlLine struct {
+	num    int
+	indent int
+
+	// Text of the line, without the indentation, comments or trailing space.
+	text string
+}
+
+// yamlParser parses a YAML document one line at a time, reading each block collection
+// from the lines at the same indentation.
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML returns the value of the YAML document in s, of the same types as
+// json.Unmarshal returns into an interface{}, but with json.Numbers for numbers.
+func parseYAML(s string) (interface{}, error) {
+	p := &yamlParser{}
+	for idx, raw := range strings.Split(s, "\n") {
+		raw = strings.TrimRight(raw, "\r")
+		text := strings.TrimLeft(raw, " ")
+		indent := len(raw) - len(text)
+		text = strings.TrimRight(stripYAMLComment(text), " \t")
+		switch {
+		case text == "":
+			continue
+		case strings.HasPrefix(text, "\t"):
+			return nil, syntaxErrorf(idx+1, indent+1, "tabs can't be used for indentation")
+		case indent == 0 && (text == "---" || text == "..."):
+			if len(p.lines) > 0 {
+				return nil, syntaxErrorf(idx+1, 1, "only one document is supported")
+			}
+			continue
+		case strings.HasPrefix(text, "%"):
+			return nil, syntaxErrorf(idx+1, 1, "directives are not supported")
+		}
+		p.lines = append(p.lines, yamlLine{num: idx + 1, indent: indent, text: text})
+	}
+	if len(p.lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	v, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		return nil, syntaxErrorf(line.num, line.indent+1, "unexpected indentation")
+	}
+	return v, nil
+}
+
+// stripYAMLComment returns text up to the '#' starting a comment, if it has one. A '#'
+// starts a comment at the start of the text, or after a space outside quotes.
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t[{,:", text[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// isYAMLItem reports whether text is an entry of a block sequence.
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey splits text, a line of a block mapping, into its key and the text of its
+// value, which is empty if the value is on the following lines.
+func splitYAMLKey(text string) (key, rest string, ok bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case i == 0 && (c == '"' || c == '\''):
+			quote = c
+		case i == 0 && (c == '[' || c == '{'):
+			return "", "", false
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// block parses the block collection or value starting at the current line, whose
+// indentation is indent.
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	line := p.lines[p.pos]
+	if isYAMLItem(line.text) {
+		return p.sequence(indent)
+	}
+	if _, _, ok := splitYAMLKey(line.text); ok {
+		return p.mapping(indent)
+	}
+	p.pos++
+	return p.value(line, line.text)
+}
+
+// nested parses the value of a mapping key or sequence entry with nothing after it on
+// its line, which is the block indented below it, if there is one, or null. If
+// sequence is set, a block sequence at the same indentation is the value too.
+func (p *yamlParser) nested(indent int, sequence bool) (interface{}, error) {
+	if p.pos < len(p.lines) {
+		next := p.lines[p.pos]
+		if next.indent > indent || (sequence && next.indent == indent && isYAMLItem(next.text)) {
+			return p.block(next.indent)
+		}
+	}
+	return nil, nil
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	seq := []interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		rest := strings.TrimLeft(line.text[1:], " ")
+		var item interface{}
+		var err error
+		if rest == "" {
+			p.pos++
+			item, err = p.nested(indent, false)
+		} else {
+			// The entry's value is a block starting at its own column, so a mapping in
+			// an entry continues on the following lines at that column:
+			col := line.indent + len(line.text) - len(rest)
+			p.lines[p.pos] = yamlLine{num: line.num, indent: col, text: rest}
+			item, err = p.block(col)
+		}
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, item)
+	}
+	return seq, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+		rawKey, rest, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, syntaxErrorf(line.num, line.indent+1, "expected a key followed by ':'")
+		}
+		key, err := p.scalar(line, rawKey)
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprint(key)
+		if key == nil {
+			name = "null"
+		}
+		if _, dup := m[name]; dup {
+			return nil, syntaxErrorf(line.num, line.indent+1, "duplicate key %q", name)
+		}
+
+		p.pos++
+		var v interface{}
+		if rest == "" {
+			v, err = p.nested(indent, true)
+		} else {
+			v, err = p.value(line, rest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[name] = v
+	}
+	return m, nil
+}
+
+// value parses text, the value at the end of line. Flow collections may continue
+// onto the following lines.
+func (p *yamlParser) value(line yamlLine, text string) (interface{}, error) {
+	col := line.indent + len(line.text) - len(text) + 1
+	switch text[0] {
+	case '[', '{':
+		f := &yamlFlow{s: text, line: line.num, col: col}
+		for !yamlFlowClosed(f.s) && p.pos < len(p.lines) {
+			f.s += " "
+			f.joins = append(f.joins, yamlJoin{at: len(f.s), line: p.lines[p.pos]})
+			f.s += p.lines[p.pos].text
+			p.pos++
+		}
+		v, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		if f.space(); f.i < len(f.s) {
+			return nil, f.errorf("unexpected %q after the end of the collection", f.s[f.i:])
+		}
+		return v, nil
+	case '|', '>':
+		return nil, syntaxErrorf(line.num, col, "block scalars are not supported, use a quoted string")
+	case '&', '*', '!':
+		return nil, syntaxErrorf(line.num, col, "anchors, aliases and tags are not supported")
+	}
+	return p.scalar(line, text)
+}
+
+// scalar parses text, the whole of a scalar in line.
+func (p *yamlParser) scalar(line yamlLine, text string) (interface{}, error) {
+	col := line.indent + strings.Index(line.text, text) + 1
+	if text[0] != '"' && text[0] != '\'' {
+		return yamlPlain(text), nil
+	}
+	f := &yamlFlow{s: text, line: line.num, col: col}
+	v, err := f.quoted()
+	if err != nil {
+		return nil, err
+	}
+	if f.i < len(f.s) {
+		return nil, f.errorf("unexpected %q after the end of the string", f.s[f.i:])
+	}
+	return v, nil
+}
+
+// yamlPlain returns the value of the plain scalar s: null, a bool, a number or a string.
+func yamlPlain(s string) interface{} {
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if n, ok := parseNumber(s); ok {
+		return n
+	}
+	return s
+}
+
+// yamlFlowClosed reports whether every flow collection opened in text is closed.
+func yamlFlowClosed(text string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+// yamlFlow parses a YAML flow collection or quoted scalar in s, which starts at line
+// and col of the document.
+type yamlFlow struct {
+	s         string
+	i         int
+	line, col int
+
+	// Lines of a flow collection after the first, and where they were joined onto s:
+	joins []yamlJoin
+}
+
+type yamlJoin struct {
+	at   int
+	line yamlLine
+}
+
+func (f *yamlFlow) errorf(format string, args ...interface{}) error {
+	line, col := f.line, f.col+f.i
+	for _, join := range f.joins {
+		if f.i >= join.at {
+			line, col = join.line.num, join.line.indent+1+f.i-join.at
+		}
+	}
+	return syntaxErrorf(line, col, format, args...)
+}
+
+func (f *yamlFlow) space() {
+	for f.i < len(f.s) && (f.s[f.i] == ' ' || f.s[f.i] == '\t') {
+		f.i++
+	}
+}
+
+func (f *yamlFlow) value() (interface{}, error) {
+	f.space()
+	if f.i >= len(f.s) {
+		return nil, f.errorf("unexpected end of the collection")
+	}
+	switch f.s[f.i] {
+	case '[':
+		f.i++
+		seq := []interface{}{}
+		for {
+			if f.space(); f.i < len(f.s) && f.s[f.i] == ']' {
+				f.i++
+				return seq, nil
+			}
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+
+	case '{':
+		f.i++
+		m := map[string]interface{}{}
+		for {
+			if f.space(); f.i < len(f.s) && f.s[f.i] == '}' {
+				f.i++
+				return m, nil
+			}
+			at := f.i
+			key, err := f.scalar(true)
+			if err != nil {
+				return nil, err
+			}
+			name := fmt.Sprint(key)
+			if _, dup := m[name]; dup {
+				f.i = at
+				return nil, f.errorf("duplicate key %q", name)
+			}
+			if f.space(); f.i >= len(f.s) || f.s[f.i] != ':' {
+				return nil, f.errorf("expected ':' after the key %q", name)
+			}
+			f.i++
+			if m[name], err = f.value(); err != nil {
+				return nil, err
+			}
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+
+	case '&', '*', '!':
+		return nil, f.errorf("anchors, aliases and tags are not supported")
+	}
+	return f.scalar(false)
+}
+
+// separator skips the ',' after an entry of a collection, or stops at close, which
+// ends it.
+func (f *yamlFlow) separator(close byte) error {
+	f.space()
+	switch {
+	case f.i < len(f.s) && f.s[f.i] == ',':
+		f.i++
+	case f.i < len(f.s) && f.s[f.i] == close:
+	default:
+		return f.errorf("expected ',' or '%c'", close)
+	}
+	return nil
+}
+
+// scalar parses a quoted or plain scalar. Plain scalars end at a flow indicator, or if
+// key is set, at a ':'.
+func (f *yamlFlow) scalar(key bool) (interface{}, error) {
+	f.space()
+	if f.i < len(f.s) && (f.s[f.i] == '"' || f.s[f.i] == '\'') {
+		return f.quoted()
+	}
+	start := f.i
+	for f.i < len(f.s) && strings.IndexByte(",[]{}", f.s[f.i]) < 0 {
+		if f.s[f.i] == ':' && (key || f.i+1 == len(f.s) || strings.IndexByte(" ,]}", f.s[f.i+1]) >= 0) {
+			break
+		}
+		f.i++
+	}
+	text := strings.TrimSpace(f.s[start:f.i])
+	if text == "" && !key {
+		return nil, nil
+	}
+	if text == "" {
+		return nil, f.errorf("expected a key")
+	}
+	return yamlPlain(text), nil
+}
+
+// quoted parses a single or double quoted string starting at f.i.
+func (f *yamlFlow) quoted() (string, error) {
+	quote := f.s[f.i]
+	start := f.i
+	f.i++
+	var out strings.Builder
+	for f.i < len(f.s) {
+		c := f.s[f.i]
+		switch {
+		case c == quote && quote == '\'' && f.i+1 < len(f.s) && f.s[f.i+1] == '\'':
+			out.WriteByte('\'')
+			f.i += 2
+		case c == quote:
+			f.i++
+			return out.String(), nil
+		case c == '\\' && quote == '"':
+			r, next, err := readEscape(f.s, f.i+1)
+			if err != nil {
+				return "", f.errorf("%v", err)
+			}
+			out.WriteRune(r)
+			f.i = next
+		default:
+			out.WriteByte(c)
+			f.i++
+		}
+	}
+	f.i = start
+	return "", f.errorf("unterminated string; strings can't continue onto the next line")
+}
+
+// tomlParser parses a TOML document, adding each key to the table the last header
+// opened.
+type tomlParser struct {
+	s     string
+	i     int
+	root  map[string]interface{}
+	table map[string]interface{}
+}
+
+// parseTOML returns the value of the TOML document in s, of the same types as
+// json.Unmarshal returns into an interface{}, but with json.Numbers for numbers.
+func parseTOML(s string) (interface{}, error) {
+	p := &tomlParser{s: s, root: map[string]interface{}{}}
+	p.table = p.root
+	for {
+		p.skipLines()
+		if p.i >= len(p.s) {
+			return p.root, nil
+		}
+
+		var err error
+		at := p.i
+		switch {
+		case strings.HasPrefix(p.s[p.i:], "[["):
+			p.i += 2
+			var keys []string
+			if keys, err = p.key(); err == nil {
+				if err = p.expect("]]"); err == nil {
+					p.table, err = p.arrayTable(keys)
+				}
+			}
+		case p.s[p.i] == '[':
+			p.i++
+			var keys []string
+			if keys, err = p.key(); err == nil {
+				if err = p.expect("]"); err == nil {
+					p.table, err = p.subTable(p.root, keys)
+				}
+			}
+		default:
+			err = p.keyValue(p.table)
+		}
+		if err != nil {
+			if _, ok := err.(*mapError); !ok {
+				err = p.errorAt(at, err)
+			}
+			return nil, err
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *tomlParser) errorAt(i int, err error) error {
+	pos := lineCol([]byte(p.s), int64(i))
+	return &mapError{field: pos, err: err}
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return p.errorAt(p.i, fmt.Errorf(format, args...))
+}
+
+// space skips spaces and tabs.
+func (p *tomlParser) space() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// skipLines skips whitespace, newlines and comments.
+func (p *tomlParser) skipLines() {
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case ' ', '\t', '\r', '\n':
+			p.i++
+		case '#':
+			for p.i < len(p.s) && p.s[p.i] != '\n' {
+				p.i++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endOfLine skips the rest of the line, which may only be a comment.
+func (p *tomlParser) endOfLine() error {
+	p.space()
+	if p.i < len(p.s) && p.s[p.i] == '#' {
+		for p.i < len(p.s) && p.s[p.i] != '\n' {
+			p.i++
+		}
+	}
+	if strings.HasPrefix(p.s[p.i:], "\r\n") {
+		p.i++
+	}
+	if p.i < len(p.s) && p.s[p.i] != '\n' {
+		return p.errorf("expected the end of the line, found %q", p.rest())
+	}
+	return nil
+}
+
+// rest returns the rest of the current line, for errors.
+func (p *tomlParser) rest() string {
+	rest := p.s[p.i:]
+	if end := strings.IndexAny(rest, "\r\n"); end >= 0 {
+		rest = rest[:end]
+	}
+	return rest
+}
+
+func (p *tomlParser) expect(s string) error {
+	p.space()
+	if !strings.HasPrefix(p.s[p.i:], s) {
+		return p.errorf("expected %q, found %q", s, p.rest())
+	}
+	p.i += len(s)
+	return nil
+}
+
+// key parses a bare, quoted or dotted key, returning each part.
+func (p *tomlParser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.space()
+		var key string
+		if p.i < len(p.s) && (p.s[p.i] == '"' || p.s[p.i] == '\'') {
+			var err error
+			if key, err = p.str(); err != nil {
+				return nil, err
+			}
+		} else {
+			start := p.i
+			for p.i < len(p.s) && isTOMLBareKey(p.s[p.i]) {
+				p.i++
+			}
+			if key = p.s[start:p.i]; key == "" {
+				return nil, p.errorf("expected a key, found %q", p.rest())
+			}
+		}
+		keys = append(keys, key)
+
+		if p.space(); p.i >= len(p.s) || p.s[p.i] != '.' {
+			return keys, nil
+		}
+		p.i++
+	}
+}
+
+func isTOMLBareKey(c byte) bool {
+	return c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// keyValue parses 'key = value', and adds it to table.
+func (p *tomlParser) keyValue(table map[string]interface{}) error {
+	at := p.i
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	p.space()
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	parent, err := p.subTable(table, keys[:len(keys)-1])
+	if err != nil {
+		return p.errorAt(at, err)
+	}
+	last := keys[len(keys)-1]
+	if _, dup := parent[last]; dup {
+		return p.errorAt(at, fmt.Errorf("duplicate key %q", strings.Join(keys, ".")))
+	}
+	parent[last] = v
+	return nil
+}
+
+// subTable returns the table at keys within table, creating tables which don't exist.
+// Keys naming an array of tables refer to its last table.
+func (p *tomlParser) subTable(table map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for idx, key := range keys {
+		switch existing := table[key].(type) {
+		case nil:
+			sub := map[string]interface{}{}
+			table[key] = sub
+			table = sub
+		case map[string]interface{}:
+			table = existing
+		case []interface{}:
+			var last map[string]interface{}
+			if len(existing) > 0 {
+				last, _ = existing[len(existing)-1].(map[string]interface{})
+			}
+			if last == nil {
+				return nil, fmt.Errorf("%q is an array, not a table", strings.Join(keys[:idx+1], "."))
+			}
+			table = last
+		default:
+			return nil, fmt.Errorf("%q is already a value, not a table", strings.Join(keys[:idx+1], "."))
+		}
+	}
+	return table, nil
+}
+
+// arrayTable appends a table to the array of tables at keys, and returns it.
+func (p *tomlParser) arrayTable(keys []string) (map[string]interface{}, error) {
+	parent, err := p.subTable(p.root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	table := map[string]interface{}{}
+	switch existing := parent[last].(type) {
+	case nil:
+		parent[last] = []interface{}{table}
+	case []interface{}:
+		parent[last] = append(existing, table)
+	default:
+		return nil, fmt.Errorf("%q is already a value, not an array of tables", strings.Join(keys, "."))
+	}
+	return table, nil
+}
+
+var tomlDate = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}|^[0-9]{2}:[0-9]{2}`)
+
+func (p *tomlParser) value() (interface{}, error) {
+	if p.i >= len(p.s) {
+		return nil, p.errorf("expected a value")
+	}
+	switch p.s[p.i] {
+	case '"', '\'':
+		return p.str()
+
+	case '[':
+		p.i++
+		arr := []interface{}{}
+		for {
+			if p.skipLines(); p.i < len(p.s) && p.s[p.i] == ']' {
+				p.i++
+				return arr, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+			p.skipLines()
+			switch {
+			case p.i < len(p.s) && p.s[p.i] == ',':
+				p.i++
+			case p.i < len(p.s) && p.s[p.i] == ']':
+			default:
+				return nil, p.errorf("expected ',' or ']', found %q", p.rest())
+			}
+		}
+
+	case '{':
+		p.i++
+		table := map[string]interface{}{}
+		for {
+			if p.space(); p.i < len(p.s) && p.s[p.i] == '}' {
+				p.i++
+				return table, nil
+			}
+			if err := p.keyValue(table); err != nil {
+				return nil, err
+			}
+			p.space()
+			switch {
+			case p.i < len(p.s) && p.s[p.i] == ',':
+				p.i++
+			case p.i < len(p.s) && p.s[p.i] == '}':
+			default:
+				return nil, p.errorf("expected ',' or '}', found %q", p.rest())
+			}
+		}
+	}
+
+	start := p.i
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n,]}#", p.s[p.i]) < 0 {
+		p.i++
+	}
+	token := p.s[start:p.i]
+	switch {
+	case token == "":
+		return nil, p.errorf("expected a value, found %q", p.rest())
+	case token == "true":
+		return true, nil
+	case token == "false":
+		return false, nil
+	case tomlDate.MatchString(token):
+		return nil, p.errorAt(start, fmt.Errorf("dates and times are not supported"))
+	}
+	if n, ok := parseNumber(strings.ReplaceAll(token, "_", "")); ok && !strings.HasPrefix(token, "_") {
+		return n, nil
+	}
+	return nil, p.errorAt(start, fmt.Errorf("invalid value %q", token))
+}
+
+// str parses a basic, literal or multi-line string.
+func (p *tomlParser) str() (string, error) {
+	quote := p.s[p.i]
+	multi := strings.HasPrefix(p.s[p.i:], strings.Repeat(string(quote), 3))
+	start := p.i
+	if multi {
+		p.i += 3
+		// A newline straight after the opening quotes is trimmed:
+		if strings.HasPrefix(p.s[p.i:], "\r\n") {
+			p.i += 2
+		} else if strings.HasPrefix(p.s[p.i:], "\n") {
+			p.i++
+		}
+	} else {
+		p.i++
+	}
+
+	var out strings.Builder
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		switch {
+		case multi && strings.HasPrefix(p.s[p.i:], strings.Repeat(string(quote), 3)):
+			p.i += 3
+			// Up to two more quotes are part of the string:
+			for n := 0; n < 2 && p.i < len(p.s) && p.s[p.i] == quote; n++ {
+				out.WriteByte(quote)
+				p.i++
+			}
+			return out.String(), nil
+		case !multi && c == quote:
+			p.i++
+			return out.String(), nil
+		case !multi && c == '\n':
+			return "", p.errorAt(start, fmt.Errorf("unterminated string"))
+		case c == '\\' && quote == '"':
+			// In multi-line strings, a backslash at the end of a line trims the newline
+			// and the whitespace which follows it:
+			if rest := strings.TrimLeft(p.s[p.i+1:], " \t"); multi && (strings.HasPrefix(rest, "\n") || strings.HasPrefix(rest, "\r\n")) {
+				p.i = len(p.s) - len(strings.TrimLeft(rest, " \t\r\n"))
+				continue
+			}
+			r, next, err := readEscape(p.s, p.i+1)
+			if err != nil {
+				return "", p.errorf("%v", err)
+			}
+			out.WriteRune(r)
+			p.i = next
+		default:
+			out.WriteByte(c)
+			p.i++
+		}
+	}
+	return "", p.errorAt(start, fmt.Errorf("unterminated string"))
+}
@@ -0,0 +1,109 @@
+package bitmap
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMapJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		path string
+		in   string
+		out  string
+	}{
+		{"json-unchanged", "m.json", `{"a": 1}`, `{"a": 1}`},
+
+		{"yaml-block-mapping", "m.yaml", "a: 1\nb: x\n", `{"a":1,"b":"x"}`},
+		{"yaml-nested-mapping", "m.yml", "a:\n  b:\n    c: 1\n  d: 2\n", `{"a":{"b":{"c":1},"d":2}}`},
+		{"yaml-block-sequence", "m.yaml", "a:\n  - 1\n  - x\n", `{"a":[1,"x"]}`},
+		{"yaml-sequence-same-indent", "m.yaml", "a:\n- 1\n- 2\nb: 3\n", `{"a":[1,2],"b":3}`},
+		{"yaml-sequence-of-mappings", "m.yaml", "areas:\n  - x: 1\n    y: 2\n  - x: 3\n", `{"areas":[{"x":1,"y":2},{"x":3}]}`},
+		{"yaml-nested-sequence", "m.yaml", "- - 1\n  - 2\n- 3\n", `[[1,2],3]`},
+		{"yaml-flow-sequence", "m.yaml", "a: [1, 2, x]\n", `{"a":[1,2,"x"]}`},
+		{"yaml-flow-mapping", "m.yaml", "a: {x: 1, y: [2, 3]}\n", `{"a":{"x":1,"y":[2,3]}}`},
+		{"yaml-flow-multiline", "m.yaml", "a: [1,\n  2,\n  3]\n", `{"a":[1,2,3]}`},
+		{"yaml-flow-trailing-comma", "m.yaml", "a: [1, 2,]\nb: {x: 1,}\n", `{"a":[1,2],"b":{"x":1}}`},
+		{"yaml-flow-empty", "m.yaml", "a: []\nb: {}\n", `{"a":[],"b":{}}`},
+		{"yaml-plain-scalars", "m.yaml", "a: true\nb: false\nc: null\nd: ~\ne:\nf: 1.5\ng: 0x10\nh: -3\n",
+			`{"a":true,"b":false,"c":null,"d":null,"e":null,"f":1.5,"g":16,"h":-3}`},
+		{"yaml-plain-string", "m.yaml", "a: hello world\nb: a#b\n", `{"a":"hello world","b":"a#b"}`},
+		{"yaml-single-quoted", "m.yaml", "a: 'don''t'\nb: '#ff00ff'\n", `{"a":"don't","b":"#ff00ff"}`},
+		{"yaml-double-quoted", "m.yaml", `a: "x\ty\u00e9\"z"` + "\n", `{"a":"x\tyé\"z"}`},
+		{"yaml-quoted-key", "m.yaml", "'quoted key': 1\n\"other key\": 2\n", `{"other key":2,"quoted key":1}`},
+		{"yaml-quoted-number", "m.yaml", "a: '1'\n", `{"a":"1"}`},
+		{"yaml-comments", "m.yaml", "# heading\na: 1 # trailing\n\n  # indented\nb: 'x # y' # after\n", `{"a":1,"b":"x # y"}`},
+		{"yaml-document-marker", "m.yaml", "---\na: 1\n", `{"a":1}`},
+		{"yaml-crlf", "m.yaml", "a: 1\r\nb: 2\r\n", `{"a":1,"b":2}`},
+
+		{"toml-keys", "m.toml", "a = 1\nb = 'x'\n", `{"a":1,"b":"x"}`},
+		{"toml-dotted-keys", "m.toml", "a.b = 1\na.c = 2\n\"d e\".f = 3\n", `{"a":{"b":1,"c":2},"d e":{"f":3}}`},
+		{"toml-table", "m.toml", "top = 1\n[gen]\nvarName = \"v\"\n[gen.sub]\nx = 2\n", `{"gen":{"sub":{"x":2},"varName":"v"},"top":1}`},
+		{"toml-quoted-table", "m.toml", "[\"a b\"]\nc = 1\n", `{"a b":{"c":1}}`},
+		{"toml-array-of-tables", "m.toml", "[[areas]]\nx = 1\n[[areas]]\nx = 2\n[areas.gen]\nvarName = \"q\"\n",
+			`{"areas":[{"x":1},{"gen":{"varName":"q"},"x":2}]}`},
+		{"toml-inline-table", "m.toml", "gen = {varName = \"v\", size = {w = 1}}\n", `{"gen":{"size":{"w":1},"varName":"v"}}`},
+		{"toml-arrays", "m.toml", "a = [1, 2.5, 'x']\nb = [\n  1, # one\n  2,\n]\nc = []\n", `{"a":[1,2.5,"x"],"b":[1,2],"c":[]}`},
+		{"toml-inline-table-trailing-comma", "m.toml", "a = [{x = 1}, {x = 2},]\n", `{"a":[{"x":1},{"x":2}]}`},
+		{"toml-numbers", "m.toml", "a = 1_000\nb = 0xff\nc = 0o17\nd = 0b101\ne = -1.5e3\nf = +2\n",
+			`{"a":1000,"b":255,"c":15,"d":5,"e":-1500,"f":2}`},
+		{"toml-bools", "m.toml", "a = true\nb = false\n", `{"a":true,"b":false}`},
+		{"toml-basic-string", "m.toml", `a = "x\ty\u00e9\"z"` + "\n", `{"a":"x\tyé\"z"}`},
+		{"toml-literal-string", "m.toml", `a = 'raw\n'` + "\n", `{"a":"raw\\n"}`},
+		{"toml-multiline-strings", "m.toml", "a = \"\"\"\nMulti \\\n  line\"\"\"\nb = '''\nraw\nlines'''\n", `{"a":"Multi line","b":"raw\nlines"}`},
+		{"toml-comments", "m.toml", "# heading\na = 1 # trailing\n[t] # table\nb = '#x'\n", `{"a":1,"t":{"b":"#x"}}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := mapJSON(tc.path, []byte(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tc.out {
+				t.Fatalf("expected %s, found %s", tc.out, out)
+			}
+		})
+	}
+}
+
+func TestMapJSONErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		path string
+		in   string
+		err  string
+	}{
+		{"yaml-indentation", "m.yaml", "a:\n  b: 1\n     c: 2\n", `m.yaml: 3:6: unexpected indentation`},
+		{"yaml-unclosed-flow", "m.yaml", "areas: [{x: 0, y: 0", `m.yaml: 1:20: expected ',' or '}'`},
+		{"yaml-multiline-flow", "m.yaml", "a: 1\nb: [1,\n  2 }\n", `m.yaml: 3:5: expected ',' or ']'`},
+		{"yaml-multiline-flow-key", "m.yaml", "a: {x: 1,\n    x: 2}\n", `m.yaml: 2:5: duplicate key "x"`},
+		{"yaml-duplicate-key", "m.yaml", "a: 1\na: 2\n", `m.yaml: 2:1: duplicate key "a"`},
+		{"yaml-block-scalar", "m.yaml", "a: |\n  text\n", `m.yaml: 1:4: block scalars are not supported, use a quoted string`},
+		{"yaml-tab-indent", "m.yaml", "a:\n\tb: 1\n", `m.yaml: 2:1: tabs can't be used for indentation`},
+
+		{"toml-missing-value", "m.toml", "a = \n", `m.toml: 1:5: expected a value, found ""`},
+		{"toml-duplicate-key", "m.toml", "a = 1\na = 2\n", `m.toml: 2:1: duplicate key "a"`},
+		{"toml-date", "m.toml", "a = 2020-01-01\n", `m.toml: 1:5: dates and times are not supported`},
+		{"toml-two-keys", "m.toml", "a = 1   b = 2\n", `m.toml: 1:9: expected the end of the line, found "b = 2"`},
+		{"toml-unclosed-array", "m.toml", "a = [1\n", `m.toml: 2:1: expected ',' or ']', found ""`},
+		{"toml-missing-array-item", "m.toml", "a = [1,\n", `m.toml: 2:1: expected a value`},
+		{"toml-value-as-table", "m.toml", "a = 1\n[a]\n", `m.toml: 2:1: "a" is already a value, not a table`},
+		{"toml-unterminated-string", "m.toml", "a = \"x\n", `m.toml: 1:5: unterminated string`},
+
+		{"json-syntax", "m.json", "{\n  \"a\": 1,\n}", `m.json: 3:1: invalid character '}' looking for beginning of object key string`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bts := []byte(tc.in)
+			out, err := mapJSON(tc.path, bts)
+			if err == nil {
+				var v interface{}
+				err = json.Unmarshal(out, &v)
+			}
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if msg := fileMapError(tc.path, bts, err).Error(); msg != tc.err {
+				t.Fatalf("expected %q, found %q", tc.err, msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+)
+
+// monoPaletted maps each pixel in img to the darkest or lightest palette character
+// by comparing its luma (0-255) against a threshold, without quantizing. Transparent
+// pixels count as black. The threshold is g.Mono, or if it is 'auto', the threshold
+// that best separates the image's luma histogram using Otsu's method.
+//
+// As with a colour map, the palette index is also the intensity, so the result has one
+// palette entry per character, of which only the first and last are used.
+func (g *Generator) monoPaletted(img image.Image) (*image.Paletted, error) {
+	size := g.Palette.Size
+	if size < 2 {
+		return nil, fmt.Errorf("mono requires a palette of at least 2 characters")
+	}
+
+	bounds := img.Bounds()
+	luma := make([]uint8, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			luma = append(luma, color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+		}
+	}
+
+	var threshold int
+	if g.Mono == "auto" {
+		threshold = otsuThreshold(luma)
+	} else {
+		var err error
+		threshold, err = strconv.Atoi(g.Mono)
+		if err != nil || threshold < 0 || threshold > 255 {
+			return nil, fmt.Errorf("mono threshold must be 0-255 or 'auto', found %q", g.Mono)
+		}
+	}
+
+	palette := make(color.Palette, size)
+	for i := range palette {
+		v := uint8(i * 0xff / (size - 1))
+		palette[i] = color.Gray{Y: v}
+	}
+	dark, light := uint8(0), uint8(size-1)
+	if g.Invert {
+		dark, light = light, dark
+	}
+
+	out := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette)
+	for i, v := range luma {
+		if int(v) >= threshold {
+			out.Pix[i] = light
+		} else {
+			out.Pix[i] = dark
+		}
+	}
+	return out, nil
+}
+
+// otsuThreshold returns the threshold which minimises the variance within the two
+// classes of values either side of it. Values >= the threshold are in the upper class.
+func otsuThreshold(values []uint8) int {
+	var hist [256]int
+	var sum float64
+	for _, v := range values {
+		hist[v]++
+		sum += float64(v)
+	}
+
+	total := float64(len(values))
+	var best, bestVariance float64
+	var below, belowSum float64
+	for t := 1; t < 256; t++ {
+		below += float64(hist[t-1])
+		belowSum += float64(t-1) * float64(hist[t-1])
+		above := total - below
+		if below == 0 || above == 0 {
+			continue
+		}
+		meanBelow := belowSum / below
+		meanAbove := (sum - belowSum) / above
+		variance := below * above * (meanBelow - meanAbove) * (meanBelow - meanAbove)
+		if variance > bestVariance {
+			best, bestVariance = float64(t), variance
+		}
+	}
+	if bestVariance == 0 {
+		return 128
+	}
+	return int(best)
+}
@@ -0,0 +1,191 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+)
+
+// decodeNetpbm decodes a PBM, PGM or PPM image in either the ASCII (P1-P3) or binary
+// (P4-P6) variant. Samples with a maxval other than 255 are rescaled to 8 bits.
+func decodeNetpbm(bts []byte) (image.Image, error) {
+	if len(bts) < 2 || bts[0] != 'P' || bts[1] < '1' || bts[1] > '6' {
+		return nil, fmt.Errorf("netpbm: invalid magic number")
+	}
+	kind := bts[1] - '0'
+	ascii := kind <= 3
+	pos := 2
+
+	// Header and ASCII values are whitespace separated, and '#' starts a comment that
+	// runs to the end of the line:
+	token := func() (string, error) {
+		for pos < len(bts) {
+			if c := bts[pos]; c == '#' {
+				for pos < len(bts) && bts[pos] != '\n' && bts[pos] != '\r' {
+					pos++
+				}
+			} else if isSpace(c) {
+				pos++
+			} else {
+				break
+			}
+		}
+		start := pos
+		for pos < len(bts) && bts[pos] > ' ' && bts[pos] != '#' {
+			pos++
+		}
+		if start == pos {
+			return "", fmt.Errorf("netpbm: unexpected end of file")
+		}
+		return string(bts[start:pos]), nil
+	}
+	number := func() (int, error) {
+		tok, err := token()
+		if err != nil {
+			return 0, err
+		}
+		v, err := strconv.Atoi(tok)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("netpbm: invalid number %q", tok)
+		}
+		return v, nil
+	}
+
+	width, err := number()
+	if err != nil {
+		return nil, err
+	}
+	height, err := number()
+	if err != nil {
+		return nil, err
+	}
+	maxval := 1
+	if kind != 1 && kind != 4 {
+		if maxval, err = number(); err != nil {
+			return nil, err
+		}
+		if maxval < 1 || maxval > 65535 {
+			return nil, fmt.Errorf("netpbm: invalid maxval %d", maxval)
+		}
+	}
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("netpbm: invalid size %dx%d", width, height)
+	}
+
+	// A single whitespace character separates the header from binary data:
+	pos++
+
+	channels := 1
+	if kind == 3 || kind == 6 {
+		channels = 3
+	}
+	sampleBytes := 1
+	if maxval > 255 {
+		sampleBytes = 2
+	}
+
+	// Check there is enough pixel data for the size before allocating the image, so a
+	// corrupt header can't allocate more than the data could fill. Binary rows are a
+	// fixed number of bytes, and every ASCII sample is at least one byte. Every pixel
+	// is at least a bit, which keeps the row size from overflowing:
+	stride := (width + 7) / 8
+	rowBytes := width * channels * sampleBytes
+	if kind == 4 {
+		rowBytes = stride
+	} else if ascii {
+		rowBytes = width * channels
+	}
+	if remaining := len(bts) - pos; width > len(bts)*8 || rowBytes > remaining || height > remaining/rowBytes {
+		return nil, fmt.Errorf("netpbm: pixel data too short for %dx%d", width, height)
+	}
+
+	// PBM rows are packed, most significant bit first, with 1 meaning black:
+	if kind == 4 {
+		out := image.NewGray(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				if bts[pos+y*stride+x/8]&(0x80>>(x%8)) == 0 {
+					out.SetGray(x, y, color.Gray{255})
+				}
+			}
+		}
+		return out, nil
+	}
+
+	sample := func() (int, error) {
+		if kind == 1 {
+			// PBM values may be written without separators:
+			for pos < len(bts) && bts[pos] != '0' && bts[pos] != '1' {
+				if bts[pos] == '#' {
+					if _, err := token(); err != nil {
+						return 0, err
+					}
+					continue
+				}
+				pos++
+			}
+			if pos >= len(bts) {
+				return 0, fmt.Errorf("netpbm: unexpected end of file")
+			}
+			pos++
+			return int(bts[pos-1] - '0'), nil
+		}
+		var v int
+		if ascii {
+			var err error
+			if v, err = number(); err != nil {
+				return 0, err
+			}
+		} else {
+			if pos+sampleBytes > len(bts) {
+				return 0, fmt.Errorf("netpbm: pixel data too short")
+			}
+			v = int(bts[pos])
+			if sampleBytes == 2 {
+				v = v<<8 | int(bts[pos+1])
+			}
+			pos += sampleBytes
+		}
+		if v > maxval {
+			return 0, fmt.Errorf("netpbm: value %d exceeds maxval %d", v, maxval)
+		}
+		return v, nil
+	}
+	scale := func(v int) uint8 {
+		if kind == 1 {
+			return uint8((1 - v) * 255)
+		}
+		return uint8((v*255 + maxval/2) / maxval)
+	}
+
+	var out image.Image
+	var set func(x, y int, vals [3]uint8)
+	if channels == 1 {
+		gray := image.NewGray(image.Rect(0, 0, width, height))
+		out, set = gray, func(x, y int, vals [3]uint8) { gray.SetGray(x, y, color.Gray{vals[0]}) }
+	} else {
+		rgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+		out, set = rgba, func(x, y int, vals [3]uint8) {
+			rgba.SetNRGBA(x, y, color.NRGBA{vals[0], vals[1], vals[2], 255})
+		}
+	}
+	if ascii {
+		// Undo the separator skip, as ASCII values are whitespace separated anyway:
+		pos--
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var vals [3]uint8
+			for c := 0; c < channels; c++ {
+				v, err := sample()
+				if err != nil {
+					return nil, err
+				}
+				vals[c] = scale(v)
+			}
+			set(x, y, vals)
+		}
+	}
+	return out, nil
+}
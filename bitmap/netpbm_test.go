@@ -0,0 +1,64 @@
+package bitmap
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestDecodeNetpbm(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		size image.Point
+		at   image.Point
+		gray uint8
+	}{
+		{"p1-unseparated", "P1\n2 2\n0110", image.Pt(2, 2), image.Pt(1, 0), 0},
+		{"p2-maxval", "P2 2 1 15 0 15", image.Pt(2, 1), image.Pt(1, 0), 255},
+		{"p3", "P3\n# comment\n1 1 255\n255 255 255\n", image.Pt(1, 1), image.Pt(0, 0), 255},
+		{"p4", "P4\n9 1\n\x80\x00", image.Pt(9, 1), image.Pt(0, 0), 0},
+		{"p5-16bit", "P5 1 1 65535\n\xff\xff", image.Pt(1, 1), image.Pt(0, 0), 255},
+		{"p6", "P6 1 1 255\n\x00\x00\x00", image.Pt(1, 1), image.Pt(0, 0), 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			img, err := decodeNetpbm([]byte(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := img.Bounds().Size(); size != tc.size {
+				t.Fatalf("expected size %v, found %v", tc.size, size)
+			}
+			if gray := color.GrayModel.Convert(img.At(tc.at.X, tc.at.Y)).(color.Gray).Y; gray != tc.gray {
+				t.Fatalf("expected grey %d at %v, found %d", tc.gray, tc.at, gray)
+			}
+		})
+	}
+}
+
+func TestDecodeNetpbmErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		err  string
+	}{
+		// Headers whose size is far larger than the data are rejected before the image
+		// is allocated:
+		{"p6-huge", "P6 100000 100000 255\n\x00\x00\x00", "pixel data too short for 100000x100000"},
+		{"p4-huge", "P4 9223372036854775807 2\n\x00", "pixel data too short"},
+		{"p2-huge", "P2 65535 65535 255\n0 0 0", "pixel data too short"},
+		{"p5-short", "P5 2 2 255\n\x00\x00\x00", "pixel data too short for 2x2"},
+		{"p5-16bit-short", "P5 2 1 65535\n\x00\x00\x00", "pixel data too short for 2x1"},
+		{"p2-missing-values", "P2 2 2 255\n0 0 0  ", "unexpected end of file"},
+		{"bad-magic", "P7 1 1", "invalid magic number"},
+		{"bad-maxval", "P2 1 1 0 0", "invalid maxval"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := decodeNetpbm([]byte(tc.in))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, found %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package bitmap
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output is a single rendered result, i.e. one area from an image map.
+type Output struct {
+	Name string
+	Data []byte
+
+	// Variable the output was rendered from, and its logical size in pixels. Width,
+	// Height and Size are 0 for outputs that aren't a single image, such as a group's
+	// index.
+	VarName string
+	Width   int
+	Height  int
+
+	// Number of bytes of image data emitted by the Build the output came from, after
+	// packing. Every output of a Build has the same Size. See renderContext.dataSize.
+	Size int
+
+	// Binary outputs can't be concatenated with other outputs, so they can only be
+	// written to an archive or directory.
+	Binary bool
+}
+
+type outputWriter interface {
+	Write(out Output) error
+	Close() error
+}
+
+// openOutput returns an outputWriter for path. If path is empty, outputs are written to
+// stdout. If path has a '.zip', '.tar', '.tar.gz' or '.tgz' extension, each output is
+// written as a separate entry in the archive. Otherwise, all outputs are concatenated
+// into the file at path.
+//
+// If path ends in a path separator or is an existing directory, each output is written
+// to a separate file in that directory.
+//
+// Tar entries are given modTime as their modification time. Zip entries have no
+// modification time.
+func openOutput(path string, modTime time.Time) (outputWriter, error) {
+	if path == "" {
+		return &streamWriter{w: bufio.NewWriter(os.Stdout)}, nil
+	}
+
+	if strings.HasSuffix(path, "/") || strings.HasSuffix(path, string(filepath.Separator)) {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, err
+		}
+		return &dirWriter{dir: path, names: entryNames{}}, nil
+	} else if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return &dirWriter{dir: path, names: entryNames{}}, nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return &zipWriter{f: f, zw: zip.NewWriter(f), names: entryNames{}}, nil
+
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		gz := gzip.NewWriter(f)
+		return &tarWriter{f: f, gz: gz, tw: tar.NewWriter(gz), modTime: modTime, names: entryNames{}}, nil
+
+	case strings.HasSuffix(lower, ".tar"):
+		return &tarWriter{f: f, tw: tar.NewWriter(f), modTime: modTime, names: entryNames{}}, nil
+
+	default:
+		return &streamWriter{w: bufio.NewWriter(f), c: f}, nil
+	}
+}
+
+// separateOutputs reports whether openOutput writes each output for path to a separate
+// file or archive entry, rather than concatenating them.
+func separateOutputs(path string) bool {
+	if path == "" {
+		return false
+	}
+	if strings.HasSuffix(path, "/") || strings.HasSuffix(path, string(filepath.Separator)) {
+		return true
+	} else if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return true
+	}
+	lower := strings.ToLower(path)
+	for _, ext := range []string{".zip", ".tar.gz", ".tgz", ".tar"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+type streamWriter struct {
+	w *bufio.Writer
+	c io.Closer
+	n int
+}
+
+func (s *streamWriter) Write(out Output) error {
+	if out.Binary {
+		return fmt.Errorf("output %q is binary, -o must be an archive or directory", out.Name)
+	}
+	if s.n > 0 {
+		s.w.WriteByte('\n')
+	}
+	s.n++
+	s.w.Write(out.Data)
+	_, err := s.w.WriteString("\n")
+	return err
+}
+
+func (s *streamWriter) Close() error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if s.c != nil {
+		return s.c.Close()
+	}
+	return nil
+}
+
+type dirWriter struct {
+	dir   string
+	names entryNames
+}
+
+func (d *dirWriter) Write(out Output) error {
+	return os.WriteFile(filepath.Join(d.dir, d.names.unique(out.Name)), out.Data, 0644)
+}
+
+func (d *dirWriter) Close() error { return nil }
+
+type zipWriter struct {
+	f     *os.File
+	zw    *zip.Writer
+	names entryNames
+}
+
+func (z *zipWriter) Write(out Output) error {
+	w, err := z.zw.Create(z.names.unique(out.Name))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out.Data)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	if err := z.zw.Close(); err != nil {
+		z.f.Close()
+		return err
+	}
+	return z.f.Close()
+}
+
+type tarWriter struct {
+	f       *os.File
+	gz      *gzip.Writer
+	tw      *tar.Writer
+	modTime time.Time
+	names   entryNames
+}
+
+func (t *tarWriter) Write(out Output) error {
+	hdr := &tar.Header{
+		Name:    t.names.unique(out.Name),
+		Mode:    0644,
+		Size:    int64(len(out.Data)),
+		ModTime: t.modTime,
+	}
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := t.tw.Write(out.Data)
+	return err
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		t.f.Close()
+		return err
+	}
+	if t.gz != nil {
+		if err := t.gz.Close(); err != nil {
+			t.f.Close()
+			return err
+		}
+	}
+	return t.f.Close()
+}
+
+// archiveTime returns the modification time to give archive entries. If reproducible is
+// set, SOURCE_DATE_EPOCH is used, or the Unix epoch if it is not set, so archives are
+// byte for byte identical whenever their contents are.
+func archiveTime(reproducible bool) (time.Time, error) {
+	if !reproducible {
+		return time.Now(), nil
+	}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q", epoch)
+		}
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Unix(0, 0).UTC(), nil
+}
+
+// entryNames ensures archive entries are not duplicated by appending a counter to any
+// name that has already been used.
+type entryNames map[string]bool
+
+func (e entryNames) unique(name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; e[candidate]; i++ {
+		candidate = fmt.Sprintf("%s_%d%s", base, i, ext)
+	}
+	e[candidate] = true
+	return candidate
+}
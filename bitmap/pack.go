@@ -0,0 +1,131 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// packRows packs the emitted values into bits per value, most significant first. Each
+// row starts on a byte boundary, so rows can be addressed without unpacking the whole
+// image. It returns the packed data and the number of bytes in each row.
+func (rc *renderContext) packRows(bits int) (packed []byte, rowBytes int, err error) {
+	l := rc.layout
+	if l.size != l.stride*l.height {
+		return nil, 0, fmt.Errorf("%s: packing does not support -size-align", rc.gen.VarName)
+	}
+
+	perByte := 8 / bits
+	rowBytes = (l.stride + perByte - 1) / perByte
+	mask := uint8(1<<bits - 1)
+	packed = make([]byte, 0, rowBytes*l.height)
+	rc.eachRow(func(row []uint8) {
+		start := len(packed)
+		packed = append(packed, make([]byte, rowBytes)...)
+		for x, px := range row {
+			v := rc.paletteIndexToValue[px]
+			if v > mask && err == nil {
+				err = fmt.Errorf("%s: value %d does not fit in %d bits", rc.gen.VarName, v, bits)
+			}
+			packed[start+x/perByte] |= (v & mask) << (8 - bits - x%perByte*bits)
+		}
+	})
+	return packed, rowBytes, err
+}
+
+// byteRows returns the emitted bytes of each row, for renderers that write plain lists
+// of numbers. If gen.PackBits is set, the rows are packed as described by packRows, and
+// bits is the number of bits per value. Otherwise bits is 0 and each byte is a value.
+func (rc *renderContext) byteRows() (rows [][]uint8, bits int, err error) {
+	if bits = rc.gen.PackBits; bits > 0 && bits < 8 {
+		packed, rowBytes, err := rc.packRows(bits)
+		if err != nil {
+			return nil, 0, err
+		}
+		for y := 0; y < rc.layout.height; y++ {
+			rows = append(rows, packed[y*rowBytes:(y+1)*rowBytes])
+		}
+		return rows, bits, nil
+	}
+
+	rc.eachRow(func(row []uint8) {
+		values := make([]uint8, len(row))
+		for x, px := range row {
+			values[x] = rc.paletteIndexToValue[px]
+		}
+		rows = append(rows, values)
+	})
+	return rows, 0, nil
+}
+
+// renderPackedCPP renders the image for the cpp and cpp17 renderers with several values
+// packed into each byte, as described by packRows. If gen.Unpack is set, a function
+// which unpacks the image into a caller provided buffer of '<var>_size' values is
+// emitted too.
+func renderPackedCPP(renderCtx *renderContext, out *bytes.Buffer) error {
+	gen := renderCtx.gen
+	bits := gen.PackBits
+	packed, rowBytes, err := renderCtx.packRows(bits)
+	if err != nil {
+		return err
+	}
+
+	if !renderCtx.literal {
+		out.WriteString(fmt.Sprintf("// Values: %s\n", renderCtx.charDefs()))
+	}
+	out.WriteString(fmt.Sprintf("// %d bits per value, most significant first. Each row starts on a byte boundary.\n", bits))
+
+	name := gen.VarName
+	l := renderCtx.layout
+	width, height := l.logicalSize()
+	if l.rotated {
+		out.WriteString(fmt.Sprintf("// Stored rotated 90 degrees clockwise: pixel x,y is value x*%s_stride + (%s_height-1-y)\n", name, name))
+	}
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_width = %d;\n", name, width))
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_height = %d;\n", name, height))
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_stride = %d;\n", name, l.stride))
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_size = %d;\n", name, l.size))
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_bits = %d;\n", name, bits))
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_row_bytes = %d;\n", name, rowBytes))
+	if l.rotated {
+		out.WriteString(fmt.Sprintf("static constexpr bool %s_rotated = true;\n", name))
+	}
+	out.WriteByte('\n')
+
+	if err := renderCtx.writeColorTableCPP(out); err != nil {
+		return err
+	}
+	renderCtx.writeRevCPP(out)
+	renderCtx.writeSrcSizeCPP(out)
+	renderCtx.writeFramesCPP(out)
+	if err := renderCtx.writeGlyphsCPP(out); err != nil {
+		return err
+	}
+
+	out.WriteString(renderCtx.alignasCPP())
+	out.WriteString(fmt.Sprintf("static const std::array<uint8_t, %d*%d> %s = {{\n", rowBytes, l.height, name))
+	for y := 0; y < l.height; y++ {
+		for _, line := range renderCtx.wrapRow(packed[y*rowBytes : (y+1)*rowBytes]) {
+			out.WriteString("    ")
+			for _, b := range line {
+				out.WriteString(fmt.Sprintf("0x%02x,", b))
+			}
+			out.WriteByte('\n')
+		}
+	}
+	out.WriteString("}};\n\n")
+
+	if gen.Unpack {
+		out.WriteString(fmt.Sprintf("// Unpacks %s into out, which must hold %s_size values.\n", name, name))
+		out.WriteString(fmt.Sprintf("static inline void %s_unpack(uint8_t *out) {\n", name))
+		out.WriteString(fmt.Sprintf("    for (size_t y = 0; y < %d; y++) {\n", l.height))
+		out.WriteString(fmt.Sprintf("        const uint8_t *row = &%s[y * %s_row_bytes];\n", name, name))
+		out.WriteString(fmt.Sprintf("        for (size_t x = 0; x < %s_stride; x++) {\n", name))
+		out.WriteString(fmt.Sprintf("            out[y * %s_stride + x] = (row[x / %d] >> (%d - (x %% %d) * %d)) & 0x%x;\n",
+			name, 8/bits, 8-bits, 8/bits, bits, 1<<bits-1))
+		out.WriteString("        }\n")
+		out.WriteString("    }\n")
+		out.WriteString("}\n\n")
+	}
+
+	return nil
+}
@@ -0,0 +1,377 @@
+package bitmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type Palette struct {
+	Size           int
+	IntensityRune  [256]rune
+	IntensityIndex [256]uint8
+
+	// If true, the chars have not been chosen yet, and are picked from autoChars by
+	// Generator.pickChars.
+	Auto bool
+}
+
+// autoChars is the pool 'auto' palettes pick chars from, in order. The default chars
+// come first, so small palettes look the same as the default.
+const autoChars = "_cowgCONWabdefhijklmnpqrstuvxyzABDEFGHIJKLMPQRSTUVXYZ"
+
+// defaultAutoSize is the number of chars picked for a palette of 'auto' with no size.
+const defaultAutoSize = 9
+
+// rendererReserved lists the identifiers each renderer's output already uses, which
+// auto palette chars must not shadow. The arduino renderer's chars are macros, and
+// '#undef F' would remove the F() macro from Arduino.h.
+var rendererReserved = map[string][]string{
+	"arduino": {"F"},
+}
+
+func (p *Palette) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("could not unmarshal palette: %w", err)
+	}
+	pp, err := PaletteFromChars(s)
+	if err != nil {
+		return err
+	}
+	*p = *pp
+	return nil
+}
+
+func (p Palette) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+func (p *Palette) String() string {
+	if p.Auto {
+		return fmt.Sprintf("auto:%d", p.Size)
+	}
+	var out strings.Builder
+	for i := 0; i < p.Size; i++ {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		out.WriteRune(p.IntensityRune[i])
+		out.WriteByte('=')
+		out.WriteString(strconv.Itoa(int(p.IntensityIndex[i])))
+	}
+	return out.String()
+}
+
+func (p *Palette) Set(v string) error {
+	pl, err := PaletteFromChars(v)
+	if err != nil {
+		return err
+	}
+	*p = *pl
+	return nil
+}
+
+var splitPtn = regexp.MustCompile(`,\s*`)
+
+func PaletteFromChars(v string) (*Palette, error) {
+	p := &Palette{}
+
+	if v == "auto" || strings.HasPrefix(v, "auto:") {
+		size := defaultAutoSize
+		if v != "auto" {
+			n, err := strconv.Atoi(strings.TrimPrefix(v, "auto:"))
+			if err != nil || n < 1 || n > len(autoChars) {
+				return nil, fmt.Errorf("invalid auto palette %q, expected 'auto:<n>' where n is 1-%d", v, len(autoChars))
+			}
+			size = n
+		}
+		p.Size, p.Auto = size, true
+		for intensity := 0; intensity < size; intensity++ {
+			p.IntensityIndex[intensity] = uint8(intensity)
+		}
+
+	} else if strings.Contains(v, "=") {
+		bits := splitPtn.Split(v, -1)
+		if len(bits) > 256 {
+			return nil, fmt.Errorf("too many characters in palette")
+		}
+		p.Size = len(bits)
+		for intensity, bit := range bits {
+			pchar, n := utf8.DecodeRuneInString(bit)
+			if bit[n] != '=' {
+				return nil, fmt.Errorf("expected '=' after rune in palette at intensity %d", intensity)
+			}
+
+			idx, err := strconv.ParseUint(bit[n+1:], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid palette index at intensity %d: %w", intensity, err)
+			}
+
+			p.IntensityIndex[intensity] = uint8(idx)
+			p.IntensityRune[intensity] = pchar
+		}
+
+	} else {
+		if utf8.RuneCountInString(v) > 256 {
+			return nil, fmt.Errorf("too many characters in palette")
+		}
+		var intensity uint8
+		for _, r := range v {
+			p.IntensityIndex[intensity] = intensity
+			p.IntensityRune[intensity] = r
+			intensity++
+		}
+		p.Size = int(intensity)
+	}
+
+	return p, nil
+}
+
+// ColorMap maps exact source colours to palette characters and indexes, bypassing
+// quantization and intensity sorting. Colors[i] corresponds to intensity i in Palette.
+type ColorMap struct {
+	Colors  []color.NRGBA
+	Palette Palette
+}
+
+func (c *ColorMap) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("could not unmarshal colour map: %w", err)
+	}
+	return c.Set(s)
+}
+
+func (c ColorMap) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+func (c *ColorMap) String() string {
+	var out strings.Builder
+	for i, col := range c.Colors {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		out.WriteString(hexColor(col))
+		out.WriteByte('=')
+		out.WriteRune(c.Palette.IntensityRune[i])
+		out.WriteByte('=')
+		out.WriteString(strconv.Itoa(int(c.Palette.IntensityIndex[i])))
+	}
+	return out.String()
+}
+
+// Set parses a comma separated list of colour/char pairs, i.e. '#ff0000=r,#00ff00=g',
+// optionally followed by an explicit palette index, i.e. '#ff0000=r=3'. If the index is
+// omitted, the entry's position in the list is used.
+func (c *ColorMap) Set(v string) error {
+	cm := ColorMap{}
+	if v == "" {
+		*c = cm
+		return nil
+	}
+
+	bits := splitPtn.Split(v, -1)
+	if len(bits) > 256 {
+		return fmt.Errorf("too many colours in colour map")
+	}
+	for i, bit := range bits {
+		parts := strings.SplitN(bit, "=", 3)
+		if len(parts) < 2 {
+			return fmt.Errorf("expected '<colour>=<char>' in colour map entry %d", i)
+		}
+		col, err := parseHexColor(parts[0])
+		if err != nil {
+			return fmt.Errorf("invalid colour in colour map entry %d: %w", i, err)
+		}
+		pchar, n := utf8.DecodeRuneInString(parts[1])
+		if n == 0 || n != len(parts[1]) {
+			return fmt.Errorf("expected a single char in colour map entry %d", i)
+		}
+		idx := uint64(i)
+		if len(parts) == 3 {
+			idx, err = strconv.ParseUint(parts[2], 10, 8)
+			if err != nil {
+				return fmt.Errorf("invalid palette index in colour map entry %d: %w", i, err)
+			}
+		}
+		for _, existing := range cm.Colors {
+			if existing == col {
+				return fmt.Errorf("duplicate colour %s in colour map", hexColor(col))
+			}
+		}
+		cm.Colors = append(cm.Colors, col)
+		cm.Palette.IntensityRune[i] = pchar
+		cm.Palette.IntensityIndex[i] = uint8(idx)
+	}
+	cm.Palette.Size = len(cm.Colors)
+
+	*c = cm
+	return nil
+}
+
+// parseHexColor parses a colour in '#rrggbb' or '#rrggbbaa' format. The leading '#' is
+// optional.
+func parseHexColor(v string) (color.NRGBA, error) {
+	v = strings.TrimPrefix(v, "#")
+	if len(v) != 6 && len(v) != 8 {
+		return color.NRGBA{}, fmt.Errorf("expected '#rrggbb' or '#rrggbbaa', found %q", v)
+	}
+	n, err := strconv.ParseUint(v, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("expected '#rrggbb' or '#rrggbbaa', found %q", v)
+	}
+	if len(v) == 6 {
+		n = n<<8 | 0xff
+	}
+	return color.NRGBA{R: uint8(n >> 24), G: uint8(n >> 16), B: uint8(n >> 8), A: uint8(n)}, nil
+}
+
+func hexColor(col color.NRGBA) string {
+	if col.A == 0xff {
+		return fmt.Sprintf("#%02x%02x%02x", col.R, col.G, col.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", col.R, col.G, col.B, col.A)
+}
+
+// loadFixedPalette loads a palette from v, which may be a comma separated list of hex
+// colours (i.e. '#000000,#ffffff'), a GIMP palette file ('.gpl'), or an image file. If
+// v is an image, a paletted image's palette is used, otherwise the image's unique
+// colours are used in the order they are first seen.
+func loadFixedPalette(v string) (color.Palette, error) {
+	var palette color.Palette
+
+	switch {
+	case strings.HasPrefix(v, "#"):
+		for _, bit := range splitPtn.Split(v, -1) {
+			col, err := parseHexColor(bit)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fixed palette colour: %w", err)
+			}
+			palette = append(palette, col)
+		}
+
+	case strings.ToLower(filepath.Ext(v)) == ".gpl":
+		bts, err := os.ReadFile(v)
+		if err != nil {
+			return nil, err
+		}
+		palette, err = parseGPL(bts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GIMP palette %q: %w", v, err)
+		}
+
+	default:
+		img, err := decode(v, decodeOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not load fixed palette image: %w", err)
+		}
+		if pimg, ok := img.(*image.Paletted); ok {
+			palette = pimg.Palette
+		} else if pimg, ok := img.(asePaletted); ok {
+			palette = pimg.Palette
+		} else {
+			palette = uniqueColors(img)
+		}
+	}
+
+	if len(palette) == 0 {
+		return nil, fmt.Errorf("fixed palette %q is empty", v)
+	}
+	if len(palette) > 256 {
+		return nil, fmt.Errorf("fixed palette %q has more than 256 colours", v)
+	}
+	return palette, nil
+}
+
+// parseGPL parses the colours from a GIMP palette file.
+func parseGPL(bts []byte) (color.Palette, error) {
+	var palette color.Palette
+	scn := bufio.NewScanner(bytes.NewReader(bts))
+	line := 0
+	for scn.Scan() {
+		line++
+		text := strings.TrimSpace(scn.Text())
+		if line == 1 {
+			if text != "GIMP Palette" {
+				return nil, fmt.Errorf("missing 'GIMP Palette' header")
+			}
+			continue
+		}
+		if text == "" || text[0] == '#' || strings.HasPrefix(text, "Name:") || strings.HasPrefix(text, "Columns:") {
+			continue
+		}
+		var r, g, b uint8
+		if _, err := fmt.Sscan(text, &r, &g, &b); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		palette = append(palette, color.NRGBA{R: r, G: g, B: b, A: 0xff})
+	}
+	return palette, scn.Err()
+}
+
+func uniqueColors(img image.Image) color.Palette {
+	var palette color.Palette
+	seen := map[color.NRGBA]bool{}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if !seen[c] {
+				seen[c] = true
+				palette = append(palette, c)
+			}
+		}
+	}
+	return palette
+}
+
+// pickChars chooses the chars for an 'auto' palette from autoChars, skipping any that
+// would collide with the renderer's own identifiers, the variable name, or the
+// identifiers in g.Reserved. Explicit chars are checked against g.Reserved instead.
+func (g *Generator) pickChars() error {
+	reserved := map[string]bool{g.VarName: true}
+	for _, name := range splitPtn.Split(g.Reserved, -1) {
+		if name != "" {
+			reserved[name] = true
+		}
+	}
+
+	if !g.Palette.Auto {
+		for intensity := 0; intensity < g.Palette.Size; intensity++ {
+			if c := string(g.Palette.IntensityRune[intensity]); g.Reserved != "" && reserved[c] {
+				return fmt.Errorf("palette char %q is reserved", c)
+			}
+		}
+		return nil
+	}
+
+	for _, name := range rendererReserved[g.Renderer] {
+		reserved[name] = true
+	}
+	intensity := 0
+	for _, r := range autoChars {
+		if intensity == g.Palette.Size {
+			break
+		}
+		if !reserved[string(r)] {
+			g.Palette.IntensityRune[intensity] = r
+			intensity++
+		}
+	}
+	if intensity < g.Palette.Size {
+		return fmt.Errorf("only %d unreserved chars are available for an auto palette of %d", intensity, g.Palette.Size)
+	}
+	g.Palette.Auto = false
+	return nil
+}
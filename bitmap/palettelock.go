@@ -0,0 +1,149 @@
+package bitmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// paletteLockPath returns the path of g.PaletteLock, with '{var}' replaced by the
+// variable name so each area in a map can have its own lock file.
+func (g *Generator) paletteLockPath() string {
+	return strings.ReplaceAll(g.PaletteLock, "{var}", g.VarName)
+}
+
+// loadPaletteLock loads a char to colour mapping saved by savePaletteLock. If the file
+// does not exist, nil is returned without an error.
+func loadPaletteLock(path string) (map[rune]color.NRGBA, error) {
+	bts, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]string
+	if err := json.Unmarshal(bts, &raw); err != nil {
+		return nil, fmt.Errorf("invalid palette lock %q: %w", path, err)
+	}
+	out := make(map[rune]color.NRGBA, len(raw))
+	for char, hex := range raw {
+		r, n := utf8.DecodeRuneInString(char)
+		if n == 0 || n != len(char) {
+			return nil, fmt.Errorf("invalid palette lock %q: expected a single character, found %q", path, char)
+		}
+		col, err := parseHexColor(hex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid palette lock %q: %w", path, err)
+		}
+		out[r] = col
+	}
+	return out, nil
+}
+
+// paletteLock is the colour each char in use was mapped to by a Build, to be saved to
+// path once the build has succeeded.
+type paletteLock struct {
+	path   string
+	colors map[string]string
+}
+
+// newPaletteLock returns the colour each palette index in use is mapped to, keyed by
+// its character.
+func newPaletteLock(path string, palimg *image.Paletted, paletteIndexes []uint8, paletteIndexToChar [256]rune) *paletteLock {
+	lock := &paletteLock{path: path, colors: map[string]string{}}
+	for _, v := range paletteIndexes {
+		col := color.NRGBAModel.Convert(palimg.Palette[v]).(color.NRGBA)
+		lock.colors[string(paletteIndexToChar[v])] = hexColor(col)
+	}
+	return lock
+}
+
+// save writes the lock to its path, in the format loadPaletteLock reads. A lock
+// without a path, whose Build saved nothing, is skipped.
+func (lock *paletteLock) save() error {
+	if lock.path == "" {
+		return nil
+	}
+	bts, err := json.MarshalIndent(lock.colors, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(lock.path, append(bts, '\n'), 0644)
+}
+
+// deferPaletteLocks gives each task with a palette lock its own paletteLock for Build
+// to leave the lock in, rather than saving it, so savePaletteLocks can save every lock
+// once the whole build has succeeded. Two tasks saving to the same path would
+// overwrite each other's lock, so that is an error.
+func deferPaletteLocks(tasks []buildTask) error {
+	varNames := map[string]string{}
+	for idx := range tasks {
+		gen := tasks[idx].gen
+		if gen.PaletteLock == "" {
+			continue
+		}
+		path := gen.paletteLockPath()
+		if other, ok := varNames[path]; ok {
+			return fmt.Errorf("%s and %s would both save their palette lock to %q; use '{var}' in the palette lock path", other, gen.VarName, path)
+		}
+		varNames[path] = gen.VarName
+		tasks[idx].gen = gen.Clone()
+		tasks[idx].gen.lock = &paletteLock{}
+	}
+	return nil
+}
+
+// savePaletteLocks saves the locks left by the Builds of tasks prepared with
+// deferPaletteLocks.
+func savePaletteLocks(tasks []buildTask) error {
+	for _, task := range tasks {
+		if task.gen.lock != nil {
+			if err := task.gen.lock.save(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lockedPaletted maps each pixel in img to the nearest colour in lock. Like a colour
+// map, the palette index of each colour is its character's intensity in pal, so
+// unused characters have a transparent placeholder colour.
+func lockedPaletted(img image.Image, pal *Palette, lock map[rune]color.NRGBA) (*image.Paletted, error) {
+	palette := make(color.Palette, pal.Size)
+	var candidates color.Palette
+	var candidateIndexes []uint8
+	found := map[rune]bool{}
+	for intensity := 0; intensity < pal.Size; intensity++ {
+		palette[intensity] = color.NRGBA{}
+		if col, ok := lock[pal.IntensityRune[intensity]]; ok {
+			found[pal.IntensityRune[intensity]] = true
+			palette[intensity] = col
+			candidates = append(candidates, col)
+			candidateIndexes = append(candidateIndexes, uint8(intensity))
+		}
+	}
+	for char := range lock {
+		if !found[char] {
+			return nil, fmt.Errorf("palette lock character %q is not in the palette", char)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("palette lock is empty")
+	}
+
+	bounds := img.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			idx := candidates.Index(img.At(x, y))
+			out.SetColorIndex(x-bounds.Min.X, y-bounds.Min.Y, candidateIndexes[idx])
+		}
+	}
+	return out, nil
+}
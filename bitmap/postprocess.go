@@ -0,0 +1,86 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// postProcess pipes data through the external command cmdline, returning the command's
+// stdout. Any occurrence of '{out}' in cmdline is replaced with name.
+//
+// cmdline is split into arguments using a small subset of shell quoting rules: single
+// and double quotes group words, and backslash escapes the next character outside
+// single quotes. No other shell features are supported.
+func postProcess(cmdline string, name string, data []byte) ([]byte, error) {
+	args, err := splitCommand(strings.ReplaceAll(cmdline, "{out}", name))
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty postprocess command")
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("postprocess command %q failed: %w", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+func splitCommand(v string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	var inWord bool
+	var quote rune
+	var escaped bool
+
+	for _, r := range v {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in command %q", v)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash in command %q", v)
+	}
+	if inWord {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
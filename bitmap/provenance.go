@@ -0,0 +1,88 @@
+package bitmap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+)
+
+// InputInfo describes the input file an image was loaded from, for the provenance
+// comment.
+type InputInfo struct {
+	Path   string
+	SHA256 string
+
+	// Command line the tool was run with, including the program name.
+	Command []string
+}
+
+// newInputInfo describes the input at path, which contains data.
+func newInputInfo(path string, data []byte) *InputInfo {
+	sum := sha256.Sum256(data)
+	return &InputInfo{Path: path, SHA256: hex.EncodeToString(sum[:]), Command: os.Args}
+}
+
+// toolVersion returns the module version the tool was built from, or '(devel)' if it
+// was built from a checkout.
+func toolVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// headerComment returns the text of the comment at the top of each output: the
+// attribution, followed by the provenance comment if gen.Provenance is set.
+func (rc *renderContext) headerComment() string {
+	header := rc.gen.Attribution
+	if rc.gen.Provenance {
+		if header != "" {
+			header = strings.TrimRight(header, "\n") + "\n\n"
+		}
+		header += rc.provenanceComment()
+	}
+	return header
+}
+
+// provenanceComment describes how the output was generated: the tool version, the
+// input and its hash, the source and output sizes, the palette, the scaler and the
+// command line.
+func (rc *renderContext) provenanceComment() string {
+	gen := rc.gen
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf("Generated by bmp2cpp %s\n", toolVersion()))
+	if in := gen.Input; in != nil {
+		out.WriteString(fmt.Sprintf("Input: %s (sha256 %s)\n", in.Path, in.SHA256))
+	}
+	width, height := rc.layout.logicalSize()
+	out.WriteString(fmt.Sprintf("Size: %dx%d, from %dx%d\n", width, height, rc.srcSize.X, rc.srcSize.Y))
+	if !rc.literal {
+		out.WriteString(fmt.Sprintf("Palette: %s\n", rc.palette.String()))
+	}
+	scaler := gen.Scaler
+	if scaler == "" {
+		scaler = "catmullrom"
+	}
+	out.WriteString(fmt.Sprintf("Scaler: %s\n", scaler))
+	if in := gen.Input; in != nil && len(in.Command) > 0 {
+		out.WriteString(fmt.Sprintf("Command: %s\n", quoteCommand(in.Command)))
+	}
+	return out.String()
+}
+
+// quoteCommand joins args into a command line which splitCommand, or a shell, would
+// split back into the same arguments.
+func quoteCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for idx, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`*?;&|<>(){}[]#~!") {
+			quoted[idx] = arg
+		} else {
+			quoted[idx] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,207 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"runtime"
+	"sync"
+
+	"github.com/shabbyrobe/wu2quant"
+)
+
+// quantizeParallel quantizes img to at most colors using Wu's quantizer, splitting the
+// per-pixel passes across threads goroutines. If threads is <= 0, one goroutine per CPU
+// is used.
+//
+// The conversion to RGBA and the final pixel mapping are split into horizontal bands.
+// The histogram pass inside wu2quant is not split, but it is cheap compared to the
+// conversion and mapping for large images.
+//
+// Unlike wu2quant.ToPaletted, which maps each pixel to the box it fell into when the
+// colour space was cut, pixels are mapped to the nearest palette colour (at the same
+// 5-bit-per-channel precision). This may differ very slightly from the single threaded
+// result for colours close to a box boundary.
+//
+// If alphaWeight is set, the palette is computed from alphaWeightedSample, but every
+// pixel is still mapped to it.
+func quantizeParallel(img image.Image, colors int, threads int, alphaWeight bool) (*image.Paletted, error) {
+	if colors <= 0 || colors > 256 {
+		return nil, fmt.Errorf("palette size must be 0 < sz <= 256; found %d", colors)
+	}
+	if threads < 0 {
+		threads = runtime.NumCPU()
+	}
+
+	rgba := toRGBAParallel(img, threads)
+	sample := rgba
+	if alphaWeight {
+		sample = alphaWeightedSample(rgba)
+	}
+	rgbaPalette := wu2quant.New().QuantizeRGBA(make([]color.RGBA, 0, colors), sample)
+
+	palette := make(color.Palette, len(rgbaPalette))
+	for i, c := range rgbaPalette {
+		palette[i] = c
+	}
+
+	lut := newPaletteLUT(palette)
+	size := rgba.Bounds().Size()
+	out := image.NewPaletted(image.Rect(0, 0, size.X, size.Y), palette)
+	inBands(size.Y, threads, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			lut.mapRow(out.Pix[y*out.Stride:y*out.Stride+size.X], rgba.Pix[y*rgba.Stride:])
+		}
+	})
+
+	return out, nil
+}
+
+// alphaWeightSteps is the number of times a fully opaque pixel is repeated in an
+// alphaWeightedSample. Pixels with less than half a step of alpha are left out.
+const alphaWeightSteps = 4
+
+// alphaWeightedSample returns the pixels of rgba repeated in proportion to their alpha,
+// so the quantizer's histogram counts a pixel by how visible it is. This stops a few
+// nearly transparent antialiased edge pixels taking a palette entry of their own. If
+// every pixel is nearly transparent, rgba is returned unchanged.
+func alphaWeightedSample(rgba *image.RGBA) *image.RGBA {
+	bounds := rgba.Bounds()
+	var pix []uint8
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := rgba.Pix[rgba.PixOffset(bounds.Min.X, y):rgba.PixOffset(bounds.Max.X, y)]
+		for x := 0; x < len(row); x += 4 {
+			copies := (int(row[x+3])*alphaWeightSteps + 127) / 255
+			for i := 0; i < copies; i++ {
+				pix = append(pix, row[x:x+4]...)
+			}
+		}
+	}
+	if len(pix) == 0 {
+		return rgba
+	}
+	return &image.RGBA{Pix: pix, Stride: len(pix), Rect: image.Rect(0, 0, len(pix)/4, 1)}
+}
+
+// paletteLUT is the nearest palette entry for each 5-bit-per-channel cell, which
+// matches the precision of the quantizer's histogram.
+type paletteLUT [32 * 32 * 32]uint8
+
+func newPaletteLUT(palette color.Palette) *paletteLUT {
+	var lut paletteLUT
+	for cell := range lut {
+		r, g, b := uint8(cell>>10)<<3|4, uint8(cell>>5&31)<<3|4, uint8(cell&31)<<3|4
+		lut[cell] = uint8(palette.Index(color.RGBA{R: r, G: g, B: b, A: 0xff}))
+	}
+	return &lut
+}
+
+// mapRow sets each index in dst to the nearest palette entry to the corresponding
+// RGBA pixel in src.
+func (lut *paletteLUT) mapRow(dst []uint8, src []uint8) {
+	for x := range dst {
+		p := src[x*4 : x*4+3]
+		dst[x] = lut[int(p[0]>>3)<<10|int(p[1]>>3)<<5|int(p[2]>>3)]
+	}
+}
+
+// toRGBAParallel converts img to an *image.RGBA with its origin at 0,0, splitting the
+// work into threads horizontal bands.
+func toRGBAParallel(img image.Image, threads int) *image.RGBA {
+	bounds := img.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok && bounds.Min == (image.Point{}) {
+		return rgba
+	}
+
+	size := bounds.Size()
+	out := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	inBands(size.Y, threads, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < size.X; x++ {
+				r, g, b, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+				i := out.PixOffset(x, y)
+				out.Pix[i+0] = uint8(r >> 8)
+				out.Pix[i+1] = uint8(g >> 8)
+				out.Pix[i+2] = uint8(b >> 8)
+				out.Pix[i+3] = uint8(a >> 8)
+			}
+		}
+	})
+	return out
+}
+
+// inBands calls fn concurrently for up to threads contiguous bands of rows in [0, rows).
+func inBands(rows int, threads int, fn func(y0, y1 int)) {
+	if threads > rows {
+		threads = rows
+	}
+	if threads <= 1 {
+		fn(0, rows)
+		return
+	}
+
+	var wg sync.WaitGroup
+	band := (rows + threads - 1) / threads
+	for y0 := 0; y0 < rows; y0 += band {
+		y1 := y0 + band
+		if y1 > rows {
+			y1 = rows
+		}
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			fn(y0, y1)
+		}(y0, y1)
+	}
+	wg.Wait()
+}
+
+// sharedPalette quantizes the union of imgs to at most colors, returning only the
+// colours that are used.
+func sharedPalette(imgs []image.Image, colors int) (color.Palette, error) {
+	var size image.Point
+	for _, img := range imgs {
+		sz := img.Bounds().Size()
+		if sz.X > size.X {
+			size.X = sz.X
+		}
+		size.Y += sz.Y
+	}
+
+	// Images are stacked vertically. Any space to the right of narrower images is
+	// repeated from their last column, so it doesn't add an extra colour:
+	union := image.NewNRGBA(image.Rectangle{Max: size})
+	y := 0
+	for _, img := range imgs {
+		bounds := img.Bounds()
+		for sy := bounds.Min.Y; sy < bounds.Max.Y; sy++ {
+			for x := 0; x < size.X; x++ {
+				sx := bounds.Min.X + x
+				if sx >= bounds.Max.X {
+					sx = bounds.Max.X - 1
+				}
+				union.Set(x, y, img.At(sx, sy))
+			}
+			y++
+		}
+	}
+
+	palimg, err := wu2quant.New().ToPaletted(colors, union, nil)
+	if err != nil {
+		return nil, err
+	}
+	var palette color.Palette
+	for _, idx := range uniquePaletteIndexes(palimg) {
+		palette = append(palette, palimg.Palette[idx])
+	}
+	return palette, nil
+}
+
+// paletteFromImage quantizes the image at path to at most colors.
+func paletteFromImage(path string, colors int) (color.Palette, error) {
+	img, err := decode(path, decodeOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not load palette reference image: %w", err)
+	}
+	return sharedPalette([]image.Image{img}, colors)
+}
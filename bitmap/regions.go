@@ -0,0 +1,202 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AreaRegions slices an image into areas marked on a region image of the same size,
+// where each contiguous blob of a single colour is the bounding box of an area, so
+// artists can annotate a sheet by painting over it rather than listing rectangles.
+// Fully transparent pixels, and pixels of the Background colour if it is set, are not
+// part of any region. Regions become areas after the areas listed in the map and the
+// grid, in the order of their first pixel, from the top row down and left to right.
+//
+// Each region's variable name comes from Names, which maps colours ('#rrggbb') to
+// names, or if its colour isn't in Names, the Name pattern, where '{index}' is replaced
+// with the region's number, '{color}' with its colour without the '#', and '{var}' with
+// the variable name. The default pattern is '{var}_{index}'. Regions named '-' are
+// skipped. A named colour may only mark one region.
+type AreaRegions struct {
+	// Region image, relative to the map file.
+	Image      string `json:"image"`
+	Background string `json:"background,omitempty"`
+
+	Name  string            `json:"name,omitempty"`
+	Names map[string]string `json:"names,omitempty"`
+
+	Gen   *Generator `json:"gen,omitempty"`
+	Group string     `json:"group,omitempty"`
+}
+
+// region is a contiguous blob of colour in a region image.
+type region struct {
+	color color.NRGBA
+	rect  image.Rectangle
+}
+
+// imageColors returns the colour of every pixel of img in row order.
+func imageColors(img image.Image) []color.NRGBA {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	colors := make([]color.NRGBA, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			colors[y*w+x] = color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+		}
+	}
+	return colors
+}
+
+// findBlobs returns the index of the first pixel and the bounds of every connected blob
+// of pixels in a w by h image, other than pixels for which skip returns true, in the
+// order of their first pixel. Neighbouring pixels, by index, are connected if joined
+// returns true for them. If diagonal is set, pixels which touch at a corner are
+// neighbours too.
+func findBlobs(w, h int, skip func(idx int) bool, joined func(a, b int) bool, diagonal bool) (firsts []int, rects []image.Rectangle) {
+	neighbours := []image.Point{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+	if diagonal {
+		neighbours = append(neighbours, image.Point{-1, -1}, image.Point{1, -1}, image.Point{-1, 1}, image.Point{1, 1})
+	}
+
+	seen := make([]bool, w*h)
+	var stack []int
+	for start := range seen {
+		if seen[start] || skip(start) {
+			continue
+		}
+		rect := image.Rect(start%w, start/w, start%w+1, start/w+1)
+		seen[start] = true
+		stack = append(stack[:0], start)
+		for len(stack) > 0 {
+			at := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := at%w, at/w
+			rect = rect.Union(image.Rect(x, y, x+1, y+1))
+
+			for _, d := range neighbours {
+				next := image.Pt(x+d.X, y+d.Y)
+				if next.X < 0 || next.Y < 0 || next.X >= w || next.Y >= h {
+					continue
+				}
+				if idx := next.Y*w + next.X; !seen[idx] && !skip(idx) && joined(at, idx) {
+					seen[idx] = true
+					stack = append(stack, idx)
+				}
+			}
+		}
+		firsts = append(firsts, start)
+		rects = append(rects, rect)
+	}
+	return firsts, rects
+}
+
+// findRegions returns every 4-connected blob of a single colour in img, other than
+// fully transparent pixels and pixels of background, if it is not nil, in the order of
+// their first pixel. Rectangles are relative to img's top left.
+func findRegions(img image.Image, background *color.NRGBA) []region {
+	colors := imageColors(img)
+	skip := func(idx int) bool {
+		return colors[idx].A == 0 || (background != nil && colors[idx] == *background)
+	}
+	same := func(a, b int) bool { return colors[a] == colors[b] }
+
+	firsts, rects := findBlobs(img.Bounds().Dx(), img.Bounds().Dy(), skip, same, false)
+	regions := make([]region, len(rects))
+	for idx := range rects {
+		regions[idx] = region{color: colors[firsts[idx]], rect: rects[idx]}
+	}
+	return regions
+}
+
+// regionName returns the variable name of the region with the given index and colour,
+// or an empty string if the region should be skipped.
+func (ar *AreaRegions) regionName(idx int, c color.NRGBA) string {
+	name, ok := ar.Names[hexColor(c)]
+	if !ok {
+		pattern := ar.Name
+		if pattern == "" {
+			pattern = "{var}_{index}"
+		}
+		name = strings.NewReplacer(
+			"{index}", strconv.Itoa(idx),
+			"{color}", strings.TrimPrefix(hexColor(c), "#"),
+			"{var}", ar.Gen.VarName,
+		).Replace(pattern)
+	}
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// expandRegions appends an area for each named region of the region image, if there
+// is one, which must be the size of bounds, the bounds of the input. dir is the
+// directory Image is relative to. It returns the region image's path, if one was read.
+func (im *ImageMap) expandRegions(dir string, bounds image.Rectangle) (imageFile string, err error) {
+	ar := im.Regions
+	if ar == nil {
+		return "", nil
+	}
+
+	var background *color.NRGBA
+	if ar.Background != "" {
+		c, err := parseHexColor(ar.Background)
+		if err != nil {
+			return "", mapFieldError("background", err)
+		}
+		background = &c
+	}
+	names := make(map[string]string, len(ar.Names))
+	for key, name := range ar.Names {
+		c, err := parseHexColor(key)
+		if err != nil {
+			return "", mapFieldError("names", err)
+		}
+		names[hexColor(c)] = name
+	}
+	ar.Names = names
+
+	imageFile = ar.Image
+	if !filepath.IsAbs(imageFile) {
+		imageFile = filepath.Join(dir, imageFile)
+	}
+	img, err := decode(imageFile, decodeOptions{})
+	if err != nil {
+		return imageFile, mapFieldError("image", err)
+	}
+	if img.Bounds().Size() != bounds.Size() {
+		return imageFile, mapFieldError("image", fmt.Errorf("region image is %dx%d, but the input is %dx%d",
+			img.Bounds().Dx(), img.Bounds().Dy(), bounds.Dx(), bounds.Dy()))
+	}
+
+	regions := findRegions(img, background)
+	if len(regions) == 0 {
+		return imageFile, mapFieldError("image", fmt.Errorf("region image has no regions"))
+	}
+	named := map[color.NRGBA]int{}
+	for idx, r := range regions {
+		if _, ok := ar.Names[hexColor(r.color)]; ok {
+			if first, ok := named[r.color]; ok {
+				return imageFile, mapFieldError("names", fmt.Errorf("%s marks regions %d and %d, but a named colour may only mark one",
+					hexColor(r.color), first, idx))
+			}
+			named[r.color] = idx
+		}
+
+		name := ar.regionName(idx, r.color)
+		if name == "" {
+			continue
+		}
+		gen := ar.Gen.Clone()
+		gen.VarName = name
+		area := NewArea(r.rect, gen)
+		area.Group = ar.Group
+		im.Areas = append(im.Areas, area)
+	}
+	return imageFile, nil
+}
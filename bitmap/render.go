@@ -0,0 +1,525 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+type renderContext struct {
+	paletteIndexes      []uint8
+	paletteIndexToChar  [256]rune
+	paletteIndexToValue [256]uint8
+	gen                 *Generator
+	palette             *Palette
+	img                 *image.Paletted
+	layout              layout
+
+	// The full colour image after rescaling, before quantization, in logical
+	// orientation. May differ in size from img if it was scaled after quantization.
+	source image.Image
+
+	// If true, pixels are written as their numeric palette value (with the offset
+	// applied) rather than as palette characters.
+	literal bool
+
+	// Size of the source image after rotating, before rescaling.
+	srcSize image.Point
+
+	// Palette lock to save once the build has succeeded, if a new one was made.
+	lock *paletteLock
+}
+
+func newRenderContext(
+	gen *Generator,
+	pal *Palette,
+	img *image.Paletted,
+	paletteIndexes []uint8,
+	paletteIndexToChar [256]rune,
+) *renderContext {
+	rc := &renderContext{
+		paletteIndexes:     paletteIndexes,
+		paletteIndexToChar: paletteIndexToChar,
+		gen:                gen,
+		palette:            pal,
+		img:                img,
+	}
+	for intensity, v := range paletteIndexes {
+		rc.paletteIndexToValue[v] = pal.IntensityIndex[intensity] + uint8(gen.PaletteOffset)
+	}
+	sz := img.Bounds().Size()
+	rc.layout = layout{width: sz.X, height: sz.Y, stride: sz.X, size: sz.X * sz.Y}
+	return rc
+}
+
+// writePixel writes the character, or numeric value if the context is literal, for
+// palette index px.
+func (rc *renderContext) writePixel(out *bytes.Buffer, px uint8) {
+	if rc.literal {
+		out.WriteString(strconv.Itoa(int(rc.paletteIndexToValue[px])))
+	} else {
+		out.WriteRune(rc.paletteIndexToChar[px])
+	}
+}
+
+func render(gen *Generator, renderCtx *renderContext, buf *bytes.Buffer) error {
+	if header := renderCtx.headerComment(); header != "" {
+		writeComment(buf, rendererComment(gen.Renderer), header)
+	}
+
+	switch gen.Renderer {
+	case "cpp17", "cpp":
+		if gen.ForegroundRule != "" {
+			return renderLayersCPP(renderCtx, buf)
+		}
+		if gen.PackBits > 0 && gen.PackBits < 8 {
+			return renderPackedCPP(renderCtx, buf)
+		}
+	case "js", "cjs":
+		if gen.ForegroundRule != "" {
+			return renderLayersJS(renderCtx, buf, gen.Renderer == "js")
+		}
+	}
+
+	r, ok := lookupRenderer(gen.Renderer)
+	if !ok {
+		return fmt.Errorf("unknown renderer %q", gen.Renderer)
+	}
+	return r.render(renderCtx, buf)
+}
+
+// renderer is an entry in the renderer registry. render writes the image to out, and
+// outputs are named after the variable with ext appended.
+type renderer struct {
+	render func(renderCtx *renderContext, out *bytes.Buffer) error
+	ext    string
+}
+
+// renderers holds every renderer, built in or added with RegisterRenderer, keyed by
+// the name used for -renderer. Renderers named 'exec:<command>' are not registered;
+// see lookupRenderer.
+var renderers = map[string]renderer{
+	"cpp17": {renderCPP17, ".h"},
+	"cpp":   {renderCPP, ".h"},
+	"cpp20": {renderCPP20, ".h"},
+	"cjs": {func(renderCtx *renderContext, out *bytes.Buffer) error {
+		return renderJS(renderCtx, out, false, renderCtx.gen.RowWiseJS)
+	}, ".js"},
+	"js": {func(renderCtx *renderContext, out *bytes.Buffer) error {
+		return renderJS(renderCtx, out, true, renderCtx.gen.RowWiseJS)
+	}, ".js"},
+	"term": {func(renderCtx *renderContext, out *bytes.Buffer) error {
+		return renderTerm(renderCtx, out, renderCtx.gen.TermColor)
+	}, ".txt"},
+	"xbm":     {renderXBM, ".xbm"},
+	"xpm":     {renderXPM, ".xpm"},
+	"arduino": {renderArduino, ".h"},
+	"cppm":    {renderCPPModule, ".cppm"},
+	"java":    {renderJava, ".java"},
+	"kotlin":  {renderKotlin, ".kt"},
+	"swift":   {renderSwift, ".swift"},
+	"asm":     {renderAsm, ".asm"},
+	"basic":   {renderBasic, ".bas"},
+	"glsl":    {renderGLSL, ".glsl"},
+	"wgsl":    {renderWGSL, ".wgsl"},
+	"hex":     {renderHex, ".hex"},
+	"base64":  {renderBase64, ".b64"},
+}
+
+// builtinRenderer reports whether name is one of the renderers in this package, rather
+// than one added with RegisterRenderer or an 'exec:' renderer.
+func builtinRenderer(name string) bool {
+	switch name {
+	case "cpp17", "cpp", "cpp20", "cjs", "js", "term", "xbm", "xpm", "arduino", "cppm", "java",
+		"kotlin", "swift", "asm", "basic", "glsl", "wgsl", "hex", "base64", "rustbin":
+		return true
+	}
+	return false
+}
+
+// RegisterRenderer adds a renderer which can be selected with -renderer name, for
+// output formats that don't belong in this package. fn is passed the image as
+// RenderData, and outputs are named after the variable with ext appended, i.e. '.inc'.
+// Registering a name twice replaces the earlier renderer.
+func RegisterRenderer(name string, ext string, fn func(data *RenderData, out io.Writer) error) {
+	renderers[name] = renderer{
+		render: func(renderCtx *renderContext, out *bytes.Buffer) error {
+			return fn(renderCtx.renderData(), out)
+		},
+		ext: ext,
+	}
+}
+
+// lookupRenderer returns the renderer registered as name, or an exec renderer if name
+// is 'exec:<command>'.
+func lookupRenderer(name string) (renderer, bool) {
+	if strings.HasPrefix(name, execRendererPrefix) {
+		return renderer{
+			render: func(renderCtx *renderContext, out *bytes.Buffer) error {
+				return renderExec(strings.TrimPrefix(name, execRendererPrefix), renderCtx, out)
+			},
+			ext: execRendererExt,
+		}, true
+	}
+	r, ok := renderers[name]
+	return r, ok
+}
+
+// rendererExt returns the file extension to use for outputs produced by renderer.
+func rendererExt(renderer string) string {
+	if r, ok := lookupRenderer(renderer); ok {
+		return r.ext
+	}
+	return ".h"
+}
+
+// charDefs returns a comma separated list of 'char=value' definitions for each palette
+// character that appears in the image, ordered by intensity.
+func (rc *renderContext) charDefs() string {
+	var out strings.Builder
+	pal := rc.palette
+	seenChars := mapSeenChars(rc.img, rc.paletteIndexToChar)
+	pIdx := 0
+	for intensity := range rc.paletteIndexes {
+		char := pal.IntensityRune[intensity]
+		if seenChars[char] {
+			if pIdx > 0 {
+				out.WriteString(", ")
+			}
+			pIdx++
+			out.WriteString(fmt.Sprintf("%c=%d", char,
+				pal.IntensityIndex[intensity]+uint8(rc.gen.PaletteOffset)))
+		}
+	}
+	return out.String()
+}
+
+// rendererComment returns the line comment prefix for renderer, or an empty string if
+// the renderer's output does not support comments, or writes its own. Renderers outside
+// this package are passed the attribution in RenderData instead.
+func rendererComment(renderer string) string {
+	if !builtinRenderer(renderer) {
+		return ""
+	}
+	switch renderer {
+	case "term", "xpm", "basic", "hex", "base64":
+		return ""
+	case "asm":
+		return ";"
+	default:
+		return "//"
+	}
+}
+
+// writeComment writes each line of text prefixed with the line comment prefix, followed
+// by a blank line. If prefix is empty, nothing is written.
+func writeComment(out *bytes.Buffer, prefix string, text string) {
+	if prefix == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		out.WriteString(prefix)
+		if line != "" {
+			out.WriteByte(' ')
+			out.WriteString(line)
+		}
+		out.WriteByte('\n')
+	}
+	out.WriteByte('\n')
+}
+
+func renderJS(renderCtx *renderContext, out *bytes.Buffer, esm bool, rowWiseJS bool) error {
+	gen := renderCtx.gen
+
+	if gen.Minify {
+		var full bytes.Buffer
+		if err := renderJSFull(renderCtx, &full, esm, rowWiseJS); err != nil {
+			return err
+		}
+		out.Write(minifyJS(full.Bytes()))
+		return nil
+	}
+	return renderJSFull(renderCtx, out, esm, rowWiseJS)
+}
+
+// minifyJS removes the comments, indentation, line breaks and padding from JS written
+// by renderJSFull. It is not a general purpose minifier: it relies on every line being
+// a comment or ending a statement or list item, and on there being no string literals.
+func minifyJS(src []byte) []byte {
+	var out bytes.Buffer
+	for _, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		line = strings.NewReplacer(", ", ",", " = ", "=", ": ", ":", " => ", "=>").Replace(line)
+		out.WriteString(line)
+	}
+	out.WriteByte('\n')
+	return out.Bytes()
+}
+
+func renderJSFull(renderCtx *renderContext, out *bytes.Buffer, esm bool, rowWiseJS bool) error {
+	gen := renderCtx.gen
+
+	// Sad that it has come to this:
+	out.WriteString("// prettier-ignore deno-fmt-ignore\n")
+
+	if esm {
+		out.WriteString(fmt.Sprintf("export const %s = (() => {\n", gen.VarName))
+	} else {
+		out.WriteString(fmt.Sprintf("exports.%s = (() => {\n", gen.VarName))
+	}
+
+	if !renderCtx.literal {
+		out.WriteString("  const ")
+		out.WriteString(renderCtx.charDefs())
+		out.WriteString(";\n")
+	}
+
+	if !rowWiseJS {
+		out.WriteString("  return new Uint8Array([\n")
+	} else {
+		out.WriteString("  return Object.freeze([\n")
+	}
+
+	renderCtx.eachRow(func(row []uint8) {
+		lines := renderCtx.wrapRow(row)
+		for idx, line := range lines {
+			out.WriteString("    ")
+			if rowWiseJS {
+				if idx == 0 {
+					out.WriteString("  new Uint8Array([")
+				} else {
+					out.WriteString("    ")
+				}
+			}
+			for _, px := range line {
+				renderCtx.writePixel(out, px)
+				out.WriteByte(',')
+			}
+			if rowWiseJS && idx == len(lines)-1 {
+				out.WriteString("]),")
+			}
+			out.WriteByte('\n')
+		}
+	})
+
+	out.WriteString("  ]);\n")
+	out.WriteString("})();\n")
+	renderCtx.writeLayoutJS(out, esm)
+	if err := renderCtx.writeColorTableJS(out, esm); err != nil {
+		return err
+	}
+	renderCtx.writeRevJS(out, esm)
+	renderCtx.writeSrcSizeJS(out, esm)
+	renderCtx.writeFramesJS(out, esm)
+	if err := renderCtx.writeGlyphsJS(out, esm); err != nil {
+		return err
+	}
+
+	if gen.JSRGBA {
+		renderJSRGBA(renderCtx, out, esm)
+	}
+
+	return nil
+}
+
+// renderJSRGBA writes the full colour image as non-premultiplied RGBA values in a
+// Uint8ClampedArray, which can be passed to 'new ImageData(data, width)'.
+func renderJSRGBA(renderCtx *renderContext, out *bytes.Buffer, esm bool) {
+	gen := renderCtx.gen
+	width, height := renderCtx.layout.logicalSize()
+
+	src := renderCtx.source
+	if src.Bounds().Size() != (image.Point{width, height}) {
+		scaled := image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.NearestNeighbor.Scale(scaled, scaled.Bounds(), src, src.Bounds(), draw.Src, nil)
+		src = scaled
+	}
+
+	export := fmt.Sprintf("exports.%s_rgba", gen.VarName)
+	if esm {
+		export = fmt.Sprintf("export const %s_rgba", gen.VarName)
+	}
+
+	out.WriteString("\n// prettier-ignore deno-fmt-ignore\n")
+	out.WriteString(fmt.Sprintf("%s = new Uint8ClampedArray([\n", export))
+	bounds := src.Bounds()
+	perLine := gen.Wrap
+	if perLine <= 0 {
+		perLine = bounds.Dx()
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for start := bounds.Min.X; start < bounds.Max.X; start += perLine {
+			out.WriteString("  ")
+			for x := start; x < start+perLine && x < bounds.Max.X; x++ {
+				c := color.NRGBAModel.Convert(src.At(x, y)).(color.NRGBA)
+				out.WriteString(fmt.Sprintf("%d,%d,%d,%d,", c.R, c.G, c.B, c.A))
+			}
+			out.WriteByte('\n')
+		}
+	}
+	out.WriteString("]);\n")
+	out.WriteString(fmt.Sprintf("%s_width = %d;\n", export, width))
+	out.WriteString(fmt.Sprintf("%s_height = %d;\n", export, height))
+}
+
+func renderCPP(renderCtx *renderContext, out *bytes.Buffer) error {
+	gen := renderCtx.gen
+	pal := renderCtx.palette
+
+	if !renderCtx.literal {
+		for intensity := range renderCtx.paletteIndexes {
+			out.WriteString(fmt.Sprintf("#define %c %d\n",
+				pal.IntensityRune[intensity],
+				pal.IntensityIndex[intensity]+uint8(gen.PaletteOffset)))
+		}
+		out.WriteByte('\n')
+	}
+
+	renderCtx.writeLayoutCPP(out)
+	if err := renderCtx.writeColorTableCPP(out); err != nil {
+		return err
+	}
+	renderCtx.writeRevCPP(out)
+	renderCtx.writeSrcSizeCPP(out)
+	renderCtx.writeFramesCPP(out)
+	if err := renderCtx.writeGlyphsCPP(out); err != nil {
+		return err
+	}
+
+	out.WriteString(renderCtx.alignasCPP())
+	out.WriteString("static const std::array<uint8_t, ")
+	out.WriteString(renderCtx.layout.sizeExpr())
+	out.WriteString(fmt.Sprintf("> %s = {{\n", gen.VarName))
+
+	renderCtx.eachRow(func(row []uint8) {
+		for _, line := range renderCtx.wrapRow(row) {
+			out.WriteString("    ")
+			for _, px := range line {
+				renderCtx.writePixel(out, px)
+				out.WriteByte(',')
+			}
+			out.WriteByte('\n')
+		}
+	})
+	out.WriteString("}};\n")
+	out.WriteByte('\n')
+
+	if !renderCtx.literal {
+		for intensity := range renderCtx.paletteIndexes {
+			out.WriteString(fmt.Sprintf("#undef %c\n",
+				pal.IntensityRune[intensity]))
+		}
+		out.WriteByte('\n')
+	}
+
+	return nil
+}
+
+func renderCPP17(renderCtx *renderContext, out *bytes.Buffer) error {
+	gen := renderCtx.gen
+
+	// Without the char constants, the lambda has nothing to scope, and large ones are
+	// slow to compile, or rejected by some compilers:
+	if gen.Literal {
+		return renderCPP(renderCtx, out)
+	}
+
+	renderCtx.writeLayoutCPP(out)
+	if err := renderCtx.writeColorTableCPP(out); err != nil {
+		return err
+	}
+	renderCtx.writeRevCPP(out)
+	renderCtx.writeSrcSizeCPP(out)
+	renderCtx.writeFramesCPP(out)
+	if err := renderCtx.writeGlyphsCPP(out); err != nil {
+		return err
+	}
+
+	szStr := renderCtx.layout.sizeExpr()
+	out.WriteString(renderCtx.alignasCPP())
+	out.WriteString(fmt.Sprintf("static const auto %s = []() constexpr -> const std::array<uint8_t, %s> {\n", gen.VarName, szStr))
+	if !renderCtx.literal {
+		out.WriteString("    const uint8_t ")
+		out.WriteString(renderCtx.charDefs())
+		out.WriteString(";\n")
+	}
+
+	out.WriteString("    return {{\n")
+	renderCtx.eachRow(func(row []uint8) {
+		for _, line := range renderCtx.wrapRow(row) {
+			out.WriteString("        ")
+			for _, px := range line {
+				renderCtx.writePixel(out, px)
+				out.WriteByte(',')
+			}
+			out.WriteByte('\n')
+		}
+	})
+	out.WriteString("    }};\n")
+	out.WriteString("}();\n\n")
+
+	return nil
+}
+
+// renderTerm renders the image as text for previewing in a terminal. Each pixel is
+// written twice so the image has a roughly correct aspect ratio in most terminal fonts.
+//
+// termColor may be "" or "none" for plain text, "256" for xterm-256color backgrounds or
+// "truecolor" for 24-bit backgrounds.
+func renderTerm(renderCtx *renderContext, out *bytes.Buffer, termColor string) error {
+	switch termColor {
+	case "", "none", "256", "truecolor":
+	default:
+		return fmt.Errorf("unknown terminal colour mode %q", termColor)
+	}
+	colored := termColor == "256" || termColor == "truecolor"
+
+	sz := renderCtx.img.Bounds().Size()
+	for y := 0; y < sz.Y; y++ {
+		for x := 0; x < sz.X; x++ {
+			px := renderCtx.img.ColorIndexAt(x, y)
+			char := renderCtx.paletteIndexToChar[px]
+			if colored {
+				col := renderCtx.img.Palette[px]
+				if termColor == "truecolor" {
+					r, g, b, _ := col.RGBA()
+					out.WriteString(fmt.Sprintf("\x1b[48;2;%d;%d;%dm", r>>8, g>>8, b>>8))
+				} else {
+					out.WriteString(fmt.Sprintf("\x1b[48;5;%dm", xterm256(col)))
+				}
+				if hsp(col) > 0.5 {
+					out.WriteString("\x1b[30m")
+				} else {
+					out.WriteString("\x1b[97m")
+				}
+			}
+			out.WriteRune(char)
+			out.WriteRune(char)
+		}
+		if colored {
+			out.WriteString("\x1b[0m")
+		}
+		out.WriteByte('\n')
+	}
+
+	return nil
+}
+
+// xterm256 returns the closest colour in the xterm-256color 6x6x6 colour cube.
+func xterm256(col color.Color) int {
+	r, g, b, _ := col.RGBA()
+	level := func(v uint32) int {
+		return int(math.Round(float64(v) / 0xffff * 5))
+	}
+	return 16 + 36*level(r) + 6*level(g) + level(b)
+}
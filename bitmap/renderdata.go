@@ -0,0 +1,129 @@
+package bitmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"os/exec"
+)
+
+const (
+	// execRendererPrefix selects an exec renderer, i.e. '-renderer "exec:./render.py"'.
+	execRendererPrefix = "exec:"
+
+	// execRendererExt is appended to the names of outputs from exec renderers.
+	execRendererExt = ".txt"
+)
+
+// RenderData is the image as passed to renderers added with RegisterRenderer, and, as
+// JSON, to exec renderers. Rows holds the emitted values of each stored row, with the
+// palette offset applied and any layout padding included.
+type RenderData struct {
+	VarName string `json:"varName"`
+
+	// Text for a comment at the top of the output: the attribution, and the provenance
+	// comment if requested. See renderContext.headerComment.
+	Attribution string `json:"attribution,omitempty"`
+
+	// Logical size of the image in pixels, and the stored row length and total number
+	// of values, which differ from it if the layout is padded or reordered.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	Stride int `json:"stride"`
+	Size   int `json:"size"`
+
+	// Size of the source image before rescaling.
+	SrcWidth  int `json:"srcWidth"`
+	SrcHeight int `json:"srcHeight"`
+
+	// Order the values are stored in, if not row-major: 'rotated', 'column-major' or
+	// 'vertical-bytes'. See layout.orderComment.
+	Order string `json:"order,omitempty"`
+
+	// The palette characters used by the image, ordered by intensity. Empty if the
+	// values are not palette characters, i.e. with -sdf.
+	Chars []RenderChar `json:"chars,omitempty"`
+
+	// Values are ints rather than uint8s, which encoding/json would encode as base64.
+	Rows [][]int `json:"rows"`
+}
+
+// RenderChar is a palette character, the value it is emitted as, and the colour it
+// was mapped from, in '#rrggbb' or '#rrggbbaa' format.
+type RenderChar struct {
+	Char  string `json:"char"`
+	Value uint8  `json:"value"`
+	Color string `json:"color,omitempty"`
+}
+
+func (rc *renderContext) renderData() *RenderData {
+	width, height := rc.layout.logicalSize()
+	data := &RenderData{
+		VarName:     rc.gen.VarName,
+		Attribution: rc.headerComment(),
+		Width:       width,
+		Height:      height,
+		Stride:      rc.layout.stride,
+		Size:        rc.layout.size,
+		SrcWidth:    rc.srcSize.X,
+		SrcHeight:   rc.srcSize.Y,
+		Order:       rc.layout.order,
+		Rows:        [][]int{},
+	}
+	if rc.layout.rotated {
+		data.Order = "rotated"
+	}
+
+	if !rc.literal {
+		seenChars := mapSeenChars(rc.img, rc.paletteIndexToChar)
+		for intensity, v := range rc.paletteIndexes {
+			char := rc.palette.IntensityRune[intensity]
+			if !seenChars[char] {
+				continue
+			}
+			rchar := RenderChar{Char: string(char), Value: rc.paletteIndexToValue[v]}
+			if int(v) < len(rc.img.Palette) {
+				rchar.Color = hexColor(color.NRGBAModel.Convert(rc.img.Palette[v]).(color.NRGBA))
+			}
+			data.Chars = append(data.Chars, rchar)
+		}
+	}
+
+	rc.eachRow(func(row []uint8) {
+		values := make([]int, len(row))
+		for x, px := range row {
+			values[x] = int(rc.paletteIndexToValue[px])
+		}
+		data.Rows = append(data.Rows, values)
+	})
+	return data
+}
+
+// renderExec renders the image by writing it as RenderData JSON to the stdin of the
+// external command cmdline, and using the command's stdout as the output. cmdline is
+// split into arguments as described in postProcess.
+func renderExec(cmdline string, renderCtx *renderContext, out *bytes.Buffer) error {
+	args, err := splitCommand(cmdline)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("empty exec renderer command")
+	}
+
+	input, err := json.Marshal(renderCtx.renderData())
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("exec renderer %q failed: %w", args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package bitmap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+)
+
+// ReportEntry describes a single Build for the -report HTML page. If
+// Generator.Report is set, Build fills it in.
+type ReportEntry struct {
+	Name    string
+	Source  image.Image
+	Preview *image.Paletted
+	Palette []ReportColor
+	Outputs []Output
+
+	// Number of values in the emitted array, including padding.
+	Size int
+}
+
+// ReportColor maps a palette character and emitted value to the colour it was
+// quantized from.
+type ReportColor struct {
+	Char  rune
+	Value uint8
+	Color color.NRGBA
+}
+
+// fill populates the entry from a processed image and its outputs.
+func (re *ReportEntry) fill(src image.Image, renderCtx *renderContext, outs []Output) {
+	re.Name = renderCtx.gen.VarName
+	re.Source = src
+	re.Preview = renderCtx.img
+	re.Outputs = outs
+	re.Size = renderCtx.layout.size
+
+	seen := uniquePaletteIndexes(renderCtx.img)
+	isSeen := [256]bool{}
+	for _, v := range seen {
+		isSeen[v] = true
+	}
+	re.Palette = re.Palette[:0]
+	for _, v := range renderCtx.paletteIndexes {
+		if !isSeen[v] || int(v) >= len(renderCtx.img.Palette) {
+			continue
+		}
+		re.Palette = append(re.Palette, ReportColor{
+			Char:  renderCtx.paletteIndexToChar[v],
+			Value: renderCtx.paletteIndexToValue[v],
+			Color: color.NRGBAModel.Convert(renderCtx.img.Palette[v]).(color.NRGBA),
+		})
+	}
+}
+
+var reportTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; background: #f4f4f4; }
+section { background: #fff; margin-bottom: 1.5em; padding: 1em; border-radius: 4px; }
+h2 { margin-top: 0; font-family: monospace; }
+.images { display: flex; gap: 2em; align-items: flex-start; }
+.images img { image-rendering: pixelated; border: 1px solid #ccc; background: repeating-conic-gradient(#ddd 0% 25%, #fff 0% 50%) 50% / 16px 16px; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 8px; text-align: left; font-family: monospace; }
+.swatch { display: inline-block; width: 1.5em; height: 1em; border: 1px solid #888; vertical-align: middle; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Entries}}
+<section>
+<h2>{{.Name}}</h2>
+<div class="images">
+<figure><img src="{{.Source}}" width="{{.SourceW}}"><figcaption>Source {{.SourceSize}}</figcaption></figure>
+<figure><img src="{{.Preview}}" width="{{.PreviewW}}"><figcaption>Quantized {{.PreviewSize}}</figcaption></figure>
+<table>
+<tr><th>Char</th><th>Value</th><th>Colour</th></tr>
+{{range .Palette}}<tr><td>{{.Char}}</td><td>{{.Value}}</td><td><span class="swatch" style="background: {{.Hex}}"></span> {{.Hex}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>Output</th><th>Bytes</th></tr>
+{{range .Outputs}}<tr><td>{{.Name}}</td><td>{{.Bytes}}</td></tr>
+{{end}}<tr><td>array values</td><td>{{.Size}}</td></tr>
+</table>
+</div>
+</section>
+{{end}}
+</body>
+</html>
+`))
+
+// writeReport writes a standalone HTML page describing each entry to path.
+func writeReport(path string, title string, entries []*ReportEntry) error {
+	type reportColor struct {
+		Char  string
+		Value uint8
+		Hex   template.CSS
+	}
+	type reportOutput struct {
+		Name  string
+		Bytes int
+	}
+	type reportEntry struct {
+		Name                    string
+		Source, Preview         template.URL
+		SourceSize, PreviewSize string
+		SourceW, PreviewW       int
+		Palette                 []reportColor
+		Outputs                 []reportOutput
+		Size                    int
+	}
+
+	// Images are scaled up so small sprites are visible, and embedded so the page
+	// is a single file:
+	dataURL := func(img image.Image) (template.URL, error) {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return "", err
+		}
+		return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+	}
+	displayWidth := func(sz image.Point) int {
+		w := sz.X
+		for w > 0 && w < 256 {
+			w *= 2
+		}
+		return w
+	}
+
+	data := struct {
+		Title   string
+		Entries []reportEntry
+	}{Title: title}
+
+	for _, e := range entries {
+		if e.Preview == nil {
+			continue
+		}
+		re := reportEntry{Name: e.Name, Size: e.Size}
+		var err error
+		if re.Source, err = dataURL(e.Source); err != nil {
+			return err
+		}
+		if re.Preview, err = dataURL(e.Preview); err != nil {
+			return err
+		}
+		srcSize, prvSize := e.Source.Bounds().Size(), e.Preview.Bounds().Size()
+		re.SourceSize = fmt.Sprintf("%dx%d", srcSize.X, srcSize.Y)
+		re.PreviewSize = fmt.Sprintf("%dx%d", prvSize.X, prvSize.Y)
+		re.SourceW, re.PreviewW = displayWidth(srcSize), displayWidth(prvSize)
+		for _, c := range e.Palette {
+			re.Palette = append(re.Palette, reportColor{
+				Char:  string(c.Char),
+				Value: c.Value,
+				Hex:   template.CSS(hexColor(c.Color)),
+			})
+		}
+		for _, out := range e.Outputs {
+			re.Outputs = append(re.Outputs, reportOutput{out.Name, len(out.Data)})
+		}
+		data.Entries = append(data.Entries, re)
+	}
+
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
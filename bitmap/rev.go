@@ -0,0 +1,48 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+)
+
+// values returns every emitted value, including padding, in array order.
+func (rc *renderContext) values() []uint8 {
+	values := make([]uint8, 0, rc.layout.size)
+	rc.eachRow(func(row []uint8) {
+		for _, px := range row {
+			values = append(values, rc.paletteIndexToValue[px])
+		}
+	})
+	return values
+}
+
+// checksum returns the 32-bit FNV-1a hash of the emitted values. It is cheap enough to
+// compute on a microcontroller, so it is used both by test fixtures and as the
+// '<var>_rev' constant.
+func (rc *renderContext) checksum() uint32 {
+	hash := fnv.New32a()
+	hash.Write(rc.values())
+	return hash.Sum32()
+}
+
+// writeRevCPP writes the checksum as '<var>_rev', if gen.Rev is set.
+func (rc *renderContext) writeRevCPP(out *bytes.Buffer) {
+	if !rc.gen.Rev {
+		return
+	}
+	out.WriteString(fmt.Sprintf("// FNV-1a hash of the values in %s, for detecting changed assets.\n", rc.gen.VarName))
+	out.WriteString(fmt.Sprintf("static constexpr uint32_t %s_rev = 0x%08xu;\n\n", rc.gen.VarName, rc.checksum()))
+}
+
+// writeRevJS writes the checksum as an exported '<var>_rev', if gen.Rev is set.
+func (rc *renderContext) writeRevJS(out *bytes.Buffer, esm bool) {
+	if !rc.gen.Rev {
+		return
+	}
+	if esm {
+		out.WriteString(fmt.Sprintf("export const %s_rev = 0x%08x;\n", rc.gen.VarName, rc.checksum()))
+	} else {
+		out.WriteString(fmt.Sprintf("exports.%s_rev = 0x%08x;\n", rc.gen.VarName, rc.checksum()))
+	}
+}
@@ -0,0 +1,95 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// renderRustBin renders the image's values as a raw binary file, and a small Rust
+// module that embeds it with include_bytes! alongside the dimensions and the value of
+// each palette character.
+func renderRustBin(renderCtx *renderContext) ([]Output, error) {
+	gen := renderCtx.gen
+	pal := renderCtx.palette
+	l := renderCtx.layout
+	binName := gen.VarName + ".bin"
+
+	bin := make([]byte, 0, l.size)
+	renderCtx.eachRow(func(row []uint8) {
+		for _, px := range row {
+			bin = append(bin, renderCtx.paletteIndexToValue[px])
+		}
+	})
+
+	var out bytes.Buffer
+	if header := renderCtx.headerComment(); header != "" {
+		writeComment(&out, "//", header)
+	}
+	width, height := l.logicalSize()
+	out.WriteString(fmt.Sprintf("pub const WIDTH: usize = %d;\n", width))
+	out.WriteString(fmt.Sprintf("pub const HEIGHT: usize = %d;\n", height))
+	if l.described() {
+		out.WriteString(fmt.Sprintf("pub const STRIDE: usize = %d;\n", l.stride))
+		out.WriteString(fmt.Sprintf("pub const SIZE: usize = %d;\n", l.size))
+	}
+	if l.rotated {
+		out.WriteString("pub const ROTATED: bool = true;\n")
+	}
+	if l.order == "vertical-bytes" {
+		out.WriteString(fmt.Sprintf("pub const PAGES: usize = %d;\n", l.height))
+	}
+	out.WriteString(fmt.Sprintf("pub static DATA: &[u8; %d] = include_bytes!(%q);\n", len(bin), binName))
+
+	if gen.ColorTable != "" {
+		table, bits, err := renderCtx.colorTable()
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString(fmt.Sprintf("pub static PALETTE: [u%d; %d] = [%s];\n", bits, len(table), colorTableValues(table, bits)))
+	}
+	if gen.Rev {
+		out.WriteString(fmt.Sprintf("pub const REV: u32 = 0x%08x;\n", renderCtx.checksum()))
+	}
+	if gen.SrcSize {
+		out.WriteString(fmt.Sprintf("pub const SRC_WIDTH: usize = %d;\n", renderCtx.srcSize.X))
+		out.WriteString(fmt.Sprintf("pub const SRC_HEIGHT: usize = %d;\n", renderCtx.srcSize.Y))
+	}
+	if len(gen.Frames) > 0 {
+		out.WriteString(fmt.Sprintf("/// x, y, width, height of each frame\npub static FRAMES: [[u16; 4]; %d] = [\n", len(gen.Frames)))
+		for _, r := range gen.Frames {
+			out.WriteString(fmt.Sprintf("    [%d, %d, %d, %d],\n", r.Min.X, r.Min.Y, r.Dx(), r.Dy()))
+		}
+		out.WriteString("];\n")
+	}
+	if gen.Glyphs > 0 {
+		xs, ys, widths, height, err := renderCtx.glyphTables()
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString(fmt.Sprintf("pub const GLYPH_COUNT: usize = %d;\n", gen.Glyphs))
+		out.WriteString(fmt.Sprintf("pub const GLYPH_HEIGHT: usize = %d;\n", height))
+		out.WriteString(fmt.Sprintf("pub static GLYPH_X: [u16; %d] = [%s];\n", gen.Glyphs, xs))
+		if ys != "" {
+			out.WriteString(fmt.Sprintf("pub static GLYPH_Y: [u16; %d] = [%s];\n", gen.Glyphs, ys))
+		}
+		out.WriteString(fmt.Sprintf("pub static GLYPH_WIDTHS: [u8; %d] = [%s];\n", gen.Glyphs, widths))
+	}
+
+	if !renderCtx.literal {
+		seenChars := mapSeenChars(renderCtx.img, renderCtx.paletteIndexToChar)
+		out.WriteByte('\n')
+		for intensity := range renderCtx.paletteIndexes {
+			char := pal.IntensityRune[intensity]
+			if seenChars[char] {
+				out.WriteString("#[allow(non_upper_case_globals)]\n")
+				out.WriteString(fmt.Sprintf("pub const PAL_%c: u8 = %d;\n", char,
+					pal.IntensityIndex[intensity]+uint8(gen.PaletteOffset)))
+			}
+		}
+	}
+
+	return []Output{
+		{Name: binName, Data: bin, Binary: true},
+		{Name: gen.VarName + ".rs", Data: out.Bytes()},
+	}, nil
+}
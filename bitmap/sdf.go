@@ -0,0 +1,178 @@
+package bitmap
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+const defaultSDFSpread = 4
+
+// sdfPalette maps every 8-bit distance value to itself. The characters are only used
+// by renderers that ignore literal mode, like 'term', and form a rough ramp.
+var sdfPalette = func() Palette {
+	const ramp = " .:-=+*#%@"
+	p := Palette{Size: 256}
+	for v := 0; v < 256; v++ {
+		p.IntensityIndex[v] = uint8(v)
+		p.IntensityRune[v] = rune(ramp[v*len(ramp)/256])
+	}
+	return p
+}()
+
+// grayPalette returns a palette where every index is the grey of the same value, for
+// use with sdfPalette.
+func grayPalette() color.Palette {
+	palette := make(color.Palette, 256)
+	for v := range palette {
+		palette[v] = color.Gray{Y: uint8(v)}
+	}
+	return palette
+}
+
+// buildSDF computes an 8-bit signed distance field from the shape in img, at the
+// target size. 128 is the edge of the shape, values above are inside and values below
+// are outside. SDFSpread is the distance in output pixels at which the values saturate.
+//
+// If img contains transparency, the shape is every pixel that is at least 50% opaque.
+// Otherwise, the shape is every pixel with an HSP intensity of at least 50%. If Invert
+// is set, the shape is inverted.
+//
+// Distances are computed at the source resolution and then sampled at the target size,
+// so large sources produce smooth fields for tiny outputs.
+func (g *Generator) buildSDF(img image.Image) *image.Paletted {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	useAlpha := false
+	for y := bounds.Min.Y; y < bounds.Max.Y && !useAlpha; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				useAlpha = true
+				break
+			}
+		}
+	}
+
+	inside := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+			var in bool
+			if useAlpha {
+				_, _, _, a := c.RGBA()
+				in = a >= 0x8000
+			} else {
+				in = hsp(c) >= 0.5
+			}
+			inside[y*w+x] = in != g.Invert
+		}
+	}
+
+	// Distance from each outside pixel to the shape, and from each inside pixel to the
+	// outside:
+	toInside := edt(inside, w, h, true)
+	toOutside := edt(inside, w, h, false)
+
+	size := image.Point{w, h}
+	if g.TargetWidth > 0 || g.TargetHeight > 0 {
+		size = prepareSize(g.TargetWidth, g.TargetHeight, size)
+	}
+	scaleX := float64(w) / float64(size.X)
+	scaleY := float64(h) / float64(size.Y)
+	spread := g.SDFSpread
+	if spread <= 0 {
+		spread = defaultSDFSpread
+	}
+
+	out := image.NewPaletted(image.Rectangle{Max: size}, grayPalette())
+
+	for y := 0; y < size.Y; y++ {
+		sy := int((float64(y) + 0.5) * scaleY)
+		for x := 0; x < size.X; x++ {
+			sx := int((float64(x) + 0.5) * scaleX)
+			idx := sy*w + sx
+
+			// Pixel centres are half a pixel from the edge on either side:
+			var dist float64
+			if inside[idx] {
+				dist = math.Sqrt(toOutside[idx]) - 0.5
+			} else {
+				dist = -(math.Sqrt(toInside[idx]) - 0.5)
+			}
+			dist /= scaleX
+
+			v := math.Round(128 + dist/spread*127)
+			out.Pix[y*out.Stride+x] = uint8(math.Max(0, math.Min(255, v)))
+		}
+	}
+
+	return out
+}
+
+// edt computes the squared euclidean distance from every pixel to the nearest pixel
+// where mask == target, using Felzenszwalb and Huttenlocher's separable algorithm.
+func edt(mask []bool, w, h int, target bool) []float64 {
+	inf := float64(w*w + h*h)
+	dist := make([]float64, w*h)
+	for i, m := range mask {
+		if m == target {
+			dist[i] = 0
+		} else {
+			dist[i] = inf
+		}
+	}
+
+	n := w
+	if h > n {
+		n = h
+	}
+	f := make([]float64, n)
+	d := make([]float64, n)
+	v := make([]int, n)
+	z := make([]float64, n+1)
+
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			f[y] = dist[y*w+x]
+		}
+		edt1D(f[:h], d[:h], v, z)
+		for y := 0; y < h; y++ {
+			dist[y*w+x] = d[y]
+		}
+	}
+	for y := 0; y < h; y++ {
+		copy(f[:w], dist[y*w:(y+1)*w])
+		edt1D(f[:w], d[:w], v, z)
+		copy(dist[y*w:(y+1)*w], d[:w])
+	}
+
+	return dist
+}
+
+func edt1D(f, d []float64, v []int, z []float64) {
+	n := len(f)
+	k := 0
+	v[0] = 0
+	z[0] = math.Inf(-1)
+	z[1] = math.Inf(1)
+	for q := 1; q < n; q++ {
+		s := ((f[q] + float64(q*q)) - (f[v[k]] + float64(v[k]*v[k]))) / float64(2*q-2*v[k])
+		for s <= z[k] {
+			k--
+			s = ((f[q] + float64(q*q)) - (f[v[k]] + float64(v[k]*v[k]))) / float64(2*q-2*v[k])
+		}
+		k++
+		v[k] = q
+		z[k] = s
+		z[k+1] = math.Inf(1)
+	}
+	k = 0
+	for q := 0; q < n; q++ {
+		for z[k+1] < float64(q) {
+			k++
+		}
+		dq := float64(q - v[k])
+		d[q] = dq*dq + f[v[k]]
+	}
+}
@@ -0,0 +1,117 @@
+package bitmap
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// serveIndex is the single page front end served by -serve at '/'. It converts
+// dropped images by posting them to '/api'.
+//
+//go:embed web/index.html
+var serveIndex []byte
+
+// serveMaxRequest limits the size of a request body, i.e. a base64 encoded image.
+const serveMaxRequest = 64 << 20
+
+// runServe serves the front end and an API for it on addr until it fails. The API
+// takes the same requests as -lsp-like mode, POSTed as JSON to '/api', without the
+// Content-Length framing, and responds with the same JSON.
+//
+// Unlike -lsp-like, which only its parent process can talk to, anything which can
+// reach addr can make requests, including pages open in a browser on the same machine,
+// so requests must send the image as data and can't use options which read or write
+// files or run commands. Options passed on the command line are trusted.
+func runServe(addr string, gen Generator, parallel int) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(serveIndex)
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req stdioRequest
+		var rsp stdioResponse
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, serveMaxRequest)).Decode(&req)
+		if err != nil {
+			rsp.Error = fmt.Sprintf("invalid request: %v", err)
+		} else if err := checkServeRequest(req); err != nil {
+			rsp.ID, rsp.Error = req.ID, err.Error()
+		} else {
+			rsp.ID = req.ID
+			rsp.Result, err = handleStdio(req, gen, parallel, nil)
+			if err != nil {
+				rsp.Error = err.Error()
+			}
+		}
+
+		out, err := json.Marshal(rsp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+	})
+
+	log.Printf("serving on http://%s/", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// checkServeRequest returns an error if req would touch the server's file system or
+// run anything on it.
+func checkServeRequest(req stdioRequest) error {
+	params := req.Params
+	if params.Data == nil {
+		return fmt.Errorf("missing data: images must be sent with the request")
+	}
+	if params.Map != "" {
+		return fmt.Errorf("maps can't be used with -serve")
+	}
+	if len(params.Gen) == 0 {
+		return nil
+	}
+
+	// Only the options in the request are checked, so it is decoded on its own:
+	var gen Generator
+	if err := decodeGenerator(params.Gen, &gen); err != nil {
+		return fmt.Errorf("invalid gen: %w", err)
+	}
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{
+		{"renderer", strings.HasPrefix(gen.Renderer, execRendererPrefix)},
+		{"postProcess", gen.PostProcess != ""},
+		{"fixedPalette", gen.FixedPalette != "" && !strings.HasPrefix(gen.FixedPalette, "#")},
+		{"paletteFrom", gen.PaletteFrom != ""},
+		{"paletteLock", gen.PaletteLock != ""},
+		{"deviceGamma", gen.DeviceGamma != "" && !isNumber(gen.DeviceGamma)},
+		{"stencil", gen.Stencil != ""},
+		{"preview", gen.Preview != ""},
+		{"exportImage", gen.ExportImage != ""},
+	} {
+		if opt.set {
+			return fmt.Errorf("%s can't be set by requests to -serve, as it reads or writes files or runs commands", opt.name)
+		}
+	}
+	return nil
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
@@ -0,0 +1,72 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// renderGLSL renders the image as a GLSL 'const uint <var>[N]' array, plus
+// '<var>_width' and '<var>_height' constants, for shaders that embed a small lookup
+// texture instead of binding one. Requires GLSL 3.30 or GLSL ES 3.00 for unsigned
+// integers and array constructors.
+func renderGLSL(renderCtx *renderContext, out *bytes.Buffer) error {
+	name := renderCtx.gen.VarName
+	writeValuesHeader(renderCtx, out)
+
+	renderCtx.eachLayoutConstant(func(suffix string, v int) {
+		out.WriteString(fmt.Sprintf("const uint %s_%s = %du;\n", name, suffix, v))
+	})
+	if renderCtx.gen.Rev {
+		out.WriteString(fmt.Sprintf("const uint %s_rev = 0x%08xu;\n", name, renderCtx.checksum()))
+	}
+	out.WriteByte('\n')
+
+	// GLSL doesn't allow a trailing comma in a constructor:
+	size := renderCtx.layout.size
+	out.WriteString(fmt.Sprintf("const uint %s[%d] = uint[%d](\n", name, size, size))
+	n := 0
+	renderCtx.eachRow(func(row []uint8) {
+		for _, line := range renderCtx.wrapRow(row) {
+			out.WriteString("    ")
+			for _, px := range line {
+				n++
+				out.WriteString(fmt.Sprintf("%du", renderCtx.paletteIndexToValue[px]))
+				if n < size {
+					out.WriteByte(',')
+				}
+			}
+			out.WriteByte('\n')
+		}
+	})
+	out.WriteString(");\n")
+	return nil
+}
+
+// renderWGSL renders the image as a WGSL 'const <var>: array<u32, N>', plus
+// '<var>_width' and '<var>_height' constants.
+func renderWGSL(renderCtx *renderContext, out *bytes.Buffer) error {
+	name := renderCtx.gen.VarName
+	writeValuesHeader(renderCtx, out)
+
+	renderCtx.eachLayoutConstant(func(suffix string, v int) {
+		out.WriteString(fmt.Sprintf("const %s_%s: u32 = %du;\n", name, suffix, v))
+	})
+	if renderCtx.gen.Rev {
+		out.WriteString(fmt.Sprintf("const %s_rev: u32 = 0x%08xu;\n", name, renderCtx.checksum()))
+	}
+	out.WriteByte('\n')
+
+	size := renderCtx.layout.size
+	out.WriteString(fmt.Sprintf("const %s: array<u32, %d> = array<u32, %d>(\n", name, size, size))
+	renderCtx.eachRow(func(row []uint8) {
+		for _, line := range renderCtx.wrapRow(row) {
+			out.WriteString("    ")
+			for _, px := range line {
+				out.WriteString(fmt.Sprintf("%du,", renderCtx.paletteIndexToValue[px]))
+			}
+			out.WriteByte('\n')
+		}
+	})
+	out.WriteString(");\n")
+	return nil
+}
@@ -0,0 +1,196 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"math"
+	"path/filepath"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// decodeFrames decodes every frame of an animated GIF, composited as a viewer would
+// show them. Other formats are decoded as a single frame.
+func decodeFrames(name string, bts []byte, opts decodeOptions) ([]image.Image, error) {
+	ext := sniffFormat(bts)
+	if ext == "" {
+		ext = strings.ToLower(filepath.Ext(name))
+	}
+	if ext != ".gif" {
+		img, err := decodeBytes(name, bts, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []image.Image{img}, nil
+	}
+
+	anim, err := gif.DecodeAll(bytes.NewReader(bts))
+	if err != nil {
+		return nil, err
+	}
+	bounds := image.Rect(0, 0, anim.Config.Width, anim.Config.Height)
+	canvas := image.NewNRGBA(bounds)
+	frames := make([]image.Image, 0, len(anim.Image))
+	for idx, frame := range anim.Image {
+		var previous *image.NRGBA
+		if idx < len(anim.Disposal) && anim.Disposal[idx] == gif.DisposalPrevious {
+			previous = image.NewNRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		out := image.NewNRGBA(bounds)
+		copy(out.Pix, canvas.Pix)
+		frames = append(frames, out)
+
+		if idx < len(anim.Disposal) {
+			switch anim.Disposal[idx] {
+			case gif.DisposalBackground:
+				draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+			case gif.DisposalPrevious:
+				canvas = previous
+			}
+		}
+	}
+	return frames, nil
+}
+
+// sheetTask lays the image of each task out in a grid with columns cells per row, or
+// a roughly square grid if columns is < 0, and returns a single task that builds the
+// whole sheet using gen. Each frame is transformed and rescaled using its own task's
+// generator first, so the sheet itself is not transformed or rescaled again. Cells
+// are the size of the largest frame, and the position of each frame is passed to the
+// renderer in Generator.Frames.
+func sheetTask(tasks []buildTask, columns int, gen *Generator) (buildTask, error) {
+	if len(tasks) == 0 {
+		return buildTask{}, fmt.Errorf("sprite sheet has no frames")
+	}
+	if columns < 0 {
+		columns = int(math.Ceil(math.Sqrt(float64(len(tasks)))))
+	}
+	if columns > len(tasks) {
+		columns = len(tasks)
+	}
+
+	frames, err := sheetFrames(tasks)
+	if err != nil {
+		return buildTask{}, err
+	}
+	var cell image.Point
+	for _, frame := range frames {
+		size := frame.Bounds().Size()
+		if size.X > cell.X {
+			cell.X = size.X
+		}
+		if size.Y > cell.Y {
+			cell.Y = size.Y
+		}
+	}
+
+	rows := (len(frames) + columns - 1) / columns
+	sheet := image.NewNRGBA(image.Rect(0, 0, cell.X*columns, cell.Y*rows))
+	rects := make([]image.Rectangle, len(frames))
+	for idx, frame := range frames {
+		at := image.Pt(idx%columns*cell.X, idx/columns*cell.Y)
+		rects[idx] = image.Rectangle{Min: at, Max: at.Add(frame.Bounds().Size())}
+		draw.Draw(sheet, rects[idx], frame, frame.Bounds().Min, draw.Src)
+	}
+	return buildTask{gen: sheetGenerator(gen, rects), img: sheet}, nil
+}
+
+// sheetFrames returns the image of each task, transformed and rescaled using the
+// task's own generator, ready to be laid out in a sheet or atlas.
+func sheetFrames(tasks []buildTask) ([]image.Image, error) {
+	frames := make([]image.Image, len(tasks))
+	for idx, task := range tasks {
+		img, err := transform(task.img, task.gen.Rotate, task.gen.Flip)
+		if err != nil {
+			return nil, err
+		}
+		if task.gen.ScaleAfterQuantize {
+			// The sheet is quantized as a whole, so the best that can be done is to
+			// scale each frame without introducing new colours:
+			if size, ok := task.gen.rescaleSize(img.Bounds().Size()); ok {
+				scaled := image.NewNRGBA(image.Rectangle{Max: size})
+				xdraw.NearestNeighbor.Scale(scaled, scaled.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+				img = scaled
+			}
+		} else if !task.gen.SDF {
+			img = task.gen.rescale(img)
+		}
+		frames[idx] = img
+	}
+	return frames, nil
+}
+
+// sheetGenerator returns a copy of gen for building a sheet or atlas whose frames are
+// at rects, and have already been transformed and rescaled.
+func sheetGenerator(gen *Generator, rects []image.Rectangle) *Generator {
+	gen = gen.Clone()
+	gen.Rotate, gen.Flip = 0, ""
+	gen.TargetWidth, gen.TargetHeight = 0, 0
+	gen.ScaleAfterQuantize = false
+	gen.Frames = rects
+	return gen
+}
+
+// writeFramesCPP writes the sheet's frame table as '<var>_frames', if there is one, and
+// the position of each named frame as a '<var>_rect' constant called '<var>_<name>'.
+func (rc *renderContext) writeFramesCPP(out *bytes.Buffer) {
+	frames := rc.gen.Frames
+	if len(frames) == 0 {
+		return
+	}
+	name := rc.gen.VarName
+	out.WriteString(fmt.Sprintf("static constexpr size_t %s_frame_count = %d;\n", name, len(frames)))
+	out.WriteString(fmt.Sprintf("// x, y, width, height of each frame in %s\n", name))
+	out.WriteString(fmt.Sprintf("static const uint16_t %s_frames[%d][4] = {\n", name, len(frames)))
+	for _, r := range frames {
+		out.WriteString(fmt.Sprintf("    {%d, %d, %d, %d},\n", r.Min.X, r.Min.Y, r.Dx(), r.Dy()))
+	}
+	out.WriteString("};\n\n")
+
+	if len(rc.gen.FrameNames) == len(frames) {
+		out.WriteString(fmt.Sprintf("struct %s_rect {\n", name))
+		out.WriteString("    uint16_t x, y, w, h;\n")
+		out.WriteString("};\n")
+		for idx, r := range frames {
+			out.WriteString(fmt.Sprintf("static constexpr %s_rect %s_%s = {%d, %d, %d, %d};\n",
+				name, name, rc.gen.FrameNames[idx], r.Min.X, r.Min.Y, r.Dx(), r.Dy()))
+		}
+		out.WriteByte('\n')
+	}
+}
+
+// writeFramesJS writes the sheet's frame table as an exported array of '[x, y, width,
+// height]' named '<var>_frames', if there is one, and the position of each named frame
+// as a '{x, y, w, h}' object called '<var>_<name>'.
+func (rc *renderContext) writeFramesJS(out *bytes.Buffer, esm bool) {
+	frames := rc.gen.Frames
+	if len(frames) == 0 {
+		return
+	}
+	if esm {
+		out.WriteString(fmt.Sprintf("export const %s_frames = Object.freeze([\n", rc.gen.VarName))
+	} else {
+		out.WriteString(fmt.Sprintf("exports.%s_frames = Object.freeze([\n", rc.gen.VarName))
+	}
+	for _, r := range frames {
+		out.WriteString(fmt.Sprintf("  [%d, %d, %d, %d],\n", r.Min.X, r.Min.Y, r.Dx(), r.Dy()))
+	}
+	out.WriteString("]);\n")
+
+	for idx, name := range rc.gen.FrameNames {
+		r := frames[idx]
+		if esm {
+			out.WriteString(fmt.Sprintf("export const %s_%s = ", rc.gen.VarName, name))
+		} else {
+			out.WriteString(fmt.Sprintf("exports.%s_%s = ", rc.gen.VarName, name))
+		}
+		out.WriteString(fmt.Sprintf("Object.freeze({ x: %d, y: %d, w: %d, h: %d });\n", r.Min.X, r.Min.Y, r.Dx(), r.Dy()))
+	}
+}
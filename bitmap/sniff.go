@@ -0,0 +1,56 @@
+package bitmap
+
+import (
+	"bytes"
+)
+
+// sniffFormat returns the extension of the image format bts appears to contain, based
+// on its magic bytes, or an empty string if it isn't recognised.
+func sniffFormat(bts []byte) string {
+	has := func(offset int, magic string) bool {
+		return len(bts) >= offset+len(magic) && string(bts[offset:offset+len(magic)]) == magic
+	}
+
+	switch {
+	case has(0, "\x89PNG\r\n\x1a\n"):
+		return ".png"
+	case has(0, "GIF87a"), has(0, "GIF89a"):
+		return ".gif"
+	case has(0, "\xff\xd8\xff"):
+		return ".jpg"
+	case has(0, "BM") && len(bts) >= 14:
+		return ".bmp"
+	case has(0, "II*\x00"), has(0, "MM\x00*"):
+		return ".tiff"
+	case has(0, "RIFF") && has(8, "WEBP"):
+		return ".webp"
+	case has(4, "\xe0\xa5") && len(bts) >= 128:
+		return ".aseprite"
+	case has(0, "/* XPM */"):
+		return ".xpm"
+	case len(bts) >= 3 && bts[0] == 'P' && bts[1] >= '1' && bts[1] <= '6' && isSpace(bts[2]):
+		return ".pnm"
+	case has(0, "\x00\x00\x01\x00"):
+		return ".ico"
+	case has(0, "\x00\x00\x02\x00"):
+		return ".cur"
+	}
+
+	// Text formats may start with whitespace, comments or an XML declaration, so only
+	// the start of the file is searched:
+	head := bts
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	switch {
+	case bytes.Contains(head, []byte("<svg")):
+		return ".svg"
+	case bytes.Contains(head, []byte("#define")) && bytes.Contains(bts, []byte("_bits")):
+		return ".xbm"
+	}
+	return ""
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
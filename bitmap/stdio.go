@@ -0,0 +1,210 @@
+package bitmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// stdioRequest is a single request in -lsp-like mode. Like the Language Server
+// Protocol, each message is a JSON body preceded by a 'Content-Length' header and a
+// blank line.
+type stdioRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params stdioParams     `json:"params"`
+}
+
+type stdioParams struct {
+	// Path of the input image. If Data is set, only the extension is used.
+	Input string `json:"input"`
+
+	// Contents of the input image, base64 encoded. Optional.
+	Data []byte `json:"data,omitempty"`
+
+	Map  string `json:"map,omitempty"`
+	Crop string `json:"crop,omitempty"`
+
+	// Generator options, applied over the options passed on the command line.
+	Gen json.RawMessage `json:"gen,omitempty"`
+}
+
+type stdioResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+type stdioOutput struct {
+	Name string `json:"name"`
+
+	// Text outputs are returned as Text, binary outputs as base64 encoded Binary.
+	Text   string `json:"text,omitempty"`
+	Binary []byte `json:"binary,omitempty"`
+}
+
+type stdioPreview struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	PNG    []byte `json:"png"`
+}
+
+type stdioInfo struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Format string `json:"format"`
+	Colors int    `json:"colors"`
+}
+
+// runStdio reads requests from r until EOF or an 'exit' request, writing a response
+// for each to w as soon as it is done. Requests are handled in order. Methods are:
+//
+//	convert  Build the input (or each area in the map), returning every output.
+//	preview  Build the input, returning the quantized image for each area as a PNG.
+//	info     Return the input's size, format and number of unique colours.
+//	exit     Stop reading requests.
+func runStdio(r io.Reader, w io.Writer, gen Generator, parallel int) error {
+	reader := textproto.NewReader(bufio.NewReader(r))
+	cache := &decodeCache{}
+	for {
+		header, err := reader.ReadMIMEHeader()
+		if err == io.EOF && len(header) == 0 {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("could not read request header: %w", err)
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil || length < 0 {
+			return fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader.R, body); err != nil {
+			return fmt.Errorf("could not read request body: %w", err)
+		}
+
+		var req stdioRequest
+		var rsp stdioResponse
+		if err := json.Unmarshal(body, &req); err != nil {
+			rsp.Error = fmt.Sprintf("invalid request: %v", err)
+		} else if req.Method == "exit" {
+			return nil
+		} else {
+			rsp.ID = req.ID
+			rsp.Result, err = handleStdio(req, gen, parallel, cache)
+			if err != nil {
+				rsp.Error = err.Error()
+			}
+		}
+
+		out, err := json.Marshal(rsp)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(out), out); err != nil {
+			return err
+		}
+	}
+}
+
+func handleStdio(req stdioRequest, base Generator, parallel int, cache *decodeCache) (interface{}, error) {
+	params := req.Params
+	if params.Input == "" {
+		return nil, fmt.Errorf("missing input")
+	}
+
+	if req.Method == "info" {
+		var img image.Image
+		var err error
+		if params.Data != nil {
+			img, err = decodeBytes(params.Input, params.Data, decodeOptions{})
+		} else {
+			img, err = decode(params.Input, decodeOptions{})
+		}
+		if err != nil {
+			return nil, err
+		}
+		bounds := img.Bounds()
+		colors := map[[4]uint32]bool{}
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, g, b, a := img.At(x, y).RGBA()
+				colors[[4]uint32{r, g, b, a}] = true
+			}
+		}
+		return stdioInfo{
+			Width:  bounds.Dx(),
+			Height: bounds.Dy(),
+			Format: strings.TrimPrefix(filepath.Ext(params.Input), "."),
+			Colors: len(colors),
+		}, nil
+	}
+
+	if req.Method != "convert" && req.Method != "preview" {
+		return nil, fmt.Errorf("unknown method %q", req.Method)
+	}
+
+	gen := *base.Clone()
+	if len(params.Gen) > 0 {
+		if err := decodeGenerator(params.Gen, &gen); err != nil {
+			return nil, fmt.Errorf("invalid gen: %w", err)
+		}
+	}
+	crop, err := parseCrop(params.Crop)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &convertJob{
+		gen:      gen,
+		mapFile:  params.Map,
+		crop:     crop,
+		args:     []string{params.Input},
+		data:     params.Data,
+		parallel: parallel,
+		cache:    cache,
+	}
+	warnings := &Warnings{}
+	build, err := job.build(warnings, req.Method == "preview")
+	if err != nil {
+		return nil, err
+	}
+
+	var warningList = []string{}
+	for _, w := range warnings.List() {
+		warningList = append(warningList, w.String())
+	}
+
+	if req.Method == "preview" {
+		var previews []stdioPreview
+		for _, entry := range build.report {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, entry.Preview); err != nil {
+				return nil, err
+			}
+			size := entry.Preview.Bounds().Size()
+			previews = append(previews, stdioPreview{entry.Name, size.X, size.Y, buf.Bytes()})
+		}
+		return map[string]interface{}{"previews": previews, "warnings": warningList}, nil
+	}
+
+	var outputs []stdioOutput
+	for _, outs := range build.results {
+		for _, out := range outs {
+			if out.Binary {
+				outputs = append(outputs, stdioOutput{Name: out.Name, Binary: out.Data})
+			} else {
+				outputs = append(outputs, stdioOutput{Name: out.Name, Text: string(out.Data)})
+			}
+		}
+	}
+	return map[string]interface{}{"outputs": outputs, "warnings": warningList}, nil
+}
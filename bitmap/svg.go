@@ -0,0 +1,1177 @@
+package bitmap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+	"golang.org/x/image/vector"
+)
+
+// decodeSVG rasterizes the SVG document in bts. If size is not empty, the document is
+// rasterized at that size (as with -size, either dimension may be 0 to preserve the
+// aspect ratio), otherwise it is rasterized at its intrinsic size. As with ImageMagick,
+// the document is drawn over a white background.
+//
+// Only a subset of SVG is supported, which is enough for most flat icons: path, rect,
+// circle, ellipse, line, polyline and polygon elements, use elements, solid fills and
+// strokes with either fill rule, group transforms, opacity, and style sheets whose
+// selectors are lists of types, classes and ids. As they can't be drawn correctly,
+// gradients, patterns, clip paths, masks and filters are an error. Text, images and
+// other elements are ignored, and each of these which is found adds a warning to
+// warnings.
+func decodeSVG(bts []byte, size image.Point, warnings *Warnings) (image.Image, error) {
+	root, err := parseSVGTree(bts)
+	if err != nil {
+		return nil, err
+	}
+	if root.name != "svg" {
+		return nil, fmt.Errorf("svg: root element is %q, not svg", root.name)
+	}
+	state, dst, err := svgRoot(root.attrs, size)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &svgRenderer{dst: dst, ids: map[string]*svgNode{}, warnings: warnings, warned: map[string]bool{}}
+	var index func(n *svgNode) error
+	index = func(n *svgNode) error {
+		if n.foreign {
+			return nil
+		}
+		if id := n.attrs["id"]; id != "" && r.ids[id] == nil {
+			r.ids[id] = n
+		}
+		if n.name == "style" {
+			if typ := n.attrs["type"]; typ != "" && typ != "text/css" {
+				return fmt.Errorf("svg: unsupported style sheet type %q", typ)
+			}
+			rules, err := parseSVGStyle(n.text)
+			if err != nil {
+				return err
+			}
+			r.rules = append(r.rules, rules...)
+		}
+		for _, child := range n.children {
+			if err := index(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := index(root); err != nil {
+		return nil, err
+	}
+	if err := r.render(root, state, 0); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+const svgNamespace = "http://www.w3.org/2000/svg"
+
+// svgNode is an element of an SVG document. Elements from other namespaces, such as an
+// editor's metadata, are foreign.
+type svgNode struct {
+	name     string
+	attrs    map[string]string
+	children []*svgNode
+	foreign  bool
+
+	// The contents of a style element.
+	text string
+}
+
+// parseSVGTree parses the elements of the SVG document in bts. Text is discarded,
+// except within style elements.
+func parseSVGTree(bts []byte) (*svgNode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(bts))
+	dec.Strict = false
+
+	var root *svgNode
+	var stack []*svgNode
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("svg: %w", err)
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &svgNode{
+				name:    tok.Name.Local,
+				attrs:   map[string]string{},
+				foreign: tok.Name.Space != "" && tok.Name.Space != svgNamespace,
+			}
+			for _, attr := range tok.Attr {
+				n.attrs[attr.Name.Local] = attr.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root == nil {
+				root = n
+			} else {
+				return nil, fmt.Errorf("svg: unexpected %q element after the root element", n.name)
+			}
+			stack = append(stack, n)
+
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+
+		case xml.CharData:
+			if len(stack) > 0 && stack[len(stack)-1].name == "style" {
+				stack[len(stack)-1].text += string(tok)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("svg: missing svg element")
+	}
+	return root, nil
+}
+
+// svgMaxUseDepth limits how deeply use elements may refer to elements containing more
+// use elements, which also stops a use element which refers to its own ancestor.
+const svgMaxUseDepth = 16
+
+// svgRenderer draws the elements of an SVG document onto dst.
+type svgRenderer struct {
+	dst    *image.RGBA
+	raster vector.Rasterizer
+
+	// Elements by their id, for use elements to refer to.
+	ids map[string]*svgNode
+
+	// The rules of the document's style sheets, in the order they were found.
+	rules []svgRule
+
+	// Warnings about parts of the document which aren't drawn, each only added once.
+	warnings *Warnings
+	warned   map[string]bool
+}
+
+func (r *svgRenderer) warn(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if !r.warned[msg] {
+		r.warned[msg] = true
+		r.warnings.Add(WarnSVGUnsupported, "%s", msg)
+	}
+}
+
+// render draws n and its children, with the state inherited from n's parent. depth is
+// the number of use elements n is drawn through.
+func (r *svgRenderer) render(n *svgNode, state svgState, depth int) error {
+	if n.foreign {
+		return nil
+	}
+
+	switch n.name {
+	case "svg", "g", "a", "switch":
+		if err := r.apply(&state, n); err != nil {
+			return err
+		}
+		return r.renderChildren(n, state, depth)
+
+	case "use":
+		return r.use(n, state, depth)
+
+	case "path", "rect", "circle", "ellipse", "line", "polyline", "polygon":
+		if err := r.apply(&state, n); err != nil {
+			return err
+		}
+		paths, err := svgShape(n.name, n.attrs)
+		if err != nil {
+			return err
+		}
+		if len(paths) > 0 {
+			state.draw(r.dst, &r.raster, paths)
+		}
+
+	case "defs", "symbol", "linearGradient", "radialGradient", "pattern", "clipPath", "mask",
+		"marker", "filter", "title", "desc", "metadata", "style":
+		// These are only drawn where they are referred to, if at all, and style sheets
+		// are found by decodeSVG.
+
+	default:
+		r.warn("<%s> elements are not supported", n.name)
+	}
+	return nil
+}
+
+func (r *svgRenderer) renderChildren(n *svgNode, state svgState, depth int) error {
+	for _, child := range n.children {
+		if err := r.render(child, state, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply updates state with the properties of n, as svgState.apply does, and fails if
+// n uses properties which can't be drawn.
+func (r *svgRenderer) apply(state *svgState, n *svgNode) error {
+	props := svgProps(n, r.rules)
+	for _, name := range []string{"fill", "stroke"} {
+		if strings.HasPrefix(props[name], "url(") {
+			return fmt.Errorf("svg: <%s> %s %q: gradients and patterns are not supported", n.name, name, props[name])
+		}
+	}
+	for _, name := range []string{"clip-path", "mask", "filter"} {
+		if v := props[name]; v != "" && v != "none" {
+			return fmt.Errorf("svg: <%s> %s %q: %ss are not supported", n.name, name, v, name)
+		}
+	}
+	return state.apply(props, n.attrs["transform"])
+}
+
+// use draws the element a use element refers to, as if it were the only child of a
+// group with the use element's attributes, translated by its x and y. A symbol is drawn
+// like a nested svg element, with its viewBox fitted to the use element's width and
+// height.
+func (r *svgRenderer) use(n *svgNode, state svgState, depth int) error {
+	href := n.attrs["href"]
+	target := r.ids[strings.TrimPrefix(href, "#")]
+	if !strings.HasPrefix(href, "#") || target == nil {
+		r.warn("<use> elements referring to %q are not drawn, as it is not an element of the document", href)
+		return nil
+	}
+	if depth >= svgMaxUseDepth {
+		return fmt.Errorf("svg: <use> elements refer to each other more than %d deep", svgMaxUseDepth)
+	}
+
+	if err := r.apply(&state, n); err != nil {
+		return err
+	}
+	state.transform = state.transform.mul(svgMatrix{1, 0, 0, 1, svgLength(n.attrs["x"]), svgLength(n.attrs["y"])})
+	if target.name != "symbol" {
+		return r.render(target, state, depth+1)
+	}
+
+	if err := r.apply(&state, target); err != nil {
+		return err
+	}
+	if vb := target.attrs["viewBox"]; vb != "" {
+		viewBox, err := svgViewBox(vb)
+		if err != nil {
+			return err
+		}
+		width, height := svgLength(n.attrs["width"]), svgLength(n.attrs["height"])
+		if width <= 0 {
+			width = viewBox[2]
+		}
+		if height <= 0 {
+			height = viewBox[3]
+		}
+		state.transform = state.transform.mul(svgFit(viewBox, width, height))
+	}
+	return r.renderChildren(target, state, depth+1)
+}
+
+// svgRoot prepares the destination image and the initial state from the attributes of
+// the root svg element.
+func svgRoot(attrs map[string]string, size image.Point) (svgState, *image.RGBA, error) {
+	width, height := svgLength(attrs["width"]), svgLength(attrs["height"])
+
+	var viewBox [4]float64
+	if vb := attrs["viewBox"]; vb != "" {
+		var err error
+		if viewBox, err = svgViewBox(vb); err != nil {
+			return svgState{}, nil, err
+		}
+	}
+
+	if width <= 0 && height <= 0 {
+		width, height = viewBox[2], viewBox[3]
+	} else if width <= 0 && viewBox[3] > 0 {
+		width = height * viewBox[2] / viewBox[3]
+	} else if height <= 0 && viewBox[2] > 0 {
+		height = width * viewBox[3] / viewBox[2]
+	}
+	if width <= 0 || height <= 0 {
+		return svgState{}, nil, fmt.Errorf("svg: missing width, height or viewBox")
+	}
+	if viewBox[2] == 0 {
+		viewBox = [4]float64{0, 0, width, height}
+	}
+
+	outSize := image.Point{int(math.Round(width)), int(math.Round(height))}
+	if size.X > 0 || size.Y > 0 {
+		outSize = prepareSize(size.X, size.Y, outSize)
+	}
+	if outSize.X <= 0 || outSize.Y <= 0 {
+		return svgState{}, nil, fmt.Errorf("svg: invalid size %v", outSize)
+	}
+
+	state := svgState{
+		transform:   svgFit(viewBox, float64(outSize.X), float64(outSize.Y)),
+		fill:        color.NRGBA{0, 0, 0, 255},
+		strokeNone:  true,
+		strokeWidth: 1,
+		opacity:     1,
+		fillOp:      1,
+		strokeOp:    1,
+	}
+	dst := image.NewRGBA(image.Rectangle{Max: outSize})
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	return state, dst, nil
+}
+
+func svgViewBox(s string) (viewBox [4]float64, err error) {
+	nums, err := svgNumbers(s)
+	if err != nil || len(nums) != 4 || nums[2] <= 0 || nums[3] <= 0 {
+		return viewBox, fmt.Errorf("svg: invalid viewBox %q", s)
+	}
+	copy(viewBox[:], nums)
+	return viewBox, nil
+}
+
+// svgFit returns the transform from viewBox to a viewport of width by height, centred
+// and scaled to fit, which is the default preserveAspectRatio="xMidYMid meet".
+func svgFit(viewBox [4]float64, width, height float64) svgMatrix {
+	scale := math.Min(width/viewBox[2], height/viewBox[3])
+	tx := (width-viewBox[2]*scale)/2 - viewBox[0]*scale
+	ty := (height-viewBox[3]*scale)/2 - viewBox[1]*scale
+	return svgMatrix{scale, 0, 0, scale, tx, ty}
+}
+
+// svgMatrix is an affine transform in the same order as the SVG matrix() function.
+type svgMatrix [6]float64
+
+// mul returns the transform that applies n, then m.
+func (m svgMatrix) mul(n svgMatrix) svgMatrix {
+	return svgMatrix{
+		m[0]*n[0] + m[2]*n[1],
+		m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3],
+		m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4],
+		m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+func (m svgMatrix) apply(p svgPoint) svgPoint {
+	return svgPoint{m[0]*p.x + m[2]*p.y + m[4], m[1]*p.x + m[3]*p.y + m[5]}
+}
+
+// scale returns the average factor the transform scales lengths by.
+func (m svgMatrix) scale() float64 {
+	return math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+}
+
+type svgPoint struct{ x, y float64 }
+
+// svgSubpath is a flattened subpath.
+type svgSubpath struct {
+	points []svgPoint
+	closed bool
+}
+
+// svgState holds the inherited presentation attributes of an element.
+type svgState struct {
+	transform   svgMatrix
+	fill        color.NRGBA
+	fillNone    bool
+	stroke      color.NRGBA
+	strokeNone  bool
+	strokeWidth float64
+	opacity     float64
+	fillOp      float64
+	strokeOp    float64
+	evenOdd     bool
+}
+
+var svgTransformPtn = regexp.MustCompile(`([a-zA-Z]+)\s*\(([^)]*)\)`)
+
+// svgRule is a rule of a style sheet with a single selector. A rule with a list of
+// selectors is split into a rule for each.
+type svgRule struct {
+	selector    svgSelector
+	specificity int
+	decls       []svgDecl
+}
+
+type svgDecl struct {
+	name, value string
+	important   bool
+}
+
+// svgSelector is a CSS compound selector of an optional type, classes and an id, such
+// as "path.outline". Combinators, attribute selectors and pseudo-classes aren't
+// supported.
+type svgSelector struct {
+	name    string
+	id      string
+	classes []string
+}
+
+var (
+	svgSelectorPtn     = regexp.MustCompile(`^(\*|[a-zA-Z][\w-]*)?((?:[.#][\w-]+)*)$`)
+	svgSelectorPartPtn = regexp.MustCompile(`[.#][\w-]+`)
+	svgCommentPtn      = regexp.MustCompile(`(?s)/\*.*?\*/`)
+)
+
+// parseSVGStyle parses the rules of the style sheet in css. Rules which can't be
+// parsed, or which use selectors or at-rules which aren't supported, are an error, as
+// ignoring them would draw the elements they style wrongly.
+func parseSVGStyle(css string) (rules []svgRule, err error) {
+	css = svgCommentPtn.ReplaceAllString(css, " ")
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			return rules, nil
+		}
+		open, close := strings.IndexByte(css, '{'), strings.IndexByte(css, '}')
+		if strings.HasPrefix(css, "@") {
+			return nil, fmt.Errorf("svg: unsupported style sheet rule %q", strings.Fields(css)[0])
+		} else if open < 0 || close < open {
+			return nil, fmt.Errorf("svg: invalid style sheet near %q", css)
+		}
+
+		decls := svgDeclarations(css[open+1 : close])
+		for _, s := range strings.Split(css[:open], ",") {
+			s = strings.TrimSpace(s)
+			match := svgSelectorPtn.FindStringSubmatch(s)
+			if s == "" || match == nil {
+				return nil, fmt.Errorf("svg: unsupported style sheet selector %q", s)
+			}
+
+			rule := svgRule{decls: decls}
+			if match[1] != "" && match[1] != "*" {
+				rule.selector.name = match[1]
+				rule.specificity++
+			}
+			for _, part := range svgSelectorPartPtn.FindAllString(match[2], -1) {
+				if part[0] == '#' {
+					rule.selector.id = part[1:]
+					rule.specificity += 10000
+				} else {
+					rule.selector.classes = append(rule.selector.classes, part[1:])
+					rule.specificity += 100
+				}
+			}
+			rules = append(rules, rule)
+		}
+		css = css[close+1:]
+	}
+}
+
+// svgDeclarations parses a list of CSS declarations, such as a style attribute.
+func svgDeclarations(s string) (decls []svgDecl) {
+	for _, decl := range strings.Split(s, ";") {
+		parts := strings.SplitN(decl, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		d := svgDecl{name: strings.TrimSpace(parts[0]), value: strings.TrimSpace(parts[1])}
+		if v := strings.TrimSuffix(d.value, "important"); v != d.value {
+			if v = strings.TrimSpace(v); strings.HasSuffix(v, "!") {
+				d.value, d.important = strings.TrimSpace(strings.TrimSuffix(v, "!")), true
+			}
+		}
+		decls = append(decls, d)
+	}
+	return decls
+}
+
+func (sel svgSelector) matches(n *svgNode) bool {
+	if sel.name != "" && sel.name != n.name {
+		return false
+	}
+	if sel.id != "" && sel.id != n.attrs["id"] {
+		return false
+	}
+	classes := strings.Fields(n.attrs["class"])
+	for _, want := range sel.classes {
+		found := false
+		for _, class := range classes {
+			found = found || class == want
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// svgProps returns the presentation attributes of n, overridden by the declarations of
+// the rules which match it, then by its style attribute, then by the rules' !important
+// declarations, as CSS cascades them.
+func svgProps(n *svgNode, rules []svgRule) map[string]string {
+	props := map[string]string{}
+	for _, name := range []string{"fill", "stroke", "stroke-width", "opacity", "fill-opacity",
+		"stroke-opacity", "fill-rule", "clip-path", "mask", "filter"} {
+		if v, ok := n.attrs[name]; ok {
+			props[name] = strings.TrimSpace(v)
+		}
+	}
+
+	var matched []svgRule
+	for _, rule := range rules {
+		if rule.selector.matches(n) {
+			matched = append(matched, rule)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].specificity < matched[j].specificity
+	})
+	for _, rule := range matched {
+		for _, decl := range rule.decls {
+			if !decl.important {
+				props[decl.name] = decl.value
+			}
+		}
+	}
+	for _, decl := range svgDeclarations(n.attrs["style"]) {
+		props[decl.name] = decl.value
+	}
+	for _, rule := range matched {
+		for _, decl := range rule.decls {
+			if decl.important {
+				props[decl.name] = decl.value
+			}
+		}
+	}
+	return props
+}
+
+// apply updates the state with the properties of an element, as returned by svgProps,
+// and its transform attribute.
+func (s *svgState) apply(props map[string]string, tf string) error {
+	for name, v := range props {
+		if v == "inherit" {
+			continue
+		}
+		switch name {
+		case "fill", "stroke":
+			col, none, err := svgColor(v)
+			if err != nil {
+				return err
+			}
+			if name == "fill" {
+				s.fill, s.fillNone = col, none
+			} else {
+				s.stroke, s.strokeNone = col, none
+			}
+		case "stroke-width":
+			s.strokeWidth = svgLength(v)
+		case "opacity":
+			s.opacity *= svgOpacity(v)
+		case "fill-opacity":
+			s.fillOp = svgOpacity(v)
+		case "stroke-opacity":
+			s.strokeOp = svgOpacity(v)
+		case "fill-rule":
+			s.evenOdd = v == "evenodd"
+		}
+	}
+
+	if tf != "" {
+		for _, match := range svgTransformPtn.FindAllStringSubmatch(tf, -1) {
+			args, err := svgNumbers(match[2])
+			if err != nil {
+				return fmt.Errorf("svg: invalid transform %q", tf)
+			}
+			arg := func(idx int, dflt float64) float64 {
+				if idx < len(args) {
+					return args[idx]
+				}
+				return dflt
+			}
+
+			var m svgMatrix
+			switch match[1] {
+			case "matrix":
+				if len(args) != 6 {
+					return fmt.Errorf("svg: invalid transform %q", tf)
+				}
+				copy(m[:], args)
+			case "translate":
+				m = svgMatrix{1, 0, 0, 1, arg(0, 0), arg(1, 0)}
+			case "scale":
+				m = svgMatrix{arg(0, 1), 0, 0, arg(1, arg(0, 1)), 0, 0}
+			case "rotate":
+				rad := arg(0, 0) * math.Pi / 180
+				sin, cos := math.Sincos(rad)
+				cx, cy := arg(1, 0), arg(2, 0)
+				m = svgMatrix{1, 0, 0, 1, cx, cy}.
+					mul(svgMatrix{cos, sin, -sin, cos, 0, 0}).
+					mul(svgMatrix{1, 0, 0, 1, -cx, -cy})
+			case "skewX":
+				m = svgMatrix{1, 0, math.Tan(arg(0, 0) * math.Pi / 180), 1, 0, 0}
+			case "skewY":
+				m = svgMatrix{1, math.Tan(arg(0, 0) * math.Pi / 180), 0, 1, 0, 0}
+			default:
+				return fmt.Errorf("svg: unknown transform %q", match[1])
+			}
+			s.transform = s.transform.mul(m)
+		}
+	}
+	return nil
+}
+
+// draw fills and strokes paths, which are in user space, onto dst.
+func (s *svgState) draw(dst *image.RGBA, raster *vector.Rasterizer, paths []svgSubpath) {
+	bounds := dst.Bounds()
+	for idx := range paths {
+		for pidx, pt := range paths[idx].points {
+			paths[idx].points[pidx] = s.transform.apply(pt)
+		}
+	}
+
+	paint := func(col color.NRGBA, opacity float64) *image.Uniform {
+		col.A = uint8(math.Round(float64(col.A) * opacity * s.opacity))
+		return image.NewUniform(col)
+	}
+
+	if !s.fillNone && s.evenOdd {
+		mask := svgEvenOdd(paths, bounds)
+		draw.DrawMask(dst, bounds, paint(s.fill, s.fillOp), image.Point{}, mask, image.Point{}, draw.Over)
+	} else if !s.fillNone {
+		raster.Reset(bounds.Dx(), bounds.Dy())
+		for _, sub := range paths {
+			if len(sub.points) < 2 {
+				continue
+			}
+			raster.MoveTo(float32(sub.points[0].x), float32(sub.points[0].y))
+			for _, pt := range sub.points[1:] {
+				raster.LineTo(float32(pt.x), float32(pt.y))
+			}
+			raster.ClosePath()
+		}
+		raster.Draw(dst, bounds, paint(s.fill, s.fillOp), image.Point{})
+	}
+
+	half := s.strokeWidth * s.transform.scale() / 2
+	if !s.strokeNone && half > 0 {
+		// Strokes are drawn as a quad for each segment with round joins and caps. Every
+		// shape is wound the same way so overlaps don't cancel each other out.
+		raster.Reset(bounds.Dx(), bounds.Dy())
+		for _, sub := range paths {
+			pts := sub.points
+			if sub.closed && len(pts) > 0 {
+				pts = append(pts, pts[0])
+			}
+			for idx, pt := range pts {
+				svgCircle(raster, pt, half)
+				if idx == 0 {
+					continue
+				}
+				prev := pts[idx-1]
+				dx, dy := pt.x-prev.x, pt.y-prev.y
+				ln := math.Hypot(dx, dy)
+				if ln == 0 {
+					continue
+				}
+				nx, ny := -dy/ln*half, dx/ln*half
+				raster.MoveTo(float32(prev.x+nx), float32(prev.y+ny))
+				raster.LineTo(float32(pt.x+nx), float32(pt.y+ny))
+				raster.LineTo(float32(pt.x-nx), float32(pt.y-ny))
+				raster.LineTo(float32(prev.x-nx), float32(prev.y-ny))
+				raster.ClosePath()
+			}
+		}
+		raster.Draw(dst, bounds, paint(s.stroke, s.strokeOp), image.Point{})
+	}
+}
+
+// svgEvenOddSamples is the number of rows sampled in each row of pixels when filling
+// with the even-odd rule.
+const svgEvenOddSamples = 16
+
+// svgEvenOdd returns the coverage of paths, which are in device space, filled with the
+// even-odd rule, which vector.Rasterizer doesn't support. Each subpath is closed.
+func svgEvenOdd(paths []svgSubpath, bounds image.Rectangle) *image.Alpha {
+	type edge struct{ x0, y0, x1, y1 float64 }
+	var edges []edge
+	for _, sub := range paths {
+		n := len(sub.points)
+		for idx, a := range sub.points {
+			if b := sub.points[(idx+1)%n]; a.y != b.y {
+				edges = append(edges, edge{a.x, a.y, b.x, b.y})
+			}
+		}
+	}
+
+	// Each sample row adds the coverage of the spans between alternate crossings:
+	mask := image.NewAlpha(bounds)
+	width := bounds.Dx()
+	cover := make([]float64, width+1)
+	span := func(x0, x1 float64) {
+		x0, x1 = math.Max(x0, 0), math.Min(x1, float64(width))
+		if x1 <= x0 {
+			return
+		}
+		const weight = 1.0 / svgEvenOddSamples
+		i0, i1 := int(x0), int(x1)
+		if i0 == i1 {
+			cover[i0] += (x1 - x0) * weight
+			return
+		}
+		cover[i0] += (float64(i0+1) - x0) * weight
+		for i := i0 + 1; i < i1; i++ {
+			cover[i] += weight
+		}
+		cover[i1] += (x1 - float64(i1)) * weight
+	}
+
+	var xs []float64
+	for y := 0; y < bounds.Dy(); y++ {
+		for i := range cover {
+			cover[i] = 0
+		}
+		for sample := 0; sample < svgEvenOddSamples; sample++ {
+			sy := float64(y) + (float64(sample)+0.5)/svgEvenOddSamples
+			xs = xs[:0]
+			for _, e := range edges {
+				if (sy >= e.y0) != (sy >= e.y1) {
+					xs = append(xs, e.x0+(sy-e.y0)*(e.x1-e.x0)/(e.y1-e.y0))
+				}
+			}
+			sort.Float64s(xs)
+			for i := 0; i+1 < len(xs); i += 2 {
+				span(xs[i], xs[i+1])
+			}
+		}
+		for x := 0; x < width; x++ {
+			mask.Pix[y*mask.Stride+x] = uint8(math.Round(math.Min(1, cover[x]) * 255))
+		}
+	}
+	return mask
+}
+
+// svgCircle adds a circle to raster, wound the same way as the stroke segment quads.
+func svgCircle(raster *vector.Rasterizer, c svgPoint, r float64) {
+	const steps = 16
+	raster.MoveTo(float32(c.x+r), float32(c.y))
+	for i := 1; i < steps; i++ {
+		sin, cos := math.Sincos(-2 * math.Pi * float64(i) / steps)
+		raster.LineTo(float32(c.x+r*cos), float32(c.y+r*sin))
+	}
+	raster.ClosePath()
+}
+
+// svgShape returns the flattened subpaths for a shape element, or nil if the element is
+// not a shape.
+func svgShape(name string, attrs map[string]string) ([]svgSubpath, error) {
+	num := func(name string) float64 { return svgLength(attrs[name]) }
+
+	ellipse := func(cx, cy, rx, ry float64) []svgSubpath {
+		const steps = 64
+		sub := svgSubpath{closed: true}
+		for i := 0; i < steps; i++ {
+			sin, cos := math.Sincos(-2 * math.Pi * float64(i) / steps)
+			sub.points = append(sub.points, svgPoint{cx + rx*cos, cy + ry*sin})
+		}
+		return []svgSubpath{sub}
+	}
+
+	switch name {
+	case "path":
+		return svgPathData(attrs["d"])
+
+	case "rect":
+		x, y, w, h := num("x"), num("y"), num("width"), num("height")
+		if w <= 0 || h <= 0 {
+			return nil, nil
+		}
+		return []svgSubpath{{
+			points: []svgPoint{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}},
+			closed: true,
+		}}, nil
+
+	case "circle":
+		if r := num("r"); r > 0 {
+			return ellipse(num("cx"), num("cy"), r, r), nil
+		}
+
+	case "ellipse":
+		if rx, ry := num("rx"), num("ry"); rx > 0 && ry > 0 {
+			return ellipse(num("cx"), num("cy"), rx, ry), nil
+		}
+
+	case "line":
+		return []svgSubpath{{
+			points: []svgPoint{{num("x1"), num("y1")}, {num("x2"), num("y2")}},
+		}}, nil
+
+	case "polyline", "polygon":
+		nums, err := svgNumbers(attrs["points"])
+		if err != nil {
+			return nil, err
+		}
+		sub := svgSubpath{closed: name == "polygon"}
+		for i := 0; i+1 < len(nums); i += 2 {
+			sub.points = append(sub.points, svgPoint{nums[i], nums[i+1]})
+		}
+		return []svgSubpath{sub}, nil
+	}
+	return nil, nil
+}
+
+// svgPathData parses and flattens the 'd' attribute of a path element.
+func svgPathData(d string) ([]svgSubpath, error) {
+	const curveSteps = 16
+
+	var out []svgSubpath
+	var cur, start, ctrl svgPoint
+	var sub svgSubpath
+	var cmd, lastCmd byte
+
+	flush := func() {
+		if len(sub.points) > 0 {
+			out = append(out, sub)
+		}
+		sub = svgSubpath{}
+	}
+	lineTo := func(pt svgPoint) {
+		if len(sub.points) == 0 {
+			sub.points = append(sub.points, cur)
+		}
+		sub.points = append(sub.points, pt)
+		cur = pt
+	}
+
+	sc := svgScanner{s: d}
+	for {
+		sc.skip()
+		if sc.done() {
+			break
+		}
+		if c := sc.s[sc.i]; (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			cmd = c
+			sc.i++
+		} else if cmd == 0 {
+			return nil, fmt.Errorf("svg: invalid path data %q", d)
+		}
+
+		rel := cmd >= 'a'
+		abs := func(x, y float64) svgPoint {
+			if rel {
+				return svgPoint{cur.x + x, cur.y + y}
+			}
+			return svgPoint{x, y}
+		}
+
+		upper := cmd &^ 0x20
+		var nums []float64
+		var arity int
+		switch upper {
+		case 'Z':
+			if len(sub.points) > 0 {
+				sub.closed = true
+			}
+			flush()
+			cur = start
+			lastCmd = 'Z'
+			continue
+		case 'M', 'L', 'T':
+			arity = 2
+		case 'H', 'V':
+			arity = 1
+		case 'C':
+			arity = 6
+		case 'S', 'Q':
+			arity = 4
+		case 'A':
+			arity = 7
+		default:
+			return nil, fmt.Errorf("svg: unknown path command %q", cmd)
+		}
+		for i := 0; i < arity; i++ {
+			var v float64
+			var ok bool
+			if upper == 'A' && (i == 3 || i == 4) {
+				v, ok = sc.flag()
+			} else {
+				v, ok = sc.number()
+			}
+			if !ok {
+				return nil, fmt.Errorf("svg: invalid path data %q", d)
+			}
+			nums = append(nums, v)
+		}
+
+		switch upper {
+		case 'M':
+			flush()
+			cur = abs(nums[0], nums[1])
+			start = cur
+			// Subsequent pairs are implicit line commands:
+			if rel {
+				cmd = 'l'
+			} else {
+				cmd = 'L'
+			}
+		case 'L':
+			lineTo(abs(nums[0], nums[1]))
+		case 'H':
+			if rel {
+				lineTo(svgPoint{cur.x + nums[0], cur.y})
+			} else {
+				lineTo(svgPoint{nums[0], cur.y})
+			}
+		case 'V':
+			if rel {
+				lineTo(svgPoint{cur.x, cur.y + nums[0]})
+			} else {
+				lineTo(svgPoint{cur.x, nums[0]})
+			}
+		case 'C', 'S':
+			var c1, c2, end svgPoint
+			if upper == 'C' {
+				c1, c2, end = abs(nums[0], nums[1]), abs(nums[2], nums[3]), abs(nums[4], nums[5])
+			} else {
+				c1 = cur
+				if l := lastCmd &^ 0x20; l == 'C' || l == 'S' {
+					c1 = svgPoint{2*cur.x - ctrl.x, 2*cur.y - ctrl.y}
+				}
+				c2, end = abs(nums[0], nums[1]), abs(nums[2], nums[3])
+			}
+			p0 := cur
+			for i := 1; i <= curveSteps; i++ {
+				t := float64(i) / curveSteps
+				mt := 1 - t
+				lineTo(svgPoint{
+					mt*mt*mt*p0.x + 3*mt*mt*t*c1.x + 3*mt*t*t*c2.x + t*t*t*end.x,
+					mt*mt*mt*p0.y + 3*mt*mt*t*c1.y + 3*mt*t*t*c2.y + t*t*t*end.y,
+				})
+			}
+			ctrl = c2
+		case 'Q', 'T':
+			var c, end svgPoint
+			if upper == 'Q' {
+				c, end = abs(nums[0], nums[1]), abs(nums[2], nums[3])
+			} else {
+				c = cur
+				if l := lastCmd &^ 0x20; l == 'Q' || l == 'T' {
+					c = svgPoint{2*cur.x - ctrl.x, 2*cur.y - ctrl.y}
+				}
+				end = abs(nums[0], nums[1])
+			}
+			p0 := cur
+			for i := 1; i <= curveSteps; i++ {
+				t := float64(i) / curveSteps
+				mt := 1 - t
+				lineTo(svgPoint{
+					mt*mt*p0.x + 2*mt*t*c.x + t*t*end.x,
+					mt*mt*p0.y + 2*mt*t*c.y + t*t*end.y,
+				})
+			}
+			ctrl = c
+		case 'A':
+			end := abs(nums[5], nums[6])
+			for _, pt := range svgArc(cur, end, nums[0], nums[1], nums[2], nums[3] != 0, nums[4] != 0) {
+				lineTo(pt)
+			}
+		}
+		lastCmd = cmd
+	}
+	flush()
+	return out, nil
+}
+
+// svgArc flattens an elliptical arc from p0 to p1, excluding p0, using the endpoint to
+// centre conversion from the SVG implementation notes.
+func svgArc(p0, p1 svgPoint, rx, ry, rotation float64, large, sweep bool) []svgPoint {
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || p0 == p1 {
+		return []svgPoint{p1}
+	}
+
+	sinPhi, cosPhi := math.Sincos(rotation * math.Pi / 180)
+	dx, dy := (p0.x-p1.x)/2, (p0.y-p1.y)/2
+	x1 := cosPhi*dx + sinPhi*dy
+	y1 := -sinPhi*dx + cosPhi*dy
+
+	if lambda := x1*x1/(rx*rx) + y1*y1/(ry*ry); lambda > 1 {
+		rx, ry = rx*math.Sqrt(lambda), ry*math.Sqrt(lambda)
+	}
+
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	coef := math.Sqrt(math.Max(0, num/den))
+	if large == sweep {
+		coef = -coef
+	}
+	cx1, cy1 := coef*rx*y1/ry, -coef*ry*x1/rx
+	cx := cosPhi*cx1 - sinPhi*cy1 + (p0.x+p1.x)/2
+	cy := sinPhi*cx1 + cosPhi*cy1 + (p0.y+p1.y)/2
+
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	theta := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	delta := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+
+	steps := int(math.Ceil(math.Abs(delta) / (math.Pi / 16)))
+	if steps < 1 {
+		steps = 1
+	}
+	out := make([]svgPoint, 0, steps)
+	for i := 1; i <= steps; i++ {
+		sin, cos := math.Sincos(theta + delta*float64(i)/float64(steps))
+		out = append(out, svgPoint{
+			cx + rx*cos*cosPhi - ry*sin*sinPhi,
+			cy + rx*cos*sinPhi + ry*sin*cosPhi,
+		})
+	}
+	out[len(out)-1] = p1
+	return out
+}
+
+// svgScanner reads numbers from SVG path data and number lists, which may be separated
+// by whitespace, commas, or nothing at all (e.g. '1-2.5.5').
+type svgScanner struct {
+	s string
+	i int
+}
+
+func (sc *svgScanner) done() bool { return sc.i >= len(sc.s) }
+
+func (sc *svgScanner) skip() {
+	for sc.i < len(sc.s) && strings.IndexByte(" \t\r\n,", sc.s[sc.i]) >= 0 {
+		sc.i++
+	}
+}
+
+func (sc *svgScanner) number() (float64, bool) {
+	sc.skip()
+	start := sc.i
+	if sc.i < len(sc.s) && (sc.s[sc.i] == '-' || sc.s[sc.i] == '+') {
+		sc.i++
+	}
+	dot, exp := false, false
+	for sc.i < len(sc.s) {
+		c := sc.s[sc.i]
+		if c >= '0' && c <= '9' {
+			sc.i++
+		} else if c == '.' && !dot && !exp {
+			dot = true
+			sc.i++
+		} else if (c == 'e' || c == 'E') && !exp && sc.i > start {
+			exp = true
+			sc.i++
+			if sc.i < len(sc.s) && (sc.s[sc.i] == '-' || sc.s[sc.i] == '+') {
+				sc.i++
+			}
+		} else {
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(sc.s[start:sc.i], 64)
+	return v, err == nil
+}
+
+// flag reads an arc flag, which may not be separated from the following number.
+func (sc *svgScanner) flag() (float64, bool) {
+	sc.skip()
+	if sc.done() || (sc.s[sc.i] != '0' && sc.s[sc.i] != '1') {
+		return 0, false
+	}
+	sc.i++
+	return float64(sc.s[sc.i-1] - '0'), true
+}
+
+func svgNumbers(s string) ([]float64, error) {
+	var out []float64
+	sc := svgScanner{s: s}
+	for sc.skip(); !sc.done(); sc.skip() {
+		v, ok := sc.number()
+		if !ok {
+			return nil, fmt.Errorf("svg: invalid number list %q", s)
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// svgLength parses a length in user units, or 0 if it is empty, a percentage or uses an
+// unsupported unit.
+func svgLength(s string) float64 {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "px")
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+func svgOpacity(s string) float64 {
+	s = strings.TrimSpace(s)
+	scale := 1.0
+	if strings.HasSuffix(s, "%") {
+		s, scale = strings.TrimSuffix(s, "%"), 100
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 1
+	}
+	return math.Max(0, math.Min(1, v/scale))
+}
+
+var svgRGBPtn = regexp.MustCompile(`^rgba?\(\s*([\d.]+%?)\s*[, ]\s*([\d.]+%?)\s*[, ]\s*([\d.]+%?)\s*(?:[,/]\s*([\d.]+%?)\s*)?\)$`)
+
+// svgColor parses a paint value. Gradients and other paint servers are drawn as black.
+func svgColor(s string) (col color.NRGBA, none bool, err error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "none" || s == "transparent":
+		return color.NRGBA{}, true, nil
+	case s == "currentColor" || strings.HasPrefix(s, "url("):
+		return color.NRGBA{0, 0, 0, 255}, false, nil
+	case strings.HasPrefix(s, "#"):
+		hex := s[1:]
+		if len(hex) == 3 || len(hex) == 4 {
+			var long strings.Builder
+			for _, c := range hex {
+				long.WriteRune(c)
+				long.WriteRune(c)
+			}
+			hex = long.String()
+		}
+		col, err := parseHexColor(hex)
+		return col, false, err
+	}
+
+	if m := svgRGBPtn.FindStringSubmatch(s); m != nil {
+		channel := func(v string, max float64) uint8 {
+			scale := max
+			if strings.HasSuffix(v, "%") {
+				v, scale = strings.TrimSuffix(v, "%"), 100
+			}
+			f, _ := strconv.ParseFloat(v, 64)
+			return uint8(math.Round(math.Max(0, math.Min(1, f/scale)) * 255))
+		}
+		col = color.NRGBA{channel(m[1], 255), channel(m[2], 255), channel(m[3], 255), 255}
+		if m[4] != "" {
+			col.A = channel(m[4], 1)
+		}
+		return col, false, nil
+	}
+
+	if named, ok := colornames.Map[strings.ToLower(s)]; ok {
+		return color.NRGBA{named.R, named.G, named.B, named.A}, false, nil
+	}
+	return color.NRGBA{}, false, fmt.Errorf("svg: unknown colour %q", s)
+}
@@ -0,0 +1,281 @@
+package bitmap
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// svgProbe is a pixel of a rasterized SVG, and whether it should be inked (dark) or
+// paper (white).
+type svgProbe struct {
+	x, y int
+	ink  bool
+}
+
+func decodeSVGTest(t *testing.T, doc string) (image.Image, []Warning) {
+	t.Helper()
+	var warnings Warnings
+	img, err := decodeSVG([]byte(doc), image.Point{}, &warnings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img, warnings.List()
+}
+
+func TestDecodeSVG(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		doc    string
+		size   image.Point
+		probes []svgProbe
+	}{
+		{
+			name: "rect",
+			doc:  `<svg xmlns="http://www.w3.org/2000/svg" width="16" height="16"><rect x="4" y="4" width="8" height="8"/></svg>`,
+			size: image.Pt(16, 16),
+			probes: []svgProbe{
+				{2, 2, false}, {8, 8, true}, {13, 13, false},
+			},
+		},
+		{
+			name: "viewbox-scaled",
+			doc:  `<svg viewBox="0 0 8 8" width="32"><circle cx="4" cy="4" r="2"/></svg>`,
+			size: image.Pt(32, 32),
+			probes: []svgProbe{
+				{16, 16, true}, {2, 2, false}, {16, 4, false},
+			},
+		},
+		{
+			// A stroked icon in the style of many icon sets, with no fill inherited
+			// from the root:
+			name: "stroked-icon",
+			doc: `<svg xmlns="http://www.w3.org/2000/svg" width="24" height="24" viewBox="0 0 24 24"
+				fill="none" stroke="currentColor" stroke-width="2">
+				<circle cx="12" cy="12" r="10"/>
+				<line x1="12" y1="8" x2="12" y2="16"/>
+			</svg>`,
+			size: image.Pt(24, 24),
+			probes: []svgProbe{
+				{12, 2, true}, {12, 12, true}, {7, 12, false}, {0, 0, false},
+			},
+		},
+		{
+			name: "transform-group",
+			doc: `<svg width="20" height="10">
+				<g transform="translate(10 0)"><rect width="10" height="10" fill="#000"/></g>
+			</svg>`,
+			size: image.Pt(20, 10),
+			probes: []svgProbe{
+				{5, 5, false}, {15, 5, true},
+			},
+		},
+		{
+			// A square with a square hole, drawn in the same direction, which only the
+			// even-odd rule leaves empty:
+			name: "evenodd-hole",
+			doc: `<svg width="20" height="20">
+				<path fill-rule="evenodd" d="M2 2H18V18H2Z M6 6H14V14H6Z"/>
+			</svg>`,
+			size: image.Pt(20, 20),
+			probes: []svgProbe{
+				{4, 4, true}, {10, 10, false}, {16, 16, true}, {0, 0, false},
+			},
+		},
+		{
+			name: "nonzero-hole-filled",
+			doc: `<svg width="20" height="20">
+				<path d="M2 2H18V18H2Z M6 6H14V14H6Z"/>
+			</svg>`,
+			size: image.Pt(20, 20),
+			probes: []svgProbe{
+				{4, 4, true}, {10, 10, true},
+			},
+		},
+		{
+			// A five pointed star drawn as a single self-intersecting polygon, whose
+			// centre is inside it twice:
+			name: "evenodd-star",
+			doc: `<svg width="100" height="100" style="fill-rule: evenodd">
+				<polygon points="50,5 79,95 2,40 98,40 21,95"/>
+			</svg>`,
+			size: image.Pt(100, 100),
+			probes: []svgProbe{
+				{50, 55, false}, {50, 20, true}, {15, 43, true}, {50, 98, false},
+			},
+		},
+		{
+			name: "use",
+			doc: `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="30" height="10">
+				<defs><rect id="box" width="10" height="10"/></defs>
+				<use href="#box"/>
+				<use xlink:href="#box" x="20"/>
+			</svg>`,
+			size: image.Pt(30, 10),
+			probes: []svgProbe{
+				{5, 5, true}, {15, 5, false}, {25, 5, true},
+			},
+		},
+		{
+			name: "use-before-definition",
+			doc: `<svg width="10" height="10">
+				<use href="#later" transform="translate(5 0)"/>
+				<defs><rect id="later" width="5" height="10"/></defs>
+			</svg>`,
+			size: image.Pt(10, 10),
+			probes: []svgProbe{
+				{2, 5, false}, {7, 5, true},
+			},
+		},
+		{
+			name: "use-symbol",
+			doc: `<svg width="40" height="20">
+				<symbol id="dot" viewBox="0 0 2 2"><rect width="1" height="1"/></symbol>
+				<use href="#dot" x="20" width="20" height="20"/>
+			</svg>`,
+			size: image.Pt(40, 20),
+			probes: []svgProbe{
+				{5, 5, false}, {25, 5, true}, {35, 15, false},
+			},
+		},
+		{
+			name: "style-sheet",
+			doc: `<svg width="40" height="10">
+				<style>
+					/* The rect rule overrides the second square's fill attribute. */
+					.ink { fill: black }
+					rect { fill: white }
+					rect.ink, #last { fill: #000 }
+				</style>
+				<rect class="ink" width="10" height="10"/>
+				<rect x="10" width="10" height="10" fill="black"/>
+				<circle class="paper ink" cx="25" cy="5" r="5"/>
+				<rect id="last" x="30" width="10" height="10"/>
+			</svg>`,
+			size: image.Pt(40, 10),
+			probes: []svgProbe{
+				{5, 5, true}, {15, 5, false}, {25, 5, true}, {35, 5, true},
+			},
+		},
+		{
+			// The style attribute overrides the style sheet, unless it is !important:
+			name: "style-sheet-cascade",
+			doc: `<svg width="20" height="10">
+				<defs><style><![CDATA[
+					.a { fill: white }
+					.b { fill: white !important }
+				]]></style></defs>
+				<rect class="a" width="10" height="10" style="fill: black"/>
+				<rect class="b" x="10" width="10" height="10" style="fill: black"/>
+			</svg>`,
+			size: image.Pt(20, 10),
+			probes: []svgProbe{
+				{5, 5, true}, {15, 5, false},
+			},
+		},
+		{
+			name: "use-inherits-fill",
+			doc: `<svg width="10" height="10">
+				<defs><rect id="box" width="10" height="10"/></defs>
+				<use href="#box" fill="none"/>
+			</svg>`,
+			size: image.Pt(10, 10),
+			probes: []svgProbe{
+				{5, 5, false},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			img, warnings := decodeSVGTest(t, tc.doc)
+			if len(warnings) > 0 {
+				t.Fatalf("unexpected warnings: %v", warnings)
+			}
+			if size := img.Bounds().Size(); size != tc.size {
+				t.Fatalf("expected size %v, found %v", tc.size, size)
+			}
+			for _, probe := range tc.probes {
+				gray := color.GrayModel.Convert(img.At(probe.x, probe.y)).(color.Gray).Y
+				if ink := gray < 128; ink != probe.ink {
+					t.Errorf("pixel %d,%d: expected ink %t, found grey %d", probe.x, probe.y, probe.ink, gray)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeSVGWarnings(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		doc  string
+		warn []string
+	}{
+		{
+			name: "text-once",
+			doc: `<svg width="10" height="10">
+				<text>one</text><g><text>two</text></g>
+			</svg>`,
+			warn: []string{"<text> elements are not supported"},
+		},
+		{
+			name: "missing-use",
+			doc:  `<svg width="10" height="10"><use href="#nope"/></svg>`,
+			warn: []string{`referring to "#nope"`},
+		},
+		{
+			name: "editor-metadata-ignored",
+			doc: `<svg xmlns="http://www.w3.org/2000/svg" xmlns:sodipodi="http://sodipodi.sourceforge.net/DTD/sodipodi-0.dtd" width="10" height="10">
+				<sodipodi:namedview pagecolor="#ffffff"/>
+			</svg>`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, warnings := decodeSVGTest(t, tc.doc)
+			if len(warnings) != len(tc.warn) {
+				t.Fatalf("expected %d warnings, found %v", len(tc.warn), warnings)
+			}
+			for idx, w := range warnings {
+				if w.Kind != WarnSVGUnsupported || !strings.Contains(w.Message, tc.warn[idx]) {
+					t.Errorf("expected a %s warning containing %q, found %v", WarnSVGUnsupported, tc.warn[idx], w)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeSVGErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{"not-svg", `<html></html>`, `root element is "html"`},
+		{"no-size", `<svg><rect width="1" height="1"/></svg>`, "missing width, height or viewBox"},
+		{"bad-viewbox", `<svg viewBox="0 0 0 1"/>`, "invalid viewBox"},
+		{"bad-path", `<svg width="1" height="1"><path d="M 0 0 X"/></svg>`, "unknown path command"},
+		{"use-cycle", `<svg width="1" height="1"><g id="a"><use href="#a"/></g></svg>`, "refer to each other"},
+		{
+			"gradient",
+			`<svg width="1" height="1"><linearGradient id="g"/><rect width="1" height="1" style="fill: url(#g)"/></svg>`,
+			"gradients and patterns are not supported",
+		},
+		{
+			"gradient-from-style-sheet",
+			`<svg width="1" height="1"><style>.a { stroke: url(#g) }</style><rect class="a" width="1" height="1"/></svg>`,
+			"gradients and patterns are not supported",
+		},
+		{"clip-path", `<svg width="1" height="1"><g clip-path="url(#c)"/></svg>`, "clip-paths are not supported"},
+		{"mask", `<svg width="1" height="1"><rect mask="url(#m)" width="1" height="1"/></svg>`, "masks are not supported"},
+		{"filter", `<svg width="1" height="1"><rect style="filter: url(#f)" width="1" height="1"/></svg>`, "filters are not supported"},
+		{"selector-combinator", `<svg width="1" height="1"><style>g .a { fill: red }</style></svg>`, `unsupported style sheet selector "g .a"`},
+		{"selector-pseudo-class", `<svg width="1" height="1"><style>a:hover { fill: red }</style></svg>`, "unsupported style sheet selector"},
+		{"at-rule", `<svg width="1" height="1"><style>@media print { .a { fill: red } }</style></svg>`, `unsupported style sheet rule "@media"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := decodeSVG([]byte(tc.doc), image.Point{}, nil)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, found %v", tc.err, err)
+			}
+		})
+	}
+}
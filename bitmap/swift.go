@@ -0,0 +1,37 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// renderSwift renders the image as top level Swift declarations: the values as
+// 'let <var>: [UInt8] = [...]', plus '<var>_width' and '<var>_height' constants and, if
+// gen.Rev is set, '<var>_rev' as a UInt32. For Embedded Swift and playgrounds.
+func renderSwift(renderCtx *renderContext, out *bytes.Buffer) error {
+	name := renderCtx.gen.VarName
+	writeValuesHeader(renderCtx, out)
+
+	renderCtx.eachLayoutConstant(func(suffix string, v int) {
+		out.WriteString(fmt.Sprintf("let %s_%s = %d\n", name, suffix, v))
+	})
+	if renderCtx.gen.Rev {
+		out.WriteString(fmt.Sprintf("let %s_rev: UInt32 = 0x%08x\n", name, renderCtx.checksum()))
+	}
+	out.WriteByte('\n')
+
+	out.WriteString(fmt.Sprintf("let %s: [UInt8] = [\n", name))
+	renderCtx.eachRow(func(row []uint8) {
+		for _, line := range renderCtx.wrapRow(row) {
+			out.WriteString("    ")
+			for _, px := range line {
+				out.WriteString(strconv.Itoa(int(renderCtx.paletteIndexToValue[px])))
+				out.WriteByte(',')
+			}
+			out.WriteByte('\n')
+		}
+	})
+	out.WriteString("]\n")
+	return nil
+}
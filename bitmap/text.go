@@ -0,0 +1,48 @@
+package bitmap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// renderHex renders the image's bytes as a single double quoted string of lower case
+// hex digits, which is a valid JSON, JS or C string literal. If gen.PackBits is set, the
+// packed bytes are encoded instead, as described by packRows. Nothing else is emitted,
+// so the size and layout must be known by the reader.
+func renderHex(renderCtx *renderContext, out *bytes.Buffer) error {
+	data, err := renderCtx.textBytes("hex")
+	if err != nil {
+		return err
+	}
+	out.WriteByte('"')
+	out.WriteString(hex.EncodeToString(data))
+	out.WriteString("\"\n")
+	return nil
+}
+
+// renderBase64 renders the image's bytes like renderHex, but as a padded standard
+// base64 string, which is a third shorter.
+func renderBase64(renderCtx *renderContext, out *bytes.Buffer) error {
+	data, err := renderCtx.textBytes("base64")
+	if err != nil {
+		return err
+	}
+	out.WriteByte('"')
+	out.WriteString(base64.StdEncoding.EncodeToString(data))
+	out.WriteString("\"\n")
+	return nil
+}
+
+// textBytes returns the rows from byteRows joined together, for the string renderers.
+func (rc *renderContext) textBytes(renderer string) ([]byte, error) {
+	if rc.gen.Unpack {
+		return nil, fmt.Errorf("-unpack is not supported by the %s renderer", renderer)
+	}
+	rows, _, err := rc.byteRows()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Join(rows, nil), nil
+}
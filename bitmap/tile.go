@@ -0,0 +1,83 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/shabbyrobe/wu2quant"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// tilePaletteArea is the maximum number of pixels in the reduced copy of the image
+// that tiledPaletted computes the palette from.
+const tilePaletteArea = 512 * 512
+
+// tiledPaletted rescales and quantizes img a band of g.TileRows output rows at a time,
+// so only one band of the rescaled image is held in full colour at once, rather than
+// the whole rescaled image plus the quantizer's copy of it. Memory use isn't bounded:
+// img, which has already been decoded (and adjusted) in full, and the quantized output,
+// at one byte per pixel, are still held whole.
+//
+// The palette is computed first from a reduced copy of the image, then each band is
+// rescaled with an affine transform of the whole image (so bands join without seams)
+// and mapped to the nearest palette colour. The result may differ slightly from the
+// untiled pipeline, which quantizes every pixel at full size.
+func (g *Generator) tiledPaletted(img image.Image) (*image.Paletted, error) {
+	switch {
+	case g.Linear:
+		return nil, fmt.Errorf("tiling does not support linear rescaling")
+	case g.NoQuantize, g.FixedPalette != "", g.PaletteFrom != "", len(g.SharedPalette) > 0:
+		return nil, fmt.Errorf("tiling only supports adaptive quantization")
+	}
+
+	scaler, ok := findScaler(g.Scaler).(draw.Transformer)
+	if !ok {
+		return nil, fmt.Errorf("scaler %q does not support tiling", g.Scaler)
+	}
+
+	bounds := img.Bounds()
+	size, _ := g.rescaleSize(bounds.Size())
+
+	// Palette pass:
+	reduced := size
+	if area := reduced.X * reduced.Y; area > tilePaletteArea {
+		ratio := math.Sqrt(float64(tilePaletteArea) / float64(area))
+		reduced = image.Pt(int(math.Max(1, float64(size.X)*ratio)), int(math.Max(1, float64(size.Y)*ratio)))
+	}
+	sample := image.NewRGBA(image.Rectangle{Max: reduced})
+	draw.ApproxBiLinear.Scale(sample, sample.Bounds(), img, bounds, draw.Over, nil)
+	if g.AlphaWeight {
+		sample = alphaWeightedSample(sample)
+	}
+	quantized, err := wu2quant.New().ToPaletted(g.Palette.Size, sample, nil)
+	if err != nil {
+		return nil, err
+	}
+	var palette color.Palette
+	for _, idx := range uniquePaletteIndexes(quantized) {
+		palette = append(palette, quantized.Palette[idx])
+	}
+	lut := newPaletteLUT(palette)
+
+	// Mapping pass:
+	s2d := f64.Aff3{
+		float64(size.X) / float64(bounds.Dx()), 0, -float64(bounds.Min.X) * float64(size.X) / float64(bounds.Dx()),
+		0, float64(size.Y) / float64(bounds.Dy()), -float64(bounds.Min.Y) * float64(size.Y) / float64(bounds.Dy()),
+	}
+	out := image.NewPaletted(image.Rectangle{Max: size}, palette)
+	for y0 := 0; y0 < size.Y; y0 += g.TileRows {
+		y1 := y0 + g.TileRows
+		if y1 > size.Y {
+			y1 = size.Y
+		}
+		band := image.NewRGBA(image.Rect(0, y0, size.X, y1))
+		scaler.Transform(band, s2d, img, bounds, draw.Over, nil)
+		for y := y0; y < y1; y++ {
+			lut.mapRow(out.Pix[y*out.Stride:y*out.Stride+size.X], band.Pix[band.PixOffset(0, y):])
+		}
+	}
+	return out, nil
+}
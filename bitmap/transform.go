@@ -0,0 +1,108 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// transform rotates img clockwise by rotate degrees (0, 90, 180 or 270), then flips it
+// horizontally ("h") or vertically ("v") if flip is set. The result has its origin at
+// 0,0. If no transform is required, img is returned unchanged.
+func transform(img image.Image, rotate int, flip string) (image.Image, error) {
+	rotate = ((rotate % 360) + 360) % 360
+	if rotate%90 != 0 {
+		return nil, fmt.Errorf("rotation must be a multiple of 90, found %d", rotate)
+	}
+	if flip != "" && flip != "h" && flip != "v" {
+		return nil, fmt.Errorf("flip must be 'h' or 'v', found %q", flip)
+	}
+	if rotate == 0 && flip == "" {
+		return img, nil
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	ow, oh := w, h
+	if rotate == 90 || rotate == 270 {
+		ow, oh = h, w
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, ow, oh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch rotate {
+			case 0:
+				dx, dy = x, y
+			case 90:
+				dx, dy = h-1-y, x
+			case 180:
+				dx, dy = w-1-x, h-1-y
+			case 270:
+				dx, dy = y, w-1-x
+			}
+			switch flip {
+			case "h":
+				dx = ow - 1 - dx
+			case "v":
+				dy = oh - 1 - dy
+			}
+			out.SetNRGBA(dx, dy, color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA))
+		}
+	}
+	return out, nil
+}
+
+// rotateStored rotates img 90 degrees clockwise for -store-rotated, preserving its
+// palette. Logical pixel x,y is stored at column h-1-y of row x, where h is the logical
+// height.
+func rotateStored(img *image.Paletted) *image.Paletted {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	out := image.NewPaletted(image.Rect(0, 0, h, w), img.Palette)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			out.SetColorIndex(h-1-y, x, img.ColorIndexAt(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return out
+}
+
+// transposeStored transposes img for column-major storage, preserving its palette.
+// Logical pixel x,y is stored at column y of row x.
+func transposeStored(img *image.Paletted) *image.Paletted {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	out := image.NewPaletted(image.Rect(0, 0, h, w), img.Palette)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			out.SetColorIndex(y, x, img.ColorIndexAt(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return out
+}
+
+// verticalBytes packs img into the page layout used by SSD1306 and similar displays:
+// each byte holds a column of 8 pixels, least significant bit at the top, and each row
+// of bytes is a page of 8 pixel rows. A bit is set if the pixel's value is 1, and every
+// value must be 0 or 1.
+//
+// The result is an image of byte values, which should be emitted with the SDF palette.
+func verticalBytes(img *image.Paletted, paletteIndexToValue [256]uint8) (*image.Paletted, error) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	out := image.NewPaletted(image.Rect(0, 0, w, (h+7)/8), grayPalette())
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			switch paletteIndexToValue[img.ColorIndexAt(bounds.Min.X+x, bounds.Min.Y+y)] {
+			case 0:
+			case 1:
+				out.Pix[out.PixOffset(x, y/8)] |= 1 << (y % 8)
+			default:
+				return nil, fmt.Errorf("vertical-bytes layout requires every value to be 0 or 1, i.e. a 2 character palette")
+			}
+		}
+	}
+	return out, nil
+}
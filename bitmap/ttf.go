@@ -0,0 +1,155 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// isFontFile reports whether bts appears to be a TrueType or OpenType font, based on
+// its magic bytes.
+func isFontFile(bts []byte) bool {
+	if len(bts) < 4 {
+		return false
+	}
+	switch string(bts[:4]) {
+	case "\x00\x01\x00\x00", "true", "OTTO", "ttcf":
+		return true
+	}
+	return false
+}
+
+// parseFont parses the TrueType or OpenType font in bts, which may have TrueType or CFF
+// outlines. The first font of a font collection is used.
+func parseFont(bts []byte) (*sfnt.Font, error) {
+	fonts, err := sfnt.ParseCollection(bts)
+	if err != nil {
+		return nil, err
+	}
+	return fonts.Font(0)
+}
+
+// drawSegments adds the outline segs to raster, offset by origin.
+func drawSegments(raster *vector.Rasterizer, segs sfnt.Segments, origin [2]float32) {
+	pt := func(p fixed.Point26_6) (float32, float32) {
+		return origin[0] + float32(p.X)/64, origin[1] + float32(p.Y)/64
+	}
+	for idx, seg := range segs {
+		switch seg.Op {
+		case sfnt.SegmentOpMoveTo:
+			if idx > 0 {
+				raster.ClosePath()
+			}
+			raster.MoveTo(pt(seg.Args[0]))
+		case sfnt.SegmentOpLineTo:
+			raster.LineTo(pt(seg.Args[0]))
+		case sfnt.SegmentOpQuadTo:
+			cx, cy := pt(seg.Args[0])
+			px, py := pt(seg.Args[1])
+			raster.QuadTo(cx, cy, px, py)
+		case sfnt.SegmentOpCubeTo:
+			c1x, c1y := pt(seg.Args[0])
+			c2x, c2y := pt(seg.Args[1])
+			px, py := pt(seg.Args[2])
+			raster.CubeTo(c1x, c1y, c2x, c2y, px, py)
+		}
+	}
+	if len(segs) > 0 {
+		raster.ClosePath()
+	}
+}
+
+// rasterize renders the chars from fs.first to fs.first+fs.count-1 of the font in bts at
+// fs.px pixels per em, into a strip of glyphs drawn in white on black, and returns the
+// strip, each glyph and the font's ascent in pixels.
+//
+// Each glyph is the height of a line, with the baseline at the ascent, and spans its
+// advance and any ink which overhangs it. Where it starts relative to the pen position
+// is the glyph's offset. Chars the font doesn't have are drawn with its missing glyph,
+// and a warning is added to warnings.
+func (fs *fontStrip) rasterize(bts []byte, warnings *Warnings) (image.Image, []fontGlyph, int, error) {
+	f, err := parseFont(bts)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	count := fs.count
+	if count == 0 {
+		count = 127 - fs.first
+	}
+	if count <= 0 || fs.first < 0 || fs.first+count-1 > 255 {
+		return nil, nil, 0, fmt.Errorf("chars %d to %d are outside 0 to 255", fs.first, fs.first+count-1)
+	}
+
+	var buf sfnt.Buffer
+	ppem := fixed.Int26_6(math.Round(fs.px * 64))
+	metrics, err := f.Metrics(&buf, ppem, font.HintingNone)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	ascent := metrics.Ascent.Ceil()
+	height := ascent + metrics.Descent.Ceil()
+	if height <= 0 {
+		return nil, nil, 0, fmt.Errorf("font has no height at %gpx", fs.px)
+	}
+
+	var glyphs []fontGlyph
+	var indexes []sfnt.GlyphIndex
+	x := 0
+	for code := fs.first; code < fs.first+count; code++ {
+		g, err := f.GlyphIndex(&buf, rune(code))
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if g == 0 {
+			warnings.Add(WarnMissingGlyph, "font has no glyph for char %d (%q)", code, rune(code))
+		}
+		bounds, adv, err := f.GlyphBounds(&buf, g, ppem, font.HintingNone)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		advance := adv.Round()
+		left, right := 0, advance
+		if ink := bounds.Min.X.Floor(); ink < left {
+			left = ink
+		}
+		if ink := bounds.Max.X.Ceil(); ink > right {
+			right = ink
+		}
+		if right <= left {
+			right = left + 1
+		}
+		glyphs = append(glyphs, fontGlyph{
+			code:    code,
+			rect:    image.Rect(x, 0, x+right-left, height),
+			advance: advance,
+			offset:  left,
+		})
+		indexes = append(indexes, g)
+		x += right - left
+	}
+
+	// Each glyph is rasterized separately, so ink outside its rectangle, such as tall
+	// accents, is cropped rather than drawn over its neighbours:
+	strip := image.NewNRGBA(image.Rect(0, 0, x, height))
+	draw.Draw(strip, strip.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	var raster vector.Rasterizer
+	for idx, glyph := range glyphs {
+		raster.Reset(glyph.rect.Dx(), height)
+		raster.DrawOp = draw.Over
+		segs, err := f.LoadGlyph(&buf, indexes[idx], ppem, nil)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		drawSegments(&raster, segs, [2]float32{float32(-glyph.offset), float32(ascent)})
+		raster.Draw(strip, glyph.rect, image.White, image.Point{})
+	}
+	return strip, glyphs, ascent, nil
+}
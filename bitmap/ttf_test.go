@@ -0,0 +1,117 @@
+package bitmap
+
+import (
+	"encoding/binary"
+	"image"
+	"os"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// fontCollection returns a font collection holding only the font in ttf.
+func fontCollection(ttf []byte) []byte {
+	const header = 16
+	out := make([]byte, header+len(ttf))
+	copy(out, "ttcf")
+	binary.BigEndian.PutUint32(out[4:], 0x00010000)
+	binary.BigEndian.PutUint32(out[8:], 1)
+	binary.BigEndian.PutUint32(out[12:], header)
+
+	// Table offsets are from the start of the file, so each moves by the header:
+	font := out[header:]
+	copy(font, ttf)
+	for idx := 0; idx < int(binary.BigEndian.Uint16(font[4:])); idx++ {
+		rec := font[12+16*idx:]
+		binary.BigEndian.PutUint32(rec[8:], binary.BigEndian.Uint32(rec[8:])+header)
+	}
+	return out
+}
+
+// inked reports whether any pixel of img within r is brighter than mid grey.
+func inked(img image.Image, r image.Rectangle) bool {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if v, _, _, _ := img.At(x, y).RGBA(); v > 0x8000 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestFontRasterize(t *testing.T) {
+	// CFFTest.otf is from golang.org/x/image/font/testdata, and has CFF outlines for
+	// the digits '0' and '1'.
+	cff, err := os.ReadFile("testdata/CFFTest.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		bts  []byte
+	}{
+		{"truetype", goregular.TTF},
+		{"cff", cff},
+		{"collection", fontCollection(goregular.TTF)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if !isFontFile(tc.bts) {
+				t.Fatal("expected a font file")
+			}
+			var warnings Warnings
+			fs := &fontStrip{first: '0', count: 2, px: 20}
+			strip, glyphs, ascent, err := fs.rasterize(tc.bts, &warnings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ws := warnings.List(); len(ws) > 0 {
+				t.Fatalf("unexpected warnings: %v", ws)
+			}
+			if ascent <= 0 || ascent > strip.Bounds().Dy() {
+				t.Fatalf("ascent %d outside the strip's height %d", ascent, strip.Bounds().Dy())
+			}
+			if len(glyphs) != 2 {
+				t.Fatalf("expected 2 glyphs, found %d", len(glyphs))
+			}
+			for idx, glyph := range glyphs {
+				if glyph.code != '0'+idx {
+					t.Fatalf("glyph %d: expected code %d, found %d", idx, '0'+idx, glyph.code)
+				}
+				if glyph.advance <= 0 || glyph.advance > glyph.rect.Dx() {
+					t.Fatalf("glyph %d: advance %d outside 1 to %d", idx, glyph.advance, glyph.rect.Dx())
+				}
+				if !inked(strip, glyph.rect) {
+					t.Fatalf("glyph %d: expected ink in %v", idx, glyph.rect)
+				}
+			}
+
+			// The counter of the '0' is empty, above any slash through it:
+			zero := glyphs[0].rect
+			counter := image.Pt((zero.Min.X+zero.Max.X)/2, ascent-int(fs.px*0.55))
+			if inked(strip, image.Rectangle{Min: counter, Max: counter.Add(image.Pt(1, 1))}) {
+				t.Fatalf("expected the counter of '0' at %v to be empty", counter)
+			}
+		})
+	}
+}
+
+func TestFontRasterizeMissingGlyph(t *testing.T) {
+	var warnings Warnings
+	fs := &fontStrip{first: 1, count: 1, px: 12}
+	if _, _, _, err := fs.rasterize(goregular.TTF, &warnings); err != nil {
+		t.Fatal(err)
+	}
+	ws := warnings.List()
+	if len(ws) != 1 || ws[0].Kind != WarnMissingGlyph {
+		t.Fatalf("expected a %s warning, found %v", WarnMissingGlyph, ws)
+	}
+}
+
+func TestFontRasterizeInvalid(t *testing.T) {
+	fs := &fontStrip{first: '0', count: 1, px: 12}
+	if _, _, _, err := fs.rasterize(goregular.TTF[:100], nil); err == nil {
+		t.Fatal("expected an error for a truncated font")
+	}
+}
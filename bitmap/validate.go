@@ -0,0 +1,203 @@
+package bitmap
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const validateUsage = "validate [options] <manifest.json|map.json|bmp2cpp.json|bmp2cpp.toml>..."
+
+// runValidate checks manifests, image maps and config files without building or
+// writing anything, so a change to a tree of assets can be checked quickly in CI. Every
+// input and referenced file must exist and decode, every area must fit its image, and
+// every generator's options must be usable together. All problems in every file are
+// reported, rather than just the first.
+func runValidate(args []string) error {
+	var sizeRaw string
+	var strictWarnings bool
+	var gen Generator
+
+	flags := flag.NewFlagSet("validate", 0)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s\n", validateUsage)
+		flags.PrintDefaults()
+	}
+	registerGeneratorFlags(flags, &gen, &sizeRaw)
+	registerLogFlags(flags)
+	flags.BoolVar(&strictWarnings, "strict-warnings", false, "Treat warnings, such as overlapping areas, as problems.")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := parseSize(sizeRaw, &gen); err != nil {
+		return &usageError{err: err}
+	}
+	if err := checkFlagChoices(&gen); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return usageErrorf("missing <file> arg")
+	}
+
+	var problems []string
+	for _, path := range flags.Args() {
+		for _, err := range validateFile(path, &gen, strictWarnings) {
+			problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s) found:\n%s", len(problems), strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// validateFile checks the manifest, image map or config file at path, using defaults
+// as the starting point for every generator. Files named bmp2cpp.json or bmp2cpp.toml,
+// or with a 'files' list, are config files, and files with a 'jobs' list are manifests.
+func validateFile(path string, defaults *Generator, strictWarnings bool) []error {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return []error{err}
+	}
+	if bts, err = mapJSON(path, bts); err != nil {
+		return []error{err}
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(bts, &keys); err != nil {
+		return []error{err}
+	}
+
+	switch {
+	case keys["jobs"] != nil:
+		jobs, err := loadManifest(path, defaults)
+		if err != nil {
+			return []error{err}
+		}
+		var errs []error
+		for idx, job := range jobs {
+			job.strictWarnings = strictWarnings
+			if err := job.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("job %d (%s): %w", idx, job.outFile, err))
+			}
+		}
+		return errs
+
+	case isConfigFileName(path), keys["files"] != nil:
+		return validateConfig(path, defaults)
+
+	default:
+		job := &convertJob{
+			gen:            *defaults,
+			mapFile:        path,
+			strictWarnings: strictWarnings,
+			warnPrefix:     path,
+		}
+		if err := job.validateMap(); err != nil {
+			return []error{err}
+		}
+		return nil
+	}
+}
+
+const mapValidateUsage = "map validate [options] <map.json> [<input>]"
+
+// runMapValidate checks an image map without building anything, reporting the field
+// of the first problem found. See validateMap.
+func runMapValidate(args []string) error {
+	o := newCLIOptions()
+	flags := newCommandFlags("map validate", mapValidateUsage)
+	o.registerGenFlags(flags)
+	flags.BoolVar(&o.strictWarnings, "strict-warnings", false, "Treat warnings, such as overlapping areas, as problems.")
+	if err := o.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return usageErrorf("expected <map.json> [<input>] args")
+	}
+	o.mapFile = flags.Arg(0)
+	job := o.job(flags.Args()[1:])
+	job.warnPrefix = o.mapFile
+	return job.validateMap()
+}
+
+// validateMap checks the image map job.mapFile. If the job has an input, or the map has
+// a source, the job is validated as a whole. Otherwise only what can be checked without
+// an image is: the map must decode, the grid's names file must exist, and every listed
+// area and grid cell must have options which can be used together.
+func (job *convertJob) validateMap() error {
+	gen := job.gen
+	imap, bts, err := loadImageMap(job.mapFile, &gen)
+	if err != nil {
+		return err
+	}
+	if len(job.args) > 0 || imap.Source != "" {
+		return job.validate()
+	}
+
+	if _, err := imap.expandGrid(filepath.Dir(job.mapFile)); err != nil {
+		return fileMapError(job.mapFile, bts, mapFieldError("grid", err))
+	}
+	for _, area := range imap.Areas {
+		if err := area.Gen.checkOptions(); err != nil {
+			return fileMapError(job.mapFile, bts, fmt.Errorf("%s: %w", area.Gen.VarName, err))
+		}
+	}
+	return nil
+}
+
+// validateConfig checks the options of the config file at path, applied to defaults,
+// and of each of its file overrides applied on top of those.
+func validateConfig(path string, defaults *Generator) []error {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return []error{err}
+	}
+	gen := *defaults
+	if err := cfg.Apply(&gen, ""); err != nil {
+		return []error{err}
+	}
+	if err := gen.checkOptions(); err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	for idx, file := range cfg.Files {
+		fileGen := gen
+		if err := decodeGenerator(file.Gen, &fileGen); err != nil {
+			errs = append(errs, fmt.Errorf("file %d (%s): %w", idx, file.Match, err))
+		} else if err := fileGen.checkOptions(); err != nil {
+			errs = append(errs, fmt.Errorf("file %d (%s): %w", idx, file.Match, err))
+		}
+	}
+	return errs
+}
+
+// validate loads the job's inputs and areas, as build does, then checks that every
+// other file they refer to exists and that every area's options can be used together,
+// without building anything.
+func (job *convertJob) validate() error {
+	warnings := &Warnings{}
+	build, tasks, err := job.loadTasks(warnings)
+	if err != nil {
+		return err
+	}
+	for _, path := range build.files {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+	for _, task := range tasks {
+		if err := task.gen.checkOptions(); err != nil {
+			return fmt.Errorf("%s: %w", task.gen.VarName, err)
+		}
+	}
+
+	warnings.Report(os.Stderr, job.warnPrefix)
+	if n := len(warnings.List()); n > 0 && job.strictWarnings {
+		return fmt.Errorf("%d warning(s) found, failing due to -strict-warnings", n)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// varNamePlaceholders lists the placeholders of Generator.VarNameTemplate.
+var varNamePlaceholders = []string{"{name}", "{index}", "{file}", "{w}", "{h}"}
+
+var varNamePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// checkVarNameTemplate returns an error if g.VarNameTemplate contains an unknown
+// placeholder.
+func (g *Generator) checkVarNameTemplate() error {
+	for _, found := range varNamePlaceholder.FindAllString(g.VarNameTemplate, -1) {
+		known := false
+		for _, placeholder := range varNamePlaceholders {
+			known = known || found == placeholder
+		}
+		if !known {
+			return fmt.Errorf("unknown placeholder %s in -var-template, expected one of %s",
+				found, strings.Join(varNamePlaceholders, ", "))
+		}
+	}
+	return nil
+}
+
+// expandVarName returns g with VarName set from g.VarNameTemplate, if there is one, for
+// the area with the given index of the image read from input, or g if there is not.
+// srcSize is the size of the area, which '{w}' and '{h}' are replaced with after it is
+// rotated, fitted to the target size, padded and placed on the canvas.
+func (g *Generator) expandVarName(idx int, input string, srcSize image.Point) *Generator {
+	if g.VarNameTemplate == "" {
+		return g
+	}
+	size := g.outputSize(srcSize)
+	out := g.Clone()
+	out.VarName = strings.NewReplacer(
+		"{name}", g.VarName,
+		"{index}", strconv.Itoa(idx),
+		"{file}", fileVarName(input),
+		"{w}", strconv.Itoa(size.X),
+		"{h}", strconv.Itoa(size.Y),
+	).Replace(g.VarNameTemplate)
+	return out
+}
+
+// outputSize returns the size of the image built from a source of srcSize. Invalid
+// options are ignored; Build reports them.
+func (g *Generator) outputSize(srcSize image.Point) image.Point {
+	size := srcSize
+	if g.Rotate == 90 || g.Rotate == 270 {
+		size = image.Pt(size.Y, size.X)
+	}
+	_, _, size, _ = g.fitSizes(size)
+
+	pad, _ := parsePad(g.Pad)
+	size = size.Add(image.Pt(pad.left+pad.right, pad.top+pad.bottom))
+	if canvas, _ := parseCanvas(g.Canvas); canvas != (image.Point{}) {
+		size = canvas
+	}
+	return size
+}
+
+// fileVarName returns the name of the file at path without its extension, with every
+// char which isn't valid in an identifier replaced with '_'.
+func fileVarName(path string) string {
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,92 @@
+package bitmap
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+type WarningKind string
+
+const (
+	// The image used fewer colours than the palette has characters.
+	WarnPaletteUnderuse WarningKind = "palette-underuse"
+
+	// A palette value plus the offset does not fit in a uint8 and will wrap.
+	WarnOffsetOverflow WarningKind = "offset-overflow"
+
+	// The image was downscaled by more than lossyDownscaleRatio in either dimension.
+	WarnLossyDownscale WarningKind = "lossy-downscale"
+
+	// Two or more areas in an image map have the same rectangle.
+	WarnDuplicateArea WarningKind = "duplicate-area"
+
+	// Two or more outputs have the same variable name, so their identifiers collide.
+	WarnDuplicateVarName WarningKind = "duplicate-var"
+
+	// The padded image was larger than the canvas, so it was cropped.
+	WarnCanvasCrop WarningKind = "canvas-crop"
+
+	// A font being rasterized has no glyph for a char, so its missing glyph was used.
+	WarnMissingGlyph WarningKind = "missing-glyph"
+
+	// An indexed Aseprite sprite uses more colours than the palette has characters, so
+	// it was quantized and lost its authored palette order.
+	WarnAuthoredPalette WarningKind = "authored-palette"
+
+	// An SVG input uses elements or properties which aren't supported, so parts of it
+	// are missing or drawn differently.
+	WarnSVGUnsupported WarningKind = "svg-unsupported"
+)
+
+// Downscaling by more than this ratio produces a WarnLossyDownscale warning.
+const lossyDownscaleRatio = 4
+
+type Warning struct {
+	Kind    WarningKind
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("warning [%s]: %s", w.Kind, w.Message)
+}
+
+// Warnings collects warnings from a conversion. It is safe for concurrent use. A nil
+// *Warnings discards all warnings.
+type Warnings struct {
+	mu   sync.Mutex
+	list []Warning
+}
+
+func (ws *Warnings) Add(kind WarningKind, format string, args ...interface{}) {
+	if ws == nil {
+		return
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.list = append(ws.list, Warning{Kind: kind, Message: fmt.Sprintf(format, args...)})
+}
+
+func (ws *Warnings) List() []Warning {
+	if ws == nil {
+		return nil
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return append([]Warning(nil), ws.list...)
+}
+
+// Report writes each warning to w, prefixed with prefix if it is not empty, unless
+// -quiet is set.
+func (ws *Warnings) Report(w io.Writer, prefix string) {
+	if verbosity < 0 {
+		return
+	}
+	for _, warning := range ws.List() {
+		if prefix != "" {
+			fmt.Fprintf(w, "%s: %s\n", prefix, warning)
+		} else {
+			fmt.Fprintln(w, warning)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+//go:build js && wasm
+// +build js,wasm
+
+package bitmap
+
+import (
+	"encoding/json"
+	"runtime"
+	"syscall/js"
+)
+
+func init() {
+	runMain = runWasm
+}
+
+// runWasm exposes the converter to JavaScript as 'bmp2cpp.convert(bytes, options)',
+// then waits forever so it can be called. Build with:
+//
+//	GOOS=js GOARCH=wasm go build -o bmp2cpp.wasm
+//
+// and load bmp2cpp.wasm with the wasm_exec.js shipped with Go. bytes is a Uint8Array
+// of the input image, and options an optional object of Generator options, as used in
+// maps and config files. It returns the result of the -lsp-like convert method,
+// '{outputs: [{name, text|binary}], warnings: [...]}', or '{error: "..."}'.
+func runWasm() error {
+	convert := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return convertJS(args)
+	})
+	js.Global().Set("bmp2cpp", js.ValueOf(map[string]interface{}{
+		"convert": convert,
+	}))
+	select {}
+}
+
+func convertJS(args []js.Value) interface{} {
+	if len(args) == 0 || args[0].Type() != js.TypeObject {
+		return map[string]interface{}{"error": "missing bytes"}
+	}
+	req := stdioRequest{Method: "convert"}
+	req.Params.Input = "input"
+	req.Params.Data = make([]byte, args[0].Get("length").Int())
+	js.CopyBytesToGo(req.Params.Data, args[0])
+	if len(args) > 1 && args[1].Truthy() {
+		req.Params.Gen = json.RawMessage(js.Global().Get("JSON").Call("stringify", args[1]).String())
+	}
+
+	result, err := handleStdio(req, *NewGenerator(), runtime.NumCPU(), nil)
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	out, err := json.Marshal(result)
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	return js.Global().Get("JSON").Call("parse", string(out))
+}
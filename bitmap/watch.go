@@ -0,0 +1,60 @@
+package bitmap
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// watchJob runs job, then polls every file it read for modifications, running it again
+// whenever one changes. Errors are logged rather than returned so that a broken
+// intermediate save doesn't stop the watch.
+func watchJob(job *convertJob, interval time.Duration) error {
+	var modTimes map[string]time.Time
+
+	snapshot := func(files []string) map[string]time.Time {
+		times := make(map[string]time.Time, len(files))
+		for _, file := range files {
+			if info, err := os.Stat(file); err == nil {
+				times[file] = info.ModTime()
+			} else {
+				times[file] = time.Time{}
+			}
+		}
+		return times
+	}
+
+	convert := func() {
+		files, err := job.run()
+		if err != nil {
+			log.Println(err)
+
+			// The job may have failed before reading all of its files, so keep
+			// watching the ones from the last run too:
+			for file := range modTimes {
+				files = append(files, file)
+			}
+		} else {
+			log.Printf("wrote %s", job.outFile)
+		}
+		modTimes = snapshot(files)
+	}
+
+	convert()
+	for {
+		time.Sleep(interval)
+
+		changed := false
+		for file, modTime := range modTimes {
+			info, err := os.Stat(file)
+			if err != nil {
+				changed = changed || !modTime.IsZero()
+			} else if !info.ModTime().Equal(modTime) {
+				changed = true
+			}
+		}
+		if changed {
+			convert()
+		}
+	}
+}
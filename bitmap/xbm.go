@@ -0,0 +1,99 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"regexp"
+	"strconv"
+)
+
+var (
+	xbmDefinePtn = regexp.MustCompile(`#define\s+\S*?_?(width|height)\s+(\d+)`)
+	xbmBitsPtn   = regexp.MustCompile(`(?s)_bits\s*\[\s*\]\s*=\s*\{(.*?)\}`)
+	xbmBytePtn   = regexp.MustCompile(`0[xX][0-9a-fA-F]+|\d+`)
+)
+
+// decodeXBM decodes an X11 bitmap. Set bits are white and clear bits are black, so set
+// bits become the highest palette value, as they would be on a monochrome display.
+func decodeXBM(bts []byte) (image.Image, error) {
+	var width, height int
+	for _, m := range xbmDefinePtn.FindAllSubmatch(bts, -1) {
+		v, _ := strconv.Atoi(string(m[2]))
+		if string(m[1]) == "width" {
+			width = v
+		} else {
+			height = v
+		}
+	}
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("xbm: missing width or height")
+	}
+
+	m := xbmBitsPtn.FindSubmatch(bts)
+	if m == nil {
+		return nil, fmt.Errorf("xbm: missing bits array")
+	}
+	var bits []byte
+	for _, raw := range xbmBytePtn.FindAll(m[1], -1) {
+		v, err := strconv.ParseUint(string(raw), 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("xbm: invalid byte %q", raw)
+		}
+		bits = append(bits, byte(v))
+	}
+
+	stride := (width + 7) / 8
+	if len(bits) < stride*height {
+		return nil, fmt.Errorf("xbm: expected %d bytes, found %d", stride*height, len(bits))
+	}
+
+	out := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{color.Black, color.White})
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// Bits are stored least significant first:
+			if bits[y*stride+x/8]&(1<<(x%8)) != 0 {
+				out.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return out, nil
+}
+
+// renderXBM renders the image as an X11 bitmap. Pixels with a non-zero value are set, so
+// the palette must have exactly 2 characters.
+func renderXBM(renderCtx *renderContext, out *bytes.Buffer) error {
+	gen := renderCtx.gen
+	if renderCtx.palette.Size != 2 {
+		return fmt.Errorf("xbm renderer requires a 2 character palette")
+	}
+
+	width, height := renderCtx.layout.width, renderCtx.layout.height
+	out.WriteString(fmt.Sprintf("#define %s_width %d\n", gen.VarName, width))
+	out.WriteString(fmt.Sprintf("#define %s_height %d\n", gen.VarName, height))
+	out.WriteString(fmt.Sprintf("static unsigned char %s_bits[] = {\n", gen.VarName))
+
+	stride := (width + 7) / 8
+	row := make([]uint8, stride)
+	for y := 0; y < height; y++ {
+		for bx := range row {
+			var v byte
+			for bit := 0; bit < 8 && bx*8+bit < width; bit++ {
+				if renderCtx.paletteIndexToValue[renderCtx.img.ColorIndexAt(bx*8+bit, y)] != 0 {
+					v |= 1 << bit
+				}
+			}
+			row[bx] = v
+		}
+		for _, line := range renderCtx.wrapRow(row) {
+			out.WriteString("   ")
+			for _, v := range line {
+				out.WriteString(fmt.Sprintf(" 0x%02x,", v))
+			}
+			out.WriteByte('\n')
+		}
+	}
+	out.WriteString("};\n")
+	return nil
+}
@@ -0,0 +1,176 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+)
+
+// decodeXPM decodes an XPM3 pixmap. Only the 'c' (colour) key of each colour definition
+// is used.
+func decodeXPM(bts []byte) (image.Image, error) {
+	// Every value in an XPM is a C string; comments and the declaration are ignored:
+	var strs []string
+	for rest := string(bts); ; {
+		start := strings.IndexByte(rest, '"')
+		if start < 0 {
+			break
+		}
+		if cmt := strings.Index(rest, "/*"); cmt >= 0 && cmt < start {
+			end := strings.Index(rest[cmt:], "*/")
+			if end < 0 {
+				break
+			}
+			rest = rest[cmt+end+2:]
+			continue
+		}
+		end := strings.IndexByte(rest[start+1:], '"')
+		if end < 0 {
+			return nil, fmt.Errorf("xpm: unterminated string")
+		}
+		strs = append(strs, rest[start+1:start+1+end])
+		rest = rest[start+end+2:]
+	}
+	if len(strs) == 0 {
+		return nil, fmt.Errorf("xpm: missing values")
+	}
+
+	var width, height, ncolors, cpp int
+	if _, err := fmt.Sscan(strs[0], &width, &height, &ncolors, &cpp); err != nil {
+		return nil, fmt.Errorf("xpm: invalid header %q", strs[0])
+	}
+	if width <= 0 || height <= 0 || cpp <= 0 || ncolors <= 0 || ncolors > 256 {
+		return nil, fmt.Errorf("xpm: unsupported header %q", strs[0])
+	}
+	if len(strs) < 1+ncolors+height {
+		return nil, fmt.Errorf("xpm: expected %d values, found %d", 1+ncolors+height, len(strs))
+	}
+
+	var palette color.Palette
+	keys := map[string]uint8{}
+	for idx, def := range strs[1 : 1+ncolors] {
+		if len(def) < cpp {
+			return nil, fmt.Errorf("xpm: invalid colour %q", def)
+		}
+		fields := strings.Fields(def[cpp:])
+		var col color.Color
+		for i := 0; i+1 < len(fields); i += 2 {
+			if fields[i] == "c" {
+				c, err := xpmColor(strings.Join(fields[i+1:], " "), fields[i+1])
+				if err != nil {
+					return nil, err
+				}
+				col = c
+				break
+			}
+		}
+		if col == nil {
+			return nil, fmt.Errorf("xpm: colour %q has no 'c' key", def)
+		}
+		keys[def[:cpp]] = uint8(idx)
+		palette = append(palette, col)
+	}
+
+	out := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+	for y, row := range strs[1+ncolors : 1+ncolors+height] {
+		if len(row) < width*cpp {
+			return nil, fmt.Errorf("xpm: row %d is too short", y)
+		}
+		for x := 0; x < width; x++ {
+			idx, ok := keys[row[x*cpp:(x+1)*cpp]]
+			if !ok {
+				return nil, fmt.Errorf("xpm: unknown pixel %q at %d,%d", row[x*cpp:(x+1)*cpp], x, y)
+			}
+			out.SetColorIndex(x, y, idx)
+		}
+	}
+	return out, nil
+}
+
+// xpmColor parses an XPM colour, which may be 'None', a '#' hex colour with 1 to 4 hex
+// digits per channel, or an X11 colour name. Names may contain spaces, so the rest of
+// the definition is tried first, then only the first word.
+func xpmColor(rest string, word string) (color.Color, error) {
+	if strings.EqualFold(word, "none") {
+		return color.NRGBA{}, nil
+	}
+	if strings.HasPrefix(word, "#") {
+		hex := word[1:]
+		if len(hex) == 0 || len(hex)%3 != 0 || len(hex) > 12 {
+			return nil, fmt.Errorf("xpm: invalid colour %q", word)
+		}
+		n := len(hex) / 3
+		var ch [3]uint8
+		for i := range ch {
+			v, err := strconv.ParseUint(hex[i*n:(i+1)*n], 16, 16)
+			if err != nil {
+				return nil, fmt.Errorf("xpm: invalid colour %q", word)
+			}
+			ch[i] = uint8(v * 255 / (1<<(4*n) - 1))
+		}
+		return color.NRGBA{ch[0], ch[1], ch[2], 255}, nil
+	}
+	for _, name := range []string{rest, word} {
+		name = strings.ToLower(strings.ReplaceAll(name, " ", ""))
+		if c, ok := colornames.Map[name]; ok {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("xpm: unknown colour %q", word)
+}
+
+// renderXPM renders the image as an XPM3 pixmap, using the palette characters as the
+// pixel keys and the quantized colours as their values.
+func renderXPM(renderCtx *renderContext, out *bytes.Buffer) error {
+	gen := renderCtx.gen
+	if renderCtx.palette == &sdfPalette {
+		return fmt.Errorf("xpm renderer does not support raw values")
+	}
+
+	seenChars := mapSeenChars(renderCtx.img, renderCtx.paletteIndexToChar)
+	var defs []string
+	for _, px := range renderCtx.paletteIndexes {
+		char := renderCtx.paletteIndexToChar[px]
+		if !seenChars[char] {
+			continue
+		}
+		if char == '"' || char == '\\' || char > 0x7e || char < 0x20 {
+			return fmt.Errorf("xpm renderer cannot use palette character %q", char)
+		}
+		seenChars[char] = false
+		col := color.NRGBAModel.Convert(renderCtx.img.Palette[px]).(color.NRGBA)
+		value := "None"
+		if col.A != 0 {
+			value = fmt.Sprintf("#%02x%02x%02x", col.R, col.G, col.B)
+		}
+		defs = append(defs, fmt.Sprintf("%c c %s", char, value))
+	}
+
+	out.WriteString("/* XPM */\n")
+	if header := renderCtx.headerComment(); header != "" {
+		for _, line := range strings.Split(strings.TrimRight(header, "\n"), "\n") {
+			out.WriteString(fmt.Sprintf("/* %s */\n", strings.ReplaceAll(line, "*/", "* /")))
+		}
+	}
+
+	size := renderCtx.img.Bounds().Size()
+	out.WriteString(fmt.Sprintf("static const char *%s[] = {\n", gen.VarName))
+	out.WriteString(fmt.Sprintf("\"%d %d %d 1\",\n", size.X, size.Y, len(defs)))
+	for _, def := range defs {
+		out.WriteString(fmt.Sprintf("\"%s\",\n", def))
+	}
+	for y := 0; y < size.Y; y++ {
+		out.WriteByte('"')
+		for x := 0; x < size.X; x++ {
+			out.WriteRune(renderCtx.paletteIndexToChar[renderCtx.img.ColorIndexAt(x, y)])
+		}
+		out.WriteString("\",\n")
+	}
+	out.WriteString("};\n")
+	return nil
+}